@@ -0,0 +1,298 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/cmd/evm/internal/debugger"
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/peterh/liner"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var (
+	DebugTxFlag = cli.StringFlag{
+		Name:  "tx",
+		Usage: "hash of the transaction to replay from the chain database",
+	}
+	ChainDataFlag = cli.StringFlag{
+		Name:  "chaindata",
+		Usage: "chain database directory to replay the transaction from",
+	}
+)
+
+var debugCommand = cli.Command{
+	Action:    debugCmd,
+	Name:      "debug",
+	Usage:     "interactively debug evm code or a transaction",
+	ArgsUsage: "<code>",
+	Flags:     []cli.Flag{DebugTxFlag, ChainDataFlag},
+	Description: `The debug command runs EVM code, set up like for the run command, or replays
+a transaction from a chain database in an interactive step debugger. Replaying a
+transaction requires the state of its parent block to be available.`,
+}
+
+// errExecutionFailed is returned when a replayed transaction fails.
+var errExecutionFailed = errors.New("execution failed")
+
+func debugCmd(ctx *cli.Context) error {
+	var (
+		dbg = debugger.New()
+		run func() ([]byte, error)
+		err error
+	)
+	if ctx.IsSet(DebugTxFlag.Name) {
+		if !ctx.IsSet(ChainDataFlag.Name) {
+			utils.Fatalf("Replaying a transaction requires --%s", ChainDataFlag.Name)
+		}
+		chaindata := ctx.String(ChainDataFlag.Name)
+		db, err := rawdb.NewLevelDBDatabaseWithFreezer(chaindata, 256, 16, filepath.Join(chaindata, "ancient"), "", true)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		if run, err = replayTx(db, common.HexToHash(ctx.String(DebugTxFlag.Name)), dbg); err != nil {
+			return err
+		}
+	} else if run, err = prepareCode(ctx, dbg); err != nil {
+		return err
+	}
+	prompter := newLinePrompter()
+	defer prompter.Close()
+
+	// Pause the running execution on interrupts
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt)
+	defer signal.Stop(sigc)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-sigc:
+				dbg.Pause()
+			case <-done:
+				return
+			}
+		}
+	}()
+	dbg.Start(run)
+	ev := debugger.NewTerminal(dbg, prompter, os.Stdout).Run()
+
+	fmt.Printf("Execution finished, output 0x%x\n", ev.Output)
+	if ev.Err != nil {
+		fmt.Printf(" error: %v\n", ev.Err)
+	}
+	return nil
+}
+
+// prepareCode sets up the execution of the code given by the flags, like the
+// run command does, with the tracer attached.
+func prepareCode(ctx *cli.Context, tracer vm.Tracer) (func() ([]byte, error), error) {
+	var (
+		statedb       *state.StateDB
+		chainConfig   = params.AllEthashProtocolChanges
+		genesisConfig = new(core.Genesis)
+		sender        = common.BytesToAddress([]byte("sender"))
+		receiver      = common.BytesToAddress([]byte("receiver"))
+	)
+	if ctx.GlobalString(GenesisFlag.Name) != "" {
+		genesisConfig = readGenesis(ctx.GlobalString(GenesisFlag.Name))
+		db := rawdb.NewMemoryDatabase()
+		genesis := genesisConfig.ToBlock(db)
+		statedb, _ = state.New(genesis.Root(), state.NewDatabase(db))
+		if genesisConfig.Config != nil {
+			chainConfig = genesisConfig.Config
+		}
+	} else {
+		statedb, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	}
+	if ctx.GlobalString(SenderFlag.Name) != "" {
+		sender = common.HexToAddress(ctx.GlobalString(SenderFlag.Name))
+	}
+	statedb.CreateAccount(sender)
+
+	if ctx.GlobalString(ReceiverFlag.Name) != "" {
+		receiver = common.HexToAddress(ctx.GlobalString(ReceiverFlag.Name))
+	}
+	code, err := loadCode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gas := ctx.GlobalUint64(GasFlag.Name)
+	if genesisConfig.GasLimit != 0 {
+		gas = genesisConfig.GasLimit
+	}
+	cfg := &runtime.Config{
+		ChainConfig: chainConfig,
+		Origin:      sender,
+		State:       statedb,
+		GasLimit:    gas,
+		GasPrice:    utils.GlobalBig(ctx, PriceFlag.Name),
+		Value:       utils.GlobalBig(ctx, ValueFlag.Name),
+		Difficulty:  genesisConfig.Difficulty,
+		Time:        new(big.Int).SetUint64(genesisConfig.Timestamp),
+		Coinbase:    genesisConfig.Coinbase,
+		BlockNumber: new(big.Int).SetUint64(genesisConfig.Number),
+		EVMConfig:   vm.Config{Debug: true, Tracer: tracer},
+	}
+	input := common.FromHex(ctx.GlobalString(InputFlag.Name))
+
+	if ctx.GlobalBool(CreateFlag.Name) {
+		return func() ([]byte, error) {
+			output, _, _, err := runtime.Create(append(code, input...), cfg)
+			return output, err
+		}, nil
+	}
+	if len(code) > 0 {
+		statedb.SetCode(receiver, code)
+	}
+	return func() ([]byte, error) {
+		output, _, err := runtime.Call(receiver, input, cfg)
+		return output, err
+	}, nil
+}
+
+// replayTx sets up the execution of a transaction from the chain database, on
+// top of the state left by the transactions preceding it in its block.
+func replayTx(db ethdb.Database, hash common.Hash, tracer vm.Tracer) (func() ([]byte, error), error) {
+	tx, blockHash, number, index := rawdb.ReadTransaction(db, hash)
+	if tx == nil {
+		return nil, fmt.Errorf("transaction %#x not found", hash)
+	}
+	block := rawdb.ReadBlock(db, blockHash, number)
+	if block == nil {
+		return nil, fmt.Errorf("block %#x not found", blockHash)
+	}
+	parent := rawdb.ReadHeader(db, block.ParentHash(), number-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent %#x not found", block.ParentHash())
+	}
+	config := rawdb.ReadChainConfig(db, rawdb.ReadCanonicalHash(db, 0))
+	if config == nil {
+		return nil, errors.New("chain config not found")
+	}
+	statedb, err := state.New(parent.Root, state.NewDatabase(db))
+	if err != nil {
+		return nil, fmt.Errorf("state of block #%d not available: %v", number-1, err)
+	}
+	var (
+		chain  = &replayChain{db: db}
+		signer = types.MakeSigner(config, block.Number())
+		author = block.Coinbase()
+	)
+	if config.Clique != nil {
+		if author, err = clique.New(config.Clique, db).Author(block.Header()); err != nil {
+			return nil, err
+		}
+	}
+	for i, tx := range block.Transactions() {
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			return nil, err
+		}
+		vmctx := core.NewEVMContext(msg, block.Header(), chain, &author)
+		if uint64(i) == index {
+			return func() ([]byte, error) {
+				vmenv := vm.NewEVM(vmctx, statedb, config, vm.Config{Debug: true, Tracer: tracer})
+				output, _, failed, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.Gas()))
+				if err == nil && failed {
+					err = errExecutionFailed
+				}
+				return output, err
+			}, nil
+		}
+		vmenv := vm.NewEVM(vmctx, statedb, config, vm.Config{})
+		if _, _, _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.Gas())); err != nil {
+			return nil, fmt.Errorf("transaction %#x failed: %v", tx.Hash(), err)
+		}
+		// Only delete empty objects if EIP158/161 (a.k.a Spurious Dragon) is in effect
+		statedb.Finalise(vmenv.ChainConfig().IsEIP158(block.Number()))
+	}
+	return nil, fmt.Errorf("transaction %#x not found in block %#x", hash, blockHash)
+}
+
+// replayChain gives replayed transactions access to the chain headers, for the
+// BLOCKHASH opcode.
+type replayChain struct {
+	db ethdb.Database
+}
+
+// Engine implements core.ChainContext. It is never used, the block author
+// being resolved beforehand.
+func (c *replayChain) Engine() consensus.Engine {
+	return nil
+}
+
+// GetHeader implements core.ChainContext.
+func (c *replayChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return rawdb.ReadHeader(c.db, hash, number)
+}
+
+// linePrompter reads commands from the terminal with line editing, restoring
+// the normal terminal mode in between so that interrupts reach the process.
+type linePrompter struct {
+	*liner.State
+	normalMode liner.ModeApplier
+	rawMode    liner.ModeApplier
+}
+
+// newLinePrompter creates a prompter working off the standard streams.
+func newLinePrompter() *linePrompter {
+	normalMode, _ := liner.TerminalMode()
+
+	p := &linePrompter{State: liner.NewLiner()}
+	if rawMode, err := liner.TerminalMode(); err == nil && normalMode != nil && liner.TerminalSupported() {
+		p.normalMode, p.rawMode = normalMode, rawMode
+		normalMode.ApplyMode()
+	}
+	p.SetCtrlCAborts(true)
+	return p
+}
+
+// PromptInput implements debugger.Prompter, prompting again if aborted.
+func (p *linePrompter) PromptInput(prompt string) (string, error) {
+	if p.rawMode != nil {
+		p.rawMode.ApplyMode()
+		defer p.normalMode.ApplyMode()
+	}
+	for {
+		line, err := p.Prompt(prompt)
+		if err != liner.ErrPromptAborted {
+			return line, err
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package debugger
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// BreakpointKind is the condition of a breakpoint.
+type BreakpointKind int
+
+const (
+	BreakPC     BreakpointKind = iota // Break on a program counter
+	BreakOp                           // Break on an opcode
+	BreakSStore                       // Break on a storage write
+)
+
+// Breakpoint is a condition pausing the execution before an opcode is executed.
+type Breakpoint struct {
+	ID      int
+	Kind    BreakpointKind
+	PC      uint64          // Program counter of PC breakpoints
+	Address *common.Address // Code of PC breakpoints, any if nil
+	Op      vm.OpCode       // Opcode of opcode breakpoints
+	Slot    *common.Hash    // Slot of storage write breakpoints, any if nil
+}
+
+// String implements fmt.Stringer.
+func (bp *Breakpoint) String() string {
+	switch bp.Kind {
+	case BreakPC:
+		if bp.Address != nil {
+			return fmt.Sprintf("breakpoint %d: pc %d in %x", bp.ID, bp.PC, *bp.Address)
+		}
+		return fmt.Sprintf("breakpoint %d: pc %d", bp.ID, bp.PC)
+	case BreakOp:
+		return fmt.Sprintf("breakpoint %d: opcode %v", bp.ID, bp.Op)
+	default:
+		if bp.Slot != nil {
+			return fmt.Sprintf("breakpoint %d: storage write to %x", bp.ID, *bp.Slot)
+		}
+		return fmt.Sprintf("breakpoint %d: storage write", bp.ID)
+	}
+}
+
+// matches returns whether the opcode about to be executed hits the breakpoint.
+func (bp *Breakpoint) matches(pc uint64, op vm.OpCode, stack *vm.Stack, contract *vm.Contract) bool {
+	switch bp.Kind {
+	case BreakPC:
+		if bp.Address == nil {
+			return pc == bp.PC
+		}
+		code := contract.Address()
+		if contract.CodeAddr != nil {
+			code = *contract.CodeAddr
+		}
+		return pc == bp.PC && code == *bp.Address
+	case BreakOp:
+		return op == bp.Op
+	default:
+		if op != vm.SSTORE {
+			return false
+		}
+		return bp.Slot == nil || (len(stack.Data()) > 0 && common.BigToHash(stack.Back(0)) == *bp.Slot)
+	}
+}
+
+// AddBreakpoint adds a breakpoint, assigning it a new identifier.
+func (d *Debugger) AddBreakpoint(bp Breakpoint) *Breakpoint {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	bp.ID = d.nextID
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, &bp)
+	return &bp
+}
+
+// RemoveBreakpoint removes a breakpoint, returning whether it existed.
+func (d *Debugger) RemoveBreakpoint(id int) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns the breakpoints set.
+func (d *Debugger) Breakpoints() []*Breakpoint {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]*Breakpoint{}, d.breakpoints...)
+}
+
+// hit returns the first breakpoint the opcode about to be executed hits.
+func (d *Debugger) hit(pc uint64, op vm.OpCode, stack *vm.Stack, contract *vm.Contract) *Breakpoint {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, bp := range d.breakpoints {
+		if bp.matches(pc, op, stack, contract) {
+			return bp
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+// Package debugger implements an interactive EVM step debugger, pausing the
+// execution from within the tracer hooks.
+package debugger
+
+import (
+	"math/big"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// Frame is a call frame of the execution.
+type Frame struct {
+	Address     common.Address // Account whose storage and balance are used
+	CodeAddress common.Address // Account whose code is executed, zero for creations
+	Caller      common.Address // Account that called into the frame
+	Depth       int            // Call depth of the frame, starting at 1
+	PC          uint64         // Current program counter in the frame
+}
+
+// Slot is a storage slot along with its current value.
+type Slot struct {
+	Key   common.Hash
+	Value common.Hash
+}
+
+// Step is the state of the EVM paused before executing an opcode.
+type Step struct {
+	PC      uint64
+	Op      vm.OpCode
+	Gas     uint64
+	Cost    uint64
+	Depth   int
+	Stack   []*big.Int // Stack items, the top one last
+	Code    []byte     // Code being executed, not to be modified
+	Memory  []byte
+	Storage []Slot  // Slots of the current account accessed so far
+	Frames  []Frame // Call frames, the innermost one last
+	Reason  string  // Why the execution was paused
+}
+
+// Event is either a pause of the execution or its end.
+type Event struct {
+	Step *Step // State of the paused execution, nil if finished
+
+	Output []byte // Output of the finished execution
+	Err    error  // Failure of the finished execution
+}
+
+// mode is the way execution is resumed.
+type mode int
+
+const (
+	modeStep     mode = iota // Pause on the next opcode
+	modeNext                 // Pause on the next opcode in the same or an outer frame
+	modeOut                  // Pause on the next opcode in an outer frame
+	modeContinue             // Pause on breakpoints only
+	modeQuit                 // Abort the execution
+)
+
+// Debugger is a Tracer pausing the execution it is attached to, either step by
+// step or on breakpoints, until resumed by the controlling goroutine. The paused
+// states are delivered as events by Wait.
+type Debugger struct {
+	events chan *Event
+	resume chan mode
+
+	mode   mode
+	depth  int   // Depth of the frame next and out are relative to
+	pause  int32 // Set to pause the execution at the next opcode
+	frames []Frame
+	slots  map[common.Address]map[common.Hash]struct{} // Storage slots accessed per account
+
+	lock        sync.Mutex
+	breakpoints []*Breakpoint
+	nextID      int
+}
+
+// New creates a debugger pausing on the first opcode executed.
+func New() *Debugger {
+	return &Debugger{
+		events: make(chan *Event),
+		resume: make(chan mode),
+		mode:   modeStep,
+		slots:  make(map[common.Address]map[common.Hash]struct{}),
+		nextID: 1,
+	}
+}
+
+// Start runs the execution in a new goroutine, the debugger being its tracer.
+func (d *Debugger) Start(run func() ([]byte, error)) {
+	go func() {
+		output, err := run()
+		d.events <- &Event{Output: output, Err: err}
+	}()
+}
+
+// Wait blocks until the execution pauses or finishes.
+func (d *Debugger) Wait() *Event {
+	return <-d.events
+}
+
+// Step resumes the paused execution until the next opcode.
+func (d *Debugger) Step() { d.resume <- modeStep }
+
+// Next resumes the paused execution until the next opcode of the current frame,
+// stepping over calls, or of an outer frame.
+func (d *Debugger) Next() { d.resume <- modeNext }
+
+// Out resumes the paused execution until the current frame returns.
+func (d *Debugger) Out() { d.resume <- modeOut }
+
+// Continue resumes the paused execution until a breakpoint is hit.
+func (d *Debugger) Continue() { d.resume <- modeContinue }
+
+// Quit aborts the paused execution. The opcode it is paused at is still
+// executed, the EVM only checking for cancellation between opcodes.
+func (d *Debugger) Quit() { d.resume <- modeQuit }
+
+// Pause requests the running execution to pause at the next opcode. It is safe
+// to call from any goroutine.
+func (d *Debugger) Pause() {
+	atomic.StoreInt32(&d.pause, 1)
+}
+
+// CaptureStart implements the Tracer interface.
+func (d *Debugger) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	d.frames = d.frames[:0]
+	return nil
+}
+
+// CaptureState implements the Tracer interface, tracking the call frames and
+// pausing the execution if requested.
+func (d *Debugger) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if err != nil || d.mode == modeQuit {
+		return nil
+	}
+	// Track the call frames and the storage slots accessed
+	if depth < len(d.frames) {
+		d.frames = d.frames[:depth]
+	}
+	for depth > len(d.frames) {
+		frame := Frame{Address: contract.Address(), Caller: contract.Caller(), Depth: len(d.frames) + 1}
+		if contract.CodeAddr != nil {
+			frame.CodeAddress = *contract.CodeAddr
+		}
+		d.frames = append(d.frames, frame)
+	}
+	d.frames[depth-1].PC = pc
+
+	if (op == vm.SLOAD || op == vm.SSTORE) && len(stack.Data()) > 0 {
+		slots, ok := d.slots[contract.Address()]
+		if !ok {
+			slots = make(map[common.Hash]struct{})
+			d.slots[contract.Address()] = slots
+		}
+		slots[common.BigToHash(stack.Back(0))] = struct{}{}
+	}
+	// Pause if stepping or on a breakpoint
+	var reason string
+	switch {
+	case atomic.CompareAndSwapInt32(&d.pause, 1, 0):
+		reason = "paused"
+	case d.mode == modeStep:
+		reason = "step"
+	case d.mode == modeNext && depth <= d.depth:
+		reason = "next"
+	case d.mode == modeOut && depth < d.depth:
+		reason = "out"
+	default:
+		if bp := d.hit(pc, op, stack, contract); bp != nil {
+			reason = bp.String()
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+	d.events <- &Event{Step: d.snapshot(env, pc, op, gas, cost, memory, stack, contract, depth, reason)}
+
+	d.mode, d.depth = <-d.resume, depth
+	if d.mode == modeQuit {
+		env.Cancel()
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface.
+func (d *Debugger) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface.
+func (d *Debugger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	return nil
+}
+
+// snapshot copies the state of the execution before the opcode is executed.
+func (d *Debugger) snapshot(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, reason string) *Step {
+	step := &Step{
+		PC:     pc,
+		Op:     op,
+		Gas:    gas,
+		Cost:   cost,
+		Depth:  depth,
+		Code:   contract.Code,
+		Memory: common.CopyBytes(memory.Data()),
+		Frames: append([]Frame{}, d.frames...),
+		Reason: reason,
+	}
+	for _, item := range stack.Data() {
+		step.Stack = append(step.Stack, new(big.Int).Set(item))
+	}
+	for key := range d.slots[contract.Address()] {
+		step.Storage = append(step.Storage, Slot{Key: key, Value: env.StateDB.GetState(contract.Address(), key)})
+	}
+	sort.Slice(step.Storage, func(i, j int) bool {
+		return step.Storage[i].Key.Big().Cmp(step.Storage[j].Key.Big()) < 0
+	})
+	return step
+}
@@ -0,0 +1,242 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package debugger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+)
+
+var (
+	callerAddr = common.BytesToAddress([]byte("caller"))
+	calleeAddr = common.HexToAddress("0xbb")
+
+	// callerCode calls the callee, then stores 1 into slot 0.
+	callerCode = common.Hex2Bytes("6000600060006000600060bb5af150600160005500")
+	// calleeCode stores 2 into slot 5.
+	calleeCode = common.Hex2Bytes("600260055500")
+)
+
+// debug starts the execution of the caller under a new debugger.
+func debug(t *testing.T) (*Debugger, *state.StateDB) {
+	t.Helper()
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	statedb.SetCode(callerAddr, callerCode)
+	statedb.SetCode(calleeAddr, calleeCode)
+
+	dbg := New()
+	cfg := &runtime.Config{State: statedb, EVMConfig: vm.Config{Debug: true, Tracer: dbg}}
+	dbg.Start(func() ([]byte, error) {
+		output, _, err := runtime.Call(callerAddr, nil, cfg)
+		return output, err
+	})
+	return dbg, statedb
+}
+
+// expect waits for the execution to pause at the given position.
+func expect(t *testing.T, dbg *Debugger, depth int, pc uint64, reason string) *Step {
+	t.Helper()
+
+	ev := dbg.Wait()
+	if ev.Step == nil {
+		t.Fatalf("execution finished (err %v), want pause at depth %d pc %d", ev.Err, depth, pc)
+	}
+	if ev.Step.Depth != depth || ev.Step.PC != pc || ev.Step.Reason != reason {
+		t.Fatalf("paused at depth %d pc %d (%s), want depth %d pc %d (%s)", ev.Step.Depth, ev.Step.PC, ev.Step.Reason, depth, pc, reason)
+	}
+	return ev.Step
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	dbg, statedb := debug(t)
+
+	expect(t, dbg, 1, 0, "step")
+	dbg.Next()
+	expect(t, dbg, 1, 2, "next")
+	dbg.Pause()
+	dbg.Continue()
+	expect(t, dbg, 1, 4, "paused")
+
+	call := dbg.AddBreakpoint(Breakpoint{Kind: BreakOp, Op: vm.CALL})
+	dbg.Continue()
+	step := expect(t, dbg, 1, 13, call.String())
+	if len(step.Stack) != 7 || step.Stack[len(step.Stack)-2].Cmp(new(big.Int).SetBytes(calleeAddr.Bytes())) != 0 {
+		t.Errorf("stack mismatch at call: %v", step.Stack)
+	}
+	dbg.Step()
+	step = expect(t, dbg, 2, 0, "step")
+	if len(step.Frames) != 2 || step.Frames[1].Address != calleeAddr || step.Frames[1].Caller != callerAddr || step.Frames[0].PC != 13 {
+		t.Errorf("frames mismatch in callee: %+v", step.Frames)
+	}
+	if !bytes.Equal(step.Code, calleeCode) {
+		t.Errorf("code mismatch in callee: %x", step.Code)
+	}
+	dbg.Out()
+	expect(t, dbg, 1, 14, "out")
+
+	sstore := dbg.AddBreakpoint(Breakpoint{Kind: BreakSStore})
+	dbg.Continue()
+	step = expect(t, dbg, 1, 19, sstore.String())
+	if len(step.Storage) != 1 || step.Storage[0] != (Slot{}) {
+		t.Errorf("storage mismatch before write: %v", step.Storage)
+	}
+	dbg.Continue()
+	if ev := dbg.Wait(); ev.Step != nil || ev.Err != nil {
+		t.Fatalf("execution not finished cleanly: step %+v, err %v", ev.Step, ev.Err)
+	}
+	if have := statedb.GetState(calleeAddr, common.BigToHash(big.NewInt(5))); have != common.BigToHash(common.Big2) {
+		t.Errorf("callee slot mismatch: have %x", have)
+	}
+}
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	dbg, _ := debug(t)
+	expect(t, dbg, 1, 0, "step")
+
+	// Breakpoints restricted to other code or slots must not be hit
+	other := common.HexToAddress("0xcc")
+	dbg.AddBreakpoint(Breakpoint{Kind: BreakPC, PC: 4, Address: &other})
+	slot := common.BigToHash(common.Big3)
+	dbg.AddBreakpoint(Breakpoint{Kind: BreakSStore, Slot: &slot})
+
+	pc := dbg.AddBreakpoint(Breakpoint{Kind: BreakPC, PC: 4, Address: &calleeAddr})
+	dbg.Continue()
+	expect(t, dbg, 2, 4, pc.String())
+
+	if !dbg.RemoveBreakpoint(pc.ID) || dbg.RemoveBreakpoint(pc.ID) {
+		t.Fatalf("breakpoint %d not removed exactly once", pc.ID)
+	}
+	zero := common.Hash{}
+	sstore := dbg.AddBreakpoint(Breakpoint{Kind: BreakSStore, Slot: &zero})
+	if n := len(dbg.Breakpoints()); n != 3 {
+		t.Fatalf("breakpoint count mismatch: have %d, want 3", n)
+	}
+	dbg.Continue()
+	expect(t, dbg, 1, 19, sstore.String())
+
+	dbg.Continue()
+	if ev := dbg.Wait(); ev.Step != nil {
+		t.Fatalf("execution paused at pc %d, want finished", ev.Step.PC)
+	}
+}
+
+func TestDebuggerNextOverCall(t *testing.T) {
+	dbg, _ := debug(t)
+	expect(t, dbg, 1, 0, "step")
+
+	dbg.AddBreakpoint(Breakpoint{Kind: BreakOp, Op: vm.CALL})
+	dbg.Continue()
+	expect(t, dbg, 1, 13, "breakpoint 1: opcode CALL")
+	dbg.Next()
+	expect(t, dbg, 1, 14, "next")
+	dbg.Quit()
+	if ev := dbg.Wait(); ev.Step != nil {
+		t.Fatalf("execution paused at pc %d, want finished", ev.Step.PC)
+	}
+}
+
+func TestDebuggerQuit(t *testing.T) {
+	dbg, statedb := debug(t)
+	expect(t, dbg, 1, 0, "step")
+
+	dbg.Quit()
+	if ev := dbg.Wait(); ev.Step != nil {
+		t.Fatalf("execution paused at pc %d, want aborted", ev.Step.PC)
+	}
+	if have := statedb.GetState(calleeAddr, common.BigToHash(big.NewInt(5))); have != (common.Hash{}) {
+		t.Errorf("storage written by aborted execution: %x", have)
+	}
+}
+
+// scriptedPrompter feeds a fixed list of commands to the terminal.
+type scriptedPrompter struct {
+	commands []string
+	history  []string
+}
+
+func (p *scriptedPrompter) PromptInput(prompt string) (string, error) {
+	if len(p.commands) == 0 {
+		return "", errors.New("end of script")
+	}
+	command := p.commands[0]
+	p.commands = p.commands[1:]
+	return command, nil
+}
+
+func (p *scriptedPrompter) AppendHistory(command string) {
+	p.history = append(p.history, command)
+}
+
+func TestTerminal(t *testing.T) {
+	dbg, _ := debug(t)
+
+	var (
+		out    = new(bytes.Buffer)
+		script = &scriptedPrompter{commands: []string{
+			"b pc 4 0x00000000000000000000000000000000000000bb",
+			"break sstore",
+			"bl",
+			"frobnicate",
+			"c",
+			"bt",
+			"",
+			"st",
+			"d 1",
+			"c",
+			"sto",
+			"l",
+			"m 0 0",
+			"q",
+		}}
+	)
+	ev := NewTerminal(dbg, script, out).Run()
+	if ev.Step != nil {
+		t.Fatalf("terminal returned a paused step")
+	}
+	if len(script.commands) != 0 {
+		t.Errorf("commands left unexecuted: %v", script.commands)
+	}
+	if len(script.history) != 13 {
+		t.Errorf("history length mismatch: have %d, want 13", len(script.history))
+	}
+	for _, want := range []string{
+		"Added breakpoint 1: pc 4 in 00000000000000000000000000000000000000bb\n",
+		"Added breakpoint 2: storage write\n",
+		"Unknown command \"frobnicate\"",
+		"[breakpoint 1: pc 4 in 00000000000000000000000000000000000000bb] depth 2 00000000000000000000000000000000000000bb pc 4: SSTORE",
+		fmt.Sprintf("#2 00000000000000000000000000000000000000bb pc 4, called by %x\n", callerAddr),
+		"   0: 0x5\n   1: 0x2\n",
+		fmt.Sprintf("[breakpoint 2: storage write] depth 1 %x pc 19: SSTORE", callerAddr),
+		"0000000000000000000000000000000000000000000000000000000000000000: 0000000000000000000000000000000000000000000000000000000000000000\n",
+		"=>    19: SSTORE\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// Prompter reads the commands of the user.
+type Prompter interface {
+	// PromptInput displays the given prompt to the user and returns the line
+	// entered.
+	PromptInput(prompt string) (string, error)
+
+	// AppendHistory appends a command to the scrollback history.
+	AppendHistory(command string)
+}
+
+const help = `Commands:
+  s, step                    execute the next opcode
+  n, next                    execute until the next opcode of this frame, stepping over calls
+  fin, finish                execute until the current frame returns
+  c, continue                execute until a breakpoint is hit (Ctrl-C pauses)
+  b, break pc <pc> [<addr>]  break on a program counter, optionally of an account's code only
+  b, break op <opcode>       break on an opcode
+  b, break sstore [<slot>]   break on storage writes, optionally to a slot only
+  d, delete <id>             delete a breakpoint
+  bl, breakpoints            list the breakpoints
+  l, list                    disassemble the code around the program counter
+  st, stack                  show the stack
+  m, memory [<off> [<size>]] show the memory
+  sto, storage               show the storage slots of the account accessed so far
+  bt, frames                 show the call frames
+  q, quit                    abort the execution
+An empty line repeats the last command.`
+
+// Terminal is a line based user interface driving a debugger.
+type Terminal struct {
+	dbg    *Debugger
+	prompt Prompter
+	out    io.Writer
+	last   string // Last command, repeated on empty lines
+}
+
+// NewTerminal creates a user interface for the debugger, reading commands from
+// the prompter and writing views to the output.
+func NewTerminal(dbg *Debugger, prompt Prompter, out io.Writer) *Terminal {
+	return &Terminal{dbg: dbg, prompt: prompt, out: out}
+}
+
+// Run drives the debugger until the execution finishes, returning its result.
+// Failing to read a command aborts the execution.
+func (t *Terminal) Run() *Event {
+	fmt.Fprintln(t.out, "Type 'help' for the list of commands.")
+	for ev := t.dbg.Wait(); ; ev = t.dbg.Wait() {
+		if ev.Step == nil {
+			return ev
+		}
+		t.showStep(ev.Step)
+		for {
+			line, err := t.prompt.PromptInput("(evm) ")
+			if err != nil {
+				t.dbg.Quit()
+				break
+			}
+			if line = strings.TrimSpace(line); line == "" {
+				line = t.last
+			} else {
+				t.prompt.AppendHistory(line)
+			}
+			t.last = line
+			if t.execute(ev.Step, strings.Fields(line)) {
+				break
+			}
+		}
+	}
+}
+
+// execute runs a command, returning whether the execution was resumed.
+func (t *Terminal) execute(step *Step, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "s", "step":
+		t.dbg.Step()
+	case "n", "next":
+		t.dbg.Next()
+	case "fin", "finish":
+		t.dbg.Out()
+	case "c", "continue":
+		t.dbg.Continue()
+	case "q", "quit":
+		t.dbg.Quit()
+	case "b", "break":
+		bp, err := parseBreakpoint(args[1:])
+		if err != nil {
+			fmt.Fprintf(t.out, "Invalid breakpoint: %v\n", err)
+			return false
+		}
+		fmt.Fprintf(t.out, "Added %v\n", t.dbg.AddBreakpoint(*bp))
+		return false
+	case "d", "delete":
+		id, err := strconv.Atoi(strings.Join(args[1:], ""))
+		if err != nil || !t.dbg.RemoveBreakpoint(id) {
+			fmt.Fprintf(t.out, "Unknown breakpoint %q\n", strings.Join(args[1:], " "))
+		}
+		return false
+	case "bl", "breakpoints":
+		for _, bp := range t.dbg.Breakpoints() {
+			fmt.Fprintln(t.out, bp)
+		}
+		return false
+	case "l", "list":
+		t.showCode(step)
+		return false
+	case "st", "stack":
+		for i := len(step.Stack) - 1; i >= 0; i-- {
+			fmt.Fprintf(t.out, "%4d: %#x\n", len(step.Stack)-1-i, step.Stack[i])
+		}
+		return false
+	case "m", "mem", "memory":
+		t.showMemory(step, args[1:])
+		return false
+	case "sto", "storage":
+		for _, slot := range step.Storage {
+			fmt.Fprintf(t.out, "%x: %x\n", slot.Key, slot.Value)
+		}
+		return false
+	case "bt", "frames":
+		for i := len(step.Frames) - 1; i >= 0; i-- {
+			frame := step.Frames[i]
+			fmt.Fprintf(t.out, "#%d %x pc %d, called by %x", frame.Depth, frame.Address, frame.PC, frame.Caller)
+			if frame.CodeAddress != frame.Address && frame.CodeAddress != (common.Address{}) {
+				fmt.Fprintf(t.out, ", code of %x", frame.CodeAddress)
+			}
+			fmt.Fprintln(t.out)
+		}
+		return false
+	case "h", "help":
+		fmt.Fprintln(t.out, help)
+		return false
+	default:
+		fmt.Fprintf(t.out, "Unknown command %q, type 'help' for the list of commands\n", args[0])
+		return false
+	}
+	return true
+}
+
+// showStep prints the opcode about to be executed.
+func (t *Terminal) showStep(step *Step) {
+	var address common.Address
+	if len(step.Frames) > 0 {
+		address = step.Frames[len(step.Frames)-1].Address
+	}
+	fmt.Fprintf(t.out, "[%s] depth %d %x pc %d: %s  gas %d cost %d\n", step.Reason, step.Depth, address, step.PC, instruction(step.Code, step.PC), step.Gas, step.Cost)
+}
+
+// showCode disassembles the instructions around the program counter.
+func (t *Terminal) showCode(step *Step) {
+	var (
+		it     = asm.NewInstructionIterator(step.Code)
+		before []uint64 // Previous instructions, at most 4
+		after  int
+	)
+	for it.Next() && after < 5 {
+		switch {
+		case it.PC() < step.PC:
+			if before = append(before, it.PC()); len(before) > 4 {
+				before = before[1:]
+			}
+		case it.PC() == step.PC:
+			for _, pc := range before {
+				fmt.Fprintf(t.out, "   %5d: %s\n", pc, instruction(step.Code, pc))
+			}
+			fmt.Fprintf(t.out, "=> %5d: %s\n", it.PC(), instruction(step.Code, it.PC()))
+		default:
+			fmt.Fprintf(t.out, "   %5d: %s\n", it.PC(), instruction(step.Code, it.PC()))
+			after++
+		}
+	}
+}
+
+// showMemory prints a hex dump of a range of the memory, the whole if none.
+func (t *Terminal) showMemory(step *Step, args []string) {
+	offset, size := 0, len(step.Memory)
+	if len(args) > 0 {
+		n, err := strconv.ParseUint(args[0], 0, 32)
+		if err != nil {
+			fmt.Fprintf(t.out, "Invalid offset %q\n", args[0])
+			return
+		}
+		offset, size = int(n), len(step.Memory)-int(n)
+	}
+	if len(args) > 1 {
+		n, err := strconv.ParseUint(args[1], 0, 32)
+		if err != nil {
+			fmt.Fprintf(t.out, "Invalid size %q\n", args[1])
+			return
+		}
+		size = int(n)
+	}
+	if offset > len(step.Memory) {
+		offset = len(step.Memory)
+	}
+	if offset+size > len(step.Memory) {
+		size = len(step.Memory) - offset
+	}
+	for i := offset; i < offset+size; i += 32 {
+		end := i + 32
+		if end > offset+size {
+			end = offset + size
+		}
+		fmt.Fprintf(t.out, "%06x: %x\n", i, step.Memory[i:end])
+	}
+}
+
+// instruction formats the instruction at a program counter with its immediate.
+func instruction(code []byte, pc uint64) string {
+	if pc >= uint64(len(code)) {
+		return vm.STOP.String()
+	}
+	op := vm.OpCode(code[pc])
+	if op < vm.PUSH1 || op > vm.PUSH32 {
+		return op.String()
+	}
+	end := pc + 1 + uint64(op-vm.PUSH1+1)
+	if end > uint64(len(code)) {
+		end = uint64(len(code))
+	}
+	return fmt.Sprintf("%v %#x", op, code[pc+1:end])
+}
+
+// parseBreakpoint parses the arguments of the break command.
+func parseBreakpoint(args []string) (*Breakpoint, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing kind, want pc, op or sstore")
+	}
+	switch args[0] {
+	case "pc":
+		if len(args) < 2 || len(args) > 3 {
+			return nil, fmt.Errorf("want pc <pc> [<address>]")
+		}
+		pc, err := strconv.ParseUint(args[1], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid program counter %q", args[1])
+		}
+		bp := &Breakpoint{Kind: BreakPC, PC: pc}
+		if len(args) == 3 {
+			if !common.IsHexAddress(args[2]) {
+				return nil, fmt.Errorf("invalid address %q", args[2])
+			}
+			address := common.HexToAddress(args[2])
+			bp.Address = &address
+		}
+		return bp, nil
+
+	case "op":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("want op <opcode>")
+		}
+		op := vm.StringToOp(strings.ToUpper(args[1]))
+		if op == 0 && strings.ToUpper(args[1]) != vm.STOP.String() {
+			return nil, fmt.Errorf("unknown opcode %q", args[1])
+		}
+		return &Breakpoint{Kind: BreakOp, Op: op}, nil
+
+	case "sstore":
+		if len(args) > 2 {
+			return nil, fmt.Errorf("want sstore [<slot>]")
+		}
+		bp := &Breakpoint{Kind: BreakSStore}
+		if len(args) == 2 {
+			slot, ok := new(big.Int).SetString(args[1], 0)
+			if !ok {
+				return nil, fmt.Errorf("invalid slot %q", args[1])
+			}
+			hash := common.BigToHash(slot)
+			bp.Slot = &hash
+		}
+		return bp, nil
+	}
+	return nil, fmt.Errorf("unknown kind %q, want pc, op or sstore", args[0])
+}
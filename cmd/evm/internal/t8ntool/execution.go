@@ -0,0 +1,255 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"golang.org/x/crypto/sha3"
+)
+
+// Prestate is the state and environment a list of transactions is applied on.
+type Prestate struct {
+	Env stEnv             `json:"env"`
+	Pre core.GenesisAlloc `json:"pre"`
+}
+
+// ommer is an uncle of the block being assembled, rewarded with a share of the
+// block reward depending on its distance from the block.
+type ommer struct {
+	Delta   uint64         `json:"delta"`
+	Address common.Address `json:"address"`
+}
+
+// stEnv is the block environment the transactions are executed in.
+type stEnv struct {
+	Coinbase    common.Address                      `json:"currentCoinbase"`
+	Difficulty  *math.HexOrDecimal256               `json:"currentDifficulty"`
+	GasLimit    math.HexOrDecimal64                 `json:"currentGasLimit"`
+	Number      math.HexOrDecimal64                 `json:"currentNumber"`
+	Timestamp   math.HexOrDecimal64                 `json:"currentTimestamp"`
+	BlockHashes map[math.HexOrDecimal64]common.Hash `json:"blockHashes,omitempty"`
+	Ommers      []ommer                             `json:"ommers,omitempty"`
+}
+
+// rejectedTx is a transaction which could not be applied to the state.
+type rejectedTx struct {
+	Index int    `json:"index"`
+	Err   string `json:"error"`
+}
+
+// ExecutionResult contains the roots, receipts and rejected transactions after
+// applying the transactions to the pre-state.
+type ExecutionResult struct {
+	StateRoot   common.Hash    `json:"stateRoot"`
+	TxRoot      common.Hash    `json:"txRoot"`
+	ReceiptRoot common.Hash    `json:"receiptRoot"`
+	LogsHash    common.Hash    `json:"logsHash"`
+	Bloom       types.Bloom    `json:"logsBloom"`
+	Receipts    types.Receipts `json:"receipts"`
+	Rejected    []*rejectedTx  `json:"rejected,omitempty"`
+}
+
+// errMissingDifficulty is returned if the environment doesn't specify the block
+// difficulty.
+var errMissingDifficulty = errors.New("missing currentDifficulty in env")
+
+// hashChain is a chain context serving headers made up of the block hashes in
+// the environment, so the EVM can resolve the BLOCKHASH opcode. Hashes missing
+// from the environment resolve to the zero hash.
+type hashChain struct {
+	hashes map[math.HexOrDecimal64]common.Hash
+}
+
+// Engine implements core.ChainContext. The coinbase of the block is always
+// explicit, so no consensus engine is needed.
+func (c *hashChain) Engine() consensus.Engine {
+	return nil
+}
+
+// GetHeader implements core.ChainContext, returning a header which only links
+// to the hash of its parent.
+func (c *hashChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if number == 0 {
+		return nil
+	}
+	return &types.Header{
+		Number:     new(big.Int).SetUint64(number),
+		ParentHash: c.hashes[math.HexOrDecimal64(number-1)],
+	}
+}
+
+// Apply applies a set of transactions to the pre-state, returning the post state
+// and the results of the execution. Transactions that can't be applied are not
+// included in the block, but are reported as rejected.
+func (pre *Prestate) Apply(vmConfig vm.Config, chainConfig *params.ChainConfig, txs types.Transactions, miningReward int64, getTracerFn func(txIndex int, txHash common.Hash) (vm.Tracer, error)) (*state.StateDB, *ExecutionResult, error) {
+	if pre.Env.Difficulty == nil {
+		return nil, nil, errMissingDifficulty
+	}
+	var (
+		number = uint64(pre.Env.Number)
+		header = &types.Header{
+			ParentHash: pre.Env.BlockHashes[math.HexOrDecimal64(number-1)],
+			Coinbase:   pre.Env.Coinbase,
+			Difficulty: (*big.Int)(pre.Env.Difficulty),
+			Number:     new(big.Int).SetUint64(number),
+			GasLimit:   uint64(pre.Env.GasLimit),
+			Time:       uint64(pre.Env.Timestamp),
+		}
+		chain   = &hashChain{hashes: pre.Env.BlockHashes}
+		statedb = MakePreState(rawdb.NewMemoryDatabase(), pre.Pre)
+		signer  = types.MakeSigner(chainConfig, header.Number)
+		gaspool = new(core.GasPool).AddGas(header.GasLimit)
+		usedGas uint64
+
+		included types.Transactions
+		receipts = make(types.Receipts, 0)
+		rejected []*rejectedTx
+	)
+	// If DAO is supported/enabled, we need to handle it here
+	if chainConfig.DAOForkSupport && chainConfig.DAOForkBlock != nil && chainConfig.DAOForkBlock.Cmp(header.Number) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	for i, tx := range txs {
+		if _, err := types.Sender(signer, tx); err != nil {
+			rejected = append(rejected, &rejectedTx{i, err.Error()})
+			continue
+		}
+		tracer, err := getTracerFn(i, tx.Hash())
+		if err != nil {
+			return nil, nil, err
+		}
+		vmConfig.Tracer = tracer
+		vmConfig.Debug = tracer != nil
+
+		statedb.Prepare(tx.Hash(), common.Hash{}, len(included))
+		var (
+			snapshot = statedb.Snapshot()
+			gasLeft  = *gaspool
+		)
+		receipt, err := core.ApplyTransaction(chainConfig, chain, &header.Coinbase, gaspool, statedb, header, tx, &usedGas, vmConfig)
+		if err != nil {
+			statedb.RevertToSnapshot(snapshot)
+			*gaspool = gasLeft
+			rejected = append(rejected, &rejectedTx{i, err.Error()})
+			continue
+		}
+		included = append(included, tx)
+		receipts = append(receipts, receipt)
+	}
+	// Add mining reward, scaling the ommer rewards by their distance
+	if miningReward >= 0 {
+		var (
+			blockReward = big.NewInt(miningReward)
+			minerReward = new(big.Int).Set(blockReward)
+			perOmmer    = new(big.Int).Div(blockReward, big.NewInt(32))
+		)
+		for _, ommer := range pre.Env.Ommers {
+			minerReward.Add(minerReward, perOmmer)
+
+			reward := big.NewInt(8)
+			reward.Sub(reward, new(big.Int).SetUint64(ommer.Delta))
+			reward.Mul(reward, blockReward)
+			reward.Div(reward, big.NewInt(8))
+			statedb.AddBalance(ommer.Address, reward)
+		}
+		statedb.AddBalance(pre.Env.Coinbase, minerReward)
+	}
+	// Commit the block state and assemble the execution results
+	root, err := statedb.Commit(chainConfig.IsEIP158(header.Number))
+	if err != nil {
+		return nil, nil, err
+	}
+	var logs []*types.Log
+	for _, receipt := range receipts {
+		logs = append(logs, receipt.Logs...)
+	}
+	execRs := &ExecutionResult{
+		StateRoot:   root,
+		TxRoot:      types.DeriveSha(included),
+		ReceiptRoot: types.DeriveSha(receipts),
+		LogsHash:    rlpHash(logs),
+		Bloom:       types.CreateBloom(receipts),
+		Receipts:    receipts,
+		Rejected:    rejected,
+	}
+	statedb, err = state.New(root, statedb.Database())
+	if err != nil {
+		return nil, nil, err
+	}
+	return statedb, execRs, nil
+}
+
+// MakePreState creates a state database containing the given accounts.
+func MakePreState(db ethdb.Database, accounts core.GenesisAlloc) *state.StateDB {
+	sdb := state.NewDatabase(db)
+	statedb, _ := state.New(common.Hash{}, sdb)
+	for addr, a := range accounts {
+		statedb.SetCode(addr, a.Code)
+		statedb.SetNonce(addr, a.Nonce)
+		statedb.SetBalance(addr, a.Balance)
+		for k, v := range a.Storage {
+			statedb.SetState(addr, k, v)
+		}
+	}
+	// Commit and re-open to start with a clean state.
+	root, _ := statedb.Commit(false)
+	statedb, _ = state.New(root, sdb)
+	return statedb
+}
+
+// DumpAlloc converts the entire state into a genesis allocation.
+func DumpAlloc(statedb *state.StateDB) core.GenesisAlloc {
+	alloc := make(core.GenesisAlloc)
+	for addr, account := range statedb.RawDump(false, false, true).Accounts {
+		balance, _ := new(big.Int).SetString(account.Balance, 10)
+		genesisAccount := core.GenesisAccount{
+			Code:    common.FromHex(account.Code),
+			Balance: balance,
+			Nonce:   account.Nonce,
+		}
+		if len(account.Storage) > 0 {
+			genesisAccount.Storage = make(map[common.Hash]common.Hash, len(account.Storage))
+			for key, value := range account.Storage {
+				genesisAccount.Storage[key] = common.HexToHash(value)
+			}
+		}
+		alloc[addr] = genesisAccount
+	}
+	return alloc
+}
+
+// rlpHash computes the Keccak256 hash of the RLP encoding of an object.
+func rlpHash(x interface{}) (h common.Hash) {
+	hw := sha3.NewLegacyKeccak256()
+	rlp.Encode(hw, x)
+	hw.Sum(h[:0])
+	return h
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/tests"
+)
+
+// Tests that a list of transactions is applied on top of a prestate, rejecting
+// the invalid ones, resolving block hashes from the environment and producing
+// a post-state alloc matching the reported state root.
+func TestApply(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	var (
+		sender    = crypto.PubkeyToAddress(key.PublicKey)
+		recipient = common.HexToAddress("0x00000000000000000000000000000000000000aa")
+		contract  = common.HexToAddress("0x00000000000000000000000000000000000000bb")
+		coinbase  = common.HexToAddress("0x00000000000000000000000000000000000000cc")
+		parent    = common.HexToHash("0xdeadbeef")
+		config    = tests.Forks["Istanbul"]
+		signer    = types.NewEIP155Signer(config.ChainID)
+	)
+	prestate := &Prestate{
+		Env: stEnv{
+			Coinbase:    coinbase,
+			Difficulty:  (*math.HexOrDecimal256)(big.NewInt(0x20000)),
+			GasLimit:    1000000,
+			Number:      5,
+			Timestamp:   1000,
+			BlockHashes: map[math.HexOrDecimal64]common.Hash{4: parent},
+		},
+		Pre: core.GenesisAlloc{
+			sender: {Balance: big.NewInt(1000000000)},
+			// The contract stores the hash of the parent block into slot 0
+			contract: {Balance: new(big.Int), Code: common.FromHex("0x60044060005500")},
+		},
+	}
+	sign := func(tx *types.Transaction) *types.Transaction {
+		signed, err := types.SignTx(tx, signer, key)
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		return signed
+	}
+	txs := types.Transactions{
+		sign(types.NewTransaction(0, recipient, big.NewInt(1000), 21000, big.NewInt(1), nil)),
+		sign(types.NewTransaction(0, recipient, big.NewInt(1000), 21000, big.NewInt(1), nil)), // nonce too low
+		sign(types.NewTransaction(1, contract, new(big.Int), 100000, big.NewInt(1), nil)),
+		sign(types.NewTransaction(2, recipient, big.NewInt(1000), 2000000, big.NewInt(1), nil)), // exceeds block gas limit
+	}
+	statedb, result, err := prestate.Apply(vm.Config{}, config, txs, 0, func(int, common.Hash) (vm.Tracer, error) { return nil, nil })
+	if err != nil {
+		t.Fatalf("failed to apply transactions: %v", err)
+	}
+	// Check the included and rejected transactions
+	if len(result.Receipts) != 2 {
+		t.Fatalf("receipt count mismatch: have %d, want 2", len(result.Receipts))
+	}
+	if len(result.Rejected) != 2 || result.Rejected[0].Index != 1 || result.Rejected[1].Index != 3 {
+		t.Fatalf("rejected transactions mismatch: %+v", result.Rejected)
+	}
+	if have, want := result.TxRoot, types.DeriveSha(types.Transactions{txs[0], txs[2]}); have != want {
+		t.Errorf("tx root mismatch: have %x, want %x", have, want)
+	}
+	// Check the post-state and that the dumped alloc reproduces its root
+	if have := statedb.GetState(contract, common.Hash{}); have != parent {
+		t.Errorf("block hash mismatch: have %x, want %x", have, parent)
+	}
+	alloc := DumpAlloc(statedb)
+	if have := alloc[recipient].Balance; have.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("recipient balance mismatch: have %v, want 1000", have)
+	}
+	if have := alloc[sender].Nonce; have != 2 {
+		t.Errorf("sender nonce mismatch: have %d, want 2", have)
+	}
+	fees := new(big.Int).SetUint64(result.Receipts[1].CumulativeGasUsed)
+	if have := alloc[coinbase].Balance; have.Cmp(fees) != 0 {
+		t.Errorf("coinbase balance mismatch: have %v, want %v", have, fees)
+	}
+	if root := MakePreState(rawdb.NewMemoryDatabase(), alloc).IntermediateRoot(false); root != result.StateRoot {
+		t.Errorf("alloc root mismatch: have %x, want %x", root, result.StateRoot)
+	}
+}
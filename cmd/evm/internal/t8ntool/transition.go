@@ -0,0 +1,235 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package t8ntool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/tests"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Exit codes of the state transition tool, allowing test generators to tell the
+// failure modes apart.
+const (
+	ErrorEVM      = 2
+	ErrorVMConfig = 3
+	ErrorJSON     = 10
+	ErrorIO       = 11
+)
+
+// Special file names selecting the standard streams for input and output.
+const (
+	stdinSelector  = "stdin"
+	stdoutSelector = "stdout"
+	stderrSelector = "stderr"
+)
+
+// NumberedError is an error carrying the exit code of the tool.
+type NumberedError struct {
+	errorCode int
+	err       error
+}
+
+// NewError creates an error with the given exit code.
+func NewError(errorCode int, err error) *NumberedError {
+	return &NumberedError{errorCode, err}
+}
+
+// Error implements error.
+func (n *NumberedError) Error() string {
+	return fmt.Sprintf("ERROR(%d): %v", n.errorCode, n.err.Error())
+}
+
+// Code returns the exit code of the error.
+func (n *NumberedError) Code() int {
+	return n.errorCode
+}
+
+// input is the combined input of the tool when read from stdin.
+type input struct {
+	Alloc core.GenesisAlloc  `json:"alloc,omitempty"`
+	Env   *stEnv             `json:"env,omitempty"`
+	Txs   types.Transactions `json:"txs,omitempty"`
+}
+
+// Main is the entry point of the t8n command, applying the input transactions
+// to the input prestate and writing out the post-state and execution results.
+func Main(ctx *cli.Context) error {
+	// Configure the go-ethereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.Int(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	// Configure the EVM logger, writing the trace of each transaction to a file
+	var (
+		getTracer = func(txIndex int, txHash common.Hash) (vm.Tracer, error) { return nil, nil }
+		traces    []*os.File
+	)
+	if ctx.Bool(TraceFlag.Name) {
+		logConfig := &vm.LogConfig{
+			DisableStack:  ctx.Bool(TraceDisableStackFlag.Name),
+			DisableMemory: ctx.Bool(TraceDisableMemoryFlag.Name),
+		}
+		getTracer = func(txIndex int, txHash common.Hash) (vm.Tracer, error) {
+			traceFile, err := os.Create(fmt.Sprintf("trace-%d-%v.jsonl", txIndex, txHash.String()))
+			if err != nil {
+				return nil, NewError(ErrorIO, fmt.Errorf("failed creating trace-file: %v", err))
+			}
+			traces = append(traces, traceFile)
+			return vm.NewJSONLogger(logConfig, traceFile), nil
+		}
+		defer func() {
+			for _, traceFile := range traces {
+				traceFile.Close()
+			}
+		}()
+	}
+	// We need to load three things: alloc, env and transactions. May be either in
+	// stdin input or in files. Check if anything needs to be read from stdin
+	var (
+		prestate  Prestate
+		allocStr  = ctx.String(InputAllocFlag.Name)
+		envStr    = ctx.String(InputEnvFlag.Name)
+		txStr     = ctx.String(InputTxsFlag.Name)
+		inputData = &input{}
+	)
+	if allocStr == stdinSelector || envStr == stdinSelector || txStr == stdinSelector {
+		decoder := json.NewDecoder(os.Stdin)
+		if err := decoder.Decode(inputData); err != nil {
+			return NewError(ErrorJSON, fmt.Errorf("failed unmarshaling stdin: %v", err))
+		}
+	}
+	if allocStr != stdinSelector {
+		if err := readJSONFile(allocStr, &inputData.Alloc); err != nil {
+			return err
+		}
+	}
+	prestate.Pre = inputData.Alloc
+
+	if envStr != stdinSelector {
+		var env stEnv
+		if err := readJSONFile(envStr, &env); err != nil {
+			return err
+		}
+		inputData.Env = &env
+	}
+	if inputData.Env == nil {
+		return NewError(ErrorJSON, fmt.Errorf("missing env"))
+	}
+	prestate.Env = *inputData.Env
+
+	if txStr != stdinSelector {
+		if err := readJSONFile(txStr, &inputData.Txs); err != nil {
+			return err
+		}
+	}
+	// Construct the chain config of the requested fork
+	forkName := ctx.String(ForknameFlag.Name)
+	config, ok := tests.Forks[forkName]
+	if !ok {
+		return NewError(ErrorVMConfig, tests.UnsupportedForkError{Name: forkName})
+	}
+	chainConfig := *config
+	chainConfig.ChainID = big.NewInt(ctx.Int64(ChainIDFlag.Name))
+
+	// Run the transactions and dispatch the outputs
+	state, result, err := prestate.Apply(vm.Config{}, &chainConfig, inputData.Txs, ctx.Int64(RewardFlag.Name), getTracer)
+	if err != nil {
+		if _, ok := err.(*NumberedError); ok {
+			return err
+		}
+		return NewError(ErrorEVM, err)
+	}
+	return dispatchOutput(ctx, DumpAlloc(state), result)
+}
+
+// readJSONFile decodes the contents of a JSON file into the given value.
+func readJSONFile(path string, v interface{}) error {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NewError(ErrorIO, fmt.Errorf("failed reading %v: %v", path, err))
+	}
+	if err := json.Unmarshal(blob, v); err != nil {
+		return NewError(ErrorJSON, fmt.Errorf("failed unmarshaling %v: %v", path, err))
+	}
+	return nil
+}
+
+// saveFile marshals the object to the given file
+func saveFile(filename string, data interface{}) error {
+	b, err := json.MarshalIndent(data, "", " ")
+	if err != nil {
+		return NewError(ErrorJSON, fmt.Errorf("failed marshalling output: %v", err))
+	}
+	if err = ioutil.WriteFile(filename, b, 0644); err != nil {
+		return NewError(ErrorIO, fmt.Errorf("failed writing output: %v", err))
+	}
+	return nil
+}
+
+// dispatchOutput writes the output data to either stderr or stdout, or to the
+// specified files. Outputs directed to the same stream are combined into a
+// single JSON object.
+func dispatchOutput(ctx *cli.Context, alloc core.GenesisAlloc, result *ExecutionResult) error {
+	stdOutObject := make(map[string]interface{})
+	stdErrObject := make(map[string]interface{})
+
+	dispatch := func(fName, name string, obj interface{}) error {
+		switch fName {
+		case stdoutSelector:
+			stdOutObject[name] = obj
+		case stderrSelector:
+			stdErrObject[name] = obj
+		default: // save to file
+			if err := saveFile(fName, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := dispatch(ctx.String(OutputAllocFlag.Name), "alloc", alloc); err != nil {
+		return err
+	}
+	if err := dispatch(ctx.String(OutputResultFlag.Name), "result", result); err != nil {
+		return err
+	}
+	if len(stdOutObject) > 0 {
+		b, err := json.MarshalIndent(stdOutObject, "", " ")
+		if err != nil {
+			return NewError(ErrorJSON, fmt.Errorf("failed marshalling output: %v", err))
+		}
+		os.Stdout.Write(b)
+	}
+	if len(stdErrObject) > 0 {
+		b, err := json.MarshalIndent(stdErrObject, "", " ")
+		if err != nil {
+			return NewError(ErrorJSON, fmt.Errorf("failed marshalling output: %v", err))
+		}
+		os.Stderr.Write(b)
+	}
+	return nil
+}
@@ -22,6 +22,7 @@ import (
 	"math/big"
 	"os"
 
+	"github.com/ethereum/go-ethereum/cmd/evm/internal/t8ntool"
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -44,6 +45,22 @@ var (
 		Name:  "cpuprofile",
 		Usage: "creates a CPU profile at the given path",
 	}
+	EVMProfileFlag = cli.StringFlag{
+		Name:  "evmprofile",
+		Usage: "creates a pprof profile of the gas spent by the executed EVM code at the given path",
+	}
+	CoverageFlag = cli.StringFlag{
+		Name:  "coverage",
+		Usage: "creates an lcov coverage report of the code sources at the given path (requires --srcmap)",
+	}
+	SourceMapFlag = cli.StringFlag{
+		Name:  "srcmap",
+		Usage: "file containing the Solidity source map of the code",
+	}
+	SourcesFlag = cli.StringFlag{
+		Name:  "sources",
+		Usage: "comma separated source files referenced by the source map, in index order",
+	}
 	StatDumpFlag = cli.BoolFlag{
 		Name:  "statdump",
 		Usage: "displays stack and heap memory information",
@@ -126,6 +143,27 @@ var (
 	}
 )
 
+var stateTransitionCommand = cli.Command{
+	Name:    "transition",
+	Aliases: []string{"t8n"},
+	Usage:   "executes a full state transition",
+	Action:  t8ntool.Main,
+	Flags: []cli.Flag{
+		t8ntool.TraceFlag,
+		t8ntool.TraceDisableMemoryFlag,
+		t8ntool.TraceDisableStackFlag,
+		t8ntool.OutputAllocFlag,
+		t8ntool.OutputResultFlag,
+		t8ntool.InputAllocFlag,
+		t8ntool.InputEnvFlag,
+		t8ntool.InputTxsFlag,
+		t8ntool.ForknameFlag,
+		t8ntool.ChainIDFlag,
+		t8ntool.RewardFlag,
+		t8ntool.VerbosityFlag,
+	},
+}
+
 func init() {
 	app.Flags = []cli.Flag{
 		BenchFlag,
@@ -142,6 +180,10 @@ func init() {
 		InputFileFlag,
 		MemProfileFlag,
 		CPUProfileFlag,
+		EVMProfileFlag,
+		CoverageFlag,
+		SourceMapFlag,
+		SourcesFlag,
 		StatDumpFlag,
 		GenesisFlag,
 		MachineFlag,
@@ -153,16 +195,22 @@ func init() {
 	}
 	app.Commands = []cli.Command{
 		compileCommand,
+		debugCommand,
 		disasmCommand,
 		runCommand,
 		stateTestCommand,
+		stateTransitionCommand,
 	}
 	cli.CommandHelpTemplate = utils.OriginCommandHelpTemplate
 }
 
 func main() {
 	if err := app.Run(os.Args); err != nil {
+		code := 1
+		if ec, ok := err.(*t8ntool.NumberedError); ok {
+			code = ec.Code()
+		}
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(code)
 	}
 }
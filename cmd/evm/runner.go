@@ -25,6 +25,7 @@ import (
 	"os"
 	goruntime "runtime"
 	"runtime/pprof"
+	"strings"
 	"testing"
 	"time"
 
@@ -97,6 +98,56 @@ func timedExec(bench bool, execFunc func() ([]byte, uint64, error)) ([]byte, uin
 	return output, gasLeft, execTime, err
 }
 
+// loadCode loads the code to execute from the flags, or compiles the EASM file
+// given as argument. No code is returned if neither is given.
+func loadCode(ctx *cli.Context) ([]byte, error) {
+	var code []byte
+	codeFileFlag := ctx.GlobalString(CodeFileFlag.Name)
+	codeFlag := ctx.GlobalString(CodeFlag.Name)
+
+	// The '--code' or '--codefile' flag overrides code in state
+	if codeFileFlag != "" || codeFlag != "" {
+		var hexcode []byte
+		if codeFileFlag != "" {
+			var err error
+			// If - is specified, it means that code comes from stdin
+			if codeFileFlag == "-" {
+				//Try reading from stdin
+				if hexcode, err = ioutil.ReadAll(os.Stdin); err != nil {
+					fmt.Printf("Could not load code from stdin: %v\n", err)
+					os.Exit(1)
+				}
+			} else {
+				// Codefile with hex assembly
+				if hexcode, err = ioutil.ReadFile(codeFileFlag); err != nil {
+					fmt.Printf("Could not load code from file: %v\n", err)
+					os.Exit(1)
+				}
+			}
+		} else {
+			hexcode = []byte(codeFlag)
+		}
+		hexcode = bytes.TrimSpace(hexcode)
+		if len(hexcode)%2 != 0 {
+			fmt.Printf("Invalid input length for hex data (%d)\n", len(hexcode))
+			os.Exit(1)
+		}
+		code = common.FromHex(string(hexcode))
+	} else if fn := ctx.Args().First(); len(fn) > 0 {
+		// EASM-file to compile
+		src, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		bin, err := compiler.Compile(fn, src, false)
+		if err != nil {
+			return nil, err
+		}
+		code = common.Hex2Bytes(bin)
+	}
+	return code, nil
+}
+
 func runCmd(ctx *cli.Context) error {
 	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
 	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
@@ -124,6 +175,16 @@ func runCmd(ctx *cli.Context) error {
 	} else {
 		debugLogger = vm.NewStructLogger(logconfig)
 	}
+	var profiler *vm.Profiler
+	if ctx.GlobalString(EVMProfileFlag.Name) != "" || ctx.GlobalString(CoverageFlag.Name) != "" {
+		if tracer != nil {
+			utils.Fatalf("Execution profiling can't be combined with tracing")
+		}
+		if ctx.GlobalString(CoverageFlag.Name) != "" && ctx.GlobalString(SourceMapFlag.Name) == "" {
+			utils.Fatalf("Coverage reports require a source map")
+		}
+		profiler = vm.NewProfiler()
+	}
 	if ctx.GlobalString(GenesisFlag.Name) != "" {
 		gen := readGenesis(ctx.GlobalString(GenesisFlag.Name))
 		genesisConfig = gen
@@ -144,49 +205,9 @@ func runCmd(ctx *cli.Context) error {
 		receiver = common.HexToAddress(ctx.GlobalString(ReceiverFlag.Name))
 	}
 
-	var code []byte
-	codeFileFlag := ctx.GlobalString(CodeFileFlag.Name)
-	codeFlag := ctx.GlobalString(CodeFlag.Name)
-
-	// The '--code' or '--codefile' flag overrides code in state
-	if codeFileFlag != "" || codeFlag != "" {
-		var hexcode []byte
-		if codeFileFlag != "" {
-			var err error
-			// If - is specified, it means that code comes from stdin
-			if codeFileFlag == "-" {
-				//Try reading from stdin
-				if hexcode, err = ioutil.ReadAll(os.Stdin); err != nil {
-					fmt.Printf("Could not load code from stdin: %v\n", err)
-					os.Exit(1)
-				}
-			} else {
-				// Codefile with hex assembly
-				if hexcode, err = ioutil.ReadFile(codeFileFlag); err != nil {
-					fmt.Printf("Could not load code from file: %v\n", err)
-					os.Exit(1)
-				}
-			}
-		} else {
-			hexcode = []byte(codeFlag)
-		}
-		hexcode = bytes.TrimSpace(hexcode)
-		if len(hexcode)%2 != 0 {
-			fmt.Printf("Invalid input length for hex data (%d)\n", len(hexcode))
-			os.Exit(1)
-		}
-		code = common.FromHex(string(hexcode))
-	} else if fn := ctx.Args().First(); len(fn) > 0 {
-		// EASM-file to compile
-		src, err := ioutil.ReadFile(fn)
-		if err != nil {
-			return err
-		}
-		bin, err := compiler.Compile(fn, src, false)
-		if err != nil {
-			return err
-		}
-		code = common.Hex2Bytes(bin)
+	code, err := loadCode(ctx)
+	if err != nil {
+		return err
 	}
 	initialGas := ctx.GlobalUint64(GasFlag.Name)
 	if genesisConfig.GasLimit != 0 {
@@ -209,6 +230,11 @@ func runCmd(ctx *cli.Context) error {
 		},
 	}
 
+	if profiler != nil {
+		runtimeConfig.EVMConfig.Debug = true
+		runtimeConfig.EVMConfig.Tracer = profiler
+	}
+
 	if cpuProfilePath := ctx.GlobalString(CPUProfileFlag.Name); cpuProfilePath != "" {
 		f, err := os.Create(cpuProfilePath)
 		if err != nil {
@@ -240,9 +266,13 @@ func runCmd(ctx *cli.Context) error {
 	}
 	input := common.FromHex(string(bytes.TrimSpace(hexInput)))
 
-	var execFunc func() ([]byte, uint64, error)
+	var (
+		execFunc func() ([]byte, uint64, error)
+		executed = code // Code executed, profiled for coverage
+	)
 	if ctx.GlobalBool(CreateFlag.Name) {
 		input = append(code, input...)
+		executed = input
 		execFunc = func() ([]byte, uint64, error) {
 			output, _, gasLeft, err := runtime.Create(input, &runtimeConfig)
 			return output, gasLeft, err
@@ -251,6 +281,7 @@ func runCmd(ctx *cli.Context) error {
 		if len(code) > 0 {
 			statedb.SetCode(receiver, code)
 		}
+		executed = statedb.GetCode(receiver)
 		execFunc = func() ([]byte, uint64, error) {
 			return runtime.Call(receiver, input, &runtimeConfig)
 		}
@@ -264,6 +295,13 @@ func runCmd(ctx *cli.Context) error {
 		fmt.Println(string(statedb.Dump(false, false, true)))
 	}
 
+	if profiler != nil {
+		if err := writeExecutionProfile(ctx, profiler, executed); err != nil {
+			fmt.Println("could not write execution profile: ", err)
+			os.Exit(1)
+		}
+	}
+
 	if memProfilePath := ctx.GlobalString(MemProfileFlag.Name); memProfilePath != "" {
 		f, err := os.Create(memProfilePath)
 		if err != nil {
@@ -307,3 +345,43 @@ Gas used:           %d
 
 	return nil
 }
+
+// writeExecutionProfile writes the pprof profile and the coverage report of the
+// executed code, as requested by the flags.
+func writeExecutionProfile(ctx *cli.Context, profiler *vm.Profiler, code []byte) error {
+	if path := ctx.GlobalString(EVMProfileFlag.Name); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if err := profiler.WriteProfile(f); err != nil {
+			return err
+		}
+	}
+	if path := ctx.GlobalString(CoverageFlag.Name); path != "" {
+		srcmap, err := ioutil.ReadFile(ctx.GlobalString(SourceMapFlag.Name))
+		if err != nil {
+			return err
+		}
+		var sources []vm.SourceFile
+		if names := ctx.GlobalString(SourcesFlag.Name); names != "" {
+			for _, name := range strings.Split(names, ",") {
+				content, err := ioutil.ReadFile(name)
+				if err != nil {
+					return err
+				}
+				sources = append(sources, vm.SourceFile{Name: name, Content: content})
+			}
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return profiler.WriteCoverage(f, code, string(bytes.TrimSpace(srcmap)), sources)
+	}
+	return nil
+}
@@ -28,6 +28,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/console"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
@@ -67,6 +68,8 @@ It expects the genesis file as argument.`,
 			utils.CacheFlag,
 			utils.SyncModeFlag,
 			utils.GCModeFlag,
+			utils.StateDiffLimitFlag,
+			utils.TxLookupLimitFlag,
 			utils.CacheDatabaseFlag,
 			utils.CacheGCFlag,
 		},
@@ -95,6 +98,48 @@ Optional second and third arguments control the first and
 last block to write. In this mode, the file will be appended
 if already existing. If the file ends with .gz, the output will
 be gzipped.`,
+	}
+	importAncientsCommand = cli.Command{
+		Action:    utils.MigrateFlags(importAncients),
+		Name:      "import-ancients",
+		Usage:     "Import ancient chain segments from an archive file",
+		ArgsUsage: "<filename> (<filename 2> ... <filename N>) ",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The import-ancients command writes the chain segments of one or more archive files,
+produced by export-ancients, directly into the ancient store of the database.
+
+The blocks are not executed, but every segment is verified against its checksum,
+the block headers and the total difficulties before being written. The database
+must be initialized with the same genesis block and must not contain any blocks
+beyond its ancient store. Archives must be imported in order.`,
+	}
+	exportAncientsCommand = cli.Command{
+		Action:    utils.MigrateFlags(exportAncients),
+		Name:      "export-ancients",
+		Usage:     "Export ancient chain segments into an archive file",
+		ArgsUsage: "<filename> [<blockNumFirst> <blockNumLast>]",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The export-ancients command writes the headers, bodies, receipts and total difficulties
+of the frozen chain segment straight from the ancient store into a self-verifying
+archive file.
+
+Requires a first argument of the file to write to. Optional second and third arguments
+control the first and last block to write, otherwise the entire ancient store is
+exported. If the file ends with .gz, the output will be gzipped.`,
 	}
 	importPreimagesCommand = cli.Command{
 		Action:    utils.MigrateFlags(importPreimages),
@@ -140,6 +185,30 @@ The export-preimages command export hash preimages to an RLP encoded stream`,
 		Category: "BLOCKCHAIN COMMANDS",
 		Description: `
 The first argument must be the directory containing the blockchain to download from`,
+	}
+	migratedbCommand = cli.Command{
+		Action:    utils.MigrateFlags(migrateDb),
+		Name:      "migratedb",
+		Usage:     "Copy the key-value store of the local chain into another database",
+		ArgsUsage: "<destinationChaindataDir> [<range>...]",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.CacheFlag,
+			utils.SyncModeFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The migratedb command copies the key-value store of the local chain database
+directly into the database at the destination directory, without re-syncing
+or re-executing anything. The ancient store is not touched, its directory can
+be copied or shared as is.
+
+Optional arguments after the destination select the key ranges to copy, one of
+"all" (default), "headers", "difficulties", "canonical", "numbers", "bodies",
+"receipts", "statediffs", "txlookups", "bloombits", "state", "preimages",
+"config" or "metadata". Interrupted copies resume where they left off, and
+every range is verified by entry count and content hash once copied.`,
 	}
 	removedbCommand = cli.Command{
 		Action:    utils.MigrateFlags(removeDB),
@@ -188,6 +257,25 @@ Use "ethereum dump 0" to dump the genesis block.`,
 		},
 		Category: "BLOCKCHAIN COMMANDS",
 	}
+	signerHistoryCommand = cli.Command{
+		Action:    utils.MigrateFlags(signerHistory),
+		Name:      "signerhistory",
+		Usage:     "Render the clique signer set timeline of the database",
+		ArgsUsage: " ",
+		Flags: []cli.Flag{
+			utils.DataDirFlag,
+			utils.AncientFlag,
+			utils.CacheFlag,
+			utils.RinkebyFlag,
+			utils.GoerliFlag,
+			utils.SyncModeFlag,
+		},
+		Category: "BLOCKCHAIN COMMANDS",
+		Description: `
+The signerhistory command replays the voting history of a clique proof-of-authority
+chain and prints every addition and removal of an authorized signer, starting
+with the genesis signers.`,
+	}
 )
 
 // initGenesis will initialise the given JSON format genesis file and writes it as
@@ -351,6 +439,65 @@ func exportChain(ctx *cli.Context) error {
 	return nil
 }
 
+// importAncients imports ancient chain archives straight into the freezer.
+func importAncients(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	stack := makeFullNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	start := time.Now()
+	for _, arg := range ctx.Args() {
+		if err := utils.ImportAncients(db, arg); err != nil {
+			utils.Fatalf("Import error: %v\n", err)
+		}
+	}
+	fmt.Printf("Import done in %v\n", time.Since(start))
+	return nil
+}
+
+// exportAncients dumps a frozen chain segment into an ancient archive file.
+func exportAncients(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		utils.Fatalf("This command requires an argument.")
+	}
+	if len(ctx.Args()) != 1 && len(ctx.Args()) != 3 {
+		utils.Fatalf("This command requires either a single argument or a file with a block range.")
+	}
+	stack := makeFullNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	frozen, err := db.Ancients()
+	if err != nil {
+		utils.Fatalf("Failed to retrieve ancient store size: %v", err)
+	}
+	if frozen == 0 {
+		utils.Fatalf("Ancient store is empty, nothing to export")
+	}
+	first, last := uint64(0), frozen-1
+	if len(ctx.Args()) == 3 {
+		var ferr, lerr error
+		first, ferr = strconv.ParseUint(ctx.Args().Get(1), 10, 64)
+		last, lerr = strconv.ParseUint(ctx.Args().Get(2), 10, 64)
+		if ferr != nil || lerr != nil {
+			utils.Fatalf("Export error in parsing parameters: block number not an integer\n")
+		}
+	}
+	start := time.Now()
+	if err := utils.ExportAncients(db, ctx.Args().First(), first, last, rawdb.DefaultArchiveSegmentSize); err != nil {
+		utils.Fatalf("Export error: %v\n", err)
+	}
+	fmt.Printf("Export done in %v\n", time.Since(start))
+	return nil
+}
+
 // importPreimages imports preimage data from the specified file.
 func importPreimages(ctx *cli.Context) error {
 	if len(ctx.Args()) < 1 {
@@ -409,7 +556,7 @@ func copyDb(ctx *cli.Context) error {
 	dl := downloader.New(0, chainDb, syncBloom, new(event.TypeMux), chain, nil, nil)
 
 	// Create a source peer to satisfy downloader requests from
-	db, err := rawdb.NewLevelDBDatabaseWithFreezer(ctx.Args().First(), ctx.GlobalInt(utils.CacheFlag.Name)/2, 256, ctx.Args().Get(1), "")
+	db, err := rawdb.NewLevelDBDatabaseWithFreezer(ctx.Args().First(), ctx.GlobalInt(utils.CacheFlag.Name)/2, 256, ctx.Args().Get(1), "", false)
 	if err != nil {
 		return err
 	}
@@ -443,6 +590,43 @@ func copyDb(ctx *cli.Context) error {
 	return nil
 }
 
+// migrateDb copies selected key ranges of the local chain database into another
+// database directory.
+func migrateDb(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		utils.Fatalf("Destination chaindata directory path argument missing")
+	}
+	ranges := []rawdb.KeyRange{rawdb.AllKeys}
+	if len(ctx.Args()) > 1 {
+		ranges = ranges[:0]
+		for _, name := range ctx.Args()[1:] {
+			r, err := rawdb.FindKeyRange(name)
+			if err != nil {
+				utils.Fatalf("Invalid key range: %v", err)
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	stack := makeFullNode(ctx)
+	defer stack.Close()
+
+	db := utils.MakeChainDatabase(ctx, stack)
+	defer db.Close()
+
+	dst, err := rawdb.NewLevelDBDatabase(ctx.Args().First(), ctx.GlobalInt(utils.CacheFlag.Name)/2, 256, "")
+	if err != nil {
+		utils.Fatalf("Failed to open destination database: %v", err)
+	}
+	defer dst.Close()
+
+	start := time.Now()
+	if err := utils.MigrateDatabase(db, dst, ranges); err != nil {
+		utils.Fatalf("Migration error: %v", err)
+	}
+	fmt.Printf("Database migration done in %v\n", time.Since(start))
+	return nil
+}
+
 func removeDB(ctx *cli.Context) error {
 	stack, config := makeConfigNode(ctx)
 
@@ -552,6 +736,39 @@ func inspect(ctx *cli.Context) error {
 	return rawdb.InspectDatabase(chainDb)
 }
 
+func signerHistory(ctx *cli.Context) error {
+	node, _ := makeConfigNode(ctx)
+	defer node.Close()
+
+	chain, chainDb := utils.MakeChain(ctx, node)
+	defer chainDb.Close()
+
+	engine, ok := chain.Engine().(*clique.Clique)
+	if !ok {
+		utils.Fatalf("Chain is not using clique proof-of-authority")
+	}
+	changes, err := engine.SignerHistory(chain)
+	if err != nil {
+		utils.Fatalf("Failed to replay voting history: %v", err)
+	}
+	fmt.Printf("%-10s %-12s %-44s %s\n", "BLOCK", "HASH", "SIGNER", "SIGNERS")
+	for _, change := range changes {
+		op := "+"
+		if !change.Authorized {
+			op = "-"
+		}
+		fmt.Printf("%-10d %-12s %s %s %d\n", change.Block, change.Hash.TerminalString(), op, change.Address.Hex(), len(change.Signers))
+	}
+	if len(changes) > 0 {
+		fmt.Println()
+		fmt.Println("Current signers:")
+		for _, signer := range changes[len(changes)-1].Signers {
+			fmt.Printf("  %s\n", signer.Hex())
+		}
+	}
+	return nil
+}
+
 // hashish returns true for strings that look like hashes.
 func hashish(x string) bool {
 	_, err := strconv.Atoi(x)
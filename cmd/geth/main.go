@@ -65,6 +65,8 @@ var (
 		utils.BootnodesV5Flag,
 		utils.DataDirFlag,
 		utils.AncientFlag,
+		utils.SecondaryFlag,
+		utils.SecondaryRefreshFlag,
 		utils.KeyStoreDirFlag,
 		utils.ExternalSignerFlag,
 		utils.NoUSBFlag,
@@ -77,10 +79,15 @@ var (
 		utils.EthashDatasetDirFlag,
 		utils.EthashDatasetsInMemoryFlag,
 		utils.EthashDatasetsOnDiskFlag,
+		utils.EthashStratumFlag,
+		utils.EthashStratumDiffFlag,
 		utils.TxPoolLocalsFlag,
 		utils.TxPoolNoLocalsFlag,
 		utils.TxPoolJournalFlag,
 		utils.TxPoolRejournalFlag,
+		utils.TxPoolRemoteJournalFlag,
+		utils.TxPoolRemoteJournalLimitFlag,
+		utils.TxPoolRulesFlag,
 		utils.TxPoolPriceLimitFlag,
 		utils.TxPoolPriceBumpFlag,
 		utils.TxPoolAccountSlotsFlag,
@@ -91,6 +98,8 @@ var (
 		utils.SyncModeFlag,
 		utils.ExitWhenSyncedFlag,
 		utils.GCModeFlag,
+		utils.StateDiffLimitFlag,
+		utils.TxLookupLimitFlag,
 		utils.LightServeFlag,
 		utils.LightLegacyServFlag,
 		utils.LightIngressFlag,
@@ -107,6 +116,7 @@ var (
 		utils.CacheTrieFlag,
 		utils.CacheGCFlag,
 		utils.CacheNoPrefetchFlag,
+		utils.ParallelExecFlag,
 		utils.ListenPortFlag,
 		utils.MaxPeersFlag,
 		utils.MaxPendingPeersFlag,
@@ -125,6 +135,9 @@ var (
 		utils.MinerLegacyExtraDataFlag,
 		utils.MinerRecommitIntervalFlag,
 		utils.MinerNoVerfiyFlag,
+		utils.MinerWarnMissedFlag,
+		utils.MinerOrderingFlag,
+		utils.MinerPriorityFlag,
 		utils.NATFlag,
 		utils.NoDiscoverFlag,
 		utils.DiscoveryV5Flag,
@@ -200,12 +213,16 @@ func init() {
 		initCommand,
 		importCommand,
 		exportCommand,
+		importAncientsCommand,
+		exportAncientsCommand,
 		importPreimagesCommand,
 		exportPreimagesCommand,
 		copydbCommand,
+		migratedbCommand,
 		removedbCommand,
 		dumpCommand,
 		inspectCommand,
+		signerHistoryCommand,
 		// See accountcmd.go:
 		accountCommand,
 		walletCommand,
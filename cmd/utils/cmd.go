@@ -237,6 +237,81 @@ func ExportAppendChain(blockchain *core.BlockChain, fn string, first uint64, las
 	return nil
 }
 
+// ImportAncients imports a self-verifying ancient chain archive straight into
+// the freezer of the database, without executing the contained blocks.
+func ImportAncients(db ethdb.Database, fn string) error {
+	log.Info("Importing ancient chain segments", "file", fn)
+
+	// Open the file handle and potentially unwrap the gzip stream
+	fh, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var reader io.Reader = fh
+	if strings.HasSuffix(fn, ".gz") {
+		if reader, err = gzip.NewReader(reader); err != nil {
+			return err
+		}
+	}
+	_, err = rawdb.ImportAncients(db, reader)
+	return err
+}
+
+// ExportAncients exports the frozen chain segment [first, last] into the specified
+// file as a self-verifying ancient archive, truncating any data already present
+// in the file.
+func ExportAncients(db ethdb.Database, fn string, first uint64, last uint64, segment uint64) error {
+	log.Info("Exporting ancient chain segments", "file", fn)
+
+	// Open the file handle and potentially wrap with a gzip stream
+	fh, err := os.OpenFile(fn, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	var writer io.Writer = fh
+	if strings.HasSuffix(fn, ".gz") {
+		writer = gzip.NewWriter(writer)
+		defer writer.(*gzip.Writer).Close()
+	}
+	if err := rawdb.ExportAncients(db, writer, first, last, segment); err != nil {
+		return err
+	}
+	log.Info("Exported ancient chain segments", "file", fn)
+	return nil
+}
+
+// MigrateDatabase copies the given key ranges from one key-value store into the
+// other, verifying each range after the copy. Interrupting the migration stops
+// it at the next batch, leaving resume markers in the destination.
+func MigrateDatabase(src ethdb.KeyValueStore, dst ethdb.KeyValueStore, ranges []rawdb.KeyRange) error {
+	// Watch for Ctrl-C while the migration is running.
+	interrupt := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	defer close(interrupt)
+	go func() {
+		if _, ok := <-interrupt; ok {
+			log.Info("Interrupted during migration, stopping at next batch")
+		}
+		close(stop)
+	}()
+	for _, r := range ranges {
+		log.Info("Migrating key range", "range", r.Name)
+		if _, _, err := rawdb.CopyKeyRange(src, dst, r, stop); err != nil {
+			return err
+		}
+		if err := rawdb.VerifyKeyRange(src, dst, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ImportPreimages imports a batch of exported hash preimages into the database.
 func ImportPreimages(db ethdb.Database, fn string) error {
 	log.Info("Importing preimages", "file", fn)
@@ -37,6 +37,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/fdlimit"
 	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/bft"
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
@@ -147,6 +148,15 @@ var (
 		Name:  "datadir.ancient",
 		Usage: "Data directory for ancient chain segments (default = inside chaindata)",
 	}
+	SecondaryFlag = DirectoryFlag{
+		Name:  "datadir.secondary",
+		Usage: "Data directory of a running node to serve read-only over RPC (disables networking and mining)",
+	}
+	SecondaryRefreshFlag = cli.DurationFlag{
+		Name:  "datadir.secondary.refresh",
+		Usage: "Time interval to follow the chain of the secondary data directory (0 = only via admin_refreshDatabase)",
+		Value: 0,
+	}
 	KeyStoreDirFlag = DirectoryFlag{
 		Name:  "keystore",
 		Usage: "Directory for the keystore (default = inside the datadir)",
@@ -222,9 +232,19 @@ var (
 	}
 	GCModeFlag = cli.StringFlag{
 		Name:  "gcmode",
-		Usage: `Blockchain garbage collection mode ("full", "archive")`,
+		Usage: `Blockchain garbage collection mode ("full", "archive", "archive-lite")`,
 		Value: "full",
 	}
+	TxLookupLimitFlag = cli.Uint64Flag{
+		Name:  "txlookuplimit",
+		Usage: "Number of recent blocks to maintain transactions index by-hash for (default = index all blocks)",
+		Value: 0,
+	}
+	StateDiffLimitFlag = cli.Uint64Flag{
+		Name:  "statedifflimit",
+		Usage: "Number of recent blocks to keep reverse state diffs for in archive-lite mode (default = keep all blocks)",
+		Value: 0,
+	}
 	LightKDFFlag = cli.BoolFlag{
 		Name:  "lightkdf",
 		Usage: "Reduce key-derivation RAM & CPU usage at some expense of KDF strength",
@@ -316,6 +336,14 @@ var (
 		Usage: "Number of recent ethash mining DAGs to keep on disk (1+GB each)",
 		Value: eth.DefaultConfig.Ethash.DatasetsOnDisk,
 	}
+	EthashStratumFlag = cli.StringFlag{
+		Name:  "ethash.stratum",
+		Usage: "Listening address of the stratum server for remote miners (disabled if empty)",
+	}
+	EthashStratumDiffFlag = cli.Uint64Flag{
+		Name:  "ethash.stratumdiff",
+		Usage: "Share difficulty of the stratum miners (0 = block difficulty)",
+	}
 	// Transaction pool settings
 	TxPoolLocalsFlag = cli.StringFlag{
 		Name:  "txpool.locals",
@@ -330,6 +358,19 @@ var (
 		Usage: "Disk journal for local transaction to survive node restarts",
 		Value: core.DefaultTxPoolConfig.Journal,
 	}
+	TxPoolRemoteJournalFlag = cli.StringFlag{
+		Name:  "txpool.remotejournal",
+		Usage: "Disk journal for remote transactions to survive node restarts (disabled if empty)",
+	}
+	TxPoolRemoteJournalLimitFlag = cli.Uint64Flag{
+		Name:  "txpool.remotejournallimit",
+		Usage: "Maximum size of the remote transaction journal in bytes",
+		Value: core.DefaultTxPoolConfig.RemoteJournalLimit,
+	}
+	TxPoolRulesFlag = cli.StringFlag{
+		Name:  "txpool.rules",
+		Usage: "JSON file of transaction admission rules (blacklist, sender rate limit, destination gas prices)",
+	}
 	TxPoolRejournalFlag = cli.DurationFlag{
 		Name:  "txpool.rejournal",
 		Usage: "Time interval to regenerate the local transaction journal",
@@ -395,6 +436,10 @@ var (
 		Name:  "cache.noprefetch",
 		Usage: "Disable heuristic state prefetch during block import (less CPU and disk IO, more time waiting for data)",
 	}
+	ParallelExecFlag = cli.BoolFlag{
+		Name:  "exec.parallel",
+		Usage: "Execute block transactions speculatively in parallel during import (more CPU, experimental)",
+	}
 	// Miner settings
 	MiningEnabledFlag = cli.BoolFlag{
 		Name:  "mine",
@@ -466,6 +511,19 @@ var (
 		Name:  "miner.noverify",
 		Usage: "Disable remote sealing verification",
 	}
+	MinerWarnMissedFlag = cli.BoolFlag{
+		Name:  "miner.warnmissed",
+		Usage: "Warn when the local clique signer misses its in-turn slot",
+	}
+	MinerOrderingFlag = cli.StringFlag{
+		Name:  "miner.ordering",
+		Usage: `Transaction ordering policy of mined blocks ("price", "fifo", "fairshare" or "priority")`,
+		Value: eth.DefaultConfig.Miner.Ordering,
+	}
+	MinerPriorityFlag = cli.StringFlag{
+		Name:  "miner.priority",
+		Usage: "Comma separated accounts whose transactions are included first by the priority ordering",
+	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -1173,6 +1231,7 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 	setWS(ctx, cfg)
 	setNodeUserIdent(ctx, cfg)
 	setDataDir(ctx, cfg)
+	setSecondary(ctx, cfg)
 	setSmartCard(ctx, cfg)
 
 	if ctx.GlobalIsSet(ExternalSignerFlag.Name) {
@@ -1228,6 +1287,22 @@ func setDataDir(ctx *cli.Context, cfg *node.Config) {
 	}
 }
 
+// setSecondary configures the node to run on top of the chain database of another
+// live node, disabling all networking since the chain can't be modified.
+func setSecondary(ctx *cli.Context, cfg *node.Config) {
+	if !ctx.GlobalIsSet(SecondaryFlag.Name) {
+		return
+	}
+	cfg.SecondaryDataDir = ctx.GlobalString(SecondaryFlag.Name)
+	if cfg.DataDir == cfg.SecondaryDataDir {
+		Fatalf("--%s must differ from --%s", SecondaryFlag.Name, DataDirFlag.Name)
+	}
+	cfg.P2P.MaxPeers = 0
+	cfg.P2P.ListenAddr = ""
+	cfg.P2P.NoDiscovery = true
+	cfg.P2P.DiscoveryV5 = false
+}
+
 func setGPO(ctx *cli.Context, cfg *gasprice.Config) {
 	if ctx.GlobalIsSet(GpoBlocksFlag.Name) {
 		cfg.Blocks = ctx.GlobalInt(GpoBlocksFlag.Name)
@@ -1254,6 +1329,15 @@ func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	if ctx.GlobalIsSet(TxPoolJournalFlag.Name) {
 		cfg.Journal = ctx.GlobalString(TxPoolJournalFlag.Name)
 	}
+	if ctx.GlobalIsSet(TxPoolRemoteJournalFlag.Name) {
+		cfg.RemoteJournal = ctx.GlobalString(TxPoolRemoteJournalFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPoolRemoteJournalLimitFlag.Name) {
+		cfg.RemoteJournalLimit = ctx.GlobalUint64(TxPoolRemoteJournalLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPoolRulesFlag.Name) {
+		cfg.Rules = ctx.GlobalString(TxPoolRulesFlag.Name)
+	}
 	if ctx.GlobalIsSet(TxPoolRejournalFlag.Name) {
 		cfg.Rejournal = ctx.GlobalDuration(TxPoolRejournalFlag.Name)
 	}
@@ -1299,6 +1383,12 @@ func setEthash(ctx *cli.Context, cfg *eth.Config) {
 	if ctx.GlobalIsSet(EthashDatasetsOnDiskFlag.Name) {
 		cfg.Ethash.DatasetsOnDisk = ctx.GlobalInt(EthashDatasetsOnDiskFlag.Name)
 	}
+	if ctx.GlobalIsSet(EthashStratumFlag.Name) {
+		cfg.Ethash.StratumAddr = ctx.GlobalString(EthashStratumFlag.Name)
+	}
+	if ctx.GlobalIsSet(EthashStratumDiffFlag.Name) {
+		cfg.Ethash.StratumDifficulty = ctx.GlobalUint64(EthashStratumDiffFlag.Name)
+	}
 }
 
 func setMiner(ctx *cli.Context, cfg *miner.Config) {
@@ -1332,6 +1422,24 @@ func setMiner(ctx *cli.Context, cfg *miner.Config) {
 	if ctx.GlobalIsSet(MinerNoVerfiyFlag.Name) {
 		cfg.Noverify = ctx.Bool(MinerNoVerfiyFlag.Name)
 	}
+	if ctx.GlobalIsSet(MinerWarnMissedFlag.Name) {
+		cfg.WarnMissed = ctx.GlobalBool(MinerWarnMissedFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerOrderingFlag.Name) {
+		cfg.Ordering = ctx.GlobalString(MinerOrderingFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerPriorityFlag.Name) {
+		for _, account := range strings.Split(ctx.GlobalString(MinerPriorityFlag.Name), ",") {
+			if trimmed := strings.TrimSpace(account); !common.IsHexAddress(trimmed) {
+				Fatalf("Invalid account in --miner.priority: %s", trimmed)
+			} else {
+				cfg.PriorityAddresses = append(cfg.PriorityAddresses, common.HexToAddress(trimmed))
+			}
+		}
+	}
+	if _, err := miner.NewTransactionOrdering(cfg); err != nil {
+		Fatalf("Invalid --miner.ordering: %v", err)
+	}
 }
 
 func setWhitelist(ctx *cli.Context, cfg *eth.Config) {
@@ -1417,6 +1525,10 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 	CheckExclusive(ctx, DeveloperFlag, TestnetFlag, RinkebyFlag, GoerliFlag)
 	CheckExclusive(ctx, LightLegacyServFlag, LightServeFlag, SyncModeFlag, "light")
 	CheckExclusive(ctx, DeveloperFlag, ExternalSignerFlag) // Can't use both ephemeral unlocked and external signer
+	CheckExclusive(ctx, SecondaryFlag, DeveloperFlag)
+	CheckExclusive(ctx, SecondaryFlag, MiningEnabledFlag)
+	CheckExclusive(ctx, SecondaryFlag, LightServeFlag)
+	CheckExclusive(ctx, SecondaryFlag, SyncModeFlag, "light")
 
 	var ks *keystore.KeyStore
 	if keystores := stack.AccountManager().Backends(keystore.KeyStoreType); len(keystores) > 0 {
@@ -1444,15 +1556,29 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *eth.Config) {
 		cfg.DatabaseFreezer = ctx.GlobalString(AncientFlag.Name)
 	}
 
-	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
-		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
+	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" && gcmode != "archive-lite" {
+		Fatalf("--%s must be either 'full', 'archive' or 'archive-lite'", GCModeFlag.Name)
 	}
 	if ctx.GlobalIsSet(GCModeFlag.Name) {
 		cfg.NoPruning = ctx.GlobalString(GCModeFlag.Name) == "archive"
+		cfg.StateDiffs = ctx.GlobalString(GCModeFlag.Name) == "archive-lite"
+	}
+	if ctx.GlobalIsSet(StateDiffLimitFlag.Name) {
+		cfg.StateDiffLimit = ctx.GlobalUint64(StateDiffLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxLookupLimitFlag.Name) {
+		cfg.TxLookupLimit = ctx.GlobalUint64(TxLookupLimitFlag.Name)
+	}
+	if ctx.GlobalIsSet(SecondaryFlag.Name) {
+		cfg.ReadOnly = true
+		cfg.ReadOnlyRefresh = ctx.GlobalDuration(SecondaryRefreshFlag.Name)
 	}
 	if ctx.GlobalIsSet(CacheNoPrefetchFlag.Name) {
 		cfg.NoPrefetch = ctx.GlobalBool(CacheNoPrefetchFlag.Name)
 	}
+	if ctx.GlobalIsSet(ParallelExecFlag.Name) {
+		cfg.ParallelExec = ctx.GlobalBool(ParallelExecFlag.Name)
+	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cfg.TrieCleanCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
 	}
@@ -1674,6 +1800,8 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 	var engine consensus.Engine
 	if config.Clique != nil {
 		engine = clique.New(config.Clique, chainDb)
+	} else if config.BFT != nil {
+		engine = bft.New(config.BFT, chainDb)
 	} else {
 		engine = ethash.NewFaker()
 		if !ctx.GlobalBool(FakePoWFlag.Name) {
@@ -1687,8 +1815,8 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 			}, nil, false)
 		}
 	}
-	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
-		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
+	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" && gcmode != "archive-lite" {
+		Fatalf("--%s must be either 'full', 'archive' or 'archive-lite'", GCModeFlag.Name)
 	}
 	cache := &core.CacheConfig{
 		TrieCleanLimit:      eth.DefaultConfig.TrieCleanCache,
@@ -1696,6 +1824,10 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		TrieDirtyLimit:      eth.DefaultConfig.TrieDirtyCache,
 		TrieDirtyDisabled:   ctx.GlobalString(GCModeFlag.Name) == "archive",
 		TrieTimeLimit:       eth.DefaultConfig.TrieTimeout,
+		ReverseDiffs:        ctx.GlobalString(GCModeFlag.Name) == "archive-lite",
+		ReverseDiffLimit:    ctx.GlobalUint64(StateDiffLimitFlag.Name),
+		TxLookupLimit:       ctx.GlobalUint64(TxLookupLimitFlag.Name),
+		ParallelExec:        ctx.GlobalBool(ParallelExecFlag.Name),
 	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cache.TrieCleanLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
@@ -0,0 +1,852 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package bft implements a proof-of-authority consensus engine with byzantine
+// fault tolerant finality.
+//
+// Blocks are agreed upon by the validators in rounds of a three phase protocol
+// (pre-prepare, prepare and commit) run over a dedicated devp2p subprotocol. A
+// block is final as soon as 2f+1 out of the 3f+1 validators signed a commit for
+// it. The commit signatures of a block are included in the header of its child,
+// making the finality of every non-head block verifiable by any node.
+package bft
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/big"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	lru "github.com/hashicorp/golang-lru"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	checkpointInterval = 1024 // Number of blocks after which to save the vote snapshot to the database
+	inmemorySnapshots  = 128  // Number of recent vote snapshots to keep in memory
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
+	inmemoryCommits    = 128  // Number of recent commit signature sets to keep in memory
+
+	defaultRequestTimeout = 10 * time.Second // Default time to wait for a round to commit
+)
+
+// BFT proof-of-authority protocol constants.
+var (
+	epochLength = uint64(30000) // Default number of blocks after which to checkpoint and reset the pending votes
+
+	extraVanity = 32 // Fixed number of extra-data prefix bytes reserved for validator vanity
+
+	nonceAuthVote = hexutil.MustDecode("0xffffffffffffffff") // Magic nonce number to vote on adding a new validator
+	nonceDropVote = hexutil.MustDecode("0x0000000000000000") // Magic nonce number to vote on removing a validator
+
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+
+	blockDifficulty = big.NewInt(1) // Block difficulty, all finalized blocks weigh the same
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the list of validators is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errInvalidCheckpointBeneficiary is returned if a checkpoint/epoch transition
+	// block has a beneficiary set to non-zeroes.
+	errInvalidCheckpointBeneficiary = errors.New("beneficiary in checkpoint block non-zero")
+
+	// errInvalidVote is returned if a nonce value is something else that the two
+	// allowed constants of 0x00..0 or 0xff..f.
+	errInvalidVote = errors.New("vote nonce not 0x00..0 or 0xff..f")
+
+	// errInvalidCheckpointVote is returned if a checkpoint/epoch transition block
+	// has a vote nonce set to non-zeroes.
+	errInvalidCheckpointVote = errors.New("vote nonce in checkpoint block non-zero")
+
+	// errMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the validator vanity.
+	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// errInvalidExtraData is returned if a block's extra-data section after the
+	// vanity is not a valid consensus data encoding.
+	errInvalidExtraData = errors.New("invalid consensus extra-data")
+
+	// errExtraValidators is returned if non-checkpoint block contain validator
+	// data in their extra-data fields.
+	errExtraValidators = errors.New("non-checkpoint block contains extra validator list")
+
+	// errMismatchingCheckpointValidators is returned if a checkpoint block contains
+	// a list of validators different than the one the local node calculated.
+	errMismatchingCheckpointValidators = errors.New("mismatching validator list on checkpoint block")
+
+	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
+	errInvalidMixDigest = errors.New("non-zero mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not 1.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// ErrInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period.
+	ErrInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errInvalidVotingChain is returned if an authorization list is attempted to
+	// be modified via out-of-range or non-contiguous headers.
+	errInvalidVotingChain = errors.New("invalid voting chain")
+
+	// errUnauthorizedValidator is returned if a header is sealed or committed by
+	// a non-authorized entity.
+	errUnauthorizedValidator = errors.New("unauthorized validator")
+
+	// errUnexpectedCommit is returned if the first block after genesis carries
+	// commit signatures, even though the genesis block is final by definition.
+	errUnexpectedCommit = errors.New("unexpected commit signatures")
+
+	// errDuplicateCommit is returned if a header carries two commit signatures
+	// of the same validator for its parent.
+	errDuplicateCommit = errors.New("duplicate commit signature")
+
+	// errInsufficientCommit is returned if a header carries less commit signatures
+	// for its parent than required to finalize it.
+	errInsufficientCommit = errors.New("insufficient commit signatures")
+
+	// errNotRunning is returned if the consensus protocol is required, but it was
+	// not started yet.
+	errNotRunning = errors.New("consensus not running")
+)
+
+// SignerFn is a signer callback function to request a hash to be signed by a
+// backing account.
+type SignerFn func(accounts.Account, string, []byte) ([]byte, error)
+
+// Extra is the consensus data stored in the header extra-data after the vanity.
+type Extra struct {
+	Validators []common.Address // Validator list on checkpoint blocks, empty otherwise
+	Commit     [][]byte         // Commit signatures of the parent block
+	Seal       []byte           // Proposer signature over the rest of the header
+}
+
+// ExtractExtra retrieves the consensus data from the extra-data of a header.
+func ExtractExtra(header *types.Header) (*Extra, error) {
+	if len(header.Extra) < extraVanity {
+		return nil, errMissingVanity
+	}
+	extra := new(Extra)
+	if err := rlp.DecodeBytes(header.Extra[extraVanity:], extra); err != nil {
+		return nil, errInvalidExtraData
+	}
+	return extra, nil
+}
+
+// encodeExtra assembles the extra-data of a header from the given vanity and
+// consensus data.
+func encodeExtra(vanity []byte, extra *Extra) ([]byte, error) {
+	blob, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, extraVanity)
+	copy(prefix, vanity)
+
+	return append(prefix, blob...), nil
+}
+
+// GenesisExtra assembles the extra-data of a genesis block which configures the
+// given initial validators.
+func GenesisExtra(vanity []byte, validators []common.Address) []byte {
+	sorted := make([]common.Address, len(validators))
+	copy(sorted, validators)
+	sort.Sort(validatorsAscending(sorted))
+
+	extra, err := encodeExtra(vanity, &Extra{Validators: sorted})
+	if err != nil {
+		panic("can't encode: " + err.Error())
+	}
+	return extra
+}
+
+// ecrecover extracts the Ethereum account address of the proposer from a
+// sealed header.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	// Retrieve the signature from the header extra-data
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	// Recover the public key and the Ethereum address
+	pubkey, err := crypto.Ecrecover(SealHash(header).Bytes(), extra.Seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var signer common.Address
+	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
+
+	sigcache.Add(hash, signer)
+	return signer, nil
+}
+
+// commitHash returns the hash the validators sign to commit to a block.
+func commitHash(hash common.Hash) common.Hash {
+	return crypto.Keccak256Hash(CommitData(hash))
+}
+
+// CommitData returns the bytes which need to be signed by a validator to commit
+// to the block with the given hash.
+func CommitData(hash common.Hash) []byte {
+	return append(hash.Bytes(), byte(msgCommit))
+}
+
+// BFT is the proof-of-authority consensus engine with byzantine fault tolerant
+// finality.
+type BFT struct {
+	config *params.BFTConfig // Consensus engine configuration parameters
+	db     ethdb.Database    // Database to store and retrieve snapshot checkpoints
+
+	recents    *lru.ARCCache // Snapshots for recent block to speed up reorgs
+	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
+	commits    *lru.ARCCache // Commit signatures of recently finalized blocks
+
+	proposals map[common.Address]bool // Current list of proposals we are pushing
+
+	signer common.Address // Ethereum address of the signing key
+	signFn SignerFn       // Signer function to authorize hashes with
+	lock   sync.RWMutex   // Protects the signer fields
+
+	handler atomic.Value // Consensus protocol handler once the engine is started
+	startMu sync.Mutex   // Serializes starting and closing the engine
+}
+
+// New creates a BFT proof-of-authority consensus engine with the initial
+// validators set to the ones in the genesis block.
+func New(config *params.BFTConfig, db ethdb.Database) *BFT {
+	// Set any missing consensus parameters to their defaults
+	conf := *config
+	if conf.Epoch == 0 {
+		conf.Epoch = epochLength
+	}
+	// Allocate the snapshot caches and create the engine
+	recents, _ := lru.NewARC(inmemorySnapshots)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+	commits, _ := lru.NewARC(inmemoryCommits)
+
+	return &BFT{
+		config:     &conf,
+		db:         db,
+		recents:    recents,
+		signatures: signatures,
+		commits:    commits,
+		proposals:  make(map[common.Address]bool),
+	}
+}
+
+// requestTimeout returns the time to wait in the first round of a height for a
+// block to be committed before moving on to the next round.
+func (b *BFT) requestTimeout() time.Duration {
+	if b.config.RequestTimeout == 0 {
+		return defaultRequestTimeout
+	}
+	return time.Duration(b.config.RequestTimeout) * time.Millisecond
+}
+
+// Author implements consensus.Engine, returning the Ethereum address recovered
+// from the proposer seal in the header's extra-data section.
+func (b *BFT) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, b.signatures)
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (b *BFT) VerifyHeader(chain consensus.ChainReader, header *types.Header, seal bool) error {
+	return b.verifyHeader(chain, header, nil)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (b *BFT) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort := make(chan struct{})
+	results := make(chan error, len(headers))
+
+	go func() {
+		for i, header := range headers {
+			err := b.verifyHeader(chain, header, headers[:i])
+
+			select {
+			case <-abort:
+				return
+			case results <- err:
+			}
+		}
+	}()
+	return abort, results
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database. This is useful for concurrently verifying
+// a batch of new headers.
+func (b *BFT) verifyHeader(chain consensus.ChainReader, header *types.Header, parents []*types.Header) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	if header.Time > uint64(time.Now().Unix()) {
+		return consensus.ErrFutureBlock
+	}
+	// Checkpoint blocks need to enforce zero beneficiary
+	checkpoint := (number % b.config.Epoch) == 0
+	if checkpoint && header.Coinbase != (common.Address{}) {
+		return errInvalidCheckpointBeneficiary
+	}
+	// Nonces must be 0x00..0 or 0xff..f, zeroes enforced on checkpoints
+	if !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidVote
+	}
+	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidCheckpointVote
+	}
+	// Ensure that the extra-data contains the consensus data, validators only on checkpoints
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return err
+	}
+	if !checkpoint && len(extra.Validators) != 0 {
+		return errExtraValidators
+	}
+	// Ensure that the mix digest is zero as we don't have fork protection currently
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	// Ensure that the block doesn't contain any uncles which are meaningless in PoA
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	// Ensure that the block's difficulty is the constant one
+	if number > 0 && (header.Difficulty == nil || header.Difficulty.Cmp(blockDifficulty) != 0) {
+		return errInvalidDifficulty
+	}
+	// If all checks passed, validate any special fields for hard forks
+	if err := misc.VerifyForkHashes(chain.Config(), header, false); err != nil {
+		return err
+	}
+	// All basic checks passed, verify cascading fields
+	return b.verifyCascadingFields(chain, header, extra, parents)
+}
+
+// verifyCascadingFields verifies all the header fields that are not standalone,
+// rather depend on a batch of previous headers. The caller may optionally pass
+// in a batch of parents (ascending order) to avoid looking those up from the
+// database. This is useful for concurrently verifying a batch of new headers.
+func (b *BFT) verifyCascadingFields(chain consensus.ChainReader, header *types.Header, extra *Extra, parents []*types.Header) error {
+	// The genesis block is the always valid dead-end
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	// Ensure that the block's timestamp isn't too close to its parent
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time+b.config.Period > header.Time {
+		return ErrInvalidTimestamp
+	}
+	// Retrieve the snapshot needed to verify this header and cache it
+	snap, err := b.snapshot(chain, number-1, header.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	// If the block is a checkpoint block, verify the validator list
+	if number%b.config.Epoch == 0 {
+		validators := snap.validators()
+		if len(extra.Validators) != len(validators) {
+			return errMismatchingCheckpointValidators
+		}
+		for i, validator := range validators {
+			if extra.Validators[i] != validator {
+				return errMismatchingCheckpointValidators
+			}
+		}
+	}
+	// Ensure the parent block was finalized by the validators of its height
+	if err := b.verifyCommit(chain, parent, extra.Commit, parents); err != nil {
+		return err
+	}
+	// All basic checks passed, verify the seal and return
+	return b.verifySeal(chain, header, parents)
+}
+
+// verifyCommit checks whether the given commit signatures finalize the parent
+// block, that is, whether at least a quorum of the validators of its height
+// signed a commit for it. The method accepts an optional list of ancestor
+// headers (including the parent) that aren't yet part of the local blockchain.
+func (b *BFT) verifyCommit(chain consensus.ChainReader, parent *types.Header, commit [][]byte, parents []*types.Header) error {
+	// The genesis block is final by definition
+	number := parent.Number.Uint64()
+	if number == 0 {
+		if len(commit) > 0 {
+			return errUnexpectedCommit
+		}
+		return nil
+	}
+	if len(parents) > 0 {
+		parents = parents[:len(parents)-1]
+	}
+	snap, err := b.snapshot(chain, number-1, parent.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	committers, err := snap.committers(parent.Hash(), commit)
+	if err != nil {
+		return err
+	}
+	if len(committers) < snap.quorum() {
+		return errInsufficientCommit
+	}
+	return nil
+}
+
+// snapshot retrieves the authorization snapshot at a given point in time.
+func (b *BFT) snapshot(chain consensus.ChainReader, number uint64, hash common.Hash, parents []*types.Header) (*Snapshot, error) {
+	// Search for a snapshot in memory or on disk for checkpoints
+	var (
+		headers []*types.Header
+		snap    *Snapshot
+	)
+	for snap == nil {
+		// If an in-memory snapshot was found, use that
+		if s, ok := b.recents.Get(hash); ok {
+			snap = s.(*Snapshot)
+			break
+		}
+		// If an on-disk checkpoint snapshot can be found, use that
+		if number%checkpointInterval == 0 {
+			if s, err := loadSnapshot(b.config, b.signatures, b.db, hash); err == nil {
+				log.Trace("Loaded voting snapshot from disk", "number", number, "hash", hash)
+				snap = s
+				break
+			}
+		}
+		// If we're at the genesis, snapshot the initial state. Alternatively if we're
+		// at a checkpoint block without a parent (light client CHT), or we have piled
+		// up more headers than allowed to be reorged (chain reinit from a freezer),
+		// consider the checkpoint trusted and snapshot it.
+		if number == 0 || (number%b.config.Epoch == 0 && (len(headers) > params.ImmutabilityThreshold || chain.GetHeaderByNumber(number-1) == nil)) {
+			checkpoint := chain.GetHeaderByNumber(number)
+			if checkpoint != nil {
+				extra, err := ExtractExtra(checkpoint)
+				if err != nil {
+					return nil, err
+				}
+				hash := checkpoint.Hash()
+
+				snap = newSnapshot(b.config, b.signatures, number, hash, extra.Validators)
+				if err := snap.store(b.db); err != nil {
+					return nil, err
+				}
+				log.Info("Stored checkpoint snapshot to disk", "number", number, "hash", hash)
+				break
+			}
+		}
+		// No snapshot for this header, gather the header and move backward
+		var header *types.Header
+		if len(parents) > 0 {
+			// If we have explicit parents, pick from there (enforced)
+			header = parents[len(parents)-1]
+			if header.Hash() != hash || header.Number.Uint64() != number {
+				return nil, consensus.ErrUnknownAncestor
+			}
+			parents = parents[:len(parents)-1]
+		} else {
+			// No explicit parents (or no more left), reach out to the database
+			header = chain.GetHeader(hash, number)
+			if header == nil {
+				return nil, consensus.ErrUnknownAncestor
+			}
+		}
+		headers = append(headers, header)
+		number, hash = number-1, header.ParentHash
+	}
+	// Previous snapshot found, apply any pending headers on top of it
+	for i := 0; i < len(headers)/2; i++ {
+		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
+	}
+	snap, err := snap.apply(headers)
+	if err != nil {
+		return nil, err
+	}
+	b.recents.Add(snap.Hash, snap)
+
+	// If we've generated a new checkpoint snapshot, save to disk
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err = snap.store(b.db); err != nil {
+			return nil, err
+		}
+		log.Trace("Stored voting snapshot to disk", "number", snap.Number, "hash", snap.Hash)
+	}
+	return snap, err
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (b *BFT) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// VerifySeal implements consensus.Engine, checking whether the proposer seal
+// contained in the header satisfies the consensus protocol requirements.
+func (b *BFT) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
+	return b.verifySeal(chain, header, nil)
+}
+
+// verifySeal checks whether the proposer seal contained in the header satisfies
+// the consensus protocol requirements. The method accepts an optional list of
+// parent headers that aren't yet part of the local blockchain to generate the
+// snapshots from.
+func (b *BFT) verifySeal(chain consensus.ChainReader, header *types.Header, parents []*types.Header) error {
+	// Verifying the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	// Retrieve the snapshot needed to verify this header and cache it
+	snap, err := b.snapshot(chain, number-1, header.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	// Resolve the authorization key and check against validators
+	signer, err := ecrecover(header, b.signatures)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Validators[signer]; !ok {
+		return errUnauthorizedValidator
+	}
+	return nil
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (b *BFT) Prepare(chain consensus.ChainReader, header *types.Header) error {
+	// If the block isn't a checkpoint, cast a random vote (good enough for now)
+	header.Coinbase = common.Address{}
+	header.Nonce = types.BlockNonce{}
+
+	number := header.Number.Uint64()
+	// Assemble the voting snapshot to check which votes make sense
+	snap, err := b.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	if number%b.config.Epoch != 0 {
+		b.lock.RLock()
+
+		// Gather all the proposals that make sense voting on
+		addresses := make([]common.Address, 0, len(b.proposals))
+		for address, authorize := range b.proposals {
+			if snap.validVote(address, authorize) {
+				addresses = append(addresses, address)
+			}
+		}
+		// If there's pending proposals, cast a vote on them
+		if len(addresses) > 0 {
+			header.Coinbase = addresses[rand.Intn(len(addresses))]
+			if b.proposals[header.Coinbase] {
+				copy(header.Nonce[:], nonceAuthVote)
+			} else {
+				copy(header.Nonce[:], nonceDropVote)
+			}
+		}
+		b.lock.RUnlock()
+	}
+	// Set the constant difficulty
+	header.Difficulty = new(big.Int).Set(blockDifficulty)
+
+	// Assemble the consensus data, finalizing the parent with its commit signatures
+	extra := new(Extra)
+	if number%b.config.Epoch == 0 {
+		extra.Validators = snap.validators()
+	}
+	// If the local node missed the commit round of the parent (e.g. it just joined),
+	// the block can't be finalized. It is still assembled, the consensus protocol
+	// skips proposing it and moves on to the next round instead.
+	if number > 1 {
+		if extra.Commit = b.Commit(header.ParentHash); extra.Commit == nil {
+			log.Debug("Missing parent commit signatures", "number", number, "parent", header.ParentHash)
+		}
+	}
+	if header.Extra, err = encodeExtra(header.Extra, extra); err != nil {
+		return err
+	}
+	// Mix digest is reserved for now, set to empty
+	header.MixDigest = common.Hash{}
+
+	// Ensure the timestamp has the correct delay
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Time = parent.Time + b.config.Period
+	if header.Time < uint64(time.Now().Unix()) {
+		header.Time = uint64(time.Now().Unix())
+	}
+	return nil
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given.
+func (b *BFT) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	// No block rewards in PoA, so the state remains as is and uncles are dropped
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = types.CalcUncleHash(nil)
+}
+
+// FinalizeAndAssemble implements consensus.Engine, ensuring no uncles are set,
+// nor block rewards given, and returns the final block.
+func (b *BFT) FinalizeAndAssemble(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// No block rewards in PoA, so the state remains as is and uncles are dropped
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = types.CalcUncleHash(nil)
+
+	// Assemble and return the final block for sealing
+	return types.NewBlock(header, txs, nil, receipts), nil
+}
+
+// Authorize injects a private key into the consensus engine to propose and
+// commit blocks with.
+func (b *BFT) Authorize(signer common.Address, signFn SignerFn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.signer = signer
+	b.signFn = signFn
+}
+
+// Seal implements consensus.Engine, handing the block over to the consensus
+// protocol as the local candidate for its height. The block is proposed once
+// the local validator becomes the proposer of a round, and delivered into the
+// results channel if the validators commit it.
+func (b *BFT) Seal(chain consensus.ChainReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	// Sealing the genesis block is not supported
+	if block.NumberU64() == 0 {
+		return errUnknownBlock
+	}
+	h := b.running()
+	if h == nil {
+		return errNotRunning
+	}
+	h.propose(&candidate{block: block, results: results, stop: stop})
+	return nil
+}
+
+// sealBlock signs the given block as its proposer.
+func (b *BFT) sealBlock(block *types.Block) (*types.Block, error) {
+	b.lock.RLock()
+	signer, signFn := b.signer, b.signFn
+	b.lock.RUnlock()
+
+	header := block.Header()
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	if extra.Seal, err = signFn(accounts.Account{Address: signer}, accounts.MimetypeBFT, BFTRLP(header)); err != nil {
+		return nil, err
+	}
+	if header.Extra, err = encodeExtra(header.Extra[:extraVanity], extra); err != nil {
+		return nil, err
+	}
+	return block.WithSeal(header), nil
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
+// that a new block should have, which is constant for all blocks.
+func (b *BFT) CalcDifficulty(chain consensus.ChainReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(blockDifficulty)
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (b *BFT) SealHash(header *types.Header) common.Hash {
+	return SealHash(header)
+}
+
+// Commit retrieves the commit signatures of a block finalized by the local
+// node, or nil if they are unknown.
+func (b *BFT) Commit(hash common.Hash) [][]byte {
+	if commit, ok := b.commits.Get(hash); ok {
+		return commit.([][]byte)
+	}
+	blob, err := b.db.Get(append([]byte("bft-commit-"), hash[:]...))
+	if err != nil {
+		return nil
+	}
+	var commit [][]byte
+	if err := rlp.DecodeBytes(blob, &commit); err != nil {
+		return nil
+	}
+	b.commits.Add(hash, commit)
+	return commit
+}
+
+// storeCommit persists the commit signatures of a block finalized by the local
+// node, needed to build its child.
+func (b *BFT) storeCommit(hash common.Hash, commit [][]byte) error {
+	blob, err := rlp.EncodeToBytes(commit)
+	if err != nil {
+		return err
+	}
+	if err := b.db.Put(append([]byte("bft-commit-"), hash[:]...), blob); err != nil {
+		return err
+	}
+	b.commits.Add(hash, commit)
+	return nil
+}
+
+// Protocols returns the devp2p subprotocol the validators exchange consensus
+// messages over.
+func (b *BFT) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{b.makeProtocol()}
+}
+
+// Start launches the consensus protocol on top of the given chain, participating
+// in it if the engine is authorized with a validator key.
+func (b *BFT) Start(chain Blockchain) error {
+	b.startMu.Lock()
+	defer b.startMu.Unlock()
+
+	if b.running() != nil {
+		return errors.New("consensus already running")
+	}
+	h := newHandler(b, chain)
+	b.handler.Store(h)
+	h.start()
+	return nil
+}
+
+// running returns the consensus protocol handler if the engine was started.
+func (b *BFT) running() *handler {
+	h, _ := b.handler.Load().(*handler)
+	return h
+}
+
+// Close implements consensus.Engine, terminating the consensus protocol if it
+// was started.
+func (b *BFT) Close() error {
+	b.startMu.Lock()
+	defer b.startMu.Unlock()
+
+	if h := b.running(); h != nil {
+		h.stop()
+		b.handler.Store((*handler)(nil))
+	}
+	return nil
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC API to allow
+// controlling the validator voting and inspecting the consensus state.
+func (b *BFT) APIs(chain consensus.ChainReader) []rpc.API {
+	return []rpc.API{{
+		Namespace: "bft",
+		Version:   "1.0",
+		Service:   &API{chain: chain, bft: b},
+		Public:    false,
+	}}
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func SealHash(header *types.Header) (hash common.Hash) {
+	hasher := sha3.NewLegacyKeccak256()
+	encodeSigHeader(hasher, header)
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+// BFTRLP returns the rlp bytes which needs to be signed by the proposer of a
+// block. The RLP to sign consists of the entire header apart from the seal
+// contained in the consensus extra-data.
+func BFTRLP(header *types.Header) []byte {
+	b := new(bytes.Buffer)
+	encodeSigHeader(b, header)
+	return b.Bytes()
+}
+
+func encodeSigHeader(w io.Writer, header *types.Header) {
+	// Strip the seal from the consensus data, hashing malformed ones as they are
+	blob := header.Extra
+	if extra, err := ExtractExtra(header); err == nil {
+		extra.Seal = nil
+		if blob, err = encodeExtra(header.Extra[:extraVanity], extra); err != nil {
+			panic("can't encode: " + err.Error())
+		}
+	}
+	err := rlp.Encode(w, []interface{}{
+		header.ParentHash,
+		header.UncleHash,
+		header.Coinbase,
+		header.Root,
+		header.TxHash,
+		header.ReceiptHash,
+		header.Bloom,
+		header.Difficulty,
+		header.Number,
+		header.GasLimit,
+		header.GasUsed,
+		header.Time,
+		blob,
+		header.MixDigest,
+		header.Nonce,
+	})
+	if err != nil {
+		panic("can't encode: " + err.Error())
+	}
+}
@@ -0,0 +1,631 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// testValidator is a minimal node service running a validator: a chain, the
+// consensus engine and a block producer handing empty blocks to the engine.
+type testValidator struct {
+	engine *BFT
+	chain  *core.BlockChain
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newTestValidator creates a validator service constructor for the given genesis,
+// authorizing each validator with the key of its simulated node.
+func newTestValidator(genesis *core.Genesis) adapters.ServiceFunc {
+	return func(ctx *adapters.ServiceContext) (node.Service, error) {
+		db := rawdb.NewMemoryDatabase()
+		genesis.MustCommit(db)
+
+		engine := New(genesis.Config.BFT, db)
+		chain, err := core.NewBlockChain(db, nil, genesis.Config, engine, vm.Config{}, nil)
+		if err != nil {
+			return nil, err
+		}
+		key := ctx.Config.PrivateKey
+		engine.Authorize(crypto.PubkeyToAddress(key.PublicKey), func(account accounts.Account, mimetype string, data []byte) ([]byte, error) {
+			return crypto.Sign(crypto.Keccak256(data), key)
+		})
+		return &testValidator{engine: engine, chain: chain, quit: make(chan struct{})}, nil
+	}
+}
+
+func (v *testValidator) Protocols() []p2p.Protocol { return v.engine.Protocols() }
+func (v *testValidator) APIs() []rpc.API           { return v.engine.APIs(v.chain) }
+
+func (v *testValidator) Start(server *p2p.Server) error {
+	if err := v.engine.Start(v.chain); err != nil {
+		return err
+	}
+	v.wg.Add(1)
+	go v.produce()
+	return nil
+}
+
+func (v *testValidator) Stop() error {
+	close(v.quit)
+	v.wg.Wait()
+	v.engine.Close()
+	v.chain.Stop()
+	return nil
+}
+
+// produce hands a new empty block to the consensus engine on every chain head,
+// importing it if it gets finalized.
+func (v *testValidator) produce() {
+	defer v.wg.Done()
+
+	heads := make(chan core.ChainHeadEvent, 16)
+	sub := v.chain.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	var (
+		results = make(chan *types.Block, 16)
+		stop    chan struct{}
+	)
+	seal := func() {
+		if stop != nil {
+			close(stop)
+		}
+		stop = make(chan struct{})
+
+		parent := v.chain.CurrentBlock()
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			GasLimit:   parent.GasLimit(),
+		}
+		if err := v.engine.Prepare(v.chain, header); err != nil {
+			return
+		}
+		statedb, err := v.chain.StateAt(parent.Root())
+		if err != nil {
+			return
+		}
+		block, _ := v.engine.FinalizeAndAssemble(v.chain, header, statedb, nil, nil, nil)
+		v.engine.Seal(v.chain, block, results, stop)
+	}
+	seal()
+	for {
+		select {
+		case <-heads:
+			seal()
+		case block := <-results:
+			v.chain.InsertChain(types.Blocks{block})
+		case <-v.quit:
+			return
+		}
+	}
+}
+
+// byzantineValidator is a node service speaking the consensus protocol for a
+// faulty validator. It relays nothing on its own, but hands every message of its
+// peers to a test script deciding whom to forward it to.
+type byzantineValidator struct {
+	key     *ecdsa.PrivateKey
+	genesis common.Hash
+
+	peers map[common.Address]*peer // Peers keyed by their validator address
+	lock  sync.Mutex               // Protects the peer set
+	msgCh chan *message            // Messages of the peers, in arrival order
+	quit  chan struct{}
+}
+
+// newByzantineValidator creates a faulty validator service constructor for the
+// given genesis, handing the service out for the test to script it.
+func newByzantineValidator(genesis *core.Genesis, services chan<- *byzantineValidator) adapters.ServiceFunc {
+	return func(ctx *adapters.ServiceContext) (node.Service, error) {
+		v := &byzantineValidator{
+			key:     ctx.Config.PrivateKey,
+			genesis: genesis.ToBlock(nil).Hash(),
+			peers:   make(map[common.Address]*peer),
+			msgCh:   make(chan *message, maxBacklog),
+			quit:    make(chan struct{}),
+		}
+		services <- v
+		return v, nil
+	}
+}
+
+func (v *byzantineValidator) APIs() []rpc.API                { return nil }
+func (v *byzantineValidator) Start(server *p2p.Server) error { return nil }
+func (v *byzantineValidator) Stop() error                    { close(v.quit); return nil }
+
+func (v *byzantineValidator) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    protocolName,
+		Version: protocolVersion,
+		Length:  protocolLength,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			peer := newPeer(p, rw)
+			if err := peer.handshake(v.genesis); err != nil {
+				return err
+			}
+			go peer.broadcast()
+			defer close(peer.term)
+
+			validator := crypto.PubkeyToAddress(*p.Node().Pubkey())
+			v.lock.Lock()
+			v.peers[validator] = peer
+			v.lock.Unlock()
+
+			for {
+				msg, err := rw.ReadMsg()
+				if err != nil {
+					return err
+				}
+				m := new(message)
+				if err := msg.Decode(m); err != nil {
+					return err
+				}
+				if err := m.validate(); err != nil {
+					return err
+				}
+				select {
+				case v.msgCh <- m:
+				case <-v.quit:
+					return p2p.DiscQuitting
+				}
+			}
+		},
+	}}
+}
+
+// send delivers a consensus message to the given validators only.
+func (v *byzantineValidator) send(msg *message, validators ...common.Address) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	for _, validator := range validators {
+		if peer := v.peers[validator]; peer != nil {
+			peer.send(msg)
+		}
+	}
+}
+
+// sign creates a consensus message of the faulty validator.
+func (v *byzantineValidator) sign(msg *message) *message {
+	msg.Signature, _ = crypto.Sign(crypto.Keccak256(msg.payload()), v.key)
+	msg.validate()
+	return msg
+}
+
+// Tests that a network of validators finalizes blocks, keeps finalizing them with
+// a faulty validator and can vote the faulty validator out.
+func TestSimulatedValidators(t *testing.T) {
+	// Create the node configs and the genesis with their keys as the validators
+	var (
+		configs    = make([]*adapters.NodeConfig, 4)
+		validators = make([]common.Address, len(configs))
+	)
+	for i := range configs {
+		configs[i] = adapters.RandomNodeConfig()
+		configs[i].Services = []string{"bft"}
+		validators[i] = crypto.PubkeyToAddress(configs[i].PrivateKey.PublicKey)
+	}
+	config := *params.TestChainConfig
+	config.Ethash = nil
+	config.BFT = &params.BFTConfig{Period: 1, RequestTimeout: 500}
+
+	genesis := &core.Genesis{
+		Config:     &config,
+		ExtraData:  GenesisExtra(nil, validators),
+		GasLimit:   params.GenesisGasLimit,
+		Difficulty: big.NewInt(1),
+		Timestamp:  uint64(time.Now().Unix()),
+	}
+	// Start the simulated network, connecting all validators to each other
+	adapter := adapters.NewSimAdapter(adapters.Services{"bft": newTestValidator(genesis)})
+	network := simulations.NewNetwork(adapter, &simulations.NetworkConfig{DefaultService: "bft"})
+	defer network.Shutdown()
+
+	ids := make([]enode.ID, len(configs))
+	for i, conf := range configs {
+		node, err := network.NewNodeWithConfig(conf)
+		if err != nil {
+			t.Fatalf("failed to create node %d: %v", i, err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("failed to start node %d: %v", i, err)
+		}
+		ids[i] = node.ID()
+	}
+	if err := network.ConnectNodesFull(ids); err != nil {
+		t.Fatalf("failed to connect nodes: %v", err)
+	}
+	service := func(i int) *testValidator {
+		node, _ := adapter.GetNode(ids[i])
+		return node.Service("bft").(*testValidator)
+	}
+	client := func(i int) *rpc.Client {
+		client, err := network.GetNode(ids[i]).Client()
+		if err != nil {
+			t.Fatalf("failed to connect to node %d: %v", i, err)
+		}
+		return client
+	}
+	// waitHeight waits until the given validators all reach a block height
+	waitHeight := func(nodes []int, height uint64) {
+		deadline := time.Now().Add(time.Minute)
+		for _, i := range nodes {
+			for service(i).chain.CurrentBlock().NumberU64() < height {
+				if time.Now().After(deadline) {
+					t.Fatalf("node %d: timeout waiting for block #%d, have #%d", i, height, service(i).chain.CurrentBlock().NumberU64())
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		}
+	}
+	// checkChains ensures the validators agree on all blocks and their finality
+	checkChains := func(nodes []int, height uint64) {
+		for n := uint64(1); n <= height; n++ {
+			want := service(nodes[0]).chain.GetHeaderByNumber(n)
+			for _, i := range nodes[1:] {
+				if have := service(i).chain.GetHeaderByNumber(n); have.Hash() != want.Hash() {
+					t.Fatalf("node %d: block #%d mismatch: have %x, want %x", i, n, have.Hash(), want.Hash())
+				}
+			}
+			var info CommitInfo
+			if err := client(nodes[0]).Call(&info, "bft_getCommit", hexutil.Uint64(n)); err != nil {
+				t.Fatalf("failed to retrieve commit of block #%d: %v", n, err)
+			}
+			if !info.Final || info.Hash != want.Hash() {
+				t.Errorf("block #%d not final: %+v", n, info)
+			}
+		}
+	}
+	// The full validator set should finalize blocks
+	all := []int{0, 1, 2, 3}
+	waitHeight(all, 4)
+	checkChains(all, 4)
+
+	// Kill a validator, vote it out and ensure finalization goes on
+	alive := []int{0, 1, 2}
+	for _, i := range alive {
+		if err := client(i).Call(nil, "bft_propose", validators[3], false); err != nil {
+			t.Fatalf("node %d: failed to propose: %v", i, err)
+		}
+	}
+	if err := network.Stop(ids[3]); err != nil {
+		t.Fatalf("failed to stop node: %v", err)
+	}
+	head := service(0).chain.CurrentBlock().NumberU64()
+	waitHeight(alive, head+uint64(len(validators)))
+
+	deadline := time.Now().Add(time.Minute)
+	for {
+		var current []common.Address
+		if err := client(0).Call(&current, "bft_getValidators", "latest"); err != nil {
+			t.Fatalf("failed to retrieve validators: %v", err)
+		}
+		if len(current) == 3 {
+			for _, validator := range current {
+				if validator == validators[3] {
+					t.Fatalf("dropped validator still authorized")
+				}
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("faulty validator not voted out, validators: %v", current)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	head = service(0).chain.CurrentBlock().NumberU64()
+	waitHeight(alive, head+2)
+	checkChains(alive, head+1)
+
+	var status Status
+	if err := client(0).Call(&status, "bft_status"); err != nil {
+		t.Fatalf("failed to retrieve consensus status: %v", err)
+	}
+	if status.Quorum != 3 || !status.Validator || status.Peers != 2 {
+		t.Errorf("consensus status mismatch: %+v", status)
+	}
+}
+
+// Tests that a validator which missed the commit round of the chain head (e.g.
+// because it just joined) doesn't fail preparing the next block, but skips its
+// proposal and asks for a round change instead.
+func TestMissingCommitSkipsProposal(t *testing.T) {
+	// Create the validator keys and a genesis authorizing them
+	keys := make([]*ecdsa.PrivateKey, 4)
+	validators := make([]common.Address, len(keys))
+	signers := make(map[common.Address]SignerFn)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		validators[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+
+		key := keys[i]
+		signers[validators[i]] = func(account accounts.Account, mimetype string, data []byte) ([]byte, error) {
+			return crypto.Sign(crypto.Keccak256(data), key)
+		}
+	}
+	config := *params.TestChainConfig
+	config.Ethash = nil
+	config.BFT = &params.BFTConfig{RequestTimeout: 500}
+
+	genesis := &core.Genesis{
+		Config:     &config,
+		ExtraData:  GenesisExtra(nil, validators),
+		GasLimit:   params.GenesisGasLimit,
+		Difficulty: big.NewInt(1),
+		Timestamp:  uint64(time.Now().Unix()) - 100,
+	}
+	// Build a short chain finalized by a quorum of the validators
+	db := rawdb.NewMemoryDatabase()
+	genesis.MustCommit(db)
+
+	engine := New(config.BFT, db)
+	chain, _ := core.NewBlockChain(db, nil, &config, engine, vm.Config{}, nil)
+	defer chain.Stop()
+
+	engine.Authorize(validators[0], signers[validators[0]])
+
+	var blocks types.Blocks
+	for i := 0; i < 2; i++ {
+		parent := chain.CurrentBlock()
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).Add(parent.Number(), common.Big1),
+			GasLimit:   parent.GasLimit(),
+		}
+		if err := engine.Prepare(chain, header); err != nil {
+			t.Fatalf("block %d: failed to prepare header: %v", header.Number, err)
+		}
+		statedb, _ := chain.StateAt(parent.Root())
+		block, _ := engine.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+		block, err := engine.sealBlock(block)
+		if err != nil {
+			t.Fatalf("block %d: failed to seal: %v", header.Number, err)
+		}
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("block %d: failed to import: %v", header.Number, err)
+		}
+		var commit [][]byte
+		for _, key := range keys[:3] {
+			sig, _ := crypto.Sign(crypto.Keccak256(CommitData(block.Hash())), key)
+			commit = append(commit, sig)
+		}
+		if err := engine.storeCommit(block.Hash(), commit); err != nil {
+			t.Fatalf("block %d: failed to store commit: %v", header.Number, err)
+		}
+		blocks = append(blocks, block)
+	}
+	// Create a validator joining late, syncing the chain without taking part
+	// in the commit rounds
+	lateDB := rawdb.NewMemoryDatabase()
+	genesis.MustCommit(lateDB)
+
+	late := New(config.BFT, lateDB)
+	lateChain, _ := core.NewBlockChain(lateDB, nil, &config, late, vm.Config{}, nil)
+	defer lateChain.Stop()
+
+	if _, err := lateChain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to sync chain: %v", err)
+	}
+	head := lateChain.CurrentHeader()
+	snap, err := late.snapshot(lateChain, head.Number.Uint64(), head.Hash(), nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve validator set: %v", err)
+	}
+	proposer := snap.proposer(0)
+	late.Authorize(proposer, signers[proposer])
+
+	// Preparing the next block must not fail, only leave the commit empty
+	header := &types.Header{
+		ParentHash: head.Hash(),
+		Number:     new(big.Int).Add(head.Number, common.Big1),
+		GasLimit:   head.GasLimit,
+	}
+	if err := late.Prepare(lateChain, header); err != nil {
+		t.Fatalf("failed to prepare header without parent commit: %v", err)
+	}
+	if extra, err := ExtractExtra(header); err != nil || len(extra.Commit) != 0 {
+		t.Fatalf("unexpected parent commit: %v, %v", extra, err)
+	}
+	// As the proposer of the round, the late validator must skip proposing and
+	// request the next round
+	h := newHandler(late, lateChain)
+	h.newHeight(head)
+
+	if h.proposed {
+		t.Errorf("block proposed without parent commit")
+	}
+	if !h.skipped || h.target != 1 {
+		t.Errorf("round change not requested: skipped %v, target %d", h.skipped, h.target)
+	}
+	if voters := h.changes[1]; len(voters) != 1 {
+		t.Errorf("round change vote count mismatch: have %d, want 1", len(voters))
+	}
+}
+
+// Tests that a faulty validator can't stall a height by splitting the locks of the
+// honest validators across blocks of different rounds: the newer prepared
+// certificate carried by the round changes must release the older lock.
+//
+// The faulty validator sits between the honest ones, controlling the delivery of
+// their messages. In round 0 it sends its prepare of the proposal only to A, so A
+// alone locks on it. In round 1 it helps C reach a quorum on another proposal and
+// withholds its commit, leaving A and C locked on different blocks.
+func TestSplitLockRecovery(t *testing.T) {
+	// Create the node configs in validator order and the genesis authorizing them
+	configs := make([]*adapters.NodeConfig, 4)
+	for i := range configs {
+		configs[i] = adapters.RandomNodeConfig()
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return bytes.Compare(crypto.PubkeyToAddress(configs[i].PrivateKey.PublicKey).Bytes(), crypto.PubkeyToAddress(configs[j].PrivateKey.PublicKey).Bytes()) < 0
+	})
+	validators := make([]common.Address, len(configs))
+	for i, conf := range configs {
+		validators[i] = crypto.PubkeyToAddress(conf.PrivateKey.PublicKey)
+	}
+	config := *params.TestChainConfig
+	config.Ethash = nil
+	config.BFT = &params.BFTConfig{Period: 2, RequestTimeout: 500}
+
+	genesis := &core.Genesis{
+		Config:     &config,
+		ExtraData:  GenesisExtra(nil, validators),
+		GasLimit:   params.GenesisGasLimit,
+		Difficulty: big.NewInt(1),
+		Timestamp:  uint64(time.Now().Unix()),
+	}
+	// Rounds of the first height are proposed by validators 1, 2, 3 and 0, make
+	// the faulty validator the one never proposing before the split is done
+	var (
+		a, c, d = validators[1], validators[2], validators[3]
+		honest  = []common.Address{a, c, d}
+	)
+	configs[0].Services = []string{"byzantine"}
+	for _, conf := range configs[1:] {
+		conf.Services = []string{"bft"}
+	}
+	byzantines := make(chan *byzantineValidator, 1)
+	adapter := adapters.NewSimAdapter(adapters.Services{
+		"bft":       newTestValidator(genesis),
+		"byzantine": newByzantineValidator(genesis, byzantines),
+	})
+	network := simulations.NewNetwork(adapter, &simulations.NetworkConfig{DefaultService: "bft"})
+	defer network.Shutdown()
+
+	ids := make([]enode.ID, len(configs))
+	for i, conf := range configs {
+		node, err := network.NewNodeWithConfig(conf)
+		if err != nil {
+			t.Fatalf("failed to create node %d: %v", i, err)
+		}
+		if err := network.Start(node.ID()); err != nil {
+			t.Fatalf("failed to start node %d: %v", i, err)
+		}
+		ids[i] = node.ID()
+	}
+	byzantine := <-byzantines
+
+	// Connect the honest validators to the faulty one only, letting it decide
+	// which messages they get to see
+	for i := 1; i < len(ids); i++ {
+		if err := network.Connect(ids[0], ids[i]); err != nil {
+			t.Fatalf("failed to connect node %d: %v", i, err)
+		}
+	}
+	// others returns the honest validators other than the sender of a message
+	others := func(msg *message) []common.Address {
+		var others []common.Address
+		for _, validator := range honest {
+			if validator != msg.sender {
+				others = append(others, validator)
+			}
+		}
+		return others
+	}
+	split := make(chan common.Hash, 1)
+	go func() {
+		var relay, changed bool
+		for {
+			var msg *message
+			select {
+			case msg = <-byzantine.msgCh:
+			case <-byzantine.quit:
+				return
+			}
+			// Once the locks are split, behave as a silent validator relaying the
+			// messages of the honest ones
+			if msg.Code == msgRoundChange && msg.Round >= 2 {
+				relay = true
+			}
+			if relay || msg.Height != 1 {
+				byzantine.send(msg, others(msg)...)
+				continue
+			}
+			switch {
+			case msg.Round == 0 && msg.Code == msgPreprepare && msg.sender == a:
+				byzantine.send(msg, c, d)
+
+			case msg.Round == 0 && msg.Code == msgPrepare && msg.sender == c:
+				prepare := byzantine.sign(&message{Code: msgPrepare, Height: 1, Round: 0, Digest: msg.Digest})
+				byzantine.send(msg, a)
+				byzantine.send(prepare, a)
+
+			case msg.Round == 1 && msg.Code == msgRoundChange && msg.sender != a:
+				byzantine.send(msg, others(msg)...)
+				if !changed {
+					changed = true
+					byzantine.send(byzantine.sign(&message{Code: msgRoundChange, Height: 1, Round: 1}), honest...)
+				}
+			case msg.Round == 1 && msg.Code == msgPreprepare && msg.sender == c:
+				split <- msg.Digest
+				byzantine.send(msg, d)
+
+			case msg.Round == 1 && msg.Code == msgPrepare && msg.sender == d:
+				prepare := byzantine.sign(&message{Code: msgPrepare, Height: 1, Round: 1, Digest: msg.Digest})
+				byzantine.send(msg, c)
+				byzantine.send(prepare, c)
+			}
+		}
+	}()
+	// The honest validators must agree on the block prepared in round 1
+	var want common.Hash
+	select {
+	case want = <-split:
+	case <-time.After(time.Minute):
+		t.Fatalf("timeout waiting for the round 1 proposal")
+	}
+	deadline := time.Now().Add(time.Minute)
+	for i := 1; i < len(ids); i++ {
+		node, _ := adapter.GetNode(ids[i])
+		chain := node.Service("bft").(*testValidator).chain
+		for chain.CurrentBlock().NumberU64() < 1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("node %d: timeout waiting for block #1", i)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if have := chain.GetHeaderByNumber(1).Hash(); have != want {
+			t.Errorf("node %d: block #1 mismatch: have %x, want %x", i, have, want)
+		}
+	}
+}
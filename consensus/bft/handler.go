@@ -0,0 +1,809 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	knownMessages     = 16384 // Number of recently seen message hashes to deduplicate gossip with
+	maxBacklog        = 1024  // Maximum number of future messages to hold on to
+	maxFutureHeights  = 1     // Number of heights ahead of the local one to accept messages for
+	maxFutureRounds   = 10    // Number of rounds ahead of the local target to accept round changes for
+	maxRoundBackoff   = 8     // Maximum exponent of the round timeout growth
+	chainHeadChanSize = 10    // Size of the channel listening to the chain head events
+)
+
+// errInvalidProposal is returned if a proposed block does not match its own
+// header fields.
+var errInvalidProposal = errors.New("invalid proposal")
+
+// Blockchain defines the chain access needed by the consensus protocol to
+// follow the chain head and import the finalized blocks.
+type Blockchain interface {
+	consensus.ChainReader
+
+	// InsertChain imports a batch of blocks into the chain.
+	InsertChain(chain types.Blocks) (int, error)
+
+	// SubscribeChainHeadEvent registers a subscription for new chain heads.
+	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
+}
+
+// candidate is a block handed over by the miner to propose at its height.
+type candidate struct {
+	block   *types.Block
+	results chan<- *types.Block
+	stop    <-chan struct{}
+}
+
+// Status is the state of the consensus protocol at the current height.
+type Status struct {
+	Height    uint64         `json:"height"`    // Number of the block being agreed upon
+	Round     uint64         `json:"round"`     // Current round of the height
+	Proposer  common.Address `json:"proposer"`  // Validator proposing in the current round
+	Validator bool           `json:"validator"` // Whether the local node is a validator
+	Proposal  *common.Hash   `json:"proposal"`  // Hash of the block proposed in the current round
+	Locked    *common.Hash   `json:"locked"`    // Hash of the block the local node is locked on
+	Prepares  int            `json:"prepares"`  // Number of prepares for the current proposal
+	Commits   int            `json:"commits"`   // Number of commits for the current proposal
+	Quorum    int            `json:"quorum"`    // Number of validators needed to agree on a block
+	Peers     int            `json:"peers"`     // Number of peers running the consensus protocol
+}
+
+// handler runs the consensus protocol: it exchanges the messages with the other
+// validators and drives the rounds of the heights, importing the finalized
+// blocks into the chain.
+type handler struct {
+	engine  *BFT
+	chain   Blockchain
+	genesis common.Hash
+
+	peers    map[string]*peer // Peers running the consensus protocol
+	peerLock sync.RWMutex     // Protects the peer set
+	known    *lru.Cache       // Hashes of recently seen messages
+
+	height uint64 // Current height, accessed atomically to filter messages early
+
+	msgCh   chan *message
+	candCh  chan *candidate
+	headCh  chan core.ChainHeadEvent
+	headSub event.Subscription
+	quit    chan struct{}
+	wg      sync.WaitGroup
+
+	// Consensus state of the current height, only accessed by the main loop
+	parent      *types.Header                             // Chain head the height builds upon
+	snap        *Snapshot                                 // Validator set of the height
+	round       uint64                                    // Current round of the height
+	target      uint64                                    // Round the local node asked to move to
+	cand        *candidate                                // Latest block handed over by the miner
+	proposed    bool                                      // Whether the local node proposed in the round
+	skipped     bool                                      // Whether the local node skipped proposing at the height
+	proposal    *types.Block                              // Block accepted in the current round
+	prepared    bool                                      // Whether the local node committed in the round
+	locked      *types.Block                              // Block with the highest prepared certificate known
+	lockedRound uint64                                    // Round in which the locked block was prepared
+	lockedCert  [][]byte                                  // Prepare signatures of a quorum over the locked block
+	final       *types.Block                              // Block finalized at the height
+	blocks      map[common.Hash]*types.Block              // Blocks proposed at the height
+	prepares    map[common.Hash]map[common.Address][]byte // Prepare signatures of the current round
+	commits     map[common.Hash]map[common.Address][]byte // Commit signatures of the height
+	changes     map[uint64]map[common.Address]struct{}    // Round change requests of the height
+	backlog     []*message                                // Messages of future rounds or heights
+	roundTimer  <-chan time.Time                          // Expiry of the current round
+	waitTimer   <-chan time.Time                          // Expiry of the wait for the candidate timestamp
+
+	status     Status       // Snapshot of the consensus state for the API
+	statusLock sync.RWMutex // Protects the status snapshot
+}
+
+// newHandler creates a consensus protocol handler on top of the given chain.
+func newHandler(engine *BFT, chain Blockchain) *handler {
+	known, _ := lru.New(knownMessages)
+	return &handler{
+		engine: engine,
+		chain:  chain,
+		peers:  make(map[string]*peer),
+		known:  known,
+		msgCh:  make(chan *message, maxBacklog),
+		candCh: make(chan *candidate),
+		headCh: make(chan core.ChainHeadEvent, chainHeadChanSize),
+		quit:   make(chan struct{}),
+	}
+}
+
+// start launches the main loop of the consensus protocol.
+func (h *handler) start() {
+	h.genesis = h.chain.GetHeaderByNumber(0).Hash()
+	h.headSub = h.chain.SubscribeChainHeadEvent(h.headCh)
+
+	h.wg.Add(1)
+	go h.loop()
+}
+
+// stop terminates the consensus protocol, waiting for all its goroutines.
+func (h *handler) stop() {
+	close(h.quit)
+	h.wg.Wait()
+}
+
+// propose hands a new candidate block of the local miner to the protocol.
+func (h *handler) propose(cand *candidate) {
+	select {
+	case h.candCh <- cand:
+	case <-h.quit:
+	}
+}
+
+// Status retrieves the state of the consensus protocol at the current height.
+func (h *handler) Status() *Status {
+	h.statusLock.RLock()
+	status := h.status
+	h.statusLock.RUnlock()
+
+	h.peerLock.RLock()
+	status.Peers = len(h.peers)
+	h.peerLock.RUnlock()
+
+	return &status
+}
+
+// loop is the main loop of the consensus protocol, processing the messages
+// and events of the current height.
+func (h *handler) loop() {
+	defer h.wg.Done()
+	defer h.headSub.Unsubscribe()
+
+	h.newHeight(h.chain.CurrentHeader())
+	for {
+		select {
+		case ev := <-h.headCh:
+			if header := ev.Block.Header(); h.parent == nil || header.Hash() != h.parent.Hash() {
+				h.newHeight(header)
+			}
+		case cand := <-h.candCh:
+			h.cand = cand
+			h.tryPropose()
+
+		case msg := <-h.msgCh:
+			h.handle(msg)
+
+		case <-h.waitTimer:
+			h.waitTimer = nil
+			h.tryPropose()
+
+		case <-h.roundTimer:
+			h.timeout()
+
+		case <-h.headSub.Err():
+			return
+		case <-h.quit:
+			return
+		}
+		h.updateStatus()
+	}
+}
+
+// identity returns the local validator account and signer function, along with
+// whether the local node is a validator of the current height.
+func (h *handler) identity() (common.Address, SignerFn, bool) {
+	h.engine.lock.RLock()
+	signer, signFn := h.engine.signer, h.engine.signFn
+	h.engine.lock.RUnlock()
+
+	if h.snap == nil || signFn == nil {
+		return signer, signFn, false
+	}
+	_, ok := h.snap.Validators[signer]
+	return signer, signFn, ok
+}
+
+// newHeight resets the consensus state to agree on the block following the
+// given chain head.
+func (h *handler) newHeight(head *types.Header) {
+	h.parent, h.snap = head, nil
+
+	snap, err := h.engine.snapshot(h.chain, head.Number.Uint64(), head.Hash(), nil)
+	if err != nil {
+		log.Error("Failed to retrieve validator set", "number", head.Number, "hash", head.Hash(), "err", err)
+		return
+	}
+	h.snap = snap
+	atomic.StoreUint64(&h.height, head.Number.Uint64()+1)
+
+	h.target, h.skipped = 0, false
+	h.locked, h.lockedRound, h.lockedCert, h.final = nil, 0, nil, nil
+	h.blocks = make(map[common.Hash]*types.Block)
+	h.commits = make(map[common.Hash]map[common.Address][]byte)
+	h.changes = make(map[uint64]map[common.Address]struct{})
+
+	h.startRound(0)
+}
+
+// startRound resets the consensus state to start the given round of the height.
+func (h *handler) startRound(round uint64) {
+	h.round = round
+	if h.target < round {
+		h.target = round
+	}
+	h.proposed, h.proposal, h.prepared = false, nil, false
+	h.prepares = make(map[common.Hash]map[common.Address][]byte)
+	for r := range h.changes {
+		if r <= round {
+			delete(h.changes, r)
+		}
+	}
+	h.roundTimer = time.After(h.roundTimeout(round))
+	h.waitTimer = nil
+
+	log.Debug("Started consensus round", "height", h.height, "round", round, "proposer", h.snap.proposer(round))
+
+	// Process any messages received early for this round
+	backlog := h.backlog
+	h.backlog = nil
+	for _, msg := range backlog {
+		h.handle(msg)
+	}
+	h.tryPropose()
+}
+
+// roundTimeout returns how long to wait for a block to be committed in the given
+// round, doubling with every round and including the minimum block period.
+func (h *handler) roundTimeout(round uint64) time.Duration {
+	if round > maxRoundBackoff {
+		round = maxRoundBackoff
+	}
+	timeout := h.engine.requestTimeout() << round
+	if wait := time.Until(time.Unix(int64(h.parent.Time+h.engine.config.Period), 0)); wait > 0 {
+		timeout += wait
+	}
+	return timeout
+}
+
+// timeout moves the local node on to the next round if the current one failed to
+// commit a block in time. If a block was already finalized but not yet imported,
+// its import is retried instead.
+func (h *handler) timeout() {
+	h.roundTimer = nil
+	if h.snap == nil {
+		return
+	}
+	if h.final != nil {
+		h.roundTimer = time.After(h.roundTimeout(h.round))
+		h.deliver(h.final)
+		return
+	}
+	log.Debug("Consensus round timed out", "height", h.height, "round", h.round)
+	h.changeRound()
+}
+
+// changeRound asks the validators to move on to the round following the one the
+// local node targets.
+func (h *handler) changeRound() {
+	if h.target <= h.round {
+		h.target = h.round
+	}
+	h.target++
+	h.roundTimer = time.After(h.roundTimeout(h.target))
+
+	log.Debug("Requesting consensus round change", "height", h.height, "round", h.round, "next", h.target)
+	h.broadcast(h.roundChange(h.target))
+}
+
+// roundChange creates a request to move to the given round, carrying the prepared
+// certificate of the locked block if any, for the next proposer to re-propose it
+// and for the validators locked on older rounds to release their locks.
+func (h *handler) roundChange(round uint64) *message {
+	msg := &message{Code: msgRoundChange, Height: h.parent.Number.Uint64() + 1, Round: round}
+	if h.locked == nil || h.lockedRound >= round {
+		return msg
+	}
+	payload, err := rlp.EncodeToBytes(h.locked)
+	if err != nil {
+		log.Error("Failed to encode prepared block", "err", err)
+		return msg
+	}
+	msg.Digest, msg.Proposal = h.locked.Hash(), payload
+	msg.PreparedRound, msg.Prepares = h.lockedRound, h.lockedCert
+	return msg
+}
+
+// tryPropose proposes a block if the local node is the proposer of the current
+// round: the block with the highest prepared certificate it knows of if any, or
+// the latest candidate of the miner once its timestamp is reached.
+func (h *handler) tryPropose() {
+	if h.snap == nil || h.proposed || h.final != nil {
+		return
+	}
+	signer, _, validator := h.identity()
+	if !validator || h.snap.proposer(h.round) != signer {
+		return
+	}
+	block := h.locked
+	if block == nil {
+		// Without the commit signatures of the parent (e.g. the local node missed
+		// its commit round), no finalizable block can be built. Rather than waiting
+		// for the round to time out, ask for the next proposer once per height.
+		if h.parent.Number.Uint64() > 0 && h.engine.Commit(h.parent.Hash()) == nil {
+			if !h.skipped {
+				log.Debug("Skipping proposal without parent commit", "height", h.height, "round", h.round)
+				h.skipped = true
+				h.changeRound()
+			}
+			return
+		}
+		if h.cand == nil || h.cand.block.ParentHash() != h.parent.Hash() {
+			return
+		}
+		if wait := time.Until(time.Unix(int64(h.cand.block.Time()), 0)); wait > 0 {
+			h.waitTimer = time.After(wait)
+			return
+		}
+		sealed, err := h.engine.sealBlock(h.cand.block)
+		if err != nil {
+			log.Warn("Failed to seal proposal", "number", h.cand.block.Number(), "err", err)
+			return
+		}
+		block = sealed
+	}
+	payload, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		log.Error("Failed to encode proposal", "err", err)
+		return
+	}
+	h.proposed = true
+
+	msg := &message{Code: msgPreprepare, Height: block.NumberU64(), Round: h.round, Digest: block.Hash(), Proposal: payload}
+	if block == h.locked && h.lockedRound < h.round {
+		msg.PreparedRound, msg.Prepares = h.lockedRound, h.lockedCert
+	}
+	log.Debug("Proposing block", "number", block.Number(), "hash", block.Hash(), "round", h.round, "txs", len(block.Transactions()))
+	h.broadcast(msg)
+}
+
+// broadcast signs a consensus message of the local validator, sends it to all
+// the peers and processes it locally.
+func (h *handler) broadcast(msg *message) {
+	signer, signFn, validator := h.identity()
+	if !validator {
+		return
+	}
+	sig, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeBFT, msg.payload())
+	if err != nil {
+		log.Warn("Failed to sign consensus message", "msg", msg, "err", err)
+		return
+	}
+	msg.Signature = sig
+	if err := msg.validate(); err != nil {
+		log.Error("Failed to validate own consensus message", "msg", msg, "err", err)
+		return
+	}
+	h.known.Add(msg.hash, struct{}{})
+
+	h.peerLock.RLock()
+	for _, p := range h.peers {
+		p.send(msg)
+	}
+	h.peerLock.RUnlock()
+
+	h.handle(msg)
+}
+
+// handle processes a validated consensus message, deferring the ones of future
+// rounds and heights until they become current.
+func (h *handler) handle(msg *message) {
+	if h.snap == nil {
+		return
+	}
+	height := h.parent.Number.Uint64() + 1
+	if msg.Height < height {
+		return
+	}
+	if msg.Height > height {
+		h.postpone(msg)
+		return
+	}
+	if _, ok := h.snap.Validators[msg.sender]; !ok {
+		log.Debug("Discarding message of non-validator", "msg", msg, "sender", msg.sender)
+		return
+	}
+	// Remember every proposed block to be able to import it once committed
+	if msg.Code == msgPreprepare && msg.sender == h.snap.proposer(msg.Round) {
+		if block, err := msg.proposal(); err == nil {
+			h.blocks[block.Hash()] = block
+		}
+	}
+	// Commits and round changes are accounted for across rounds
+	switch msg.Code {
+	case msgCommit:
+		h.handleCommit(msg)
+		return
+	case msgRoundChange:
+		h.handleRoundChange(msg)
+		return
+	}
+	if msg.Round < h.round {
+		return
+	}
+	if msg.Round > h.round {
+		h.postpone(msg)
+		return
+	}
+	switch msg.Code {
+	case msgPreprepare:
+		h.handlePreprepare(msg)
+	case msgPrepare:
+		h.handlePrepare(msg)
+	}
+}
+
+// postpone stores a message of a future round or height in the backlog, dropping
+// the oldest one if the backlog is full.
+func (h *handler) postpone(msg *message) {
+	if len(h.backlog) >= maxBacklog {
+		h.backlog = h.backlog[1:]
+	}
+	h.backlog = append(h.backlog, msg)
+}
+
+// handlePreprepare accepts the block proposed in the current round if it's valid
+// and doesn't conflict with the lock of the local node, preparing it. A proposal
+// carrying a prepared certificate newer than the lock replaces it.
+func (h *handler) handlePreprepare(msg *message) {
+	if msg.sender != h.snap.proposer(msg.Round) {
+		log.Debug("Discarding proposal of wrong proposer", "msg", msg, "sender", msg.sender)
+		return
+	}
+	if h.proposal != nil {
+		return
+	}
+	block, err := msg.proposal()
+	if err != nil {
+		log.Debug("Discarding invalid proposal", "msg", msg, "err", err)
+		return
+	}
+	if block.ParentHash() != h.parent.Hash() {
+		log.Debug("Discarding proposal on different parent", "msg", msg, "parent", block.ParentHash())
+		return
+	}
+	h.relock(msg)
+	if h.locked != nil && h.locked.Hash() != block.Hash() {
+		log.Debug("Discarding proposal conflicting with lock", "msg", msg, "locked", h.locked.Hash())
+		return
+	}
+	if err := h.verify(block); err != nil {
+		log.Warn("Discarding invalid proposal", "number", block.Number(), "hash", block.Hash(), "err", err)
+		return
+	}
+	h.proposal = block
+	h.broadcast(&message{Code: msgPrepare, Height: msg.Height, Round: msg.Round, Digest: msg.Digest})
+
+	h.checkPrepared()
+	h.checkCommitted(block.Hash())
+}
+
+// verify checks the header of a proposed block against the consensus rules and
+// its body against the header.
+func (h *handler) verify(block *types.Block) error {
+	if err := h.engine.VerifyHeader(h.chain, block.Header(), true); err != nil {
+		return err
+	}
+	if hash := types.DeriveSha(block.Transactions()); hash != block.TxHash() {
+		return fmt.Errorf("%v: transaction root hash mismatch: have %x, want %x", errInvalidProposal, hash, block.TxHash())
+	}
+	if len(block.Uncles()) > 0 {
+		return fmt.Errorf("%v: uncles not allowed", errInvalidProposal)
+	}
+	return nil
+}
+
+// handlePrepare accounts a prepare of the current round, keeping its signature
+// for the prepared certificate.
+func (h *handler) handlePrepare(msg *message) {
+	if h.prepares[msg.Digest] == nil {
+		h.prepares[msg.Digest] = make(map[common.Address][]byte)
+	}
+	h.prepares[msg.Digest][msg.sender] = msg.Signature
+	h.checkPrepared()
+}
+
+// checkPrepared locks on the accepted proposal and commits it if a quorum of the
+// validators prepared it, keeping their prepares as the certificate of the lock.
+func (h *handler) checkPrepared() {
+	if h.proposal == nil || h.prepared {
+		return
+	}
+	hash := h.proposal.Hash()
+	if len(h.prepares[hash]) < h.snap.quorum() {
+		return
+	}
+	signer, signFn, validator := h.identity()
+	if !validator {
+		return
+	}
+	seal, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeBFT, CommitData(hash))
+	if err != nil {
+		log.Warn("Failed to sign commit", "number", h.proposal.Number(), "hash", hash, "err", err)
+		return
+	}
+	h.prepared = true
+	if h.locked == nil || h.lockedRound <= h.round {
+		cert := make([][]byte, 0, len(h.prepares[hash]))
+		for _, sig := range h.prepares[hash] {
+			cert = append(cert, sig)
+		}
+		h.locked, h.lockedRound, h.lockedCert = h.proposal, h.round, cert
+	}
+	h.broadcast(&message{Code: msgCommit, Height: h.proposal.NumberU64(), Round: h.round, Digest: hash, Seal: seal})
+}
+
+// handleCommit accounts the commit signature of a validator.
+func (h *handler) handleCommit(msg *message) {
+	// The commit signatures end up in the next header, ensure they belong to the sender
+	committers, err := h.snap.committers(msg.Digest, [][]byte{msg.Seal})
+	if err != nil || committers[0] != msg.sender {
+		log.Debug("Discarding invalid commit", "msg", msg, "sender", msg.sender, "err", err)
+		return
+	}
+	if h.commits[msg.Digest] == nil {
+		h.commits[msg.Digest] = make(map[common.Address][]byte)
+	}
+	h.commits[msg.Digest][msg.sender] = msg.Seal
+	h.checkCommitted(msg.Digest)
+}
+
+// relock moves the lock of the local node onto the block certified by a message
+// if its prepared certificate is valid and newer than the lock. A quorum prepared
+// that block after the lock was taken, so the locked block can't have been
+// committed in its round and holding on to it could stall the height for good.
+func (h *handler) relock(msg *message) {
+	if len(msg.Prepares) == 0 || (h.locked != nil && msg.PreparedRound <= h.lockedRound) {
+		return
+	}
+	if len(msg.Prepares) > len(h.snap.Validators) {
+		log.Debug("Discarding oversized prepared certificate", "msg", msg, "prepares", len(msg.Prepares))
+		return
+	}
+	block, err := msg.proposal()
+	if err != nil || block.ParentHash() != h.parent.Hash() {
+		log.Debug("Discarding invalid prepared certificate", "msg", msg, "err", err)
+		return
+	}
+	preparers, err := msg.preparers()
+	if err != nil {
+		log.Debug("Discarding invalid prepared certificate", "msg", msg, "err", err)
+		return
+	}
+	var prepared int
+	for _, preparer := range preparers {
+		if _, ok := h.snap.Validators[preparer]; ok {
+			prepared++
+		}
+	}
+	if prepared < h.snap.quorum() {
+		log.Debug("Discarding prepared certificate without quorum", "msg", msg, "prepares", prepared)
+		return
+	}
+	if h.locked != nil && h.locked.Hash() != block.Hash() {
+		log.Debug("Releasing lock on newer prepared certificate", "locked", h.locked.Hash(), "round", h.lockedRound, "hash", block.Hash(), "prepared", msg.PreparedRound)
+	}
+	h.locked, h.lockedRound, h.lockedCert = block, msg.PreparedRound, msg.Prepares
+	h.blocks[block.Hash()] = block
+}
+
+// checkCommitted finalizes a block if a quorum of the validators committed it
+// and imports it into the chain.
+func (h *handler) checkCommitted(hash common.Hash) {
+	if h.final != nil || len(h.commits[hash]) < h.snap.quorum() {
+		return
+	}
+	block := h.blocks[hash]
+	if block == nil {
+		return // Committed by others, but the proposal never reached us
+	}
+	// Gather the commit signatures in validator order and persist them for the child
+	committers := make([]common.Address, 0, len(h.commits[hash]))
+	for committer := range h.commits[hash] {
+		committers = append(committers, committer)
+	}
+	sort.Sort(validatorsAscending(committers))
+
+	commit := make([][]byte, len(committers))
+	for i, committer := range committers {
+		commit[i] = h.commits[hash][committer]
+	}
+	if err := h.engine.storeCommit(hash, commit); err != nil {
+		log.Error("Failed to store commit", "number", block.Number(), "hash", hash, "err", err)
+		return
+	}
+	h.final = block
+	log.Info("Finalized block", "number", block.Number(), "hash", hash, "round", h.round, "commits", len(commit))
+
+	h.deliver(block)
+}
+
+// deliver imports a finalized block: into the miner's results channel if it's
+// the local candidate, directly into the chain otherwise.
+func (h *handler) deliver(block *types.Block) {
+	if h.cand != nil && SealHash(h.cand.block.Header()) == SealHash(block.Header()) {
+		select {
+		case <-h.cand.stop:
+		default:
+			select {
+			case h.cand.results <- block:
+				return
+			default:
+			}
+		}
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		if _, err := h.chain.InsertChain(types.Blocks{block}); err != nil {
+			log.Error("Failed to import finalized block", "number", block.Number(), "hash", block.Hash(), "err", err)
+		}
+	}()
+}
+
+// handleRoundChange accounts a request to move to a future round, moving there
+// once a quorum of the validators asked for it. If enough validators ask for a
+// round ahead of the local node for at least one of them to be honest, the local
+// node joins the request. Requests too far ahead of the local target are ignored
+// and any prepared certificate carried by a request may release the lock.
+func (h *handler) handleRoundChange(msg *message) {
+	if msg.Round <= h.round || msg.Round > h.target+maxFutureRounds {
+		return
+	}
+	h.relock(msg)
+
+	if h.changes[msg.Round] == nil {
+		h.changes[msg.Round] = make(map[common.Address]struct{})
+	}
+	h.changes[msg.Round][msg.sender] = struct{}{}
+
+	if len(h.changes[msg.Round]) > h.snap.faulty() && msg.Round > h.target && h.final == nil {
+		h.target = msg.Round
+		h.roundTimer = time.After(h.roundTimeout(h.target))
+		h.broadcast(h.roundChange(msg.Round))
+	}
+	if msg.Round > h.round && len(h.changes[msg.Round]) >= h.snap.quorum() {
+		h.startRound(msg.Round)
+	}
+}
+
+// updateStatus refreshes the consensus state snapshot served to the API.
+func (h *handler) updateStatus() {
+	if h.snap == nil {
+		return
+	}
+	_, _, validator := h.identity()
+	status := Status{
+		Height:    h.parent.Number.Uint64() + 1,
+		Round:     h.round,
+		Proposer:  h.snap.proposer(h.round),
+		Validator: validator,
+		Quorum:    h.snap.quorum(),
+	}
+	if h.proposal != nil {
+		hash := h.proposal.Hash()
+		status.Proposal = &hash
+		status.Prepares = len(h.prepares[hash])
+		status.Commits = len(h.commits[hash])
+	}
+	if h.locked != nil {
+		hash := h.locked.Hash()
+		status.Locked = &hash
+	}
+	h.statusLock.Lock()
+	h.status = status
+	h.statusLock.Unlock()
+}
+
+// runPeer runs the consensus protocol with a remote peer, relaying its messages
+// until the connection is torn down.
+func (h *handler) runPeer(p *peer) error {
+	if err := p.handshake(h.genesis); err != nil {
+		p.Log().Debug("Consensus handshake failed", "err", err)
+		return err
+	}
+	h.peerLock.Lock()
+	if _, ok := h.peers[p.id]; ok {
+		h.peerLock.Unlock()
+		return p2p.DiscAlreadyConnected
+	}
+	h.peers[p.id] = p
+	h.peerLock.Unlock()
+
+	defer func() {
+		h.peerLock.Lock()
+		delete(h.peers, p.id)
+		h.peerLock.Unlock()
+	}()
+	go p.broadcast()
+	defer close(p.term)
+
+	for {
+		msg, err := p.rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		if msg.Size > protocolMaxMsgSize {
+			msg.Discard()
+			return fmt.Errorf("message too large: %v > %v", msg.Size, protocolMaxMsgSize)
+		}
+		if msg.Code != consensusMsg {
+			msg.Discard()
+			return fmt.Errorf("invalid message code: %v", msg.Code)
+		}
+		m := new(message)
+		if err := msg.Decode(m); err != nil {
+			return fmt.Errorf("msg %v: %v", msg, err)
+		}
+		if err := h.receive(p, m); err != nil {
+			return err
+		}
+	}
+}
+
+// receive validates a consensus message of a remote peer, gossiping it to the
+// other peers and queueing it for processing if it was not seen before.
+func (h *handler) receive(p *peer, msg *message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	if h.known.Contains(msg.hash) {
+		return nil
+	}
+	h.known.Add(msg.hash, struct{}{})
+
+	if height := atomic.LoadUint64(&h.height); msg.Height < height || msg.Height > height+maxFutureHeights {
+		return nil
+	}
+	h.peerLock.RLock()
+	for id, other := range h.peers {
+		if id != p.id {
+			other.send(msg)
+		}
+	}
+	h.peerLock.RUnlock()
+
+	select {
+	case h.msgCh <- msg:
+		return nil
+	case <-h.quit:
+		return p2p.DiscQuitting
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Consensus message codes of the three phase commit and the round changes.
+const (
+	msgPreprepare uint64 = iota
+	msgPrepare
+	msgCommit
+	msgRoundChange
+)
+
+// errInvalidMessage is returned if a consensus message is malformed.
+var errInvalidMessage = errors.New("invalid consensus message")
+
+// message is a signed consensus message exchanged by the validators.
+type message struct {
+	Code          uint64      // Consensus phase of the message
+	Height        uint64      // Number of the block being agreed upon
+	Round         uint64      // Round of the height the message belongs to
+	Digest        common.Hash // Hash of the block the message is about (prepared block for round changes)
+	Proposal      []byte      // RLP encoded proposed or prepared block (pre-prepares and certified round changes)
+	Seal          []byte      // Commit signature over the block hash (commits only)
+	PreparedRound uint64      // Round in which a quorum prepared the block (certified messages only)
+	Prepares      [][]byte    // Prepare signatures of a quorum over the block (certified messages only)
+	Signature     []byte      // Signature of the sender over the rest of the message
+
+	sender common.Address // Validator who sent the message, cached on validation
+	hash   common.Hash    // Hash of the entire message, cached on validation
+}
+
+// String implements fmt.Stringer.
+func (m *message) String() string {
+	names := map[uint64]string{msgPreprepare: "preprepare", msgPrepare: "prepare", msgCommit: "commit", msgRoundChange: "roundchange"}
+	return fmt.Sprintf("%s #%d/%d [%x…]", names[m.Code], m.Height, m.Round, m.Digest[:4])
+}
+
+// payload returns the RLP encoding of the message without its signature, which
+// is the data the sender signs.
+func (m *message) payload() []byte {
+	blob, err := rlp.EncodeToBytes([]interface{}{m.Code, m.Height, m.Round, m.Digest, m.Proposal, m.Seal, m.PreparedRound, m.Prepares})
+	if err != nil {
+		panic("can't encode: " + err.Error())
+	}
+	return blob
+}
+
+// validate checks the basic sanity of the message, recovering and caching its
+// sender and hash.
+func (m *message) validate() error {
+	if m.Code > msgRoundChange {
+		return errInvalidMessage
+	}
+	// Pre-prepares and round changes may carry the prepared certificate of an
+	// earlier round, along with the block it certifies
+	certified := len(m.Prepares) > 0
+	if certified && ((m.Code != msgPreprepare && m.Code != msgRoundChange) || m.PreparedRound >= m.Round) {
+		return errInvalidMessage
+	}
+	if !certified && m.PreparedRound != 0 {
+		return errInvalidMessage
+	}
+	if (m.Code == msgPreprepare || certified) != (len(m.Proposal) > 0) || (m.Code == msgCommit) != (len(m.Seal) > 0) {
+		return errInvalidMessage
+	}
+	pubkey, err := crypto.Ecrecover(crypto.Keccak256(m.payload()), m.Signature)
+	if err != nil {
+		return err
+	}
+	copy(m.sender[:], crypto.Keccak256(pubkey[1:])[12:])
+
+	blob, err := rlp.EncodeToBytes(m)
+	if err != nil {
+		return err
+	}
+	m.hash = crypto.Keccak256Hash(blob)
+	return nil
+}
+
+// proposal decodes the block proposed by a pre-prepare message or certified by
+// a round change, ensuring its hash matches the digest.
+func (m *message) proposal() (*types.Block, error) {
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(m.Proposal, block); err != nil {
+		return nil, err
+	}
+	if block.Hash() != m.Digest || block.NumberU64() != m.Height {
+		return nil, errInvalidMessage
+	}
+	return block, nil
+}
+
+// preparers recovers the validators who signed the prepare signatures of the
+// certificate carried by the message, skipping duplicates.
+func (m *message) preparers() ([]common.Address, error) {
+	prepare := &message{Code: msgPrepare, Height: m.Height, Round: m.PreparedRound, Digest: m.Digest}
+	sighash := crypto.Keccak256(prepare.payload())
+
+	var (
+		preparers = make([]common.Address, 0, len(m.Prepares))
+		seen      = make(map[common.Address]struct{})
+	)
+	for _, sig := range m.Prepares {
+		pubkey, err := crypto.Ecrecover(sighash, sig)
+		if err != nil {
+			return nil, err
+		}
+		var preparer common.Address
+		copy(preparer[:], crypto.Keccak256(pubkey[1:])[12:])
+
+		if _, ok := seen[preparer]; ok {
+			continue
+		}
+		seen[preparer] = struct{}{}
+		preparers = append(preparers, preparer)
+	}
+	return preparers, nil
+}
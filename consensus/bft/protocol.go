@@ -0,0 +1,177 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package bft
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// Constants to match up protocol versions and messages
+const (
+	protocolName    = "bft"
+	protocolVersion = 2
+	protocolLength  = 2 // Number of implemented message codes
+
+	protocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
+)
+
+// Protocol message codes
+const (
+	statusMsg    = 0x00
+	consensusMsg = 0x01
+)
+
+const (
+	handshakeTimeout = 5 * time.Second // Maximum time allowed for the status exchange
+	maxQueuedMsgs    = 1024            // Maximum number of consensus messages queued to a peer
+)
+
+// statusData is the network packet for the status message.
+type statusData struct {
+	Version uint32
+	Genesis common.Hash
+}
+
+// peer is a remote node running the consensus protocol.
+type peer struct {
+	*p2p.Peer
+	rw p2p.MsgReadWriter
+
+	id    string        // Unique identifier of the peer
+	queue chan *message // Consensus messages waiting to be sent
+	term  chan struct{} // Termination channel to stop the broadcaster
+}
+
+// newPeer creates a consensus protocol peer wrapping the devp2p connection.
+func newPeer(p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
+	id := p.ID()
+	return &peer{
+		Peer:  p,
+		rw:    rw,
+		id:    fmt.Sprintf("%x", id[:8]),
+		queue: make(chan *message, maxQueuedMsgs),
+		term:  make(chan struct{}),
+	}
+}
+
+// handshake exchanges the protocol version and genesis hash with the remote
+// peer, failing if they mismatch.
+func (p *peer) handshake(genesis common.Hash) error {
+	errc := make(chan error, 2)
+	go func() {
+		errc <- p2p.Send(p.rw, statusMsg, &statusData{Version: protocolVersion, Genesis: genesis})
+	}()
+	go func() {
+		errc <- p.readStatus(genesis)
+	}()
+	timeout := time.NewTimer(handshakeTimeout)
+	defer timeout.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				return err
+			}
+		case <-timeout.C:
+			return p2p.DiscReadTimeout
+		}
+	}
+	return nil
+}
+
+// readStatus reads and checks the status message of the remote peer.
+func (p *peer) readStatus(genesis common.Hash) error {
+	msg, err := p.rw.ReadMsg()
+	if err != nil {
+		return err
+	}
+	defer msg.Discard()
+
+	if msg.Code != statusMsg {
+		return fmt.Errorf("first msg has code %x (!= %x)", msg.Code, statusMsg)
+	}
+	if msg.Size > protocolMaxMsgSize {
+		return fmt.Errorf("message too large: %v > %v", msg.Size, protocolMaxMsgSize)
+	}
+	var status statusData
+	if err := msg.Decode(&status); err != nil {
+		return fmt.Errorf("msg %v: %v", msg, err)
+	}
+	if status.Version != protocolVersion {
+		return fmt.Errorf("protocol version mismatch: %d (!= %d)", status.Version, protocolVersion)
+	}
+	if status.Genesis != genesis {
+		return fmt.Errorf("genesis block mismatch: %x (!= %x)", status.Genesis[:8], genesis[:8])
+	}
+	return nil
+}
+
+// send queues a consensus message to be sent to the peer, dropping it if the
+// peer cannot keep up.
+func (p *peer) send(msg *message) {
+	select {
+	case p.queue <- msg:
+	default:
+		p.Log().Debug("Dropping consensus message", "msg", msg)
+	}
+}
+
+// broadcast is a write loop that sends the queued consensus messages to the
+// remote peer until it's terminated.
+func (p *peer) broadcast() {
+	for {
+		select {
+		case msg := <-p.queue:
+			if err := p2p.Send(p.rw, consensusMsg, msg); err != nil {
+				return
+			}
+		case <-p.term:
+			return
+		}
+	}
+}
+
+// makeProtocol creates the devp2p subprotocol running the consensus messages
+// among the validators.
+func (b *BFT) makeProtocol() p2p.Protocol {
+	return p2p.Protocol{
+		Name:    protocolName,
+		Version: protocolVersion,
+		Length:  protocolLength,
+		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			h := b.running()
+			if h == nil {
+				return errNotRunning
+			}
+			return h.runPeer(newPeer(p, rw))
+		},
+		NodeInfo: func() interface{} {
+			if h := b.running(); h != nil {
+				return h.Status()
+			}
+			return nil
+		},
+		PeerInfo: func(id enode.ID) interface{} {
+			return nil
+		},
+	}
+}
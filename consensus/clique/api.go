@@ -17,6 +17,7 @@
 package clique
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -120,6 +121,52 @@ func (api *API) Discard(address common.Address) {
 	delete(api.clique.proposals, address)
 }
 
+// GetVotes retrieves the authorization votes cast between the given blocks
+// (inclusive). If no range is requested, the votes of the current epoch are
+// returned. The pending block has no votes yet, so it is treated as the head.
+func (api *API) GetVotes(from *rpc.BlockNumber, to *rpc.BlockNumber) ([]*VoteRecord, error) {
+	head := api.chain.CurrentHeader().Number.Uint64()
+
+	// Resolve the symbolic block numbers to the head of the chain
+	resolve := func(number rpc.BlockNumber) uint64 {
+		if number == rpc.LatestBlockNumber || number == rpc.PendingBlockNumber {
+			return head
+		}
+		return uint64(number.Int64())
+	}
+	end := head
+	if to != nil {
+		end = resolve(*to)
+	}
+	start := head - head%api.clique.config.Epoch
+	if from != nil {
+		start = resolve(*from)
+	}
+	if start > end {
+		return nil, fmt.Errorf("invalid range: from %d > to %d", start, end)
+	}
+	return api.clique.Votes(api.chain, start, end)
+}
+
+// GetSignerHistory retrieves all the additions and removals of authorized
+// signers, starting with the genesis signers.
+func (api *API) GetSignerHistory() ([]*SignerChange, error) {
+	return api.clique.SignerHistory(api.chain)
+}
+
+// GetSignerStats retrieves the in-turn and out-of-turn sealed blocks, the missed
+// in-turn slots and the average sealing delay of each signer over the given
+// number of recent blocks (default 256).
+func (api *API) GetSignerStats(window *uint64) (*SignerReport, error) {
+	if window == nil {
+		return api.clique.SignerReport(api.chain, 0)
+	}
+	if *window == 0 {
+		return nil, errors.New("empty window")
+	}
+	return api.clique.SignerReport(api.chain, *window)
+}
+
 type status struct {
 	InturnPercent float64                `json:"inturnPercent"`
 	SigningStatus map[common.Address]int `json:"sealerActivity"`
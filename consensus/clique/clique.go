@@ -176,6 +176,8 @@ type Clique struct {
 	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
 
 	proposals map[common.Address]bool // Current list of proposals we are pushing
+	history   *voteIndex              // Index of the votes and signer changes of the chain
+	monitor   *signerMonitor          // Sealing performance tracker of the signers
 
 	signer common.Address // Ethereum address of the signing key
 	signFn SignerFn       // Signer function to authorize hashes with
@@ -203,6 +205,8 @@ func New(config *params.CliqueConfig, db ethdb.Database) *Clique {
 		recents:    recents,
 		signatures: signatures,
 		proposals:  make(map[common.Address]bool),
+		history:    new(voteIndex),
+		monitor:    new(signerMonitor),
 	}
 }
 
@@ -0,0 +1,275 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// VoteRecord is a single authorization vote cast in the canonical header chain.
+type VoteRecord struct {
+	Block     uint64         `json:"block"`     // Block number the vote was cast in
+	Hash      common.Hash    `json:"hash"`      // Hash of the block the vote was cast in
+	Signer    common.Address `json:"signer"`    // Authorized signer that cast this vote
+	Address   common.Address `json:"address"`   // Account being voted on to change its authorization
+	Authorize bool           `json:"authorize"` // Whether to authorize or deauthorize the voted account
+	Passed    bool           `json:"passed"`    // Whether the vote tipped the tally and changed the signer set
+}
+
+// SignerChange is a modification of the authorized signer set.
+type SignerChange struct {
+	Block      uint64           `json:"block"`      // Block number the change took effect in
+	Hash       common.Hash      `json:"hash"`       // Hash of the block the change took effect in
+	Address    common.Address   `json:"address"`    // Account added to or removed from the signer set
+	Authorized bool             `json:"authorized"` // Whether the account was added or removed
+	Signers    []common.Address `json:"signers"`    // Signer set after the change, in ascending order
+}
+
+// historyCommitInterval is the number of headers replayed between publishing
+// the progress of the voting history index.
+const historyCommitInterval = 4096
+
+// voteIndex is an in-memory index of all the votes and signer set changes of
+// the canonical chain, built by replaying the headers through the snapshots.
+// It is extended lazily up to the chain head whenever queried, rewinding to the
+// common ancestor on reorgs.
+//
+// The headers are replayed on a private copy of the index without holding the
+// lock, publishing the progress periodically, so a long initial replay doesn't
+// block the readers and is resumed by later queries if interrupted.
+type voteIndex struct {
+	snap    *Snapshot       // Voting snapshot at the last indexed header
+	votes   []*VoteRecord   // Votes cast, in chronological order
+	changes []*SignerChange // Signer set changes, in chronological order
+	lock    sync.Mutex      // Mutex protecting the published index fields
+}
+
+// copy creates a private working copy of the published index. The record lists
+// are capped to prevent appends from overwriting the published ones.
+func (idx *voteIndex) copy() *voteIndex {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	return &voteIndex{
+		snap:    idx.snap,
+		votes:   idx.votes[:len(idx.votes):len(idx.votes)],
+		changes: idx.changes[:len(idx.changes):len(idx.changes)],
+	}
+}
+
+// publish replaces the index with the given working copy if nobody else updated
+// it since the copy was made from the given snapshot.
+func (idx *voteIndex) publish(work *voteIndex, base *Snapshot) bool {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	if idx.snap != base {
+		return false
+	}
+	idx.snap, idx.votes, idx.changes = work.snap, work.votes, work.changes
+	return true
+}
+
+// update extends the index up to the current head of the given chain. If the
+// index is concurrently updated by someone else, the work is restarted from
+// their progress.
+func (idx *voteIndex) update(c *Clique, chain consensus.ChainReader) error {
+	for {
+		done, err := idx.tryUpdate(c, chain)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// tryUpdate attempts to extend the index up to the current head of the given
+// chain, returning false if it was concurrently updated by someone else.
+func (idx *voteIndex) tryUpdate(c *Clique, chain consensus.ChainReader) (bool, error) {
+	var (
+		work = idx.copy()
+		base = work.snap
+	)
+	// Rewind the index to the common ancestor if the chain was reorganised
+	if work.snap != nil {
+		number, hash := work.snap.Number, work.snap.Hash
+		for {
+			if canon := chain.GetHeaderByNumber(number); canon != nil && canon.Hash() == hash {
+				break
+			}
+			header := chain.GetHeader(hash, number)
+			if header == nil || number == 0 {
+				// Side chain pruned from the database, start over
+				work.snap, work.votes, work.changes = nil, nil, nil
+				break
+			}
+			number, hash = number-1, header.ParentHash
+		}
+		if work.snap != nil && number != work.snap.Number {
+			snap, err := c.snapshot(chain, number, hash, nil)
+			if err != nil {
+				return false, err
+			}
+			work.snap = snap
+			work.truncate(number)
+		}
+	}
+	// Start with the genesis signers if the index is empty
+	if work.snap == nil {
+		genesis := chain.GetHeaderByNumber(0)
+		if genesis == nil {
+			return false, errUnknownBlock
+		}
+		snap, err := c.snapshot(chain, 0, genesis.Hash(), nil)
+		if err != nil {
+			return false, err
+		}
+		signers := snap.signers()
+		for _, signer := range signers {
+			work.changes = append(work.changes, &SignerChange{
+				Hash:       genesis.Hash(),
+				Address:    signer,
+				Authorized: true,
+				Signers:    signers,
+			})
+		}
+		work.snap = snap
+	}
+	// Replay all the new headers one by one, recording votes and signer changes
+	var (
+		head   = chain.CurrentHeader().Number.Uint64()
+		start  = time.Now()
+		logged = time.Now()
+	)
+	for number := work.snap.Number + 1; number <= head; number++ {
+		header := chain.GetHeaderByNumber(number)
+		if header == nil {
+			return false, errUnknownBlock
+		}
+		if err := work.apply(header); err != nil {
+			return false, err
+		}
+		if number%historyCommitInterval == 0 {
+			if !idx.publish(work, base) {
+				return false, nil
+			}
+			base = work.snap
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Indexing clique voting history", "number", number, "head", head, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if work.snap == base {
+		return true, nil
+	}
+	return idx.publish(work, base), nil
+}
+
+// apply moves the index forward by a single header.
+func (idx *voteIndex) apply(header *types.Header) error {
+	snap, err := idx.snap.apply([]*types.Header{header})
+	if err != nil {
+		return err
+	}
+	var changed []*SignerChange
+	for signer := range idx.snap.Signers {
+		if _, ok := snap.Signers[signer]; !ok {
+			changed = append(changed, &SignerChange{Address: signer})
+		}
+	}
+	for signer := range snap.Signers {
+		if _, ok := idx.snap.Signers[signer]; !ok {
+			changed = append(changed, &SignerChange{Address: signer, Authorized: true})
+		}
+	}
+	for _, change := range changed {
+		change.Block, change.Hash, change.Signers = snap.Number, snap.Hash, snap.signers()
+	}
+	idx.changes = append(idx.changes, changed...)
+
+	// Checkpoint blocks and blocks without a vote carry an empty beneficiary
+	if header.Coinbase != (common.Address{}) {
+		signer, err := ecrecover(header, idx.snap.sigcache)
+		if err != nil {
+			return err
+		}
+		idx.votes = append(idx.votes, &VoteRecord{
+			Block:     snap.Number,
+			Hash:      snap.Hash,
+			Signer:    signer,
+			Address:   header.Coinbase,
+			Authorize: bytes.Equal(header.Nonce[:], nonceAuthVote),
+			Passed:    len(changed) > 0,
+		})
+	}
+	idx.snap = snap
+	return nil
+}
+
+// truncate drops all the records above the given block number. The remaining
+// lists are capped to prevent appends from overwriting the dropped records, as
+// they may still be shared with the published index.
+func (idx *voteIndex) truncate(number uint64) {
+	votes := len(idx.votes)
+	for votes > 0 && idx.votes[votes-1].Block > number {
+		votes--
+	}
+	idx.votes = idx.votes[:votes:votes]
+
+	changes := len(idx.changes)
+	for changes > 0 && idx.changes[changes-1].Block > number {
+		changes--
+	}
+	idx.changes = idx.changes[:changes:changes]
+}
+
+// Votes retrieves the authorization votes cast in the canonical chain between
+// the given block numbers (inclusive).
+func (c *Clique) Votes(chain consensus.ChainReader, from, to uint64) ([]*VoteRecord, error) {
+	if err := c.history.update(c, chain); err != nil {
+		return nil, err
+	}
+	c.history.lock.Lock()
+	defer c.history.lock.Unlock()
+
+	votes := make([]*VoteRecord, 0)
+	for _, vote := range c.history.votes {
+		if vote.Block >= from && vote.Block <= to {
+			votes = append(votes, vote)
+		}
+	}
+	return votes, nil
+}
+
+// SignerHistory retrieves all the changes of the authorized signer set in the
+// canonical chain, starting with the genesis signers.
+func (c *Clique) SignerHistory(chain consensus.ChainReader) ([]*SignerChange, error) {
+	if err := c.history.update(c, chain); err != nil {
+		return nil, err
+	}
+	c.history.lock.Lock()
+	defer c.history.lock.Unlock()
+
+	return append([]*SignerChange{}, c.history.changes...), nil
+}
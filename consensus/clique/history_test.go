@@ -0,0 +1,149 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Tests that the voting history index tracks all the votes cast and the signer
+// set changes they result in.
+func TestVotingHistory(t *testing.T) {
+	var (
+		accounts = newTesterAccountPool()
+		signers  = []string{"A", "B"}
+		votes    = []testerVote{
+			{signer: "A", voted: "C", auth: true},
+			{signer: "B", voted: "C", auth: true},
+			{signer: "C", voted: "D", auth: true},
+			{signer: "A", voted: "B"},
+		}
+	)
+	// Create the genesis block with the initial set of signers
+	genesis := &core.Genesis{
+		ExtraData: make([]byte, extraVanity+common.AddressLength*len(signers)+extraSeal),
+	}
+	accounts.checkpoint(&types.Header{Extra: genesis.ExtraData}, signers)
+
+	db := rawdb.NewMemoryDatabase()
+	genesis.Commit(db)
+
+	config := *params.TestChainConfig
+	config.Clique = &params.CliqueConfig{Period: 1, Epoch: 30000}
+	engine := New(config.Clique, db)
+	engine.fakeDiff = true
+
+	blocks, _ := core.GenerateChain(&config, genesis.ToBlock(db), engine, db, len(votes), func(j int, gen *core.BlockGen) {
+		gen.SetCoinbase(accounts.address(votes[j].voted))
+		if votes[j].auth {
+			var nonce types.BlockNonce
+			copy(nonce[:], nonceAuthVote)
+			gen.SetNonce(nonce)
+		}
+	})
+	for j, block := range blocks {
+		header := block.Header()
+		if j > 0 {
+			header.ParentHash = blocks[j-1].Hash()
+		}
+		header.Extra = make([]byte, extraVanity+extraSeal)
+		header.Difficulty = diffInTurn
+
+		accounts.sign(header, votes[j].signer)
+		blocks[j] = block.WithSeal(header)
+	}
+	chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create test chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	// Query the index concurrently while it's being built
+	var pend sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+			if _, err := engine.SignerHistory(chain); err != nil {
+				t.Errorf("failed to retrieve signer history: %v", err)
+			}
+		}()
+	}
+	pend.Wait()
+
+	// Verify the recorded votes
+	records, err := engine.Votes(chain, 0, uint64(len(blocks)))
+	if err != nil {
+		t.Fatalf("failed to retrieve votes: %v", err)
+	}
+	if len(records) != len(votes) {
+		t.Fatalf("vote count mismatch: have %d, want %d", len(records), len(votes))
+	}
+	for i, record := range records {
+		if record.Block != uint64(i+1) || record.Hash != blocks[i].Hash() {
+			t.Errorf("vote %d: location mismatch: have #%d [%x]", i, record.Block, record.Hash)
+		}
+		if record.Signer != accounts.address(votes[i].signer) || record.Address != accounts.address(votes[i].voted) || record.Authorize != votes[i].auth {
+			t.Errorf("vote %d: content mismatch: have %+v", i, record)
+		}
+		if passed := i == 1; record.Passed != passed {
+			t.Errorf("vote %d: passed mismatch: have %v, want %v", i, record.Passed, passed)
+		}
+	}
+	if records, _ := engine.Votes(chain, 2, 3); len(records) != 2 {
+		t.Errorf("ranged vote count mismatch: have %d, want %d", len(records), 2)
+	}
+	// Symbolic block numbers must resolve to the chain head
+	api := &API{chain: chain, clique: engine}
+	for _, number := range []rpc.BlockNumber{rpc.LatestBlockNumber, rpc.PendingBlockNumber} {
+		records, err := api.GetVotes(&number, &number)
+		if err != nil {
+			t.Fatalf("failed to retrieve votes from %d: %v", number, err)
+		}
+		if len(records) != 1 || records[0].Block != uint64(len(blocks)) {
+			t.Errorf("head votes mismatch from %d: have %v", number, records)
+		}
+	}
+	// Verify the signer set timeline
+	changes, err := engine.SignerHistory(chain)
+	if err != nil {
+		t.Fatalf("failed to retrieve signer history: %v", err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("signer change count mismatch: have %d, want %d", len(changes), 3)
+	}
+	for i := 0; i < 2; i++ {
+		if changes[i].Block != 0 || !changes[i].Authorized || len(changes[i].Signers) != 2 {
+			t.Errorf("genesis change %d mismatch: have %+v", i, changes[i])
+		}
+	}
+	if change := changes[2]; change.Block != 2 || change.Address != accounts.address("C") || !change.Authorized || len(change.Signers) != 3 {
+		t.Errorf("signer addition mismatch: have %+v", change)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// monitorWindow is the number of recent headers the signer performance is
+// tracked over by default.
+const monitorWindow = 256
+
+// SignerStats is the sealing performance of a single signer.
+type SignerStats struct {
+	InTurn    uint64  `json:"inTurn"`    // Blocks sealed in-turn
+	OutOfTurn uint64  `json:"outOfTurn"` // Blocks sealed out-of-turn
+	Missed    uint64  `json:"missed"`    // In-turn slots sealed by another signer
+	Delay     float64 `json:"avgDelay"`  // Average seconds the sealed blocks exceeded the period with
+}
+
+// SignerReport is the sealing performance of all signers over a window of
+// recent headers.
+type SignerReport struct {
+	Number  uint64                          `json:"number"`  // Last block of the window
+	Hash    common.Hash                     `json:"hash"`    // Hash of the last block of the window
+	Window  uint64                          `json:"window"`  // Number of headers the statistics span
+	Signers map[common.Address]*SignerStats `json:"signers"` // Statistics of the authorized signers
+}
+
+// signerMonitor caches the latest signer performance report of the chain head.
+type signerMonitor struct {
+	report *SignerReport // Report over the default window ending at the last tracked head
+	warn   bool          // Whether to warn if the local signer misses its slot
+	lock   sync.RWMutex
+}
+
+// signerReport replays the given number of headers ending at head, computing the
+// sealing performance of each signer.
+func (c *Clique) signerReport(chain consensus.ChainReader, head *types.Header, window uint64) (*SignerReport, error) {
+	if number := head.Number.Uint64(); window > number {
+		window = number
+	}
+	// Gather the headers of the window and their shared parent
+	headers := make([]*types.Header, window)
+	parent := head
+	for i := int(window) - 1; i >= 0; i-- {
+		headers[i] = parent
+		if parent = chain.GetHeader(parent.ParentHash, parent.Number.Uint64()-1); parent == nil {
+			return nil, consensus.ErrUnknownAncestor
+		}
+	}
+	snap, err := c.snapshot(chain, parent.Number.Uint64(), parent.Hash(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		stats  = make(map[common.Address]*SignerStats)
+		delays = make(map[common.Address]uint64)
+	)
+	statsOf := func(signer common.Address) *SignerStats {
+		if stats[signer] == nil {
+			stats[signer] = new(SignerStats)
+		}
+		return stats[signer]
+	}
+	// Replay the window, checking the turn-ness of each header against its parent
+	for _, header := range headers {
+		number := header.Number.Uint64()
+
+		signer, err := ecrecover(header, c.signatures)
+		if err != nil {
+			return nil, err
+		}
+		if signers := snap.signers(); len(signers) > 0 {
+			if inturn := signers[number%uint64(len(signers))]; inturn == signer {
+				statsOf(signer).InTurn++
+			} else {
+				statsOf(signer).OutOfTurn++
+				statsOf(inturn).Missed++
+			}
+		}
+		if header.Time > parent.Time+c.config.Period {
+			delays[signer] += header.Time - parent.Time - c.config.Period
+		}
+		if snap, err = snap.apply([]*types.Header{header}); err != nil {
+			return nil, err
+		}
+		parent = header
+	}
+	// Report on the current signers only, idle ones included
+	report := &SignerReport{
+		Number:  head.Number.Uint64(),
+		Hash:    head.Hash(),
+		Window:  window,
+		Signers: make(map[common.Address]*SignerStats),
+	}
+	for signer := range snap.Signers {
+		report.Signers[signer] = statsOf(signer)
+		if sealed := stats[signer].InTurn + stats[signer].OutOfTurn; sealed > 0 {
+			report.Signers[signer].Delay = float64(delays[signer]) / float64(sealed)
+		}
+	}
+	return report, nil
+}
+
+// SignerReport retrieves the sealing performance of the signers over the given
+// number of headers ending at the current head. A zero window requests the
+// default one, served from the cache if the head did not move since.
+func (c *Clique) SignerReport(chain consensus.ChainReader, window uint64) (*SignerReport, error) {
+	head := chain.CurrentHeader()
+	if window == 0 {
+		c.monitor.lock.RLock()
+		report := c.monitor.report
+		c.monitor.lock.RUnlock()
+
+		if report != nil && report.Hash == head.Hash() {
+			return report, nil
+		}
+		window = monitorWindow
+	}
+	return c.signerReport(chain, head, window)
+}
+
+// SetMissedSlotWarnings sets whether a warning is logged whenever another signer
+// seals a block in the local signer's turn.
+func (c *Clique) SetMissedSlotWarnings(enabled bool) {
+	c.monitor.lock.Lock()
+	defer c.monitor.lock.Unlock()
+
+	c.monitor.warn = enabled
+}
+
+// TrackHead updates the signer performance report and metrics with a new chain
+// head, warning about a missed slot of the local signer if requested.
+func (c *Clique) TrackHead(chain consensus.ChainReader, head *types.Header) {
+	report, err := c.signerReport(chain, head, monitorWindow)
+	if err != nil {
+		log.Debug("Failed to track clique signers", "number", head.Number, "hash", head.Hash(), "err", err)
+		return
+	}
+	for signer, stats := range report.Signers {
+		prefix := fmt.Sprintf("clique/signer/%x/", signer)
+		metrics.GetOrRegisterGauge(prefix+"inturn", nil).Update(int64(stats.InTurn))
+		metrics.GetOrRegisterGauge(prefix+"outofturn", nil).Update(int64(stats.OutOfTurn))
+		metrics.GetOrRegisterGauge(prefix+"missed", nil).Update(int64(stats.Missed))
+		metrics.GetOrRegisterGaugeFloat64(prefix+"delay", nil).Update(stats.Delay)
+	}
+	c.monitor.lock.Lock()
+	c.monitor.report = report
+	warn := c.monitor.warn
+	c.monitor.lock.Unlock()
+
+	if !warn || head.Number.Uint64() == 0 {
+		return
+	}
+	c.lock.RLock()
+	local := c.signer
+	c.lock.RUnlock()
+
+	if local == (common.Address{}) {
+		return
+	}
+	snap, err := c.snapshot(chain, head.Number.Uint64()-1, head.ParentHash, nil)
+	if err != nil || !snap.inturn(head.Number.Uint64(), local) {
+		return
+	}
+	if sealer, err := ecrecover(head, c.signatures); err == nil && sealer != local {
+		log.Warn("Local signer missed its in-turn slot", "number", head.Number, "hash", head.Hash(), "sealer", sealer)
+	}
+}
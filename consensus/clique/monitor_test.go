@@ -0,0 +1,109 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that the signer monitor correctly attributes in-turn, out-of-turn and
+// missed slots to the signers.
+func TestSignerReport(t *testing.T) {
+	accounts := newTesterAccountPool()
+
+	// Order the signers the way clique assigns their turns
+	signers := []string{"A", "B", "C"}
+	sort.Slice(signers, func(i, j int) bool {
+		a, b := accounts.address(signers[i]), accounts.address(signers[j])
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	// Seal three blocks in turn, then two out of turn (the second and the third
+	// signer missing their slots)
+	sealers := []string{signers[1], signers[2], signers[0], signers[2], signers[0]}
+
+	genesis := &core.Genesis{
+		ExtraData: make([]byte, extraVanity+common.AddressLength*len(signers)+extraSeal),
+	}
+	accounts.checkpoint(&types.Header{Extra: genesis.ExtraData}, signers)
+
+	db := rawdb.NewMemoryDatabase()
+	genesis.Commit(db)
+
+	config := *params.TestChainConfig
+	config.Clique = &params.CliqueConfig{Period: 1, Epoch: 30000}
+	engine := New(config.Clique, db)
+	engine.fakeDiff = true
+
+	blocks, _ := core.GenerateChain(&config, genesis.ToBlock(db), engine, db, len(sealers), nil)
+	for j, block := range blocks {
+		header := block.Header()
+		if j > 0 {
+			header.ParentHash = blocks[j-1].Hash()
+		}
+		header.Extra = make([]byte, extraVanity+extraSeal)
+		header.Difficulty = diffInTurn
+
+		accounts.sign(header, sealers[j])
+		blocks[j] = block.WithSeal(header)
+	}
+	chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create test chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	engine.TrackHead(chain, chain.CurrentHeader())
+
+	report, err := engine.SignerReport(chain, 0)
+	if err != nil {
+		t.Fatalf("failed to retrieve signer report: %v", err)
+	}
+	if report.Number != uint64(len(blocks)) || report.Window != uint64(len(blocks)) {
+		t.Fatalf("report span mismatch: have #%d/%d, want #%d/%d", report.Number, report.Window, len(blocks), len(blocks))
+	}
+	want := map[string]SignerStats{
+		signers[0]: {InTurn: 1, OutOfTurn: 1, Delay: 9},
+		signers[1]: {InTurn: 1, Missed: 1, Delay: 9},
+		signers[2]: {InTurn: 1, OutOfTurn: 1, Missed: 1, Delay: 9},
+	}
+	for name, stats := range want {
+		have := report.Signers[accounts.address(name)]
+		if have == nil || *have != stats {
+			t.Errorf("signer %s: stats mismatch: have %+v, want %+v", name, have, stats)
+		}
+	}
+	// Narrower windows must only account the most recent blocks
+	report, err = engine.SignerReport(chain, 2)
+	if err != nil {
+		t.Fatalf("failed to retrieve windowed signer report: %v", err)
+	}
+	if stats := report.Signers[accounts.address(signers[1])]; stats.InTurn != 0 || stats.Missed != 1 {
+		t.Errorf("windowed stats mismatch: have %+v", stats)
+	}
+}
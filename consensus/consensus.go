@@ -23,6 +23,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rpc"
 )
@@ -123,3 +124,12 @@ type PoW interface {
 	// Hashrate returns the current mining hashrate of a PoW consensus engine.
 	Hashrate() float64
 }
+
+// Handler is a consensus engine exchanging messages of its own among the sealers
+// over a dedicated network protocol.
+type Handler interface {
+	Engine
+
+	// Protocols returns the network protocols the consensus engine runs.
+	Protocols() []p2p.Protocol
+}
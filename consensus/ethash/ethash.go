@@ -48,7 +48,7 @@ var (
 	two256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
 
 	// sharedEthash is a full instance that can be shared between multiple users.
-	sharedEthash = New(Config{"", 3, 0, "", 1, 0, ModeNormal, nil}, nil, false)
+	sharedEthash = New(Config{"", 3, 0, "", 1, 0, ModeNormal, "", 0, nil}, nil, false)
 
 	// algorithmRevision is the data structure version used for file naming.
 	algorithmRevision = 23
@@ -402,6 +402,9 @@ type Config struct {
 	DatasetsOnDisk int
 	PowMode        Mode
 
+	StratumAddr       string `toml:",omitempty"` // Listening address of the stratum server (empty = disabled)
+	StratumDifficulty uint64 `toml:",omitempty"` // Share difficulty of the stratum miners (0 = block difficulty)
+
 	Log log.Logger `toml:"-"`
 }
 
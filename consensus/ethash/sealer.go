@@ -201,6 +201,7 @@ type remoteSealer struct {
 	ethash       *Ethash
 	noverify     bool
 	notifyURLs   []string
+	stratum      *stratumServer // Optional stratum server pushing the work to miners
 	results      chan<- *types.Block
 	workCh       chan *sealTask   // Notification channel to push new work and relative result channel to remote sealer
 	fetchWorkCh  chan *sealWork   // Channel used for remote sealer to fetch mining work
@@ -259,6 +260,14 @@ func startRemoteSealer(ethash *Ethash, urls []string, noverify bool) *remoteSeal
 		requestExit:  make(chan struct{}),
 		exitCh:       make(chan struct{}),
 	}
+	if addr := ethash.config.StratumAddr; addr != "" {
+		server, err := startStratumServer(s, addr, ethash.config.StratumDifficulty)
+		if err != nil {
+			ethash.config.Log.Error("Failed to start stratum server", "addr", addr, "err", err)
+		} else {
+			s.stratum = server
+		}
+	}
 	go s.loop()
 	return s
 }
@@ -269,6 +278,9 @@ func (s *remoteSealer) loop() {
 		s.cancelNotify()
 		s.reqWG.Wait()
 		close(s.exitCh)
+		if s.stratum != nil {
+			s.stratum.close()
+		}
 	}()
 
 	ticker := time.NewTicker(5 * time.Second)
@@ -282,6 +294,9 @@ func (s *remoteSealer) loop() {
 			s.results = work.results
 			s.makeWork(work.block)
 			s.notifyWork()
+			if s.stratum != nil {
+				s.stratum.newWork(work.block, s.ethash.SealHash(work.block.Header()))
+			}
 
 		case work := <-s.fetchWorkCh:
 			// Return current mining work to remote miner.
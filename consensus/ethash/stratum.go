@@ -0,0 +1,528 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// stratumProtocol is the protocol version announced to subscribing miners.
+	stratumProtocol = "EthereumStratum/1.0.0"
+
+	// stratumExtraNonceSize is the number of leading nonce bytes assigned by the
+	// server to each session, the rest being searched by the miner.
+	stratumExtraNonceSize = 2
+
+	// stratumJobs is the number of recent jobs shares are still accepted for.
+	stratumJobs = 8
+
+	// stratumSendQueue is the number of messages queued up for a session before
+	// it's considered too slow and dropped.
+	stratumSendQueue = 16
+
+	// stratumWriteTimeout is the maximum time allowed to deliver a message.
+	stratumWriteTimeout = 10 * time.Second
+
+	// stratumRateInterval is the interval in which the hashrate of the sessions
+	// is reported to the remote sealer (stale rates are dropped after 10s).
+	stratumRateInterval = 5 * time.Second
+)
+
+var (
+	// two32 is the share difficulty corresponding to a stratum difficulty of 1.
+	two32 = new(big.Int).Lsh(common.Big1, 32)
+
+	// Errors returned to the stratum miners, using the conventional error codes.
+	stratumErrUnknown      = []interface{}{20, "Other/Unknown", nil}
+	stratumErrJobNotFound  = []interface{}{21, "Job not found", nil}
+	stratumErrLowShare     = []interface{}{23, "Low difficulty share", nil}
+	stratumErrUnauthorized = []interface{}{24, "Unauthorized worker", nil}
+	stratumErrSubscription = []interface{}{25, "Not subscribed", nil}
+)
+
+// stratumJob is a mining work package pushed to the stratum miners.
+type stratumJob struct {
+	id         string      // Job identifier, the hex encoded seal hash
+	sealhash   common.Hash // Hash of the block header to seal
+	seedhash   common.Hash // Seed hash of the DAG the block is sealed with
+	number     uint64      // Number of the block being sealed
+	target     *big.Int    // Boundary a solution needs to be below to seal the block
+	difficulty *big.Int    // Difficulty of a share
+	share      *big.Int    // Boundary a share needs to be below to be accepted
+}
+
+// stratumRequest is a JSON-RPC message received from a stratum miner.
+type stratumRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// stratumResponse is a JSON-RPC reply sent to a stratum miner.
+type stratumResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  interface{}     `json:"error"`
+}
+
+// stratumNotification is a server initiated JSON-RPC message.
+type stratumNotification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// stratumServer is an EthereumStratum/1.0 TCP server pushing the work of the
+// remote sealer to connected miners and validating their share submissions.
+type stratumServer struct {
+	sealer     *remoteSealer
+	listener   net.Listener
+	difficulty *big.Int // Configured share difficulty (nil = block difficulty)
+
+	job      *stratumJob                // Most recent job pushed to the miners
+	jobs     []*stratumJob              // Recent jobs shares are accepted for, oldest first
+	sessions map[uint16]*stratumSession // Currently connected miners, keyed by extranonce
+	nonce    uint16                     // Next extranonce to try assigning to a session
+	lock     sync.Mutex
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// startStratumServer starts accepting stratum miners on the given address.
+func startStratumServer(sealer *remoteSealer, addr string, difficulty uint64) (*stratumServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &stratumServer{
+		sealer:   sealer,
+		listener: listener,
+		sessions: make(map[uint16]*stratumSession),
+		quit:     make(chan struct{}),
+	}
+	if difficulty > 0 {
+		s.difficulty = new(big.Int).SetUint64(difficulty)
+	}
+	s.wg.Add(1)
+	go s.accept()
+
+	sealer.ethash.config.Log.Info("Started stratum server", "addr", listener.Addr())
+	return s, nil
+}
+
+// close terminates the server and disconnects all miners.
+func (s *stratumServer) close() {
+	close(s.quit)
+	s.listener.Close()
+
+	s.lock.Lock()
+	for _, session := range s.sessions {
+		session.conn.Close()
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+}
+
+// accept handles the incoming miner connections until the server is closed.
+func (s *stratumServer) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+			default:
+				s.sealer.ethash.config.Log.Warn("Stratum server failed", "err", err)
+			}
+			return
+		}
+		// Assign an extranonce not held by any live session, refusing the miner
+		// if all of them are taken
+		s.lock.Lock()
+		if len(s.sessions) > math.MaxUint16 {
+			s.lock.Unlock()
+			s.sealer.ethash.config.Log.Warn("Stratum server full, refusing miner", "remote", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		for s.sessions[s.nonce] != nil {
+			s.nonce++
+		}
+		session := newStratumSession(s, conn, s.nonce)
+		s.sessions[s.nonce] = session
+		s.nonce++
+		s.lock.Unlock()
+
+		s.wg.Add(2)
+		go session.readLoop()
+		go session.writeLoop()
+	}
+}
+
+// drop removes a disconnected session.
+func (s *stratumServer) drop(session *stratumSession) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.sessions, session.nonce)
+}
+
+// newWork creates a new job from a block to seal and pushes it to all the
+// subscribed miners.
+func (s *stratumServer) newWork(block *types.Block, sealhash common.Hash) {
+	job := &stratumJob{
+		id:         hex.EncodeToString(sealhash[:]),
+		sealhash:   sealhash,
+		seedhash:   common.BytesToHash(SeedHash(block.NumberU64())),
+		number:     block.NumberU64(),
+		target:     new(big.Int).Div(two256, block.Difficulty()),
+		difficulty: block.Difficulty(),
+	}
+	if s.difficulty != nil && s.difficulty.Cmp(job.difficulty) < 0 {
+		job.difficulty = s.difficulty
+	}
+	job.share = new(big.Int).Div(two256, job.difficulty)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.job != nil && s.job.id == job.id {
+		return // Same work pushed again (e.g. thread count change)
+	}
+	s.job = job
+	if s.jobs = append(s.jobs, job); len(s.jobs) > stratumJobs {
+		s.jobs = s.jobs[1:]
+	}
+	for _, session := range s.sessions {
+		session.notify(job)
+	}
+}
+
+// currentJob retrieves the most recent job pushed to the miners.
+func (s *stratumServer) currentJob() *stratumJob {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.job
+}
+
+// findJob retrieves a recent job by identifier.
+func (s *stratumServer) findJob(id string) *stratumJob {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, job := range s.jobs {
+		if job.id == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// stratumSession is a single connected stratum miner.
+type stratumSession struct {
+	server     *stratumServer
+	conn       net.Conn
+	id         string // Session identifier returned on subscription
+	nonce      uint16 // Nonce prefix assigned to the session
+	extranonce string // Hex encoded nonce prefix assigned to the session
+
+	worker     string      // Name of the authorized worker
+	rateID     common.Hash // Identifier of the worker's hashrate at the remote sealer
+	subscribed bool
+	difficulty *big.Int // Share difficulty last sent to the miner
+
+	reported uint64   // Hashrate reported by the miner itself
+	work     *big.Int // Total difficulty of the accepted shares
+	since    time.Time
+
+	send   chan interface{}
+	closed chan struct{}
+	lock   sync.Mutex // Protects the fields accessed by both loops
+}
+
+func newStratumSession(server *stratumServer, conn net.Conn, nonce uint16) *stratumSession {
+	var extranonce [stratumExtraNonceSize]byte
+	binary.BigEndian.PutUint16(extranonce[:], nonce)
+
+	return &stratumSession{
+		server:     server,
+		conn:       conn,
+		id:         fmt.Sprintf("%08x", uint32(time.Now().UnixNano())^uint32(nonce)),
+		nonce:      nonce,
+		extranonce: hex.EncodeToString(extranonce[:]),
+		work:       new(big.Int),
+		send:       make(chan interface{}, stratumSendQueue),
+		closed:     make(chan struct{}),
+	}
+}
+
+// readLoop processes the requests of the miner until the connection is closed.
+func (s *stratumSession) readLoop() {
+	defer s.server.wg.Done()
+	defer func() {
+		close(s.closed)
+		s.conn.Close()
+		s.server.drop(s)
+	}()
+	reader := bufio.NewReader(s.conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var req stratumRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			s.server.sealer.ethash.config.Log.Debug("Invalid stratum request", "remote", s.conn.RemoteAddr(), "err", err)
+			return
+		}
+		result, fail := s.handle(&req)
+		if !s.queue(&stratumResponse{ID: req.ID, Result: result, Error: fail}) {
+			return
+		}
+		// Newly authorized miners start working on the current job right away
+		if req.Method == "mining.authorize" && fail == nil {
+			if job := s.server.currentJob(); job != nil {
+				s.notify(job)
+			}
+		}
+	}
+}
+
+// writeLoop delivers the queued messages to the miner and periodically reports
+// its hashrate to the remote sealer.
+func (s *stratumSession) writeLoop() {
+	defer s.server.wg.Done()
+
+	ticker := time.NewTicker(stratumRateInterval)
+	defer ticker.Stop()
+
+	enc := json.NewEncoder(s.conn)
+	for {
+		select {
+		case msg := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(stratumWriteTimeout))
+			if err := enc.Encode(msg); err != nil {
+				s.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			s.reportRate()
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// queue schedules a message for delivery, dropping the miner if it can't keep up.
+func (s *stratumSession) queue(msg interface{}) bool {
+	select {
+	case s.send <- msg:
+		return true
+	case <-s.closed:
+		return false
+	default:
+		s.server.sealer.ethash.config.Log.Debug("Dropping slow stratum miner", "remote", s.conn.RemoteAddr())
+		s.conn.Close()
+		return false
+	}
+}
+
+// notify pushes a job to the miner, preceded by its share difficulty if changed.
+func (s *stratumSession) notify(job *stratumJob) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.subscribed || s.worker == "" {
+		return
+	}
+	if s.difficulty == nil || s.difficulty.Cmp(job.difficulty) != 0 {
+		s.difficulty = job.difficulty
+		diff, _ := new(big.Float).Quo(new(big.Float).SetInt(job.difficulty), new(big.Float).SetInt(two32)).Float64()
+		s.queue(&stratumNotification{Method: "mining.set_difficulty", Params: []interface{}{diff}})
+	}
+	s.queue(&stratumNotification{
+		Method: "mining.notify",
+		Params: []interface{}{job.id, hex.EncodeToString(job.seedhash[:]), hex.EncodeToString(job.sealhash[:]), true},
+	})
+}
+
+// handle executes a single miner request.
+func (s *stratumSession) handle(req *stratumRequest) (interface{}, interface{}) {
+	var params []string
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, stratumErrUnknown
+		}
+	}
+	switch req.Method {
+	case "mining.subscribe":
+		s.lock.Lock()
+		s.subscribed = true
+		s.lock.Unlock()
+		return []interface{}{[]string{"mining.notify", s.id, stratumProtocol}, s.extranonce}, nil
+
+	case "mining.extranonce.subscribe":
+		return true, nil
+
+	case "mining.authorize":
+		if len(params) < 1 || params[0] == "" {
+			return nil, stratumErrUnauthorized
+		}
+		s.lock.Lock()
+		if !s.subscribed {
+			s.lock.Unlock()
+			return nil, stratumErrSubscription
+		}
+		s.worker = params[0]
+		s.rateID = crypto.Keccak256Hash([]byte(s.worker), []byte(s.id))
+		s.lock.Unlock()
+		return true, nil
+
+	case "mining.submit":
+		if len(params) < 3 {
+			return nil, stratumErrUnknown
+		}
+		return s.submit(params[1], params[2])
+
+	case "eth_submitHashrate":
+		if len(params) < 1 {
+			return nil, stratumErrUnknown
+		}
+		rate, err := hexutil.DecodeUint64(params[0])
+		if err != nil {
+			return nil, stratumErrUnknown
+		}
+		s.lock.Lock()
+		s.reported = rate
+		s.lock.Unlock()
+		s.reportRate()
+		return true, nil
+
+	default:
+		return nil, stratumErrUnknown
+	}
+}
+
+// submit validates a share, forwarding it to the remote sealer if it also
+// satisfies the block difficulty.
+func (s *stratumSession) submit(id string, suffix string) (interface{}, interface{}) {
+	s.lock.Lock()
+	worker := s.worker
+	s.lock.Unlock()
+
+	if worker == "" {
+		return nil, stratumErrUnauthorized
+	}
+	job := s.server.findJob(strings.TrimPrefix(id, "0x"))
+	if job == nil {
+		return nil, stratumErrJobNotFound
+	}
+	blob, err := hex.DecodeString(s.extranonce + strings.TrimPrefix(suffix, "0x"))
+	if err != nil || len(blob) != 8 {
+		return nil, stratumErrUnknown
+	}
+	nonce := types.EncodeNonce(binary.BigEndian.Uint64(blob))
+
+	// Fake proof-of-works accept any nonce, otherwise check the share boundary
+	var (
+		ethash = s.server.sealer.ethash
+		digest common.Hash
+	)
+	if ethash.config.PowMode != ModeFake && ethash.config.PowMode != ModeFullFake {
+		mix, result := ethash.hashimotoCache(job.number, job.sealhash[:], nonce.Uint64())
+		value := new(big.Int).SetBytes(result)
+		if value.Cmp(job.share) > 0 {
+			return nil, stratumErrLowShare
+		}
+		digest = common.BytesToHash(mix)
+
+		// Accept the share, forwarding it as a block solution if good enough
+		s.lock.Lock()
+		if s.since.IsZero() {
+			s.since = time.Now()
+		}
+		s.work.Add(s.work, job.difficulty)
+		s.lock.Unlock()
+
+		s.reportRate()
+
+		if value.Cmp(job.target) > 0 {
+			ethash.config.Log.Trace("Accepted stratum share", "worker", worker, "number", job.number, "sealhash", job.sealhash)
+			return true, nil
+		}
+	}
+	errc := make(chan error, 1)
+	select {
+	case s.server.sealer.submitWorkCh <- &mineResult{nonce: nonce, mixDigest: digest, hash: job.sealhash, errc: errc}:
+	case <-s.server.sealer.exitCh:
+		return nil, stratumErrUnknown
+	}
+	if err := <-errc; err != nil {
+		return nil, stratumErrUnknown
+	}
+	ethash.config.Log.Info("Stratum miner sealed block", "worker", worker, "number", job.number, "sealhash", job.sealhash)
+	return true, nil
+}
+
+// reportRate submits the hashrate of the miner to the remote sealer, either the
+// one reported by the miner itself or the one estimated from its shares.
+func (s *stratumSession) reportRate() {
+	s.lock.Lock()
+	if s.worker == "" {
+		s.lock.Unlock()
+		return
+	}
+	rate, id := s.reported, s.rateID
+	if rate == 0 && !s.since.IsZero() {
+		elapsed := time.Since(s.since)
+		if elapsed < time.Second {
+			elapsed = time.Second
+		}
+		rate = new(big.Int).Div(s.work, big.NewInt(int64(elapsed/time.Second))).Uint64()
+	}
+	s.lock.Unlock()
+
+	if rate == 0 {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case s.server.sealer.submitRateCh <- &hashrate{id: id, rate: rate, done: done}:
+		<-done
+	case <-s.server.sealer.exitCh:
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// stratumTestMiner is an in-process stratum miner talking to a stratum server.
+type stratumTestMiner struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	id     int
+}
+
+// stratumTestMessage is any message received by the test miner.
+type stratumTestMessage struct {
+	ID     *int              `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  []interface{}     `json:"error"`
+}
+
+// read retrieves the next message sent by the server.
+func (m *stratumTestMiner) read() *stratumTestMessage {
+	m.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	line, err := m.reader.ReadBytes('\n')
+	if err != nil {
+		m.t.Fatalf("failed to read stratum message: %v", err)
+	}
+	msg := new(stratumTestMessage)
+	if err := json.Unmarshal(line, msg); err != nil {
+		m.t.Fatalf("failed to decode stratum message %s: %v", line, err)
+	}
+	return msg
+}
+
+// call sends a request to the server and waits for its response.
+func (m *stratumTestMiner) call(method string, params ...string) *stratumTestMessage {
+	m.id++
+	blob, _ := json.Marshal(map[string]interface{}{"id": m.id, "method": method, "params": params})
+	if _, err := m.conn.Write(append(blob, '\n')); err != nil {
+		m.t.Fatalf("failed to send stratum request: %v", err)
+	}
+	msg := m.read()
+	if msg.ID == nil || *msg.ID != m.id {
+		m.t.Fatalf("unexpected stratum message: %+v", msg)
+	}
+	return msg
+}
+
+// Tests that a stratum miner receives work, gets its shares accounted and can
+// seal blocks through the stratum server.
+func TestStratumMining(t *testing.T) {
+	ethash := New(Config{PowMode: ModeTest, StratumAddr: "127.0.0.1:0", StratumDifficulty: 10}, nil, false)
+	defer ethash.Close()
+	ethash.SetThreads(-1)
+
+	if ethash.remote.stratum == nil {
+		t.Fatalf("stratum server not started")
+	}
+	conn, err := net.Dial("tcp", ethash.remote.stratum.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to stratum server: %v", err)
+	}
+	defer conn.Close()
+	miner := &stratumTestMiner{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	// Subscribe and authorize the miner
+	if res := miner.call("mining.authorize", "worker", "x"); res.Error == nil {
+		t.Fatalf("unsubscribed miner authorized")
+	}
+	var subscription []json.RawMessage
+	if err := json.Unmarshal(miner.call("mining.subscribe", "tester", stratumProtocol).Result, &subscription); err != nil || len(subscription) != 2 {
+		t.Fatalf("invalid subscription response: %v", err)
+	}
+	var extranonce string
+	if err := json.Unmarshal(subscription[1], &extranonce); err != nil || len(extranonce) != 2*stratumExtraNonceSize {
+		t.Fatalf("invalid extranonce %q: %v", extranonce, err)
+	}
+	if res := miner.call("mining.authorize", "worker", "x"); string(res.Result) != "true" {
+		t.Fatalf("miner not authorized: %v", res.Error)
+	}
+	// Push a block to seal and wait for the work to arrive
+	var (
+		header  = &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1000)}
+		block   = types.NewBlockWithHeader(header)
+		results = make(chan *types.Block, 1)
+	)
+	ethash.Seal(nil, block, results, nil)
+
+	if msg := miner.read(); msg.Method != "mining.set_difficulty" {
+		t.Fatalf("share difficulty not sent, got %+v", msg)
+	} else {
+		var diff float64
+		if err := json.Unmarshal(msg.Params[0], &diff); err != nil || diff != 10/4294967296.0 {
+			t.Fatalf("share difficulty mismatch: have %s, want %v", msg.Params[0], 10/4294967296.0)
+		}
+	}
+	msg := miner.read()
+	if msg.Method != "mining.notify" || len(msg.Params) != 4 {
+		t.Fatalf("work not sent, got %+v", msg)
+	}
+	var job, seed, hash string
+	json.Unmarshal(msg.Params[0], &job)
+	json.Unmarshal(msg.Params[1], &seed)
+	json.Unmarshal(msg.Params[2], &hash)
+
+	sealhash := ethash.SealHash(header)
+	if hash != hex.EncodeToString(sealhash[:]) {
+		t.Fatalf("work hash mismatch: have %s, want %x", hash, sealhash)
+	}
+	if want := hex.EncodeToString(SeedHash(1)); seed != want {
+		t.Fatalf("work seed mismatch: have %s, want %s", seed, want)
+	}
+	// Search for a bad share, a good share and a block solution
+	var (
+		target = new(big.Int).Div(two256, header.Difficulty)
+		share  = new(big.Int).Div(two256, big.NewInt(10))
+
+		prefix, _        = hex.DecodeString(extranonce)
+		bad, good, found string
+	)
+	for n := uint64(0); bad == "" || good == "" || found == ""; n++ {
+		nonce := uint64(binary.BigEndian.Uint16(prefix))<<48 | n
+		_, result := ethash.hashimotoCache(1, sealhash[:], nonce)
+
+		suffix := fmt.Sprintf("%012x", n)
+		switch value := new(big.Int).SetBytes(result); {
+		case value.Cmp(target) <= 0:
+			if found == "" {
+				found = suffix
+			}
+		case value.Cmp(share) <= 0:
+			if good == "" {
+				good = suffix
+			}
+		default:
+			if bad == "" {
+				bad = suffix
+			}
+		}
+	}
+	if res := miner.call("mining.submit", "worker", job, bad); res.Error == nil || res.Error[0] != float64(23) {
+		t.Errorf("low difficulty share accepted: %v", res.Error)
+	}
+	if res := miner.call("mining.submit", "worker", "deadbeef", good); res.Error == nil || res.Error[0] != float64(21) {
+		t.Errorf("share for unknown job accepted: %v", res.Error)
+	}
+	if res := miner.call("mining.submit", "worker", job, good); string(res.Result) != "true" {
+		t.Errorf("valid share rejected: %v", res.Error)
+	}
+	select {
+	case <-results:
+		t.Fatalf("share sealed the block")
+	default:
+	}
+	if rate := ethash.Hashrate(); rate <= 0 {
+		t.Errorf("share hashrate not reported: have %v", rate)
+	}
+	// Submit the solution and ensure the block is sealed
+	if res := miner.call("mining.submit", "worker", job, found); string(res.Result) != "true" {
+		t.Fatalf("valid solution rejected: %v", res.Error)
+	}
+	select {
+	case sealed := <-results:
+		if sealed.Hash() == block.Hash() {
+			t.Fatalf("block not sealed")
+		}
+		if err := ethash.VerifySeal(nil, sealed.Header()); err != nil {
+			t.Fatalf("invalid seal: %v", err)
+		}
+		if want := common.Bytes2Hex(append(prefix, common.FromHex(found)...)); hex.EncodeToString(sealed.Header().Nonce[:]) != want {
+			t.Errorf("nonce mismatch: have %x, want %s", sealed.Header().Nonce, want)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("sealed block not delivered")
+	}
+}
+
+// Tests that extranonces held by live sessions are not reassigned after the
+// allocation counter wraps around.
+func TestStratumExtraNonceReuse(t *testing.T) {
+	ethash := New(Config{PowMode: ModeTest, StratumAddr: "127.0.0.1:0"}, nil, false)
+	defer ethash.Close()
+	ethash.SetThreads(-1)
+
+	server := ethash.remote.stratum
+	subscribe := func() string {
+		conn, err := net.Dial("tcp", server.listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect to stratum server: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		miner := &stratumTestMiner{t: t, conn: conn, reader: bufio.NewReader(conn)}
+		var subscription []json.RawMessage
+		if err := json.Unmarshal(miner.call("mining.subscribe", "tester", stratumProtocol).Result, &subscription); err != nil || len(subscription) != 2 {
+			t.Fatalf("invalid subscription response: %v", err)
+		}
+		var extranonce string
+		if err := json.Unmarshal(subscription[1], &extranonce); err != nil {
+			t.Fatalf("invalid extranonce: %v", err)
+		}
+		return extranonce
+	}
+	first := subscribe()
+
+	// Rewind the allocator as if it wrapped around and connect a new miner
+	server.lock.Lock()
+	server.nonce = 0
+	server.lock.Unlock()
+
+	if second := subscribe(); second == first {
+		t.Fatalf("extranonce %s assigned to two live sessions", first)
+	}
+}
@@ -72,6 +72,10 @@ var (
 	blockPrefetchInterruptMeter = metrics.NewRegisteredMeter("chain/prefetch/interrupts", nil)
 
 	errInsertionInterrupted = errors.New("insertion is interrupted")
+
+	// errHistoricStateTooOld is returned if the state of a block is requested that
+	// is too far from any retained state to be reconstructed from reverse diffs.
+	errHistoricStateTooOld = errors.New("historical state too far from retained state")
 )
 
 const (
@@ -84,6 +88,14 @@ const (
 	badBlockLimit       = 10
 	TriesInMemory       = 128
 
+	// maxReverseDiffDistance is the maximum number of reverse state diffs applied
+	// to reconstruct a historical state, bounding the cost of a single request.
+	maxReverseDiffDistance = 16384
+
+	// reverseDiffPruneBatch is the maximum number of block heights whose reverse
+	// state diffs are pruned along with a single block write.
+	reverseDiffPruneBatch = 1024
+
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
 	//
 	// Changelog:
@@ -115,6 +127,22 @@ type CacheConfig struct {
 	TrieDirtyLimit      int           // Memory limit (MB) at which to start flushing dirty trie nodes to disk
 	TrieDirtyDisabled   bool          // Whether to disable trie write caching and GC altogether (archive node)
 	TrieTimeLimit       time.Duration // Time limit after which to flush the current in-memory trie to disk
+	ReverseDiffs        bool          // Whether to record reverse state diffs for historical state access
+	ReverseDiffLimit    uint64        // Number of recent blocks to keep reverse state diffs for (0 = all blocks)
+	TxLookupLimit       uint64        // Number of recent blocks to maintain transaction indices for (0 = all blocks)
+	ReadOnly            bool          // Whether the database is owned by another process and must not be modified
+	ParallelExec        bool          // Whether to execute the transactions of blocks speculatively in parallel
+}
+
+// TxIndexProgress is the progress of the background transaction indexer.
+type TxIndexProgress struct {
+	Indexed   uint64 // Number of recent blocks whose transactions are indexed
+	Remaining uint64 // Number of blocks still waiting to be indexed
+}
+
+// Done returns whether all the blocks within the lookup limit are indexed.
+func (p TxIndexProgress) Done() bool {
+	return p.Remaining == 0
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -218,7 +246,11 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	}
 	bc.validator = NewBlockValidator(chainConfig, bc, engine)
 	bc.prefetcher = newStatePrefetcher(chainConfig, bc, engine)
-	bc.processor = NewStateProcessor(chainConfig, bc, engine)
+	if cacheConfig.ParallelExec {
+		bc.processor = NewParallelStateProcessor(chainConfig, bc, engine)
+	} else {
+		bc.processor = NewStateProcessor(chainConfig, bc, engine)
+	}
 
 	var err error
 	bc.hc, err = NewHeaderChain(db, chainConfig, engine, bc.getProcInterrupt)
@@ -235,13 +267,18 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	bc.currentFastBlock.Store(nilBlock)
 
 	// Initialize the chain with ancient data if it isn't empty.
-	if bc.empty() {
+	if bc.empty() && !cacheConfig.ReadOnly {
 		rawdb.InitDatabaseFromFreezer(bc.db)
 	}
 
 	if err := bc.loadLastState(); err != nil {
 		return nil, err
 	}
+	// A read-only chain follows the database owner, it cannot repair anything
+	if cacheConfig.ReadOnly {
+		go bc.update()
+		return bc, nil
+	}
 	// The first thing the node will do is reconstruct the verification data for
 	// the head block (ethash cache or clique voting snapshot). Might as well do
 	// it in advance.
@@ -295,6 +332,9 @@ func NewBlockChain(db ethdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	}
 	// Take ownership of this particular state
 	go bc.update()
+
+	bc.wg.Add(1)
+	go bc.maintainTxIndex()
 	return bc, nil
 }
 
@@ -326,6 +366,9 @@ func (bc *BlockChain) empty() bool {
 func (bc *BlockChain) loadLastState() error {
 	// Restore the last known head block
 	head := rawdb.ReadHeadBlockHash(bc.db)
+	if head == (common.Hash{}) && bc.cacheConfig.ReadOnly {
+		return errors.New("empty read-only database")
+	}
 	if head == (common.Hash{}) {
 		// Corrupt or empty database, init from scratch
 		log.Warn("Empty database, resetting chain")
@@ -333,6 +376,9 @@ func (bc *BlockChain) loadLastState() error {
 	}
 	// Make sure the entire head block is available
 	currentBlock := bc.GetBlockByHash(head)
+	if currentBlock == nil && bc.cacheConfig.ReadOnly {
+		return fmt.Errorf("missing head block %x in read-only database", head)
+	}
 	if currentBlock == nil {
 		// Corrupt or empty database, init from scratch
 		log.Warn("Head block missing, resetting chain", "hash", head)
@@ -345,7 +391,10 @@ func (bc *BlockChain) loadLastState() error {
 		if err := bc.repair(&currentBlock); err != nil {
 			return err
 		}
-		rawdb.WriteHeadBlockHash(bc.db, currentBlock.Hash())
+		// Read-only chains only track the last persisted state of the owner
+		if !bc.cacheConfig.ReadOnly {
+			rawdb.WriteHeadBlockHash(bc.db, currentBlock.Hash())
+		}
 	}
 	// Everything seems to be fine, set as the head block
 	bc.currentBlock.Store(currentBlock)
@@ -358,8 +407,11 @@ func (bc *BlockChain) loadLastState() error {
 			currentHeader = header
 		}
 	}
-	bc.hc.SetCurrentHeader(currentHeader)
-
+	if bc.cacheConfig.ReadOnly {
+		bc.hc.loadCurrentHeader(currentHeader)
+	} else {
+		bc.hc.SetCurrentHeader(currentHeader)
+	}
 	// Restore the last known head fast block
 	bc.currentFastBlock.Store(currentBlock)
 	headFastBlockGauge.Update(int64(currentBlock.NumberU64()))
@@ -384,6 +436,31 @@ func (bc *BlockChain) loadLastState() error {
 	return nil
 }
 
+// Reload reloads the chain head markers from the database, following the chain
+// progression of the process owning a read-only database. A chain head event
+// is emitted if the head block changed.
+//
+// Note, the head block is the most recent one with its state persisted by the
+// owner, which may lag behind the head header.
+func (bc *BlockChain) Reload() error {
+	if !bc.cacheConfig.ReadOnly {
+		return errors.New("reload on writable chain")
+	}
+	bc.chainmu.Lock()
+	previous := bc.CurrentBlock()
+	if err := bc.loadLastState(); err != nil {
+		bc.chainmu.Unlock()
+		return err
+	}
+	current := bc.CurrentBlock()
+	bc.chainmu.Unlock()
+
+	if current.Hash() != previous.Hash() {
+		bc.chainHeadFeed.Send(ChainHeadEvent{Block: current})
+	}
+	return nil
+}
+
 // SetHead rewinds the local chain to a new head. In the case of headers, everything
 // above the new head will be deleted and the new one set. In the case of blocks
 // though, the head may be further rewound if block bodies are missing (non-archive
@@ -517,6 +594,112 @@ func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
 	return state.New(root, bc.stateCache)
 }
 
+// HistoricState returns a new mutable state based on the given header. If the
+// state of the block is no longer retained but reverse state diffs are being
+// recorded, the state is reconstructed by applying the diffs backwards from the
+// closest retained state of a later canonical block.
+//
+// Note, the diffs are applied one block at a time, so the cost grows linearly
+// with the distance to the closest retained state. Requests further away than
+// maxReverseDiffDistance blocks are rejected.
+func (bc *BlockChain) HistoricState(header *types.Header) (*state.StateDB, error) {
+	statedb, err := bc.StateAt(header.Root)
+	if err == nil || !bc.cacheConfig.ReverseDiffs {
+		return statedb, err
+	}
+	// State is missing, diffs can only be followed along the canonical chain
+	number := header.Number.Uint64()
+	if bc.GetCanonicalHash(number) != header.Hash() {
+		return nil, err
+	}
+	var (
+		diffs []*state.ReverseDiff
+		roots []common.Hash
+		head  = bc.CurrentBlock().NumberU64()
+		prev  = header
+	)
+	for n := number + 1; n <= head; n++ {
+		if n-number > maxReverseDiffDistance {
+			return nil, errHistoricStateTooOld
+		}
+		next := bc.GetHeaderByNumber(n)
+		if next == nil {
+			return nil, fmt.Errorf("missing canonical header #%d", n)
+		}
+		blob := rawdb.ReadReverseDiffRLP(bc.db, next.Hash(), n)
+		if len(blob) == 0 {
+			return nil, fmt.Errorf("missing reverse state diff #%d [%x…]", n, next.Hash().Bytes()[:4])
+		}
+		diff := new(state.ReverseDiff)
+		if err := rlp.DecodeBytes(blob, diff); err != nil {
+			return nil, fmt.Errorf("invalid reverse state diff #%d: %v", n, err)
+		}
+		diffs = append(diffs, diff)
+		roots = append(roots, prev.Root)
+
+		if bc.HasState(next.Root) {
+			// Diffs are applied from the retained state downwards, reverse them
+			for i, j := 0, len(diffs)-1; i < j; i, j = i+1, j-1 {
+				diffs[i], diffs[j] = diffs[j], diffs[i]
+				roots[i], roots[j] = roots[j], roots[i]
+			}
+			start := time.Now()
+			statedb, err := state.RevertState(bc.stateCache, next.Root, diffs, roots)
+			if err != nil {
+				return nil, err
+			}
+			log.Debug("Reconstructed historical state", "number", number, "hash", header.Hash(), "base", n, "elapsed", common.PrettyDuration(time.Since(start)))
+			return statedb, nil
+		}
+		prev = next
+	}
+	return nil, err
+}
+
+// pruneReverseDiffs deletes the reverse state diffs of all the blocks, canonical
+// or not, that fell out of the retention window given the new block number. To
+// avoid stalling block processing after the limit is lowered, at most a batch
+// of heights is pruned at once, catching up with subsequent blocks.
+func (bc *BlockChain) pruneReverseDiffs(batch ethdb.KeyValueWriter, number uint64) {
+	limit := bc.cacheConfig.ReverseDiffLimit
+	if limit == 0 || number < limit {
+		return
+	}
+	var (
+		tail uint64 = 1 // Genesis has no reverse diff
+		want        = number - limit + 1
+	)
+	if stored := rawdb.ReadReverseDiffTail(bc.db); stored != nil {
+		tail = *stored
+	}
+	if tail >= want {
+		return
+	}
+	if want-tail > reverseDiffPruneBatch {
+		want = tail + reverseDiffPruneBatch
+	}
+	for n := tail; n < want; n++ {
+		for _, hash := range rawdb.ReadAllHashes(bc.db, n) {
+			rawdb.DeleteReverseDiff(batch, hash, n)
+		}
+	}
+	rawdb.WriteReverseDiffTail(batch, want)
+}
+
+// reverseDiff calculates the RLP encoded reverse state diff of a freshly committed
+// block against the state of its parent.
+func (bc *BlockChain) reverseDiff(block *types.Block, root common.Hash) (rlp.RawValue, error) {
+	parent := bc.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	diff, err := state.NewReverseDiff(bc.stateCache, parent.Root, root)
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(diff)
+}
+
 // StateCache returns the caching database underpinning the blockchain instance.
 func (bc *BlockChain) StateCache() state.Database {
 	return bc.stateCache
@@ -825,7 +1008,7 @@ func (bc *BlockChain) Stop() {
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
 	//  - HEAD-1:   So we don't do large reorgs if our HEAD becomes an uncle
 	//  - HEAD-127: So we have a hard limit on the number of blocks reexecuted
-	if !bc.cacheConfig.TrieDirtyDisabled {
+	if !bc.cacheConfig.TrieDirtyDisabled && !bc.cacheConfig.ReadOnly {
 		triedb := bc.stateCache.TrieDB()
 
 		for _, offset := range []uint64{0, 1, TriesInMemory - 1} {
@@ -1293,6 +1476,15 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	if err != nil {
 		return NonStatTy, err
 	}
+	// If historical state access is enabled, record the changes needed to revert
+	// the state of this block to that of its parent. This needs to be done before
+	// the parent state is garbage collected.
+	var reverseDiff rlp.RawValue
+	if bc.cacheConfig.ReverseDiffs {
+		if reverseDiff, err = bc.reverseDiff(block, root); err != nil {
+			return NonStatTy, err
+		}
+	}
 	triedb := bc.stateCache.TrieDB()
 
 	// If we're running an archive node, always flush
@@ -1351,6 +1543,10 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 	// Write other block data using a batch.
 	batch := bc.db.NewBatch()
 	rawdb.WriteReceipts(batch, block.Hash(), block.NumberU64(), receipts)
+	if reverseDiff != nil {
+		rawdb.WriteReverseDiffRLP(batch, block.Hash(), block.NumberU64(), reverseDiff)
+		bc.pruneReverseDiffs(batch, block.NumberU64())
+	}
 
 	// If the total difficulty is higher than our known, add it to the canonical chain
 	// Second clause in the if statement reduces the vulnerability to selfish mining.
@@ -2047,6 +2243,116 @@ func (bc *BlockChain) update() {
 	}
 }
 
+// txIndexTail returns the oldest block number whose transactions should be
+// indexed given the current chain head.
+func (bc *BlockChain) txIndexTail(head uint64) uint64 {
+	limit := bc.cacheConfig.TxLookupLimit
+	if limit == 0 || head < limit {
+		return 0
+	}
+	return head - limit + 1
+}
+
+// indexBlocks brings the transaction indices in line with the lookup limit,
+// indexing the missing blocks or unindexing the stale ones below the chain head.
+func (bc *BlockChain) indexBlocks(head uint64, done chan struct{}, interrupt chan struct{}) {
+	defer close(done)
+
+	tail, want := rawdb.ReadTxIndexTail(bc.db), bc.txIndexTail(head)
+	if tail == nil {
+		// The database predates index tracking, everything's indexed so only
+		// drop the stale indices if there's a limit
+		if want == 0 {
+			rawdb.WriteTxIndexTail(bc.db, 0)
+		} else {
+			rawdb.UnindexTransactions(bc.db, 0, want, interrupt)
+		}
+		return
+	}
+	switch {
+	case *tail > want:
+		// The limit was raised or the indexing was interrupted, fill in the gap
+		end := *tail
+		if end > head+1 {
+			end = head + 1
+		}
+		rawdb.IndexTransactions(bc.db, want, end, interrupt)
+
+	case *tail < want:
+		// The chain progressed or the limit was lowered, drop the stale indices
+		rawdb.UnindexTransactions(bc.db, *tail, want, interrupt)
+	}
+}
+
+// maintainTxIndex is responsible for the construction and deletion of the
+// transaction indices, keeping them limited to the configured number of recent
+// blocks. New blocks are indexed upon insertion, so this loop only needs to
+// handle the limit changing across restarts and the old blocks falling out of
+// the retention window as the chain progresses.
+func (bc *BlockChain) maintainTxIndex() {
+	defer bc.wg.Done()
+
+	var (
+		done      chan struct{}
+		interrupt = make(chan struct{})
+	)
+	run := func(head uint64) {
+		done = make(chan struct{})
+		go bc.indexBlocks(head, done, interrupt)
+	}
+	headCh := make(chan ChainHeadEvent, 1)
+	sub := bc.SubscribeChainHeadEvent(headCh)
+	if sub == nil {
+		// The chain was stopped before the indexer even started
+		return
+	}
+	defer sub.Unsubscribe()
+
+	if head := bc.CurrentBlock(); head != nil {
+		run(head.NumberU64())
+	}
+	for {
+		select {
+		case head := <-headCh:
+			if done == nil {
+				run(head.Block.NumberU64())
+			}
+		case <-done:
+			done = nil
+
+		case <-bc.quit:
+			close(interrupt)
+			if done != nil {
+				log.Info("Waiting for transaction indexing to terminate")
+				<-done
+			}
+			return
+		}
+	}
+}
+
+// TxIndexProgress retrieves the progress of the transaction indexer, relative
+// to the current chain head and the configured lookup limit.
+func (bc *BlockChain) TxIndexProgress() TxIndexProgress {
+	var (
+		head = bc.CurrentBlock().NumberU64()
+		want = bc.txIndexTail(head)
+	)
+	tail := rawdb.ReadTxIndexTail(bc.db)
+	if tail == nil {
+		// Legacy database, every block is indexed
+		return TxIndexProgress{Indexed: head - want + 1}
+	}
+	switch {
+	case *tail > head:
+		return TxIndexProgress{Remaining: head - want + 1}
+	case *tail > want:
+		return TxIndexProgress{Indexed: head - *tail + 1, Remaining: *tail - want}
+	default:
+		return TxIndexProgress{Indexed: head - want + 1}
+	}
+}
+
 // BadBlocks returns a list of the last 'bad blocks' that the client has seen on the network
 func (bc *BlockChain) BadBlocks() []*types.Block {
 	blocks := make([]*types.Block, 0, bc.badBlocks.Len())
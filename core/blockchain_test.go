@@ -650,7 +650,7 @@ func TestFastVsFullChains(t *testing.T) {
 		t.Fatalf("failed to create temp freezer dir: %v", err)
 	}
 	defer os.Remove(frdir)
-	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "")
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false)
 	if err != nil {
 		t.Fatalf("failed to create temp freezer db: %v", err)
 	}
@@ -724,7 +724,7 @@ func TestLightVsFastVsFullChainHeads(t *testing.T) {
 			t.Fatalf("failed to create temp freezer dir: %v", err)
 		}
 		defer os.Remove(dir)
-		db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), dir, "")
+		db, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), dir, "", false)
 		if err != nil {
 			t.Fatalf("failed to create temp freezer db: %v", err)
 		}
@@ -1651,7 +1651,7 @@ func TestBlockchainRecovery(t *testing.T) {
 		t.Fatalf("failed to create temp freezer dir: %v", err)
 	}
 	defer os.Remove(frdir)
-	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "")
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false)
 	if err != nil {
 		t.Fatalf("failed to create temp freezer db: %v", err)
 	}
@@ -1707,7 +1707,7 @@ func TestIncompleteAncientReceiptChainInsertion(t *testing.T) {
 		t.Fatalf("failed to create temp freezer dir: %v", err)
 	}
 	defer os.Remove(frdir)
-	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "")
+	ancientDb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), frdir, "", false)
 	if err != nil {
 		t.Fatalf("failed to create temp freezer db: %v", err)
 	}
@@ -1906,7 +1906,7 @@ func testInsertKnownChainData(t *testing.T, typ string) {
 		t.Fatalf("failed to create temp freezer dir: %v", err)
 	}
 	defer os.Remove(dir)
-	chaindb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), dir, "")
+	chaindb, err := rawdb.NewDatabaseWithFreezer(rawdb.NewMemoryDatabase(), dir, "", false)
 	if err != nil {
 		t.Fatalf("failed to create temp freezer db: %v", err)
 	}
@@ -2362,3 +2362,313 @@ func TestDeleteCreateRevert(t *testing.T) {
 		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
 	}
 }
+
+// Tests that historical states pruned from the trie database can be reconstructed
+// from the recorded reverse state diffs.
+func TestHistoricStateReverseDiffs(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+		db     = rawdb.NewMemoryDatabase()
+
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+
+		// The address 0xAAAA stores the block number into the slot of the same index
+		aa    = common.HexToAddress("0x000000000000000000000000000000000000aaaa")
+		gspec = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				address: {Balance: big.NewInt(1000000000)},
+				aa:      {Code: []byte{byte(vm.NUMBER), byte(vm.NUMBER), byte(vm.SSTORE)}, Balance: big.NewInt(0)},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, TriesInMemory+32, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{1})
+
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), common.Address{0x10, byte(i)}, big.NewInt(int64(i+1)), params.TxGas, big.NewInt(1), nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+		tx, _ = types.SignTx(types.NewTransaction(b.TxNonce(address), aa, big.NewInt(0), 50000, big.NewInt(1), nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+	})
+	// Import the chain with reverse diffs enabled and no time based trie flushes
+	diskdb := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(diskdb)
+
+	cache := &CacheConfig{
+		TrieCleanLimit: 256,
+		TrieDirtyLimit: 256,
+		TrieTimeLimit:  time.Hour,
+		ReverseDiffs:   true,
+	}
+	chain, err := NewBlockChain(diskdb, cache, params.TestChainConfig, engine, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	for _, block := range blocks {
+		if !rawdb.HasReverseDiff(diskdb, block.Hash(), block.NumberU64()) {
+			t.Fatalf("block %d: reverse diff missing", block.NumberU64())
+		}
+	}
+	// Reconstruct an old state that was garbage collected
+	header := blocks[9].Header()
+	if chain.HasState(header.Root) {
+		t.Fatalf("historical state not garbage collected")
+	}
+	statedb, err := chain.HistoricState(header)
+	if err != nil {
+		t.Fatalf("failed to reconstruct historical state: %v", err)
+	}
+	if root := statedb.IntermediateRoot(false); root != header.Root {
+		t.Fatalf("reconstructed root mismatch: have %x, want %x", root, header.Root)
+	}
+	if nonce := statedb.GetNonce(address); nonce != 20 {
+		t.Errorf("sender nonce mismatch: have %d, want %d", nonce, 20)
+	}
+	if balance := statedb.GetBalance(common.Address{0x10, 9}); balance.Cmp(big.NewInt(10)) != 0 {
+		t.Errorf("recipient balance mismatch: have %v, want %v", balance, 10)
+	}
+	if statedb.Exist(common.Address{0x10, 10}) {
+		t.Errorf("future recipient exists in historical state")
+	}
+	if value := statedb.GetState(aa, common.BigToHash(big.NewInt(10))); value != common.BigToHash(big.NewInt(10)) {
+		t.Errorf("contract slot mismatch: have %x, want %x", value, common.BigToHash(big.NewInt(10)))
+	}
+	if value := statedb.GetState(aa, common.BigToHash(big.NewInt(11))); value != (common.Hash{}) {
+		t.Errorf("future contract slot set in historical state: %x", value)
+	}
+	// Non-canonical headers can't be reconstructed
+	fork := types.CopyHeader(header)
+	fork.Extra = []byte("fork")
+	if _, err := chain.HistoricState(fork); err == nil {
+		t.Errorf("non-canonical state reconstructed")
+	}
+}
+
+// Tests that reverse state diffs are only retained for the configured number of
+// recent blocks, and that historical states can't be reconstructed past them.
+func TestHistoricStateReverseDiffRetention(t *testing.T) {
+	var (
+		engine = ethash.NewFaker()
+		db     = rawdb.NewMemoryDatabase()
+
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{address: {Balance: big.NewInt(1000000000)}}}
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, TriesInMemory+32, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), common.Address{0x10, byte(i)}, big.NewInt(int64(i+1)), params.TxGas, big.NewInt(1), nil), types.HomesteadSigner{}, key)
+		b.AddTx(tx)
+	})
+	diskdb := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(diskdb)
+
+	cache := &CacheConfig{
+		TrieCleanLimit:   256,
+		TrieDirtyLimit:   256,
+		TrieTimeLimit:    time.Hour,
+		ReverseDiffs:     true,
+		ReverseDiffLimit: TriesInMemory + 22,
+	}
+	chain, err := NewBlockChain(diskdb, cache, params.TestChainConfig, engine, vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	// Only the diffs of the most recent blocks must be retained
+	tail := uint64(len(blocks)) - cache.ReverseDiffLimit + 1
+	for _, block := range blocks {
+		if have, want := rawdb.HasReverseDiff(diskdb, block.Hash(), block.NumberU64()), block.NumberU64() >= tail; have != want {
+			t.Fatalf("block %d: reverse diff existence mismatch: have %v, want %v", block.NumberU64(), have, want)
+		}
+	}
+	if stored := rawdb.ReadReverseDiffTail(diskdb); stored == nil || *stored != tail {
+		t.Fatalf("reverse diff tail mismatch: have %v, want %d", stored, tail)
+	}
+	// States within the retention window can be reconstructed, older ones not
+	header := blocks[tail+5].Header()
+	if chain.HasState(header.Root) {
+		t.Fatalf("historical state not garbage collected")
+	}
+	statedb, err := chain.HistoricState(header)
+	if err != nil {
+		t.Fatalf("failed to reconstruct historical state: %v", err)
+	}
+	if root := statedb.IntermediateRoot(false); root != header.Root {
+		t.Fatalf("reconstructed root mismatch: have %x, want %x", root, header.Root)
+	}
+	if _, err := chain.HistoricState(blocks[tail-3].Header()); err == nil {
+		t.Fatalf("historical state reconstructed past the retained diffs")
+	}
+}
+
+// Tests that the transaction indices are maintained for the configured number of
+// recent blocks, and that they get extended or pruned if the limit changes.
+func TestTransactionIndices(t *testing.T) {
+	var (
+		key, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		address = crypto.PubkeyToAddress(key.PublicKey)
+		gspec   = &Genesis{Config: params.TestChainConfig, Alloc: GenesisAlloc{address: {Balance: big.NewInt(1000000000)}}}
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(gendb)
+		signer  = types.NewEIP155Signer(params.TestChainConfig.ChainID)
+	)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), gendb, 32, func(i int, b *BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(b.TxNonce(address), common.Address{0x10}, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, key)
+		b.AddTx(tx)
+	})
+	db := rawdb.NewMemoryDatabase()
+	gspec.MustCommit(db)
+
+	// check waits for the indexer to finish and verifies the retained indices
+	check := func(chain *BlockChain, tail uint64) {
+		t.Helper()
+		for start := time.Now(); !chain.TxIndexProgress().Done(); {
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("indexer timed out: %+v", chain.TxIndexProgress())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for start := time.Now(); ; {
+			if stored := rawdb.ReadTxIndexTail(db); stored != nil && *stored == tail {
+				break
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("index tail mismatch: have %v, want %d", rawdb.ReadTxIndexTail(db), tail)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if progress := chain.TxIndexProgress(); progress.Indexed != uint64(len(blocks))-tail+1 {
+			t.Fatalf("indexed block count mismatch: have %d, want %d", progress.Indexed, uint64(len(blocks))-tail+1)
+		}
+		for _, block := range blocks {
+			indexed := rawdb.ReadTxLookupEntry(db, block.Transactions()[0].Hash()) != nil
+			if want := block.NumberU64() >= tail; indexed != want {
+				t.Fatalf("block %d: index presence mismatch: have %v, want %v", block.NumberU64(), indexed, want)
+			}
+		}
+	}
+	// Import the chain with a limit, dropping the old indices as it progresses
+	chain, _ := NewBlockChain(db, &CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: time.Hour, TxLookupLimit: 8}, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil)
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	check(chain, 25)
+	chain.Stop()
+
+	// Raise the limit and ensure the indices are extended
+	chain, _ = NewBlockChain(db, &CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: time.Hour, TxLookupLimit: 16}, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil)
+	check(chain, 17)
+	chain.Stop()
+
+	// Remove the limit and ensure everything is indexed
+	chain, _ = NewBlockChain(db, nil, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil)
+	check(chain, 0)
+	chain.Stop()
+
+	// Lower the limit and ensure the indices are pruned
+	chain, _ = NewBlockChain(db, &CacheConfig{TrieCleanLimit: 256, TrieDirtyLimit: 256, TrieTimeLimit: time.Hour, TxLookupLimit: 4}, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil)
+	check(chain, 29)
+	chain.Stop()
+}
+
+// Tests that a read-only chain can be opened on top of the database of a live
+// chain, following its progress as the database view is refreshed.
+func TestReadOnlyBlockChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readonly-chain-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		gspec   = &Genesis{Config: params.TestChainConfig}
+		gendb   = rawdb.NewMemoryDatabase()
+		genesis = gspec.MustCommit(gendb)
+	)
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, ethash.NewFaker(), gendb, 20, func(i int, b *BlockGen) {
+		b.SetCoinbase(common.Address{byte(i)})
+	})
+	// Create an archive primary chain, so that the head state is always persisted
+	db, err := rawdb.NewLevelDBDatabaseWithFreezer(dir, 16, 16, dir+"/ancient", "", false)
+	if err != nil {
+		t.Fatalf("failed to create primary database: %v", err)
+	}
+	defer db.Close()
+	gspec.MustCommit(db)
+
+	chain, err := NewBlockChain(db, &CacheConfig{TrieDirtyDisabled: true}, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create primary chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks[:10]); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	// Open a read-only chain on top of the live database
+	rodb, err := rawdb.NewLevelDBDatabaseWithFreezer(dir, 16, 16, dir+"/ancient", "", true)
+	if err != nil {
+		t.Fatalf("failed to open read-only database: %v", err)
+	}
+	defer rodb.Close()
+
+	rochain, err := NewBlockChain(rodb, &CacheConfig{ReadOnly: true}, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create read-only chain: %v", err)
+	}
+	defer rochain.Stop()
+
+	if head := rochain.CurrentBlock(); head.Hash() != blocks[9].Hash() {
+		t.Fatalf("head mismatch: have #%d, want #%d", head.NumberU64(), blocks[9].NumberU64())
+	}
+	if _, err := rochain.StateAt(blocks[9].Root()); err != nil {
+		t.Fatalf("head state unavailable: %v", err)
+	}
+	// Extend the primary chain and ensure it's only visible after a refresh
+	heads := make(chan ChainHeadEvent, 1)
+	sub := rochain.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	if _, err := chain.InsertChain(blocks[10:]); err != nil {
+		t.Fatalf("failed to insert blocks: %v", err)
+	}
+	if err := rochain.Reload(); err != nil {
+		t.Fatalf("failed to reload read-only chain: %v", err)
+	}
+	if head := rochain.CurrentBlock(); head.Hash() != blocks[9].Hash() {
+		t.Fatalf("head moved before refresh: have #%d, want #%d", head.NumberU64(), blocks[9].NumberU64())
+	}
+	if err := rodb.(ethdb.Refresher).Refresh(); err != nil {
+		t.Fatalf("failed to refresh read-only database: %v", err)
+	}
+	if err := rochain.Reload(); err != nil {
+		t.Fatalf("failed to reload read-only chain: %v", err)
+	}
+	if head := rochain.CurrentBlock(); head.Hash() != blocks[19].Hash() {
+		t.Fatalf("head mismatch after refresh: have #%d, want #%d", head.NumberU64(), blocks[19].NumberU64())
+	}
+	select {
+	case ev := <-heads:
+		if ev.Block.Hash() != blocks[19].Hash() {
+			t.Fatalf("head event mismatch: have #%d, want #%d", ev.Block.NumberU64(), blocks[19].NumberU64())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no head event after refresh")
+	}
+	if receipts := rochain.GetReceiptsByHash(blocks[19].Hash()); receipts == nil {
+		t.Fatalf("receipts missing for new head")
+	}
+}
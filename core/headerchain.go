@@ -461,7 +461,12 @@ func (hc *HeaderChain) CurrentHeader() *types.Header {
 // SetCurrentHeader sets the current head header of the canonical chain.
 func (hc *HeaderChain) SetCurrentHeader(head *types.Header) {
 	rawdb.WriteHeadHeaderHash(hc.chainDb, head.Hash())
+	hc.loadCurrentHeader(head)
+}
 
+// loadCurrentHeader sets the current head header of the canonical chain without
+// persisting it, used when the head marker is already in the database.
+func (hc *HeaderChain) loadCurrentHeader(head *types.Header) {
 	hc.currentHeader.Store(head)
 	hc.currentHeaderHash = head.Hash()
 	headHeaderGauge.Update(head.Number.Int64())
@@ -55,9 +55,15 @@ func ReadTxLookupEntry(db ethdb.Reader, hash common.Hash) *uint64 {
 // WriteTxLookupEntries stores a positional metadata for every transaction from
 // a block, enabling hash based transaction and receipt lookups.
 func WriteTxLookupEntries(db ethdb.KeyValueWriter, block *types.Block) {
-	number := block.Number().Bytes()
-	for _, tx := range block.Transactions() {
-		if err := db.Put(txLookupKey(tx.Hash()), number); err != nil {
+	writeTxLookupEntries(db, block.NumberU64(), block.Transactions())
+}
+
+// writeTxLookupEntries stores a positional metadata for every transaction of the
+// block with the given number.
+func writeTxLookupEntries(db ethdb.KeyValueWriter, number uint64, txs types.Transactions) {
+	enc := new(big.Int).SetUint64(number).Bytes()
+	for _, tx := range txs {
+		if err := db.Put(txLookupKey(tx.Hash()), enc); err != nil {
 			log.Crit("Failed to store transaction lookup entry", "err", err)
 		}
 	}
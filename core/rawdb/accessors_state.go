@@ -0,0 +1,73 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"encoding/binary"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// ReadReverseDiffRLP retrieves the reverse state diff of a block in RLP encoding.
+func ReadReverseDiffRLP(db ethdb.KeyValueReader, hash common.Hash, number uint64) rlp.RawValue {
+	data, _ := db.Get(reverseDiffKey(number, hash))
+	return data
+}
+
+// HasReverseDiff verifies the existence of the reverse state diff of a block.
+func HasReverseDiff(db ethdb.KeyValueReader, hash common.Hash, number uint64) bool {
+	if has, err := db.Has(reverseDiffKey(number, hash)); !has || err != nil {
+		return false
+	}
+	return true
+}
+
+// WriteReverseDiffRLP stores the RLP encoded reverse state diff of a block.
+func WriteReverseDiffRLP(db ethdb.KeyValueWriter, hash common.Hash, number uint64, rlp rlp.RawValue) {
+	if err := db.Put(reverseDiffKey(number, hash), rlp); err != nil {
+		log.Crit("Failed to store reverse state diff", "err", err)
+	}
+}
+
+// DeleteReverseDiff removes the reverse state diff of a block.
+func DeleteReverseDiff(db ethdb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Delete(reverseDiffKey(number, hash)); err != nil {
+		log.Crit("Failed to delete reverse state diff", "err", err)
+	}
+}
+
+// ReadReverseDiffTail retrieves the number of the oldest block whose reverse state
+// diff is retained, or nil if the diffs were never pruned.
+func ReadReverseDiffTail(db ethdb.KeyValueReader) *uint64 {
+	data, _ := db.Get(reverseDiffTailKey)
+	if len(data) != 8 {
+		return nil
+	}
+	number := binary.BigEndian.Uint64(data)
+	return &number
+}
+
+// WriteReverseDiffTail stores the number of the oldest block whose reverse state
+// diff is retained.
+func WriteReverseDiffTail(db ethdb.KeyValueWriter, number uint64) {
+	if err := db.Put(reverseDiffTailKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store the reverse state diff tail", "err", err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// IndexTransactions creates the transaction lookup entries for the canonical
+// blocks in range [from, to). The blocks are iterated backwards, moving the index
+// tail downwards with every flushed batch, so an interrupted run can be resumed
+// from where it stopped.
+//
+// The method returns early if the interrupt channel is closed or if a block body
+// is missing from the database.
+func IndexTransactions(db ethdb.Database, from uint64, to uint64, interrupt chan struct{}) {
+	if from >= to {
+		return
+	}
+	var (
+		batch  = db.NewBatch()
+		start  = time.Now()
+		logged = start
+		txs    int
+	)
+	tail := to
+	for number := to; number > from; number-- {
+		if interrupted(interrupt) {
+			break
+		}
+		body := ReadBody(db, ReadCanonicalHash(db, number-1), number-1)
+		if body == nil {
+			log.Warn("Missing block body, stopping transaction indexing", "number", number-1)
+			break
+		}
+		writeTxLookupEntries(batch, number-1, body.Transactions)
+		tail, txs = number-1, txs+len(body.Transactions)
+
+		// If enough data was accumulated in memory, dump to disk along with the tail
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			WriteTxIndexTail(batch, tail)
+			if err := batch.Write(); err != nil {
+				log.Crit("Failed to write transaction indices", "err", err)
+			}
+			batch.Reset()
+		}
+		// If we've spent too much time already, notify the user of what we're doing
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Indexing transactions", "blocks", to-tail, "txs", txs, "tail", tail, "total", to-from, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	WriteTxIndexTail(batch, tail)
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write transaction indices", "err", err)
+	}
+	log.Info("Indexed transactions", "blocks", to-tail, "txs", txs, "tail", tail, "elapsed", common.PrettyDuration(time.Since(start)))
+}
+
+// UnindexTransactions removes the transaction lookup entries of the canonical
+// blocks in range [from, to). The blocks are iterated forward, moving the index
+// tail upwards with every flushed batch.
+//
+// The method returns early if the interrupt channel is closed or if a block body
+// is missing from the database.
+func UnindexTransactions(db ethdb.Database, from uint64, to uint64, interrupt chan struct{}) {
+	if from >= to {
+		return
+	}
+	var (
+		batch  = db.NewBatch()
+		start  = time.Now()
+		logged = start
+		txs    int
+	)
+	tail := from
+	for number := from; number < to; number++ {
+		if interrupted(interrupt) {
+			break
+		}
+		body := ReadBody(db, ReadCanonicalHash(db, number), number)
+		if body == nil {
+			log.Warn("Missing block body, stopping transaction unindexing", "number", number)
+			break
+		}
+		for _, tx := range body.Transactions {
+			DeleteTxLookupEntry(batch, tx.Hash())
+		}
+		tail, txs = number+1, txs+len(body.Transactions)
+
+		// If enough data was accumulated in memory, dump to disk along with the tail
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			WriteTxIndexTail(batch, tail)
+			if err := batch.Write(); err != nil {
+				log.Crit("Failed to delete transaction indices", "err", err)
+			}
+			batch.Reset()
+		}
+		// If we've spent too much time already, notify the user of what we're doing
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Unindexing transactions", "blocks", tail-from, "txs", txs, "tail", tail, "total", to-from, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	WriteTxIndexTail(batch, tail)
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to delete transaction indices", "err", err)
+	}
+	log.Info("Unindexed transactions", "blocks", tail-from, "txs", txs, "tail", tail, "elapsed", common.PrettyDuration(time.Since(start)))
+}
+
+// interrupted returns whether the given interrupt channel has been closed.
+func interrupted(interrupt chan struct{}) bool {
+	select {
+	case <-interrupt:
+		return true
+	default:
+		return false
+	}
+}
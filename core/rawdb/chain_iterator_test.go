@@ -0,0 +1,90 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Tests that transaction indices can be created and removed for block ranges,
+// with the index tail tracking the progress.
+func TestChainIndexTransactions(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	var blocks []*types.Block
+	for i := uint64(0); i < 10; i++ {
+		// Leave the genesis block empty, its number can't be stored in the lookups
+		var txs []*types.Transaction
+		if i > 0 {
+			txs = append(txs, types.NewTransaction(i, common.Address{byte(i)}, big.NewInt(1), 21000, big.NewInt(1), nil))
+		}
+		block := types.NewBlock(&types.Header{Number: new(big.Int).SetUint64(i)}, txs, nil, nil)
+
+		WriteBlock(db, block)
+		WriteCanonicalHash(db, block.Hash(), i)
+		blocks = append(blocks, block)
+	}
+	verify := func(from, to uint64, indexed bool) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			if i == 0 {
+				continue
+			}
+			number := ReadTxLookupEntry(db, blocks[i].Transactions()[0].Hash())
+			if indexed && (number == nil || *number != i) {
+				t.Fatalf("block %d: transaction not indexed", i)
+			}
+			if !indexed && number != nil {
+				t.Fatalf("block %d: transaction indexed", i)
+			}
+		}
+	}
+	verifyTail := func(want uint64) {
+		t.Helper()
+		if tail := ReadTxIndexTail(db); tail == nil || *tail != want {
+			t.Fatalf("index tail mismatch: have %v, want %d", tail, want)
+		}
+	}
+	if tail := ReadTxIndexTail(db); tail != nil {
+		t.Fatalf("index tail present in fresh database: %d", *tail)
+	}
+	IndexTransactions(db, 0, 10, nil)
+	verify(0, 10, true)
+	verifyTail(0)
+
+	UnindexTransactions(db, 0, 6, nil)
+	verify(0, 6, false)
+	verify(6, 10, true)
+	verifyTail(6)
+
+	IndexTransactions(db, 3, 6, nil)
+	verify(0, 3, false)
+	verify(3, 10, true)
+	verifyTail(3)
+
+	// Interrupted runs must not move the tail
+	interrupt := make(chan struct{})
+	close(interrupt)
+
+	UnindexTransactions(db, 3, 10, interrupt)
+	verify(3, 10, true)
+	verifyTail(3)
+}
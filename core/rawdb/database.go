@@ -53,6 +53,22 @@ func (frdb *freezerdb) Close() error {
 	return nil
 }
 
+// Refresh implements ethdb.Refresher, updating the view of a read-only database
+// with the changes made by its owner. The key-value store is refreshed first,
+// since the owner only deletes blocks from it after moving them into the
+// freezer: this way every block is visible in at least one of the two stores.
+func (frdb *freezerdb) Refresh() error {
+	if refresher, ok := frdb.KeyValueStore.(ethdb.Refresher); ok {
+		if err := refresher.Refresh(); err != nil {
+			return err
+		}
+	}
+	if freezer, ok := frdb.AncientStore.(*freezer); ok {
+		return freezer.refresh()
+	}
+	return nil
+}
+
 // nofreezedb is a database wrapper that disables freezer data retrievals.
 type nofreezedb struct {
 	ethdb.KeyValueStore
@@ -103,10 +119,11 @@ func NewDatabase(db ethdb.KeyValueStore) ethdb.Database {
 
 // NewDatabaseWithFreezer creates a high level database on top of a given key-
 // value data store with a freezer moving immutable chain segments into cold
-// storage.
-func NewDatabaseWithFreezer(db ethdb.KeyValueStore, freezer string, namespace string) (ethdb.Database, error) {
+// storage. If readonly is set, the freezer is assumed to be owned by another
+// process and is only read, nothing is ever moved into it.
+func NewDatabaseWithFreezer(db ethdb.KeyValueStore, freezer string, namespace string, readonly bool) (ethdb.Database, error) {
 	// Create the idle freezer instance
-	frdb, err := newFreezer(freezer, namespace)
+	frdb, err := newFreezer(freezer, namespace, readonly)
 	if err != nil {
 		return nil, err
 	}
@@ -172,8 +189,9 @@ func NewDatabaseWithFreezer(db ethdb.KeyValueStore, freezer string, namespace st
 		}
 	}
 	// Freezer is consistent with the key-value database, permit combining the two
-	go frdb.freeze(db)
-
+	if !readonly {
+		go frdb.freeze(db)
+	}
 	return &freezerdb{
 		KeyValueStore: db,
 		AncientStore:  frdb,
@@ -205,12 +223,24 @@ func NewLevelDBDatabase(file string, cache int, handles int, namespace string) (
 
 // NewLevelDBDatabaseWithFreezer creates a persistent key-value database with a
 // freezer moving immutable chain segments into cold storage.
-func NewLevelDBDatabaseWithFreezer(file string, cache int, handles int, freezer string, namespace string) (ethdb.Database, error) {
-	kvdb, err := leveldb.New(file, cache, handles, namespace)
+//
+// If readonly is set, the database and the freezer are opened as a secondary
+// view of the ones written by another live process. The view is consistent and
+// can be updated to the latest content via ethdb.Refresher.
+func NewLevelDBDatabaseWithFreezer(file string, cache int, handles int, freezer string, namespace string, readonly bool) (ethdb.Database, error) {
+	var (
+		kvdb ethdb.KeyValueStore
+		err  error
+	)
+	if readonly {
+		kvdb, err = leveldb.NewSecondary(file, cache, handles)
+	} else {
+		kvdb, err = leveldb.New(file, cache, handles, namespace)
+	}
 	if err != nil {
 		return nil, err
 	}
-	frdb, err := NewDatabaseWithFreezer(kvdb, freezer, namespace)
+	frdb, err := NewDatabaseWithFreezer(kvdb, freezer, namespace, readonly)
 	if err != nil {
 		kvdb.Close()
 		return nil, err
@@ -235,6 +265,7 @@ func InspectDatabase(db ethdb.Database) error {
 		bodySize        common.StorageSize
 		receiptSize     common.StorageSize
 		tdSize          common.StorageSize
+		reverseDiffSize common.StorageSize
 		numHashPairing  common.StorageSize
 		hashNumPairing  common.StorageSize
 		trieSize        common.StorageSize
@@ -278,6 +309,8 @@ func InspectDatabase(db ethdb.Database) error {
 			bodySize += size
 		case bytes.HasPrefix(key, blockReceiptsPrefix) && len(key) == (len(blockReceiptsPrefix)+8+common.HashLength):
 			receiptSize += size
+		case bytes.HasPrefix(key, reverseDiffPrefix) && len(key) == (len(reverseDiffPrefix)+8+common.HashLength):
+			reverseDiffSize += size
 		case bytes.HasPrefix(key, txLookupPrefix) && len(key) == (len(txLookupPrefix)+common.HashLength):
 			txlookupSize += size
 		case bytes.HasPrefix(key, preimagePrefix) && len(key) == (len(preimagePrefix)+common.HashLength):
@@ -294,7 +327,7 @@ func InspectDatabase(db ethdb.Database) error {
 			trieSize += size
 		default:
 			var accounted bool
-			for _, meta := range [][]byte{databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey} {
+			for _, meta := range metadataKeys {
 				if bytes.Equal(key, meta) {
 					metadata += size
 					accounted = true
@@ -331,6 +364,7 @@ func InspectDatabase(db ethdb.Database) error {
 		{"Key-Value store", "Bloombit index", bloomBitsSize.String()},
 		{"Key-Value store", "Trie nodes", trieSize.String()},
 		{"Key-Value store", "Trie preimages", preimageSize.String()},
+		{"Key-Value store", "Reverse state diffs", reverseDiffSize.String()},
 		{"Key-Value store", "Clique snapshots", cliqueSnapsSize.String()},
 		{"Key-Value store", "Singleton metadata", metadata.String()},
 		{"Ancient store", "Headers", ancientHeaders.String()},
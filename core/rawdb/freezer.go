@@ -73,11 +73,16 @@ type freezer struct {
 
 	tables       map[string]*freezerTable // Data tables for storing everything
 	instanceLock fileutil.Releaser        // File-system lock to prevent double opens
+	readonly     bool                     // Whether the freezer is owned by another process
 }
 
 // newFreezer creates a chain freezer that moves ancient chain data into
 // append-only flat file containers.
-func newFreezer(datadir string, namespace string) (*freezer, error) {
+//
+// A read-only freezer neither locks, nor repairs the data files, since those
+// are concurrently written by their owner. It only serves the items already
+// present at opening time, tracking the owner's progress on refresh.
+func newFreezer(datadir string, namespace string, readonly bool) (*freezer, error) {
 	// Create the initial freezer object
 	var (
 		readMeter  = metrics.NewRegisteredMeter(namespace+"ancient/read", nil)
@@ -93,34 +98,40 @@ func newFreezer(datadir string, namespace string) (*freezer, error) {
 	}
 	// Leveldb uses LOCK as the filelock filename. To prevent the
 	// name collision, we use FLOCK as the lock name.
-	lock, _, err := fileutil.Flock(filepath.Join(datadir, "FLOCK"))
-	if err != nil {
-		return nil, err
+	var lock fileutil.Releaser
+	if !readonly {
+		var err error
+		if lock, _, err = fileutil.Flock(filepath.Join(datadir, "FLOCK")); err != nil {
+			return nil, err
+		}
 	}
 	// Open all the supported data tables
 	freezer := &freezer{
 		tables:       make(map[string]*freezerTable),
 		instanceLock: lock,
+		readonly:     readonly,
 	}
 	for name, disableSnappy := range freezerNoSnappy {
-		table, err := newTable(datadir, name, readMeter, writeMeter, sizeGauge, disableSnappy)
+		var (
+			table *freezerTable
+			err   error
+		)
+		if readonly {
+			table, err = newReadonlyTable(datadir, name, readMeter, sizeGauge, disableSnappy)
+		} else {
+			table, err = newTable(datadir, name, readMeter, writeMeter, sizeGauge, disableSnappy)
+		}
 		if err != nil {
-			for _, table := range freezer.tables {
-				table.Close()
-			}
-			lock.Release()
+			freezer.Close()
 			return nil, err
 		}
 		freezer.tables[name] = table
 	}
 	if err := freezer.repair(); err != nil {
-		for _, table := range freezer.tables {
-			table.Close()
-		}
-		lock.Release()
+		freezer.Close()
 		return nil, err
 	}
-	log.Info("Opened ancient database", "database", datadir)
+	log.Info("Opened ancient database", "database", datadir, "readonly", readonly)
 	return freezer, nil
 }
 
@@ -132,8 +143,10 @@ func (f *freezer) Close() error {
 			errs = append(errs, err)
 		}
 	}
-	if err := f.instanceLock.Release(); err != nil {
-		errs = append(errs, err)
+	if f.instanceLock != nil {
+		if err := f.instanceLock.Release(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 	if errs != nil {
 		return fmt.Errorf("%v", errs)
@@ -178,6 +191,9 @@ func (f *freezer) AncientSize(kind string) (uint64, error) {
 // injection will be rejected. But if two injections with same number happen at
 // the same time, we can get into the trouble.
 func (f *freezer) AppendAncient(number uint64, hash, header, body, receipts, td []byte) (err error) {
+	if f.readonly {
+		return errReadonly
+	}
 	// Ensure the binary blobs we are appending is continuous with freezer.
 	if atomic.LoadUint64(&f.frozen) != number {
 		return errOutOrderInsertion
@@ -220,6 +236,9 @@ func (f *freezer) AppendAncient(number uint64, hash, header, body, receipts, td
 
 // Truncate discards any recent data above the provided threshold number.
 func (f *freezer) TruncateAncients(items uint64) error {
+	if f.readonly {
+		return errReadonly
+	}
 	if atomic.LoadUint64(&f.frozen) <= items {
 		return nil
 	}
@@ -234,6 +253,9 @@ func (f *freezer) TruncateAncients(items uint64) error {
 
 // sync flushes all data tables to disk.
 func (f *freezer) Sync() error {
+	if f.readonly {
+		return errReadonly
+	}
 	var errs []error
 	for _, table := range f.tables {
 		if err := table.Sync(); err != nil {
@@ -374,7 +396,8 @@ func (f *freezer) freeze(db ethdb.KeyValueStore) {
 	}
 }
 
-// repair truncates all data tables to the same length.
+// repair truncates all data tables to the same length. Read-only freezers are
+// not truncated, only the items present in all tables are exposed.
 func (f *freezer) repair() error {
 	min := uint64(math.MaxUint64)
 	for _, table := range f.tables {
@@ -383,6 +406,10 @@ func (f *freezer) repair() error {
 			min = items
 		}
 	}
+	if f.readonly {
+		atomic.StoreUint64(&f.frozen, min)
+		return nil
+	}
 	for _, table := range f.tables {
 		if err := table.truncate(min); err != nil {
 			return err
@@ -391,3 +418,17 @@ func (f *freezer) repair() error {
 	atomic.StoreUint64(&f.frozen, min)
 	return nil
 }
+
+// refresh reloads the data tables of a read-only freezer, exposing the items
+// appended by the owner process since the last refresh.
+func (f *freezer) refresh() error {
+	if !f.readonly {
+		return nil
+	}
+	for _, table := range f.tables {
+		if err := table.reload(); err != nil {
+			return err
+		}
+	}
+	return f.repair()
+}
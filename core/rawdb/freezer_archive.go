@@ -0,0 +1,385 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// archiveVersion is the version number of the ancient archive format. It is
+	// bumped whenever the encoding changes in an incompatible way.
+	archiveVersion = 1
+
+	// DefaultArchiveSegmentSize is the number of blocks bundled into a single
+	// self-verifying segment of an ancient archive.
+	DefaultArchiveSegmentSize = 8192
+)
+
+var (
+	// errArchiveVersion is returned if an ancient archive was produced by an
+	// incompatible version of the exporter.
+	errArchiveVersion = errors.New("unsupported ancient archive version")
+
+	// errArchiveGenesis is returned if an ancient archive belongs to a different
+	// network than the database it's being imported into.
+	errArchiveGenesis = errors.New("ancient archive genesis mismatch")
+
+	// errArchiveChecksum is returned if the accumulator of an archive segment
+	// does not match its content.
+	errArchiveChecksum = errors.New("ancient archive segment checksum mismatch")
+)
+
+// archiveHeader is the leading item of an ancient archive, identifying the
+// format version and the network the contained chain segments belong to.
+type archiveHeader struct {
+	Version uint64
+	Genesis common.Hash
+}
+
+// archiveEntry is the raw freezer content of a single canonical block.
+type archiveEntry struct {
+	Hash     common.Hash
+	Header   rlp.RawValue
+	Body     rlp.RawValue
+	Receipts rlp.RawValue
+	Td       rlp.RawValue
+}
+
+// archiveSegment is a contiguous batch of canonical blocks starting at number
+// First, sealed by an accumulator over all the contained entries.
+type archiveSegment struct {
+	First       uint64
+	Entries     []archiveEntry
+	Accumulator common.Hash
+}
+
+// accumulate calculates the checksum of the segment's entries. Every entry is
+// folded in as its hash followed by the digests of its individual components,
+// so reordering, omitting or altering any blob changes the accumulator.
+func (s *archiveSegment) accumulate() common.Hash {
+	hasher := sha3.NewLegacyKeccak256()
+
+	hasher.Write(encodeBlockNumber(s.First))
+	for _, entry := range s.Entries {
+		hasher.Write(entry.Hash[:])
+		hasher.Write(crypto.Keccak256(entry.Header))
+		hasher.Write(crypto.Keccak256(entry.Body))
+		hasher.Write(crypto.Keccak256(entry.Receipts))
+		hasher.Write(crypto.Keccak256(entry.Td))
+	}
+	var acc common.Hash
+	hasher.Sum(acc[:0])
+	return acc
+}
+
+// ExportAncients writes the frozen canonical chain segment [first, last] into
+// the given writer as a self-verifying ancient archive. The data is streamed
+// straight out of the freezer tables in batches of segment blocks, each of them
+// sealed with a checksum accumulator.
+func ExportAncients(db ethdb.AncientReader, w io.Writer, first, last uint64, segment uint64) error {
+	frozen, err := db.Ancients()
+	if err != nil {
+		return err
+	}
+	if first > last {
+		return fmt.Errorf("invalid export range: first (%d) > last (%d)", first, last)
+	}
+	if last >= frozen {
+		return fmt.Errorf("export range beyond ancient store: last %d, frozen %d", last, frozen)
+	}
+	if segment == 0 {
+		segment = DefaultArchiveSegmentSize
+	}
+	genesis, err := db.Ancient(freezerHashTable, 0)
+	if err != nil {
+		return err
+	}
+	if err := rlp.Encode(w, &archiveHeader{Version: archiveVersion, Genesis: common.BytesToHash(genesis)}); err != nil {
+		return err
+	}
+	var (
+		start  = time.Now()
+		logged = time.Now()
+	)
+	for number := first; number <= last; {
+		limit := number + segment - 1
+		if limit > last {
+			limit = last
+		}
+		batch := &archiveSegment{
+			First:   number,
+			Entries: make([]archiveEntry, 0, limit-number+1),
+		}
+		for ; number <= limit; number++ {
+			entry, err := readArchiveEntry(db, number)
+			if err != nil {
+				return err
+			}
+			batch.Entries = append(batch.Entries, *entry)
+		}
+		batch.Accumulator = batch.accumulate()
+		if err := rlp.Encode(w, batch); err != nil {
+			return err
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Exporting ancient chain segments", "exported", number-first, "number", number-1, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	log.Info("Exported ancient chain segments", "first", first, "last", last, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// readArchiveEntry retrieves all the raw freezer components of a single block.
+func readArchiveEntry(db ethdb.AncientReader, number uint64) (*archiveEntry, error) {
+	hash, err := db.Ancient(freezerHashTable, number)
+	if err != nil {
+		return nil, fmt.Errorf("ancient hash #%d: %v", number, err)
+	}
+	header, err := db.Ancient(freezerHeaderTable, number)
+	if err != nil {
+		return nil, fmt.Errorf("ancient header #%d: %v", number, err)
+	}
+	body, err := db.Ancient(freezerBodiesTable, number)
+	if err != nil {
+		return nil, fmt.Errorf("ancient body #%d: %v", number, err)
+	}
+	receipts, err := db.Ancient(freezerReceiptTable, number)
+	if err != nil {
+		return nil, fmt.Errorf("ancient receipts #%d: %v", number, err)
+	}
+	td, err := db.Ancient(freezerDifficultyTable, number)
+	if err != nil {
+		return nil, fmt.Errorf("ancient difficulty #%d: %v", number, err)
+	}
+	return &archiveEntry{
+		Hash:     common.BytesToHash(hash),
+		Header:   header,
+		Body:     body,
+		Receipts: receipts,
+		Td:       td,
+	}, nil
+}
+
+// ImportAncients reads an ancient archive from the given reader and appends its
+// chain segments to the freezer of the database, without executing any of the
+// contained blocks. Every segment is fully verified before being written:
+//
+//   - the segment accumulator must match its content,
+//   - block hashes must match the headers and link up to their parents,
+//   - transaction, uncle and receipt roots must match the headers,
+//   - the total difficulties must accumulate the header difficulties.
+//
+// Blocks already present in the freezer are cross checked and skipped. The hash
+// to number mappings and transaction lookups are written for every imported block
+// and the head header and fast block markers are advanced, leaving the database
+// in the same state as an interrupted fast sync. The number of newly imported
+// blocks is returned.
+func ImportAncients(db ethdb.Database, r io.Reader) (uint64, error) {
+	stream := rlp.NewStream(r, 0)
+
+	var header archiveHeader
+	if err := stream.Decode(&header); err != nil {
+		return 0, fmt.Errorf("invalid archive header: %v", err)
+	}
+	if header.Version != archiveVersion {
+		return 0, fmt.Errorf("%v: have %d, want %d", errArchiveVersion, header.Version, archiveVersion)
+	}
+	genesis := ReadCanonicalHash(db, 0)
+	if genesis == (common.Hash{}) {
+		return 0, errors.New("database not initialized, genesis missing")
+	}
+	if header.Genesis != genesis {
+		return 0, fmt.Errorf("%v: have %x, want %x", errArchiveGenesis, header.Genesis, genesis)
+	}
+	frozen, err := db.Ancients()
+	if err != nil {
+		return 0, err
+	}
+	// Refuse to import into a database that already progressed beyond the freezer,
+	// since the imported segments would leave a gap in the key-value store.
+	head := ReadHeaderNumber(db, ReadHeadHeaderHash(db))
+	if head != nil && *head > 0 && *head >= frozen {
+		return 0, fmt.Errorf("chain database already contains blocks beyond the ancient store: head %d, frozen %d", *head, frozen)
+	}
+	// Gather the parent of the next block to import for linking up the segments
+	var (
+		parentHash common.Hash
+		parentTd   *big.Int
+	)
+	if frozen > 0 {
+		blob, err := db.Ancient(freezerHashTable, frozen-1)
+		if err != nil {
+			return 0, err
+		}
+		parentHash = common.BytesToHash(blob)
+		if blob, err = db.Ancient(freezerDifficultyTable, frozen-1); err != nil {
+			return 0, err
+		}
+		parentTd = new(big.Int)
+		if err := rlp.DecodeBytes(blob, parentTd); err != nil {
+			return 0, err
+		}
+	}
+	var (
+		imported uint64
+		start    = time.Now()
+		logged   = time.Now()
+		batch    = db.NewBatch()
+		last     = common.Hash{}
+	)
+	for {
+		var segment archiveSegment
+		if err := stream.Decode(&segment); err == io.EOF {
+			break
+		} else if err != nil {
+			return imported, fmt.Errorf("invalid archive segment: %v", err)
+		}
+		if segment.accumulate() != segment.Accumulator {
+			return imported, fmt.Errorf("%v: segment #%d", errArchiveChecksum, segment.First)
+		}
+		// Skip over (but verify) any blocks already present in the freezer
+		entries := segment.Entries
+		for len(entries) > 0 && segment.First < frozen {
+			blob, err := db.Ancient(freezerHashTable, segment.First)
+			if err != nil {
+				return imported, err
+			}
+			if common.BytesToHash(blob) != entries[0].Hash {
+				if len(blob) > 4 {
+					blob = blob[:4]
+				}
+				return imported, fmt.Errorf("archive block #%d [%x…] conflicts with ancient store [%x…]", segment.First, entries[0].Hash[:4], blob)
+			}
+			segment.First, entries = segment.First+1, entries[1:]
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		if segment.First != frozen {
+			return imported, fmt.Errorf("archive gap: segment starts at #%d, ancient store at #%d", segment.First, frozen)
+		}
+		// Verify the entire segment before writing anything out
+		blocks := make([]*types.Block, len(entries))
+		for i := range entries {
+			block, td, err := verifyArchiveEntry(&entries[i], frozen+uint64(i), genesis, parentHash, parentTd)
+			if err != nil {
+				return imported, err
+			}
+			blocks[i], parentHash, parentTd = block, block.Hash(), td
+		}
+		// Segment valid, push it into the freezer and index it
+		for i, entry := range entries {
+			if err := db.AppendAncient(frozen, entry.Hash[:], entry.Header, entry.Body, entry.Receipts, entry.Td); err != nil {
+				return imported, err
+			}
+			WriteHeaderNumber(batch, entry.Hash, frozen)
+			WriteTxLookupEntries(batch, blocks[i])
+
+			frozen++
+			imported++
+			last = entry.Hash
+		}
+		if err := db.Sync(); err != nil {
+			return imported, err
+		}
+		if err := batch.Write(); err != nil {
+			return imported, err
+		}
+		batch.Reset()
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Importing ancient chain segments", "imported", imported, "number", frozen-1, "hash", last, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if imported > 0 {
+		WriteHeadHeaderHash(db, last)
+		WriteHeadFastBlockHash(db, last)
+	}
+	log.Info("Imported ancient chain segments", "imported", imported, "frozen", frozen, "elapsed", common.PrettyDuration(time.Since(start)))
+	return imported, nil
+}
+
+// verifyArchiveEntry checks the self-consistency of a single archived block and
+// whether it links up to the given parent. The assembled block and its total
+// difficulty are returned.
+func verifyArchiveEntry(entry *archiveEntry, number uint64, genesis common.Hash, parentHash common.Hash, parentTd *big.Int) (*types.Block, *big.Int, error) {
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(entry.Header, header); err != nil {
+		return nil, nil, fmt.Errorf("archive block #%d: invalid header: %v", number, err)
+	}
+	if hash := header.Hash(); hash != entry.Hash {
+		return nil, nil, fmt.Errorf("archive block #%d: hash mismatch: have %x, want %x", number, hash, entry.Hash)
+	}
+	if header.Number.Uint64() != number {
+		return nil, nil, fmt.Errorf("archive block #%d: number mismatch: have %v", number, header.Number)
+	}
+	if number == 0 {
+		if entry.Hash != genesis {
+			return nil, nil, fmt.Errorf("%v: have %x, want %x", errArchiveGenesis, entry.Hash, genesis)
+		}
+	} else if header.ParentHash != parentHash {
+		return nil, nil, fmt.Errorf("archive block #%d: parent mismatch: have %x, want %x", number, header.ParentHash, parentHash)
+	}
+	body := new(types.Body)
+	if err := rlp.DecodeBytes(entry.Body, body); err != nil {
+		return nil, nil, fmt.Errorf("archive block #%d: invalid body: %v", number, err)
+	}
+	if hash := types.DeriveSha(types.Transactions(body.Transactions)); hash != header.TxHash {
+		return nil, nil, fmt.Errorf("archive block #%d: transaction root mismatch: have %x, want %x", number, hash, header.TxHash)
+	}
+	if hash := types.CalcUncleHash(body.Uncles); hash != header.UncleHash {
+		return nil, nil, fmt.Errorf("archive block #%d: uncle root mismatch: have %x, want %x", number, hash, header.UncleHash)
+	}
+	var storageReceipts []*types.ReceiptForStorage
+	if err := rlp.DecodeBytes(entry.Receipts, &storageReceipts); err != nil {
+		return nil, nil, fmt.Errorf("archive block #%d: invalid receipts: %v", number, err)
+	}
+	receipts := make(types.Receipts, len(storageReceipts))
+	for i, receipt := range storageReceipts {
+		receipts[i] = (*types.Receipt)(receipt)
+	}
+	if hash := types.DeriveSha(receipts); hash != header.ReceiptHash {
+		return nil, nil, fmt.Errorf("archive block #%d: receipt root mismatch: have %x, want %x", number, hash, header.ReceiptHash)
+	}
+	td := new(big.Int)
+	if err := rlp.DecodeBytes(entry.Td, td); err != nil {
+		return nil, nil, fmt.Errorf("archive block #%d: invalid total difficulty: %v", number, err)
+	}
+	want := new(big.Int).Set(header.Difficulty)
+	if number > 0 {
+		want.Add(want, parentTd)
+	}
+	if td.Cmp(want) != 0 {
+		return nil, nil, fmt.Errorf("archive block #%d: total difficulty mismatch: have %v, want %v", number, td, want)
+	}
+	return types.NewBlockWithHeader(header).WithBody(body.Transactions, body.Uncles), td, nil
+}
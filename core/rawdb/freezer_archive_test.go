@@ -0,0 +1,290 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// makeArchiveChain creates a chain of blocks with transactions and receipts,
+// along with their total difficulties.
+func makeArchiveChain(n int) ([]*types.Block, []types.Receipts, []*big.Int) {
+	var (
+		blocks   = make([]*types.Block, n)
+		receipts = make([]types.Receipts, n)
+		tds      = make([]*big.Int, n)
+		td       = new(big.Int)
+	)
+	for i := 0; i < n; i++ {
+		header := &types.Header{
+			Number:     big.NewInt(int64(i)),
+			Difficulty: big.NewInt(int64(1000 + i)),
+			GasLimit:   8000000,
+			Extra:      []byte("archive test"),
+		}
+		if i > 0 {
+			header.ParentHash = blocks[i-1].Hash()
+		}
+		var (
+			txs   types.Transactions
+			rcpts types.Receipts
+		)
+		for j := 0; j < i%3; j++ {
+			tx := types.NewTransaction(uint64(i*10+j), common.Address{byte(i)}, big.NewInt(int64(j)), 21000, big.NewInt(1), nil)
+			txs = append(txs, tx)
+
+			receipt := &types.Receipt{
+				Status:            types.ReceiptStatusSuccessful,
+				CumulativeGasUsed: uint64(21000 * (j + 1)),
+				Logs:              []*types.Log{{Address: common.Address{byte(j)}, Topics: []common.Hash{{byte(i)}}, Data: []byte{byte(j)}}},
+				TxHash:            tx.Hash(),
+				GasUsed:           21000,
+			}
+			receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+			rcpts = append(rcpts, receipt)
+		}
+		blocks[i] = types.NewBlock(header, txs, nil, rcpts)
+		receipts[i] = rcpts
+
+		td = new(big.Int).Add(td, header.Difficulty)
+		tds[i] = td
+	}
+	return blocks, receipts, tds
+}
+
+// newArchiveDatabase creates a freezer backed database in a temporary folder,
+// returning a cleanup function to remove it.
+func newArchiveDatabase(t *testing.T) (ethdb.Database, func()) {
+	dir, err := ioutil.TempDir("", "freezer-archive")
+	if err != nil {
+		t.Fatalf("failed to create temporary folder: %v", err)
+	}
+	db, err := NewDatabaseWithFreezer(memorydb.New(), dir, "", false)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to create freezer database: %v", err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// initArchiveGenesis writes the genesis block into the key-value store of the
+// database, as `geth init` would.
+func initArchiveGenesis(db ethdb.Database, genesis *types.Block, td *big.Int) {
+	WriteBlock(db, genesis)
+	WriteTd(db, genesis.Hash(), 0, td)
+	WriteReceipts(db, genesis.Hash(), 0, nil)
+	WriteCanonicalHash(db, genesis.Hash(), 0)
+	WriteHeadHeaderHash(db, genesis.Hash())
+	WriteHeadBlockHash(db, genesis.Hash())
+	WriteHeadFastBlockHash(db, genesis.Hash())
+}
+
+// Tests that a chain segment exported from one freezer can be imported into the
+// freezer of a freshly initialized database without any loss of information.
+func TestAncientArchiveRoundtrip(t *testing.T) {
+	blocks, receipts, tds := makeArchiveChain(50)
+
+	src, srcClose := newArchiveDatabase(t)
+	defer srcClose()
+	for i, block := range blocks {
+		WriteAncientBlock(src, block, receipts[i], tds[i])
+	}
+	var archive bytes.Buffer
+	if err := ExportAncients(src, &archive, 0, uint64(len(blocks)-1), 16); err != nil {
+		t.Fatalf("failed to export ancients: %v", err)
+	}
+	dst, dstClose := newArchiveDatabase(t)
+	defer dstClose()
+	initArchiveGenesis(dst, blocks[0], tds[0])
+
+	imported, err := ImportAncients(dst, bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to import ancients: %v", err)
+	}
+	if imported != uint64(len(blocks)) {
+		t.Fatalf("imported block count mismatch: have %d, want %d", imported, len(blocks))
+	}
+	if frozen, _ := dst.Ancients(); frozen != uint64(len(blocks)) {
+		t.Fatalf("ancient count mismatch: have %d, want %d", frozen, len(blocks))
+	}
+	for i, block := range blocks {
+		if hash := ReadCanonicalHash(dst, uint64(i)); hash != block.Hash() {
+			t.Fatalf("block #%d: canonical hash mismatch: have %x, want %x", i, hash, block.Hash())
+		}
+		if number := ReadHeaderNumber(dst, block.Hash()); number == nil || *number != uint64(i) {
+			t.Fatalf("block #%d: hash to number mapping missing", i)
+		}
+		if td := ReadTd(dst, block.Hash(), uint64(i)); td == nil || td.Cmp(tds[i]) != 0 {
+			t.Fatalf("block #%d: total difficulty mismatch: have %v, want %v", i, td, tds[i])
+		}
+		have := ReadReceipts(dst, block.Hash(), uint64(i), params.TestChainConfig)
+		if len(have) != len(receipts[i]) {
+			t.Fatalf("block #%d: receipt count mismatch: have %d, want %d", i, len(have), len(receipts[i]))
+		}
+		for _, tx := range block.Transactions() {
+			if number := ReadTxLookupEntry(dst, tx.Hash()); number == nil || *number != uint64(i) {
+				t.Fatalf("block #%d: transaction lookup missing for %x", i, tx.Hash())
+			}
+		}
+	}
+	head := blocks[len(blocks)-1].Hash()
+	if hash := ReadHeadHeaderHash(dst); hash != head {
+		t.Errorf("head header mismatch: have %x, want %x", hash, head)
+	}
+	if hash := ReadHeadFastBlockHash(dst); hash != head {
+		t.Errorf("head fast block mismatch: have %x, want %x", hash, head)
+	}
+	if hash := ReadHeadBlockHash(dst); hash != blocks[0].Hash() {
+		t.Errorf("head block mismatch: have %x, want %x", hash, blocks[0].Hash())
+	}
+	// Importing the same archive again should be a no-op
+	if imported, err := ImportAncients(dst, bytes.NewReader(archive.Bytes())); err != nil || imported != 0 {
+		t.Errorf("reimport: imported %d, err %v", imported, err)
+	}
+}
+
+// Tests that importing can continue from a partially populated freezer, skipping
+// the already known blocks.
+func TestAncientArchiveResume(t *testing.T) {
+	blocks, receipts, tds := makeArchiveChain(40)
+
+	src, srcClose := newArchiveDatabase(t)
+	defer srcClose()
+	for i, block := range blocks {
+		WriteAncientBlock(src, block, receipts[i], tds[i])
+	}
+	dst, dstClose := newArchiveDatabase(t)
+	defer dstClose()
+	for i, block := range blocks[:25] {
+		WriteAncientBlock(dst, block, receipts[i], tds[i])
+	}
+	initArchiveGenesis(dst, blocks[0], tds[0])
+
+	var archive bytes.Buffer
+	if err := ExportAncients(src, &archive, 10, uint64(len(blocks)-1), 8); err != nil {
+		t.Fatalf("failed to export ancients: %v", err)
+	}
+	imported, err := ImportAncients(dst, &archive)
+	if err != nil {
+		t.Fatalf("failed to import ancients: %v", err)
+	}
+	if imported != 15 {
+		t.Fatalf("imported block count mismatch: have %d, want %d", imported, 15)
+	}
+	if frozen, _ := dst.Ancients(); frozen != uint64(len(blocks)) {
+		t.Fatalf("ancient count mismatch: have %d, want %d", frozen, len(blocks))
+	}
+	// Archives starting beyond the freezer must be rejected
+	archive.Reset()
+	if err := ExportAncients(src, &archive, 30, 35, 0); err != nil {
+		t.Fatalf("failed to export ancients: %v", err)
+	}
+	dst2, dst2Close := newArchiveDatabase(t)
+	defer dst2Close()
+	initArchiveGenesis(dst2, blocks[0], tds[0])
+
+	if _, err := ImportAncients(dst2, &archive); err == nil || !strings.Contains(err.Error(), "archive gap") {
+		t.Fatalf("gapped import error mismatch: have %v", err)
+	}
+	// Archives conflicting with the already frozen blocks must be rejected
+	archive.Reset()
+	if err := ExportAncients(src, &archive, 0, 5, 0); err != nil {
+		t.Fatalf("failed to export ancients: %v", err)
+	}
+	dst3, dst3Close := newArchiveDatabase(t)
+	defer dst3Close()
+	for i, block := range blocks[:3] {
+		if i == 2 {
+			header := block.Header()
+			header.Extra = []byte("conflicting")
+			block = types.NewBlockWithHeader(header)
+		}
+		WriteAncientBlock(dst3, block, nil, tds[i])
+	}
+	initArchiveGenesis(dst3, blocks[0], tds[0])
+
+	if _, err := ImportAncients(dst3, &archive); err == nil || !strings.Contains(err.Error(), "conflicts with ancient store") {
+		t.Fatalf("conflicting import error mismatch: have %v", err)
+	}
+}
+
+// Tests that corrupted or forged archives are rejected before anything is
+// written into the freezer.
+func TestAncientArchiveVerification(t *testing.T) {
+	blocks, receipts, tds := makeArchiveChain(10)
+
+	src, srcClose := newArchiveDatabase(t)
+	defer srcClose()
+	for i, block := range blocks {
+		WriteAncientBlock(src, block, receipts[i], tds[i])
+	}
+	var archive bytes.Buffer
+	if err := ExportAncients(src, &archive, 0, uint64(len(blocks)-1), 0); err != nil {
+		t.Fatalf("failed to export ancients: %v", err)
+	}
+	// Flipping a bit anywhere in the payload must break the checksum
+	corrupt := common.CopyBytes(archive.Bytes())
+	corrupt[len(corrupt)/2] ^= 0x01
+
+	dst, dstClose := newArchiveDatabase(t)
+	defer dstClose()
+	initArchiveGenesis(dst, blocks[0], tds[0])
+
+	if _, err := ImportAncients(dst, bytes.NewReader(corrupt)); err == nil {
+		t.Fatalf("corrupted archive imported")
+	}
+	if frozen, _ := dst.Ancients(); frozen != 0 {
+		t.Fatalf("corrupted archive partially imported: %d blocks", frozen)
+	}
+	// A correctly sealed segment with inconsistent content must be rejected too
+	forged := &archiveSegment{First: 0}
+	for i := range blocks {
+		entry, err := readArchiveEntry(src, uint64(i))
+		if err != nil {
+			t.Fatalf("failed to read ancient entry %d: %v", i, err)
+		}
+		forged.Entries = append(forged.Entries, *entry)
+	}
+	forged.Entries[5].Td = forged.Entries[6].Td
+	forged.Accumulator = forged.accumulate()
+
+	if _, _, err := verifyArchiveEntry(&forged.Entries[5], 5, blocks[0].Hash(), blocks[4].Hash(), tds[4]); err == nil {
+		t.Fatalf("forged total difficulty accepted")
+	}
+	// Archives of a different network must be rejected
+	dst2, dst2Close := newArchiveDatabase(t)
+	defer dst2Close()
+	initArchiveGenesis(dst2, types.NewBlockWithHeader(&types.Header{Number: big.NewInt(0), Difficulty: big.NewInt(1), Extra: []byte("other")}), big.NewInt(1))
+
+	if _, err := ImportAncients(dst2, bytes.NewReader(archive.Bytes())); err == nil || !strings.Contains(err.Error(), errArchiveGenesis.Error()) {
+		t.Fatalf("foreign archive error mismatch: have %v", err)
+	}
+}
@@ -43,6 +43,9 @@ var (
 
 	// errNotSupported is returned if the database doesn't support the required operation.
 	errNotSupported = errors.New("this operation is not supported")
+
+	// errReadonly is returned if a write is attempted on a read-only freezer.
+	errReadonly = errors.New("read-only freezer")
 )
 
 // indexEntry contains the number/id of the file that the data resides in, aswell as the
@@ -80,6 +83,7 @@ type freezerTable struct {
 	items uint64 // Number of items stored in the table (including items removed from tail)
 
 	noCompression bool   // if true, disables snappy compression. Note: does not work retroactively
+	readonly      bool   // if true, the table is owned by another process and only read
 	maxFileSize   uint32 // Max file size for data-files
 	name          string
 	path          string
@@ -194,6 +198,102 @@ func newCustomTable(path string, name string, readMeter metrics.Meter, writeMete
 	return tab, nil
 }
 
+// newReadonlyTable opens a freezer table written by another process for read
+// only access. Nothing is created or repaired, the number of items is derived
+// from the index file and can be updated via reload as the table grows.
+func newReadonlyTable(path string, name string, readMeter metrics.Meter, sizeGauge metrics.Gauge, noCompression bool) (*freezerTable, error) {
+	var idxName string
+	if noCompression {
+		idxName = fmt.Sprintf("%s.ridx", name)
+	} else {
+		idxName = fmt.Sprintf("%s.cidx", name)
+	}
+	offsets, err := openFreezerFileForReadOnly(filepath.Join(path, idxName))
+	if err != nil {
+		return nil, err
+	}
+	tab := &freezerTable{
+		index:         offsets,
+		files:         make(map[uint32]*os.File),
+		readMeter:     readMeter,
+		writeMeter:    metrics.NilMeter{},
+		sizeGauge:     sizeGauge,
+		name:          name,
+		path:          path,
+		logger:        log.New("database", path, "table", name),
+		noCompression: noCompression,
+		maxFileSize:   2 * 1000 * 1000 * 1000,
+		readonly:      true,
+	}
+	if err := tab.reload(); err != nil {
+		tab.Close()
+		return nil, err
+	}
+	return tab, nil
+}
+
+// reload updates the item counters and the open data files of a read-only table
+// from the current content of the index file. Any trailing partial entry still
+// being written is ignored.
+func (t *freezerTable) reload() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil {
+		return errClosed
+	}
+	var oldSize uint64
+	if t.head != nil {
+		size, err := t.sizeNolock()
+		if err != nil {
+			return err
+		}
+		oldSize = size
+	}
+	stat, err := t.index.Stat()
+	if err != nil {
+		return err
+	}
+	entries := stat.Size() / indexEntrySize
+
+	var firstIndex, lastIndex indexEntry
+	if entries > 0 {
+		buffer := make([]byte, indexEntrySize)
+		if _, err := t.index.ReadAt(buffer, 0); err != nil {
+			return err
+		}
+		firstIndex.unmarshalBinary(buffer)
+		if _, err := t.index.ReadAt(buffer, (entries-1)*indexEntrySize); err != nil {
+			return err
+		}
+		lastIndex.unmarshalBinary(buffer)
+	} else {
+		// Index not yet initialized by its owner, treat it as empty
+		entries = 1
+	}
+	// Open any new data files and drop the ones no longer referenced
+	t.releaseFilesAfter(lastIndex.filenum, false)
+	for i := firstIndex.offset; i <= lastIndex.filenum; i++ {
+		if _, err := t.openFile(i, openFreezerFileForReadOnly); err != nil {
+			return err
+		}
+	}
+	t.head = t.files[lastIndex.filenum]
+	t.tailId = firstIndex.offset
+
+	atomic.StoreUint32(&t.itemOffset, firstIndex.filenum)
+	atomic.StoreUint32(&t.headId, lastIndex.filenum)
+	atomic.StoreUint32(&t.headBytes, lastIndex.offset)
+	atomic.StoreUint64(&t.items, uint64(firstIndex.filenum)+uint64(entries-1))
+
+	newSize, err := t.sizeNolock()
+	if err != nil {
+		return err
+	}
+	t.sizeGauge.Inc(int64(newSize) - int64(oldSize))
+	return nil
+}
+
 // repair cross checks the head and the index file and truncates them to
 // be in sync with each other after a potential crash / data loss.
 func (t *freezerTable) repair() error {
@@ -329,6 +429,10 @@ func (t *freezerTable) truncate(items uint64) error {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
+	if t.readonly {
+		return errReadonly
+	}
+
 	// If our item count is correct, don't do anything
 	if atomic.LoadUint64(&t.items) <= items {
 		return nil
@@ -455,6 +559,9 @@ func (t *freezerTable) releaseFilesAfter(num uint32, remove bool) {
 // Note, this method will *not* flush any data to disk so be sure to explicitly
 // fsync before irreversibly deleting data from the database.
 func (t *freezerTable) Append(item uint64, blob []byte) error {
+	if t.readonly {
+		return errReadonly
+	}
 	// Read lock prevents competition with truncate
 	t.lock.RLock()
 	// Ensure the table is still accessible
@@ -607,6 +714,9 @@ func (t *freezerTable) sizeNolock() (uint64, error) {
 // Sync pushes any pending data from memory out to disk. This is an expensive
 // operation, so use it with care.
 func (t *freezerTable) Sync() error {
+	if t.readonly {
+		return nil
+	}
 	if err := t.index.Sync(); err != nil {
 		return err
 	}
@@ -614,3 +614,65 @@ func TestOffset(t *testing.T) {
 // However, all 'normal' failure modes arising due to failing to sync() or save a file should be
 // handled already, and the case described above can only (?) happen if an external process/user
 // deletes files from the filesystem.
+
+// Tests that a read-only table tracks the items appended to a live table as it
+// is reloaded, without modifying it.
+func TestFreezerReadonly(t *testing.T) {
+	t.Parallel()
+	fname := fmt.Sprintf("readonly-%d", rand.Uint64())
+
+	f, err := newCustomTable(os.TempDir(), fname, metrics.NewMeter(), metrics.NewMeter(), metrics.NewGauge(), 50, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for x := 0; x < 10; x++ {
+		f.Append(uint64(x), getChunk(15, x))
+	}
+	ro, err := newReadonlyTable(os.TempDir(), fname, metrics.NewMeter(), metrics.NewGauge(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+
+	if ro.items != 10 {
+		t.Fatalf("item count mismatch: have %d, want %d", ro.items, 10)
+	}
+	// Append data spanning multiple new files, and check it appears on reload
+	for x := 10; x < 30; x++ {
+		f.Append(uint64(x), getChunk(15, x))
+	}
+	if _, err := ro.Retrieve(10); err != errOutOfBounds {
+		t.Fatalf("retrieval before reload: have %v, want %v", err, errOutOfBounds)
+	}
+	if err := ro.reload(); err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 30; y++ {
+		got, err := ro.Retrieve(uint64(y))
+		if err != nil {
+			t.Fatalf("item %d: %v", y, err)
+		}
+		if exp := getChunk(15, y); !bytes.Equal(got, exp) {
+			t.Fatalf("item %d: have %x, want %x", y, got, exp)
+		}
+	}
+	// Modifications must be rejected
+	if err := ro.Append(30, getChunk(15, 30)); err != errReadonly {
+		t.Fatalf("append error mismatch: have %v, want %v", err, errReadonly)
+	}
+	if err := ro.truncate(10); err != errReadonly {
+		t.Fatalf("truncate error mismatch: have %v, want %v", err, errReadonly)
+	}
+	// Truncations of the live table must be picked up too
+	f.truncate(5)
+	if err := ro.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ro.Retrieve(5); err != errOutOfBounds {
+		t.Fatalf("retrieval after truncate: have %v, want %v", err, errOutOfBounds)
+	}
+	if got, _ := ro.Retrieve(4); !bytes.Equal(got, getChunk(15, 4)) {
+		t.Fatalf("item 4 mismatch after truncate: have %x", got)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"golang.org/x/crypto/sha3"
+)
+
+// migrationMarkerPrefix is the prefix of the resume markers stored in the target
+// database of an interrupted key range copy.
+var migrationMarkerPrefix = []byte("migration-marker-") // migrationMarkerPrefix + range name -> last copied key
+
+// errMigrationInterrupted is returned if a key range copy is aborted midway.
+var errMigrationInterrupted = errors.New("migration interrupted")
+
+// metadataKeys are the singleton keys tracking the chain and database status.
+var metadataKeys = [][]byte{databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey, txIndexTailKey}
+
+// KeyRange is a named subset of the key-value store, as defined by the database
+// schema. Every range is confined to a key prefix, further narrowed down by an
+// optional filter on the full key.
+type KeyRange struct {
+	Name   string            // Unique name of the range
+	Prefix []byte            // Common prefix of all the keys in the range
+	Filter func([]byte) bool // Optional filter for the keys sharing the prefix
+}
+
+// contains returns whether a key belongs to the range.
+func (r KeyRange) contains(key []byte) bool {
+	if !bytes.HasPrefix(key, r.Prefix) || bytes.HasPrefix(key, migrationMarkerPrefix) {
+		return false
+	}
+	return r.Filter == nil || r.Filter(key)
+}
+
+// keyLength returns a key filter accepting only keys of the given length.
+func keyLength(length int) func([]byte) bool {
+	return func(key []byte) bool { return len(key) == length }
+}
+
+// KeyRanges are all the well known key ranges of the database schema.
+//
+// Note, contract code and trie nodes are both keyed by their hashes without any
+// prefix, so they cannot be separated from each other and belong to the same
+// state range.
+var KeyRanges = []KeyRange{
+	{Name: "headers", Prefix: headerPrefix, Filter: keyLength(len(headerPrefix) + 8 + common.HashLength)},
+	{Name: "difficulties", Prefix: headerPrefix, Filter: func(key []byte) bool {
+		return len(key) == len(headerPrefix)+8+common.HashLength+len(headerTDSuffix) && bytes.HasSuffix(key, headerTDSuffix)
+	}},
+	{Name: "canonical", Prefix: headerPrefix, Filter: func(key []byte) bool {
+		return len(key) == len(headerPrefix)+8+len(headerHashSuffix) && bytes.HasSuffix(key, headerHashSuffix)
+	}},
+	{Name: "numbers", Prefix: headerNumberPrefix, Filter: keyLength(len(headerNumberPrefix) + common.HashLength)},
+	{Name: "bodies", Prefix: blockBodyPrefix, Filter: keyLength(len(blockBodyPrefix) + 8 + common.HashLength)},
+	{Name: "receipts", Prefix: blockReceiptsPrefix, Filter: keyLength(len(blockReceiptsPrefix) + 8 + common.HashLength)},
+	{Name: "statediffs", Prefix: reverseDiffPrefix, Filter: keyLength(len(reverseDiffPrefix) + 8 + common.HashLength)},
+	{Name: "txlookups", Prefix: txLookupPrefix, Filter: keyLength(len(txLookupPrefix) + common.HashLength)},
+	{Name: "bloombits", Prefix: bloomBitsPrefix, Filter: keyLength(len(bloomBitsPrefix) + 10 + common.HashLength)},
+	{Name: "state", Filter: keyLength(common.HashLength)},
+	{Name: "preimages", Prefix: preimagePrefix, Filter: keyLength(len(preimagePrefix) + common.HashLength)},
+	{Name: "config", Prefix: configPrefix, Filter: keyLength(len(configPrefix) + common.HashLength)},
+	{Name: "metadata", Filter: func(key []byte) bool {
+		for _, meta := range metadataKeys {
+			if bytes.Equal(key, meta) {
+				return true
+			}
+		}
+		return false
+	}},
+}
+
+// AllKeys is the key range covering the entire key-value store.
+var AllKeys = KeyRange{Name: "all"}
+
+// FindKeyRange retrieves a well known key range by name.
+func FindKeyRange(name string) (KeyRange, error) {
+	if name == AllKeys.Name {
+		return AllKeys, nil
+	}
+	for _, r := range KeyRanges {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return KeyRange{}, fmt.Errorf("unknown key range %q", name)
+}
+
+// CopyKeyRange copies all the entries of a key range from the source key-value
+// store into the destination, returning the number of entries and bytes copied.
+//
+// The last copied key is recorded in the destination with every flushed batch,
+// so an interrupted copy resumes where it left off. The marker is removed once
+// the range is fully copied.
+func CopyKeyRange(src ethdb.KeyValueStore, dst ethdb.KeyValueStore, r KeyRange, interrupt chan struct{}) (uint64, uint64, error) {
+	marker := append(append([]byte{}, migrationMarkerPrefix...), r.Name...)
+
+	start := r.Prefix
+	resume, _ := dst.Get(marker)
+	if len(resume) > 0 {
+		log.Info("Resuming key range copy", "range", r.Name, "marker", common.Bytes2Hex(resume))
+		start = resume
+	}
+	it := src.NewIteratorWithStart(start)
+	defer it.Release()
+
+	var (
+		batch   = dst.NewBatch()
+		begin   = time.Now()
+		logged  = begin
+		entries uint64
+		size    uint64
+		last    []byte
+		aborted bool
+	)
+	for it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, r.Prefix) {
+			break
+		}
+		if !r.contains(key) || bytes.Equal(key, resume) {
+			continue
+		}
+		if interrupted(interrupt) {
+			aborted = true
+			break
+		}
+		if err := batch.Put(key, it.Value()); err != nil {
+			return entries, size, err
+		}
+		entries, size = entries+1, size+uint64(len(key)+len(it.Value()))
+		last = append(last[:0], key...)
+
+		// If enough data was accumulated in memory, dump to disk along with the marker
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Put(marker, last); err != nil {
+				return entries, size, err
+			}
+			if err := batch.Write(); err != nil {
+				return entries, size, err
+			}
+			batch.Reset()
+		}
+		// If we've spent too much time already, notify the user of what we're doing
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Copying key range", "range", r.Name, "entries", entries, "size", common.StorageSize(size), "elapsed", common.PrettyDuration(time.Since(begin)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return entries, size, err
+	}
+	if aborted {
+		if last != nil {
+			if err := batch.Put(marker, last); err != nil {
+				return entries, size, err
+			}
+		}
+		if err := batch.Write(); err != nil {
+			return entries, size, err
+		}
+		return entries, size, errMigrationInterrupted
+	}
+	if err := batch.Delete(marker); err != nil {
+		return entries, size, err
+	}
+	if err := batch.Write(); err != nil {
+		return entries, size, err
+	}
+	log.Info("Copied key range", "range", r.Name, "entries", entries, "size", common.StorageSize(size), "elapsed", common.PrettyDuration(time.Since(begin)))
+	return entries, size, nil
+}
+
+// HashKeyRange iterates over all the entries of a key range, returning their
+// count and a digest over the ordered key-value pairs.
+func HashKeyRange(db ethdb.KeyValueStore, r KeyRange) (uint64, common.Hash, error) {
+	it := db.NewIteratorWithPrefix(r.Prefix)
+	defer it.Release()
+
+	var (
+		hasher  = sha3.NewLegacyKeccak256()
+		entries uint64
+	)
+	for it.Next() {
+		if !r.contains(it.Key()) {
+			continue
+		}
+		hasher.Write(crypto.Keccak256(it.Key()))
+		hasher.Write(crypto.Keccak256(it.Value()))
+		entries++
+	}
+	if err := it.Error(); err != nil {
+		return 0, common.Hash{}, err
+	}
+	return entries, common.BytesToHash(hasher.Sum(nil)), nil
+}
+
+// VerifyKeyRange checks that a key range has identical content in the source
+// and destination key-value stores, comparing the entry counts and digests.
+func VerifyKeyRange(src ethdb.KeyValueStore, dst ethdb.KeyValueStore, r KeyRange) error {
+	srcCount, srcHash, err := HashKeyRange(src, r)
+	if err != nil {
+		return err
+	}
+	dstCount, dstHash, err := HashKeyRange(dst, r)
+	if err != nil {
+		return err
+	}
+	if srcCount != dstCount {
+		return fmt.Errorf("key range %s: entry count mismatch: source %d, destination %d", r.Name, srcCount, dstCount)
+	}
+	if srcHash != dstHash {
+		return fmt.Errorf("key range %s: content hash mismatch: source %x, destination %x", r.Name, srcHash, dstHash)
+	}
+	log.Info("Verified key range", "range", r.Name, "entries", dstCount, "hash", dstHash)
+	return nil
+}
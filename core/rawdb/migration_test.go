@@ -0,0 +1,113 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package rawdb
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+)
+
+// Tests that key ranges can be copied between key-value stores selectively,
+// resuming from a marker and verifying the copied content.
+func TestCopyKeyRange(t *testing.T) {
+	src := memorydb.New()
+
+	blocks, receipts, tds := makeArchiveChain(20)
+	for i, block := range blocks {
+		WriteBlock(src, block)
+		WriteReceipts(src, block.Hash(), block.NumberU64(), receipts[i])
+		WriteTd(src, block.Hash(), block.NumberU64(), tds[i])
+		WriteCanonicalHash(src, block.Hash(), block.NumberU64())
+	}
+	for i := 0; i < 50; i++ {
+		blob := big.NewInt(int64(i)).Bytes()
+		src.Put(crypto.Keccak256(blob), blob)
+	}
+	WriteHeadBlockHash(src, blocks[len(blocks)-1].Hash())
+
+	// Copy only the headers and ensure nothing else is transferred
+	headers, _ := FindKeyRange("headers")
+
+	dst := memorydb.New()
+	entries, _, err := CopyKeyRange(src, dst, headers, nil)
+	if err != nil {
+		t.Fatalf("failed to copy headers: %v", err)
+	}
+	if entries != uint64(len(blocks)) || dst.Len() != len(blocks) {
+		t.Fatalf("copied entry count mismatch: have %d/%d, want %d", entries, dst.Len(), len(blocks))
+	}
+	if err := VerifyKeyRange(src, dst, headers); err != nil {
+		t.Fatalf("failed to verify headers: %v", err)
+	}
+	state, _ := FindKeyRange("state")
+	if err := VerifyKeyRange(src, dst, state); err == nil {
+		t.Fatalf("missing state range verified")
+	}
+	// Copy part of the state range manually and resume from the marker
+	dst = memorydb.New()
+
+	it := src.NewIterator()
+	var copied int
+	for it.Next() && copied < 20 {
+		if state.contains(it.Key()) {
+			dst.Put(it.Key(), it.Value())
+			dst.Put(append(append([]byte{}, migrationMarkerPrefix...), state.Name...), it.Key())
+			copied++
+		}
+	}
+	it.Release()
+
+	entries, _, err = CopyKeyRange(src, dst, state, nil)
+	if err != nil {
+		t.Fatalf("failed to resume state copy: %v", err)
+	}
+	if entries != 30 {
+		t.Fatalf("resumed entry count mismatch: have %d, want %d", entries, 30)
+	}
+	if err := VerifyKeyRange(src, dst, state); err != nil {
+		t.Fatalf("failed to verify state: %v", err)
+	}
+	if has, _ := dst.Has(append(append([]byte{}, migrationMarkerPrefix...), state.Name...)); has {
+		t.Fatalf("resume marker not removed")
+	}
+	// Interrupted copies must report failure
+	interrupt := make(chan struct{})
+	close(interrupt)
+
+	if _, _, err := CopyKeyRange(src, memorydb.New(), AllKeys, interrupt); err != errMigrationInterrupted {
+		t.Fatalf("interrupted copy error mismatch: have %v, want %v", err, errMigrationInterrupted)
+	}
+	// Copy the entire database and verify every range
+	dst = memorydb.New()
+	if _, _, err := CopyKeyRange(src, dst, AllKeys, nil); err != nil {
+		t.Fatalf("failed to copy database: %v", err)
+	}
+	for _, r := range append(KeyRanges, AllKeys) {
+		if err := VerifyKeyRange(src, dst, r); err != nil {
+			t.Errorf("failed to verify range %s: %v", r.Name, err)
+		}
+	}
+	if hash := ReadHeadBlockHash(dst); hash != blocks[len(blocks)-1].Hash() {
+		t.Errorf("head block mismatch: have %x, want %x", hash, blocks[len(blocks)-1].Hash())
+	}
+	if _, err := FindKeyRange("unknown"); err == nil {
+		t.Errorf("unknown key range found")
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// errNotFound is returned by the revert overlay if a key is unknown.
+var errNotFound = errors.New("not found")
+
+// ReverseDiff is the set of prior values of all the accounts and storage slots
+// modified by a block. Applying it on top of the post-state of the block yields
+// the state of its parent. All keys are the hashed trie keys, since that is all
+// a trie walk can provide without preimages.
+type ReverseDiff struct {
+	Accounts []ReverseDiffAccount
+}
+
+// ReverseDiffAccount is the prior value of a single modified account.
+type ReverseDiffAccount struct {
+	Hash    common.Hash       // Hash of the account address
+	Account []byte            // RLP encoded prior account, empty if it didn't exist
+	Code    []byte            // Prior contract code, if it was removed or replaced
+	Storage []ReverseDiffSlot // Prior values of all the modified storage slots
+}
+
+// ReverseDiffSlot is the prior value of a single modified storage slot.
+type ReverseDiffSlot struct {
+	Hash  common.Hash // Hash of the storage slot key
+	Value []byte      // RLP encoded prior value, empty if the slot was unset
+}
+
+// NewReverseDiff calculates the reverse diff between the states of a block and
+// its parent, by walking the differing parts of the two account tries and those
+// of the storage tries of every modified account. Both states must be available
+// in the database.
+func NewReverseDiff(db Database, parent common.Hash, root common.Hash) (*ReverseDiff, error) {
+	prevTrie, err := trie.New(parent, db.TrieDB())
+	if err != nil {
+		return nil, err
+	}
+	currTrie, err := trie.New(root, db.TrieDB())
+	if err != nil {
+		return nil, err
+	}
+	var (
+		diff     = new(ReverseDiff)
+		accounts = make(map[common.Hash][]byte)
+		order    []common.Hash
+	)
+	// Collect the prior values of all created or modified accounts
+	diffIt, _ := trie.NewDifferenceIterator(prevTrie.NodeIterator(nil), currTrie.NodeIterator(nil))
+	it := trie.NewIterator(diffIt)
+	for it.Next() {
+		hash := common.BytesToHash(it.Key)
+		prev, err := prevTrie.TryGet(it.Key)
+		if err != nil {
+			return nil, err
+		}
+		accounts[hash] = common.CopyBytes(prev)
+		order = append(order, hash)
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	// Collect the prior values of all deleted accounts
+	diffIt, _ = trie.NewDifferenceIterator(currTrie.NodeIterator(nil), prevTrie.NodeIterator(nil))
+	it = trie.NewIterator(diffIt)
+	for it.Next() {
+		hash := common.BytesToHash(it.Key)
+		if _, ok := accounts[hash]; ok {
+			continue
+		}
+		accounts[hash] = common.CopyBytes(it.Value)
+		order = append(order, hash)
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	// Assemble the account diffs, including the storage slots and code
+	for _, hash := range order {
+		entry := ReverseDiffAccount{Hash: hash, Account: accounts[hash]}
+
+		prevAcc, err := decodeDiffAccount(entry.Account)
+		if err != nil {
+			return nil, err
+		}
+		enc, err := currTrie.TryGet(hash[:])
+		if err != nil {
+			return nil, err
+		}
+		currAcc, err := decodeDiffAccount(enc)
+		if err != nil {
+			return nil, err
+		}
+		// Storage only needs tracking if the account existed before
+		if prevAcc != nil {
+			currRoot := emptyRoot
+			if currAcc != nil {
+				currRoot = currAcc.Root
+			}
+			if prevAcc.Root != currRoot {
+				if entry.Storage, err = diffStorage(db, prevAcc.Root, currRoot); err != nil {
+					return nil, err
+				}
+			}
+			// Retain the code if it's removed from the account
+			prevCode := common.BytesToHash(prevAcc.CodeHash)
+			if prevCode != emptyCode && (currAcc == nil || !bytes.Equal(currAcc.CodeHash, prevAcc.CodeHash)) {
+				if entry.Code, err = db.ContractCode(hash, prevCode); err != nil {
+					return nil, err
+				}
+			}
+		}
+		diff.Accounts = append(diff.Accounts, entry)
+	}
+	return diff, nil
+}
+
+// diffStorage collects the prior values of all the storage slots differing
+// between two storage tries.
+func diffStorage(db Database, parent common.Hash, root common.Hash) ([]ReverseDiffSlot, error) {
+	prevTrie, err := trie.New(parent, db.TrieDB())
+	if err != nil {
+		return nil, err
+	}
+	currTrie, err := trie.New(root, db.TrieDB())
+	if err != nil {
+		return nil, err
+	}
+	var (
+		slots []ReverseDiffSlot
+		seen  = make(map[common.Hash]struct{})
+	)
+	// Created or modified slots revert to their prior value (or deletion)
+	diffIt, _ := trie.NewDifferenceIterator(prevTrie.NodeIterator(nil), currTrie.NodeIterator(nil))
+	it := trie.NewIterator(diffIt)
+	for it.Next() {
+		prev, err := prevTrie.TryGet(it.Key)
+		if err != nil {
+			return nil, err
+		}
+		hash := common.BytesToHash(it.Key)
+		slots = append(slots, ReverseDiffSlot{Hash: hash, Value: common.CopyBytes(prev)})
+		seen[hash] = struct{}{}
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	// Deleted slots revert to their prior value
+	diffIt, _ = trie.NewDifferenceIterator(currTrie.NodeIterator(nil), prevTrie.NodeIterator(nil))
+	it = trie.NewIterator(diffIt)
+	for it.Next() {
+		hash := common.BytesToHash(it.Key)
+		if _, ok := seen[hash]; ok {
+			continue
+		}
+		slots = append(slots, ReverseDiffSlot{Hash: hash, Value: common.CopyBytes(it.Value)})
+	}
+	if it.Err != nil {
+		return nil, it.Err
+	}
+	return slots, nil
+}
+
+// decodeDiffAccount decodes an RLP encoded account, returning nil for empty
+// blobs representing a non-existent account.
+func decodeDiffAccount(blob []byte) (*Account, error) {
+	if len(blob) == 0 {
+		return nil, nil
+	}
+	acc := new(Account)
+	if err := rlp.DecodeBytes(blob, acc); err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// RevertState reconstructs a historical state by applying a sequence of reverse
+// diffs backwards, starting from the retained state root. The i-th diff must
+// produce the state root roots[i], which is verified after every step.
+//
+// The reconstructed tries are kept in an ephemeral memory database layered on
+// top of the given one, so the live database is never modified and all the
+// intermediate state is garbage collected with the returned StateDB.
+func RevertState(db Database, root common.Hash, diffs []*ReverseDiff, roots []common.Hash) (*StateDB, error) {
+	if len(diffs) != len(roots) {
+		return nil, fmt.Errorf("reverse diff count mismatch: have %d diffs, %d roots", len(diffs), len(roots))
+	}
+	csc, _ := lru.New(codeSizeCacheSize)
+	overlay := &cachingDB{
+		db:            trie.NewDatabase(&revertOverlay{Database: memorydb.New(), base: db.TrieDB()}),
+		codeSizeCache: csc,
+	}
+	for i, diff := range diffs {
+		reverted, err := applyReverseDiff(overlay.db, root, diff)
+		if err != nil {
+			return nil, err
+		}
+		if reverted != roots[i] {
+			return nil, fmt.Errorf("reverted state root mismatch: have %x, want %x", reverted, roots[i])
+		}
+		root = reverted
+	}
+	return New(root, overlay)
+}
+
+// applyReverseDiff applies a single reverse diff on top of the given state root,
+// returning the root of the reverted state.
+func applyReverseDiff(db *trie.Database, root common.Hash, diff *ReverseDiff) (common.Hash, error) {
+	accTrie, err := trie.New(root, db)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	for _, entry := range diff.Accounts {
+		prevAcc, err := decodeDiffAccount(entry.Account)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if prevAcc == nil {
+			if err := accTrie.TryDelete(entry.Hash[:]); err != nil {
+				return common.Hash{}, err
+			}
+			continue
+		}
+		// Revert the storage slots on top of the current storage trie
+		if len(entry.Storage) > 0 {
+			enc, err := accTrie.TryGet(entry.Hash[:])
+			if err != nil {
+				return common.Hash{}, err
+			}
+			currAcc, err := decodeDiffAccount(enc)
+			if err != nil {
+				return common.Hash{}, err
+			}
+			currRoot := emptyRoot
+			if currAcc != nil {
+				currRoot = currAcc.Root
+			}
+			stTrie, err := trie.New(currRoot, db)
+			if err != nil {
+				return common.Hash{}, err
+			}
+			for _, slot := range entry.Storage {
+				if err := stTrie.TryUpdate(slot.Hash[:], slot.Value); err != nil {
+					return common.Hash{}, err
+				}
+			}
+			stRoot, err := stTrie.Commit(nil)
+			if err != nil {
+				return common.Hash{}, err
+			}
+			if stRoot != prevAcc.Root {
+				return common.Hash{}, fmt.Errorf("reverted storage root mismatch for %x: have %x, want %x", entry.Hash, stRoot, prevAcc.Root)
+			}
+		}
+		if len(entry.Code) > 0 {
+			db.InsertBlob(crypto.Keccak256Hash(entry.Code), entry.Code)
+		}
+		if err := accTrie.TryUpdate(entry.Hash[:], entry.Account); err != nil {
+			return common.Hash{}, err
+		}
+	}
+	return accTrie.Commit(nil)
+}
+
+// revertOverlay is an in-memory key-value store used for reverting states. Any
+// writes are kept in memory, whereas reads fall through to the trie database of
+// the live state, giving access to both flushed and in-memory trie nodes.
+type revertOverlay struct {
+	*memorydb.Database
+	base *trie.Database
+}
+
+// Has retrieves if a key is present in the overlay or the underlying database.
+func (o *revertOverlay) Has(key []byte) (bool, error) {
+	if has, err := o.Database.Has(key); err == nil && has {
+		return true, nil
+	}
+	blob, _ := o.Get(key)
+	return len(blob) > 0, nil
+}
+
+// Get retrieves the given key from the overlay if it's present there, otherwise
+// from the underlying trie database.
+func (o *revertOverlay) Get(key []byte) ([]byte, error) {
+	if blob, err := o.Database.Get(key); err == nil {
+		return blob, nil
+	}
+	if len(key) != common.HashLength {
+		return nil, errNotFound
+	}
+	return o.base.Node(common.BytesToHash(key))
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Tests that reverse diffs calculated between two states can be used to revert
+// the newer one into the older, covering account creation, modification and
+// deletion, storage changes and contract destruction.
+func TestReverseDiff(t *testing.T) {
+	db := NewDatabase(rawdb.NewMemoryDatabase())
+
+	var (
+		plain    = common.Address{0x01}
+		contract = common.Address{0x02}
+		doomed   = common.Address{0x03}
+		fresh    = common.Address{0x04}
+		emptied  = common.Address{0x05}
+	)
+	// Create the initial state and flush it to disk
+	state, _ := New(common.Hash{}, db)
+	state.SetBalance(plain, big.NewInt(100))
+	state.SetNonce(plain, 1)
+
+	state.SetBalance(contract, big.NewInt(1))
+	state.SetCode(contract, []byte{0x60, 0x00})
+	state.SetState(contract, common.Hash{0x01}, common.Hash{0x11})
+	state.SetState(contract, common.Hash{0x02}, common.Hash{0x22})
+
+	state.SetCode(doomed, []byte{0x60, 0x01})
+	state.SetState(doomed, common.Hash{0x01}, common.Hash{0x33})
+	state.SetState(doomed, common.Hash{0x02}, common.Hash{0x44})
+
+	state.SetBalance(emptied, big.NewInt(5))
+
+	parent, _ := state.Commit(false)
+	if err := db.TrieDB().Commit(parent, false); err != nil {
+		t.Fatalf("failed to commit parent state: %v", err)
+	}
+	// Mutate the state in every possible way, keeping the result in memory only
+	state, _ = New(parent, db)
+	state.SetBalance(plain, big.NewInt(50))
+	state.SetNonce(plain, 2)
+
+	state.SetState(contract, common.Hash{0x01}, common.Hash{0x12}) // modify
+	state.SetState(contract, common.Hash{0x02}, common.Hash{})     // delete
+	state.SetState(contract, common.Hash{0x03}, common.Hash{0x34}) // create
+
+	state.Suicide(doomed)
+
+	state.SetBalance(fresh, big.NewInt(7))
+	state.SetState(fresh, common.Hash{0x01}, common.Hash{0x01})
+
+	state.SubBalance(emptied, big.NewInt(5))
+
+	root, _ := state.Commit(true)
+
+	// Calculate the reverse diff and ensure it survives an encoding roundtrip
+	diff, err := NewReverseDiff(db, parent, root)
+	if err != nil {
+		t.Fatalf("failed to calculate reverse diff: %v", err)
+	}
+	if len(diff.Accounts) != 5 {
+		t.Fatalf("modified account count mismatch: have %d, want %d", len(diff.Accounts), 5)
+	}
+	blob, err := rlp.EncodeToBytes(diff)
+	if err != nil {
+		t.Fatalf("failed to encode reverse diff: %v", err)
+	}
+	diff = new(ReverseDiff)
+	if err := rlp.DecodeBytes(blob, diff); err != nil {
+		t.Fatalf("failed to decode reverse diff: %v", err)
+	}
+	// Revert the state and check that all the prior values are restored
+	reverted, err := RevertState(db, root, []*ReverseDiff{diff}, []common.Hash{parent})
+	if err != nil {
+		t.Fatalf("failed to revert state: %v", err)
+	}
+	if have := reverted.IntermediateRoot(false); have != parent {
+		t.Fatalf("reverted root mismatch: have %x, want %x", have, parent)
+	}
+	if balance := reverted.GetBalance(plain); balance.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("plain balance mismatch: have %v, want %v", balance, 100)
+	}
+	if nonce := reverted.GetNonce(plain); nonce != 1 {
+		t.Errorf("plain nonce mismatch: have %d, want %d", nonce, 1)
+	}
+	for key, want := range map[common.Hash]common.Hash{{0x01}: {0x11}, {0x02}: {0x22}, {0x03}: {}} {
+		if have := reverted.GetState(contract, key); have != want {
+			t.Errorf("contract slot %x mismatch: have %x, want %x", key, have, want)
+		}
+	}
+	if !reverted.Exist(doomed) {
+		t.Fatalf("destructed contract not restored")
+	}
+	if code := reverted.GetCode(doomed); !bytes.Equal(code, []byte{0x60, 0x01}) {
+		t.Errorf("destructed contract code mismatch: have %x, want %x", code, []byte{0x60, 0x01})
+	}
+	if have := reverted.GetState(doomed, common.Hash{0x02}); have != (common.Hash{0x44}) {
+		t.Errorf("destructed contract slot mismatch: have %x, want %x", have, common.Hash{0x44})
+	}
+	if reverted.Exist(fresh) {
+		t.Errorf("created account not removed")
+	}
+	if balance := reverted.GetBalance(emptied); balance.Cmp(big.NewInt(5)) != 0 {
+		t.Errorf("emptied account balance mismatch: have %v, want %v", balance, 5)
+	}
+	// The live state must be untouched by the reversion
+	live, _ := New(root, db)
+	if live.Exist(doomed) || !live.Exist(fresh) {
+		t.Errorf("live state modified by reversion")
+	}
+	// Reverting onto the wrong root must be detected
+	if _, err := RevertState(db, root, []*ReverseDiff{diff}, []common.Hash{root}); err == nil {
+		t.Errorf("mismatching reverted root accepted")
+	}
+}
@@ -95,6 +95,13 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	// Create a new environment which holds all relevant information
 	// about the transaction and calling mechanisms.
 	vmenv := vm.NewEVM(context, statedb, config, cfg)
+
+	return applyTransaction(msg, config, gp, statedb, header, tx, usedGas, vmenv)
+}
+
+// applyTransaction applies a transaction message on the given EVM, whose state
+// database must be backed by statedb, and creates the receipt for it.
+func applyTransaction(msg Message, config *params.ChainConfig, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, vmenv *vm.EVM) (*types.Receipt, error) {
 	// Apply the transaction to the current state (included in the env)
 	_, gas, failed, err := ApplyMessage(vmenv, msg, gp)
 	if err != nil {
@@ -109,14 +116,20 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	}
 	*usedGas += gas
 
+	return newReceipt(msg, tx, root, failed, gas, *usedGas, statedb, header), nil
+}
+
+// newReceipt creates the receipt of a transaction applied on the state, storing
+// the intermediate root and gas used by the transaction.
+func newReceipt(msg Message, tx *types.Transaction, root []byte, failed bool, gas, usedGas uint64, statedb *state.StateDB, header *types.Header) *types.Receipt {
 	// Create a new receipt for the transaction, storing the intermediate root and gas used by the tx
 	// based on the eip phase, we're passing whether the root touch-delete accounts.
-	receipt := types.NewReceipt(root, failed, *usedGas)
+	receipt := types.NewReceipt(root, failed, usedGas)
 	receipt.TxHash = tx.Hash()
 	receipt.GasUsed = gas
 	// if the transaction created a contract, store the creation address in the receipt.
 	if msg.To() == nil {
-		receipt.ContractAddress = crypto.CreateAddress(vmenv.Context.Origin, tx.Nonce())
+		receipt.ContractAddress = crypto.CreateAddress(msg.From(), tx.Nonce())
 	}
 	// Set the receipt logs and create a bloom for filtering
 	receipt.Logs = statedb.GetLogs(tx.Hash())
@@ -125,5 +138,5 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	receipt.BlockNumber = header.Number
 	receipt.TransactionIndex = uint(statedb.TxIndex())
 
-	return receipt, err
+	return receipt
 }
@@ -0,0 +1,475 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	parallelSpeculatedMeter = metrics.NewRegisteredMeter("chain/parallel/speculated", nil)
+	parallelReexecutedMeter = metrics.NewRegisteredMeter("chain/parallel/reexecuted", nil)
+)
+
+// accessKey identifies an account, or a storage slot of one, accessed by a
+// transaction.
+type accessKey struct {
+	addr    common.Address
+	slot    common.Hash
+	storage bool
+}
+
+// accessRecorder is a vm.StateDB recording the accounts and storage slots read
+// and written by a transaction executed on top of a StateDB.
+//
+// Balance added to the coinbase without otherwise accessing it (i.e. the fees)
+// is tracked separately, as it commutes across transactions and would make all
+// of them conflict otherwise.
+type accessRecorder struct {
+	db       *state.StateDB
+	coinbase common.Address
+
+	accessed map[accessKey]struct{} // Keys read or written, as any write is also a read
+	written  map[accessKey]struct{} // Keys written
+	fees     *big.Int               // Balance blindly added to the coinbase, nil if none
+	unsafe   bool                   // Whether an access can't be replayed (account recreation, storage iteration)
+
+	preimages map[common.Hash][]byte
+}
+
+// newAccessRecorder creates a recorder for the accesses of a transaction to the
+// given state database.
+func newAccessRecorder(db *state.StateDB, coinbase common.Address) *accessRecorder {
+	return &accessRecorder{
+		db:        db,
+		coinbase:  coinbase,
+		accessed:  make(map[accessKey]struct{}),
+		written:   make(map[accessKey]struct{}),
+		preimages: make(map[common.Hash][]byte),
+	}
+}
+
+func (r *accessRecorder) read(addr common.Address) {
+	r.accessed[accessKey{addr: addr}] = struct{}{}
+}
+
+func (r *accessRecorder) write(addr common.Address) {
+	r.accessed[accessKey{addr: addr}] = struct{}{}
+	r.written[accessKey{addr: addr}] = struct{}{}
+}
+
+func (r *accessRecorder) readSlot(addr common.Address, slot common.Hash) {
+	r.accessed[accessKey{addr: addr, slot: slot, storage: true}] = struct{}{}
+}
+
+func (r *accessRecorder) writeSlot(addr common.Address, slot common.Hash) {
+	r.accessed[accessKey{addr: addr, slot: slot, storage: true}] = struct{}{}
+	r.written[accessKey{addr: addr, slot: slot, storage: true}] = struct{}{}
+}
+
+func (r *accessRecorder) CreateAccount(addr common.Address) {
+	if r.db.Exist(addr) {
+		r.unsafe = true
+	}
+	r.write(addr)
+	r.db.CreateAccount(addr)
+}
+
+func (r *accessRecorder) SubBalance(addr common.Address, amount *big.Int) {
+	r.write(addr)
+	r.db.SubBalance(addr, amount)
+}
+
+func (r *accessRecorder) AddBalance(addr common.Address, amount *big.Int) {
+	if addr == r.coinbase {
+		if r.fees == nil {
+			r.fees = new(big.Int)
+		}
+		r.fees.Add(r.fees, amount)
+	} else {
+		r.write(addr)
+	}
+	r.db.AddBalance(addr, amount)
+}
+
+func (r *accessRecorder) GetBalance(addr common.Address) *big.Int {
+	r.read(addr)
+	return r.db.GetBalance(addr)
+}
+
+func (r *accessRecorder) GetNonce(addr common.Address) uint64 {
+	r.read(addr)
+	return r.db.GetNonce(addr)
+}
+
+func (r *accessRecorder) SetNonce(addr common.Address, nonce uint64) {
+	r.write(addr)
+	r.db.SetNonce(addr, nonce)
+}
+
+func (r *accessRecorder) GetCodeHash(addr common.Address) common.Hash {
+	r.read(addr)
+	return r.db.GetCodeHash(addr)
+}
+
+func (r *accessRecorder) GetCode(addr common.Address) []byte {
+	r.read(addr)
+	return r.db.GetCode(addr)
+}
+
+func (r *accessRecorder) SetCode(addr common.Address, code []byte) {
+	r.write(addr)
+	r.db.SetCode(addr, code)
+}
+
+func (r *accessRecorder) GetCodeSize(addr common.Address) int {
+	r.read(addr)
+	return r.db.GetCodeSize(addr)
+}
+
+func (r *accessRecorder) AddRefund(gas uint64) { r.db.AddRefund(gas) }
+func (r *accessRecorder) SubRefund(gas uint64) { r.db.SubRefund(gas) }
+func (r *accessRecorder) GetRefund() uint64    { return r.db.GetRefund() }
+
+func (r *accessRecorder) GetCommittedState(addr common.Address, slot common.Hash) common.Hash {
+	r.readSlot(addr, slot)
+	return r.db.GetCommittedState(addr, slot)
+}
+
+func (r *accessRecorder) GetState(addr common.Address, slot common.Hash) common.Hash {
+	r.readSlot(addr, slot)
+	return r.db.GetState(addr, slot)
+}
+
+func (r *accessRecorder) SetState(addr common.Address, slot common.Hash, value common.Hash) {
+	r.writeSlot(addr, slot)
+	r.db.SetState(addr, slot, value)
+}
+
+func (r *accessRecorder) Suicide(addr common.Address) bool {
+	r.write(addr)
+	return r.db.Suicide(addr)
+}
+
+func (r *accessRecorder) HasSuicided(addr common.Address) bool {
+	r.read(addr)
+	return r.db.HasSuicided(addr)
+}
+
+func (r *accessRecorder) Exist(addr common.Address) bool {
+	r.read(addr)
+	return r.db.Exist(addr)
+}
+
+func (r *accessRecorder) Empty(addr common.Address) bool {
+	r.read(addr)
+	return r.db.Empty(addr)
+}
+
+func (r *accessRecorder) RevertToSnapshot(id int) { r.db.RevertToSnapshot(id) }
+func (r *accessRecorder) Snapshot() int           { return r.db.Snapshot() }
+func (r *accessRecorder) AddLog(log *types.Log)   { r.db.AddLog(log) }
+
+func (r *accessRecorder) AddPreimage(hash common.Hash, preimage []byte) {
+	r.preimages[hash] = preimage
+	r.db.AddPreimage(hash, preimage)
+}
+
+func (r *accessRecorder) ForEachStorage(addr common.Address, cb func(common.Hash, common.Hash) bool) error {
+	r.unsafe = true
+	r.read(addr)
+	return r.db.ForEachStorage(addr, cb)
+}
+
+// conflicts returns whether the transaction accessed any of the given keys.
+func (r *accessRecorder) conflicts(dirty map[accessKey]struct{}) bool {
+	if len(r.accessed) < len(dirty) {
+		for key := range r.accessed {
+			if _, ok := dirty[key]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	for key := range dirty {
+		if _, ok := r.accessed[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// markDirty adds the keys written by the transaction to the given set.
+func (r *accessRecorder) markDirty(dirty map[accessKey]struct{}) {
+	for key := range r.written {
+		dirty[key] = struct{}{}
+	}
+	if r.fees != nil {
+		dirty[accessKey{addr: r.coinbase}] = struct{}{}
+	}
+}
+
+// speculation is the result of executing a transaction on top of the state at
+// the beginning of the block.
+type speculation struct {
+	statedb  *state.StateDB // Post state of the speculative execution
+	recorder *accessRecorder
+	msg      Message
+	gas      uint64
+	failed   bool
+	err      error // Error applying the transaction, to be retried sequentially
+}
+
+// ParallelStateProcessor is a Processor which executes the transactions of a
+// block speculatively in parallel on copies of the state, then commits them in
+// order. Transactions which accessed state modified by a preceding one in the
+// block are re-executed on the committed state, making the outcome identical to
+// sequential execution.
+//
+// ParallelStateProcessor implements Processor.
+type ParallelStateProcessor struct {
+	*StateProcessor
+	threads int // Number of transactions to execute concurrently
+}
+
+// NewParallelStateProcessor initialises a new ParallelStateProcessor, executing
+// transactions on as many threads as there are CPUs.
+func NewParallelStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consensus.Engine) *ParallelStateProcessor {
+	return &ParallelStateProcessor{
+		StateProcessor: NewStateProcessor(config, bc, engine),
+		threads:        runtime.NumCPU(),
+	}
+}
+
+// Process processes the state changes according to the Ethereum rules, running
+// the transaction messages speculatively in parallel and applying any rewards
+// to both the processor (coinbase) and any included uncles.
+//
+// Blocks before Byzantium, blocks with less than two transactions and traced
+// executions are processed sequentially.
+func (p *ParallelStateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg vm.Config) (types.Receipts, []*types.Log, uint64, error) {
+	txs := block.Transactions()
+	if len(txs) < 2 || p.threads < 2 || cfg.Debug || !p.config.IsByzantium(block.Number()) || !p.config.IsEIP158(block.Number()) {
+		return p.StateProcessor.Process(block, statedb, cfg)
+	}
+	var (
+		receipts = make(types.Receipts, 0, len(txs))
+		usedGas  = new(uint64)
+		header   = block.Header()
+		allLogs  []*types.Log
+		gp       = new(GasPool).AddGas(block.GasLimit())
+		signer   = types.MakeSigner(p.config, header.Number)
+	)
+	// Mutate the block and state according to any hard-fork specs
+	if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	// Speculatively execute all the transactions on the initial state of the block
+	var (
+		base      = statedb.Copy()
+		baseLock  sync.Mutex
+		next      = int32(-1)
+		specs     = make([]*speculation, len(txs))
+		done      = make([]chan struct{}, len(txs))
+		interrupt = uint32(0)
+	)
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	defer atomic.StoreUint32(&interrupt, 1)
+
+	threads := p.threads
+	if threads > len(txs) {
+		threads = len(txs)
+	}
+	for t := 0; t < threads; t++ {
+		go func() {
+			for {
+				i := int(atomic.AddInt32(&next, 1))
+				if i >= len(txs) {
+					return
+				}
+				// Skip any remaining transactions if processing failed
+				if atomic.LoadUint32(&interrupt) == 0 {
+					baseLock.Lock()
+					speculative := base.Copy()
+					baseLock.Unlock()
+
+					specs[i] = p.speculate(block, speculative, txs[i], i, signer, cfg)
+				}
+				close(done[i])
+			}
+		}()
+	}
+	// Commit the transactions in order, re-executing any which conflict with a
+	// preceding one
+	dirty := make(map[accessKey]struct{})
+	for i, tx := range txs {
+		<-done[i]
+		spec := specs[i]
+
+		var (
+			receipt *types.Receipt
+			err     error
+		)
+		if spec.err == nil && !spec.recorder.unsafe && gp.Gas() >= spec.msg.Gas() && !spec.recorder.conflicts(dirty) {
+			parallelSpeculatedMeter.Mark(1)
+			receipt, err = p.commit(block, statedb, tx, i, spec, gp, usedGas)
+			spec.recorder.markDirty(dirty)
+		} else {
+			parallelReexecutedMeter.Mark(1)
+			receipt, err = p.reexecute(block, statedb, tx, i, gp, usedGas, cfg, dirty)
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		receipts = append(receipts, receipt)
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
+	p.engine.Finalize(p.bc, header, statedb, block.Transactions(), block.Uncles())
+
+	return receipts, allLogs, *usedGas, nil
+}
+
+// speculate executes a transaction on a private copy of the initial state of
+// the block, recording the state it accessed.
+func (p *ParallelStateProcessor) speculate(block *types.Block, statedb *state.StateDB, tx *types.Transaction, index int, signer types.Signer, cfg vm.Config) *speculation {
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		return &speculation{err: err}
+	}
+	var (
+		context  = NewEVMContext(msg, block.Header(), p.bc, nil)
+		recorder = newAccessRecorder(statedb, context.Coinbase)
+		vmenv    = vm.NewEVM(context, recorder, p.config, cfg)
+	)
+	statedb.Prepare(tx.Hash(), block.Hash(), index)
+
+	_, gas, failed, err := ApplyMessage(vmenv, msg, new(GasPool).AddGas(block.GasLimit()))
+	if err != nil {
+		return &speculation{err: err}
+	}
+	statedb.Finalise(true)
+
+	return &speculation{
+		statedb:  statedb,
+		recorder: recorder,
+		msg:      msg,
+		gas:      gas,
+		failed:   failed,
+	}
+}
+
+// commit applies the outcome of a speculative transaction execution, which is
+// known not to depend on any state modified by preceding transactions, to the
+// block state.
+func (p *ParallelStateProcessor) commit(block *types.Block, statedb *state.StateDB, tx *types.Transaction, index int, spec *speculation, gp *GasPool, usedGas *uint64) (*types.Receipt, error) {
+	if err := gp.SubGas(spec.gas); err != nil {
+		return nil, err
+	}
+	statedb.Prepare(tx.Hash(), block.Hash(), index)
+
+	// Copy the modified accounts over. Only the fields that actually changed are
+	// set, as setting any marks the account touched, removing it if empty.
+	var (
+		recorder = spec.recorder
+		post     = spec.statedb
+	)
+	// The fees are added on top of the preceding ones, unless the transaction
+	// also accessed the coinbase, in which case its post state is copied too.
+	if recorder.fees != nil {
+		coinbase := accessKey{addr: recorder.coinbase}
+		if _, ok := recorder.accessed[coinbase]; ok {
+			recorder.written[coinbase] = struct{}{}
+		} else {
+			statedb.AddBalance(recorder.coinbase, recorder.fees)
+		}
+	}
+	for key := range recorder.written {
+		if key.storage {
+			continue
+		}
+		addr := key.addr
+		if !post.Exist(addr) {
+			// Destructed or touched while empty, drop it
+			statedb.Suicide(addr)
+			continue
+		}
+		if !statedb.Exist(addr) {
+			statedb.CreateAccount(addr)
+		}
+		if balance := post.GetBalance(addr); statedb.GetBalance(addr).Cmp(balance) != 0 {
+			statedb.SetBalance(addr, balance)
+		}
+		if nonce := post.GetNonce(addr); statedb.GetNonce(addr) != nonce {
+			statedb.SetNonce(addr, nonce)
+		}
+		if post.GetCodeHash(addr) != statedb.GetCodeHash(addr) {
+			statedb.SetCode(addr, post.GetCode(addr))
+		}
+	}
+	for key := range recorder.written {
+		if key.storage && post.Exist(key.addr) {
+			statedb.SetState(key.addr, key.slot, post.GetState(key.addr, key.slot))
+		}
+	}
+	for _, log := range post.GetLogs(tx.Hash()) {
+		statedb.AddLog(log)
+	}
+	for hash, preimage := range recorder.preimages {
+		statedb.AddPreimage(hash, preimage)
+	}
+	statedb.Finalise(true)
+	*usedGas += spec.gas
+
+	return newReceipt(spec.msg, tx, nil, spec.failed, spec.gas, *usedGas, statedb, block.Header()), nil
+}
+
+// reexecute applies a transaction on the block state, recording the state it
+// modifies.
+func (p *ParallelStateProcessor) reexecute(block *types.Block, statedb *state.StateDB, tx *types.Transaction, index int, gp *GasPool, usedGas *uint64, cfg vm.Config, dirty map[accessKey]struct{}) (*types.Receipt, error) {
+	msg, err := tx.AsMessage(types.MakeSigner(p.config, block.Number()))
+	if err != nil {
+		return nil, err
+	}
+	var (
+		context  = NewEVMContext(msg, block.Header(), p.bc, nil)
+		recorder = newAccessRecorder(statedb, context.Coinbase)
+		vmenv    = vm.NewEVM(context, recorder, p.config, cfg)
+	)
+	statedb.Prepare(tx.Hash(), block.Hash(), index)
+
+	receipt, err := applyTransaction(msg, p.config, gp, statedb, block.Header(), tx, usedGas, vmenv)
+	if err != nil {
+		return nil, err
+	}
+	recorder.markDirty(dirty)
+	return receipt, nil
+}
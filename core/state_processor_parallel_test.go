@@ -0,0 +1,332 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	// parallelCounter increments a single storage slot, conflicting with all
+	// other calls to it.
+	parallelCounter     = common.HexToAddress("0x1000")
+	parallelCounterCode = []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.PUSH1), 0x01, byte(vm.ADD), byte(vm.PUSH1), 0x00, byte(vm.SSTORE), byte(vm.STOP),
+	}
+	// parallelToken increments a storage slot keyed by the caller and emits a log,
+	// similar to a token transfer between distinct accounts.
+	parallelToken     = common.HexToAddress("0x2000")
+	parallelTokenCode = []byte{
+		byte(vm.CALLER), byte(vm.SLOAD), byte(vm.PUSH1), 0x01, byte(vm.ADD), byte(vm.CALLER), byte(vm.SSTORE),
+		byte(vm.CALLER), byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.LOG1), byte(vm.STOP),
+	}
+	// parallelCoinbase stores the balance of the coinbase, which is modified by
+	// every transaction.
+	parallelCoinbase     = common.HexToAddress("0x3000")
+	parallelCoinbaseCode = []byte{
+		byte(vm.COINBASE), byte(vm.BALANCE), byte(vm.PUSH1), 0x00, byte(vm.SSTORE), byte(vm.STOP),
+	}
+	// parallelSuicide destructs itself, sending its balance to the caller.
+	parallelSuicide     = common.HexToAddress("0x4000")
+	parallelSuicideCode = []byte{byte(vm.CALLER), byte(vm.SELFDESTRUCT)}
+
+	// parallelEmpty is an empty account, deleted as soon as it's touched.
+	parallelEmpty = common.HexToAddress("0x5000")
+
+	// parallelInit is the init code of a created contract, storing a slot.
+	parallelInit = []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE), byte(vm.STOP)}
+
+	// parallelHasher replaces each of the 16 storage slots following the one
+	// given in the calldata with the hash of its previous value, conflicting with
+	// all other calls touching the same slots.
+	parallelHasher     = common.HexToAddress("0x6000")
+	parallelHasherCode = []byte{
+		byte(vm.PUSH1), 0x10,
+		byte(vm.JUMPDEST),
+		byte(vm.DUP1), byte(vm.PUSH1), 0x00, byte(vm.CALLDATALOAD), byte(vm.ADD),
+		byte(vm.DUP1), byte(vm.SLOAD), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.SHA3), byte(vm.SWAP1), byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x01, byte(vm.SWAP1), byte(vm.SUB),
+		byte(vm.DUP1), byte(vm.PUSH1), 0x02, byte(vm.JUMPI),
+		byte(vm.STOP),
+	}
+)
+
+// newParallelTestChain creates the genesis of a chain with the given number of
+// funded accounts and the test contracts deployed.
+func newParallelTestChain(db ethdb.Database, accounts int) (*Genesis, []*ecdsa.PrivateKey) {
+	keys := make([]*ecdsa.PrivateKey, accounts)
+	alloc := GenesisAlloc{
+		parallelCounter:  {Code: parallelCounterCode, Balance: new(big.Int)},
+		parallelToken:    {Code: parallelTokenCode, Balance: new(big.Int)},
+		parallelCoinbase: {Code: parallelCoinbaseCode, Balance: new(big.Int)},
+		parallelSuicide:  {Code: parallelSuicideCode, Balance: big.NewInt(1000)},
+		parallelEmpty:    {Balance: new(big.Int)},
+		parallelHasher:   {Code: parallelHasherCode, Balance: new(big.Int)},
+	}
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		alloc[crypto.PubkeyToAddress(keys[i].PublicKey)] = GenesisAccount{Balance: big.NewInt(params.Ether)}
+	}
+	gspec := &Genesis{Config: params.TestChainConfig, Alloc: alloc, GasLimit: 30000000}
+	gspec.MustCommit(db)
+	return gspec, keys
+}
+
+// genParallelTxs returns a block generator creating a mix of independent and
+// conflicting transactions from the given accounts: contract calls, value
+// transfers, contract creations, self destructs and touches of empty accounts.
+func genParallelTxs(keys []*ecdsa.PrivateKey, txs int) func(int, *BlockGen) {
+	return func(i int, gen *BlockGen) {
+		gen.SetCoinbase(common.Address{0xc0})
+		for j := 0; j < txs; j++ {
+			var (
+				key  = keys[(i*txs+j)%len(keys)]
+				from = crypto.PubkeyToAddress(key.PublicKey)
+				tx   *types.Transaction
+			)
+			switch {
+			case j%10 == 0:
+				tx = types.NewTransaction(gen.TxNonce(from), parallelCounter, new(big.Int), 100000, big.NewInt(1), nil)
+			case j%10 == 1 && j < 20:
+				tx = types.NewTransaction(gen.TxNonce(from), parallelCoinbase, new(big.Int), 100000, big.NewInt(1), nil)
+			case j%10 == 2:
+				tx = types.NewContractCreation(gen.TxNonce(from), big.NewInt(1), 100000, big.NewInt(1), parallelInit)
+			case j%10 == 3 && i == 1 && j < 10:
+				tx = types.NewTransaction(gen.TxNonce(from), parallelSuicide, new(big.Int), 100000, big.NewInt(1), nil)
+			case j%10 == 4 && i == 2 && j < 10:
+				tx = types.NewTransaction(gen.TxNonce(from), parallelEmpty, new(big.Int), params.TxGas, big.NewInt(1), nil)
+			case j%5 == 0:
+				tx = types.NewTransaction(gen.TxNonce(from), common.BigToAddress(big.NewInt(int64(i*txs+j+0x10000))), big.NewInt(1), params.TxGas, big.NewInt(1), nil)
+			default:
+				tx = types.NewTransaction(gen.TxNonce(from), parallelToken, new(big.Int), 100000, big.NewInt(1), nil)
+			}
+			tx, _ = types.SignTx(tx, types.HomesteadSigner{}, key)
+			gen.AddTx(tx)
+		}
+	}
+}
+
+// genParallelHasherTxs returns a block generator creating contract calls from
+// the given accounts, each rewriting one of the given number of shared storage
+// slot ranges. The fewer the ranges, the more the transactions conflict.
+func genParallelHasherTxs(keys []*ecdsa.PrivateKey, txs int, ranges int) func(int, *BlockGen) {
+	return func(i int, gen *BlockGen) {
+		gen.SetCoinbase(common.Address{0xc0})
+		for j := 0; j < txs; j++ {
+			var (
+				key  = keys[(i*txs+j)%len(keys)]
+				from = crypto.PubkeyToAddress(key.PublicKey)
+				slot = common.BigToHash(big.NewInt(int64(j%ranges) * 16))
+			)
+			tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(from), parallelHasher, new(big.Int), 400000, big.NewInt(1), slot.Bytes()), types.HomesteadSigner{}, key)
+			gen.AddTx(tx)
+		}
+	}
+}
+
+// Tests that processing blocks in parallel produces exactly the same state,
+// receipts and logs as processing them sequentially.
+func TestParallelStateProcessor(t *testing.T) {
+	var (
+		db          = rawdb.NewMemoryDatabase()
+		gspec, keys = newParallelTestChain(db, 16)
+		genesis     = gspec.ToBlock(nil)
+	)
+	// Generate blocks where most senders are repeated and many contracts shared,
+	// finishing with one where storage heavy calls rewrite the same slots
+	var (
+		mixed     = genParallelTxs(keys, 40)
+		conflicts = genParallelHasherTxs(keys, 16, 2)
+	)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 5, func(i int, gen *BlockGen) {
+		if i == 4 {
+			conflicts(i, gen)
+			return
+		}
+		mixed(i, gen)
+	})
+
+	// Import the chain in parallel mode, validating the state root and receipts
+	chain, err := NewBlockChain(db, &CacheConfig{TrieDirtyDisabled: true, ParallelExec: true}, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	processor := chain.Processor().(*ParallelStateProcessor)
+	processor.threads = 4
+
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("block %d: failed to insert into chain: %v", n, err)
+	}
+	// Compare the full receipts and logs against sequential processing
+	sequential := NewStateProcessor(gspec.Config, chain, ethash.NewFaker())
+	for _, block := range blocks {
+		parent := chain.GetBlockByHash(block.ParentHash())
+
+		seqState, _ := chain.StateAt(parent.Root())
+		seqReceipts, seqLogs, seqGas, err := sequential.Process(block, seqState, vm.Config{})
+		if err != nil {
+			t.Fatalf("block %d: sequential processing failed: %v", block.NumberU64(), err)
+		}
+		parState, _ := chain.StateAt(parent.Root())
+		parReceipts, parLogs, parGas, err := processor.Process(block, parState, vm.Config{})
+		if err != nil {
+			t.Fatalf("block %d: parallel processing failed: %v", block.NumberU64(), err)
+		}
+		if seqGas != parGas {
+			t.Errorf("block %d: gas mismatch: have %d, want %d", block.NumberU64(), parGas, seqGas)
+		}
+		if have, want := parState.IntermediateRoot(true), seqState.IntermediateRoot(true); have != want {
+			t.Errorf("block %d: root mismatch: have %x, want %x", block.NumberU64(), have, want)
+		}
+		have, _ := json.Marshal(parReceipts)
+		want, _ := json.Marshal(seqReceipts)
+		if string(have) != string(want) {
+			t.Errorf("block %d: receipts mismatch:\nhave %s\nwant %s", block.NumberU64(), have, want)
+		}
+		have, _ = json.Marshal(parLogs)
+		want, _ = json.Marshal(seqLogs)
+		if string(have) != string(want) {
+			t.Errorf("block %d: logs mismatch:\nhave %s\nwant %s", block.NumberU64(), have, want)
+		}
+	}
+	// Ensure the edge cases were actually exercised
+	state, _ := chain.State()
+	if state.Exist(parallelSuicide) {
+		t.Errorf("self destructed contract still exists")
+	}
+	if state.Exist(parallelEmpty) {
+		t.Errorf("touched empty account still exists")
+	}
+}
+
+// Tests that a block exceeding its gas limit is rejected the same way as during
+// sequential processing.
+func TestParallelStateProcessorGasLimit(t *testing.T) {
+	var (
+		db          = rawdb.NewMemoryDatabase()
+		gspec, keys = newParallelTestChain(db, 2)
+		genesis     = gspec.ToBlock(nil)
+		chain, _    = NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	)
+	defer chain.Stop()
+
+	// The second transaction fits into the block on its own, but not after the first
+	var (
+		tx0, _ = types.SignTx(types.NewTransaction(0, common.Address{}, new(big.Int), params.TxGas, big.NewInt(1), nil), types.HomesteadSigner{}, keys[0])
+		tx1, _ = types.SignTx(types.NewTransaction(0, parallelToken, new(big.Int), genesis.GasLimit()-params.TxGas/2, big.NewInt(1), nil), types.HomesteadSigner{}, keys[1])
+		txs    = types.Transactions{tx0, tx1}
+	)
+	header := &types.Header{
+		ParentHash: genesis.Hash(),
+		Number:     big.NewInt(1),
+		GasLimit:   genesis.GasLimit(),
+		Difficulty: big.NewInt(1),
+	}
+	block := types.NewBlock(header, txs, nil, nil)
+
+	processor := NewParallelStateProcessor(gspec.Config, chain, ethash.NewFaker())
+	processor.threads = 4
+
+	statedb, _ := chain.StateAt(genesis.Root())
+	if _, _, _, err := processor.Process(block, statedb, vm.Config{}); err != ErrGasLimitReached {
+		t.Fatalf("gas limit error mismatch: have %v, want %v", err, ErrGasLimitReached)
+	}
+}
+
+func BenchmarkProcessBlock_sequential(b *testing.B) {
+	benchProcessBlock(b, false, genMixedBenchTxs)
+}
+func BenchmarkProcessBlock_parallel(b *testing.B) {
+	benchProcessBlock(b, true, genMixedBenchTxs)
+}
+func BenchmarkProcessBlockContracts_sequential(b *testing.B) {
+	benchProcessBlock(b, false, genContractBenchTxs)
+}
+func BenchmarkProcessBlockContracts_parallel(b *testing.B) {
+	benchProcessBlock(b, true, genContractBenchTxs)
+}
+func BenchmarkProcessBlockConflicts_sequential(b *testing.B) {
+	benchProcessBlock(b, false, genConflictBenchTxs)
+}
+func BenchmarkProcessBlockConflicts_parallel(b *testing.B) {
+	benchProcessBlock(b, true, genConflictBenchTxs)
+}
+
+// genMixedBenchTxs creates a block full of mainnet-like transactions: mostly
+// token transfers and value transfers between distinct accounts, along with
+// calls to a few hot contracts.
+func genMixedBenchTxs(keys []*ecdsa.PrivateKey) func(int, *BlockGen) {
+	return genParallelTxs(keys, 200)
+}
+
+// genContractBenchTxs creates a block full of storage heavy contract calls, each
+// touching distinct storage slots.
+func genContractBenchTxs(keys []*ecdsa.PrivateKey) func(int, *BlockGen) {
+	return genParallelHasherTxs(keys, 64, 64)
+}
+
+// genConflictBenchTxs creates a block full of storage heavy contract calls, with
+// groups of 16 transactions rewriting the same storage slots.
+func genConflictBenchTxs(keys []*ecdsa.PrivateKey) func(int, *BlockGen) {
+	return genParallelHasherTxs(keys, 64, 4)
+}
+
+// benchProcessBlock measures the processing of a block filled with transactions
+// by the given generator.
+func benchProcessBlock(b *testing.B, parallel bool, gen func([]*ecdsa.PrivateKey) func(int, *BlockGen)) {
+	var (
+		db          = rawdb.NewMemoryDatabase()
+		gspec, keys = newParallelTestChain(db, 200)
+		genesis     = gspec.ToBlock(nil)
+	)
+	blocks, receipts := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 1, gen(keys))
+	for _, receipt := range receipts[0] {
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			b.Fatalf("transaction %x failed in fixture", receipt.TxHash)
+		}
+	}
+	chain, _ := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{}, nil)
+	defer chain.Stop()
+
+	var processor Processor = NewStateProcessor(gspec.Config, chain, ethash.NewFaker())
+	if parallel {
+		processor = NewParallelStateProcessor(gspec.Config, chain, ethash.NewFaker())
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		statedb, _ := chain.StateAt(genesis.Root())
+		if _, _, _, err := processor.Process(blocks[0], statedb, vm.Config{}); err != nil {
+			b.Fatalf("processing failed: %v", err)
+		}
+	}
+}
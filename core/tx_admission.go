@@ -0,0 +1,382 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// senderRateWindow is the time window the per sender rate limit applies to.
+const senderRateWindow = time.Minute
+
+var (
+	// ErrBlacklisted is returned if a transaction is sent to or from a blacklisted
+	// address.
+	ErrBlacklisted = errors.New("blacklisted address")
+
+	// ErrSenderRateLimited is returned if the sender of a transaction exceeded its
+	// allowance of transactions within the rate limit window.
+	ErrSenderRateLimited = errors.New("sender rate limited")
+
+	// ErrDestinationUnderpriced is returned if a transaction is sent to a contract
+	// with a minimum gas price requirement, but pays less.
+	ErrDestinationUnderpriced = errors.New("transaction underpriced for destination")
+)
+
+// TxFilter is an admission filter consulted by the transaction pool after the
+// built in validity checks pass, but before a transaction is accepted.
+type TxFilter interface {
+	// Name returns the identifier of the filter, used to account rejections.
+	Name() string
+
+	// Admit returns an error if the transaction from the given sender must not
+	// be accepted into the pool.
+	Admit(tx *types.Transaction, from common.Address, local bool) error
+}
+
+// AccountingTxFilter is an admission filter keeping track of the transactions
+// accepted from the network, e.g. to rate limit them. It is only consulted for
+// newly submitted remote transactions, which are accounted once actually added
+// to the pool.
+type AccountingTxFilter interface {
+	TxFilter
+
+	// Added records a remote transaction from the given sender that passed all
+	// the filters and was added to the pool.
+	Added(tx *types.Transaction, from common.Address)
+}
+
+// TxAdmissionRules is the declarative form of the built in admission filters,
+// usually loaded from a JSON rule file.
+type TxAdmissionRules struct {
+	Blacklist       []common.Address                         `json:"blacklist,omitempty"`       // Addresses not allowed to send or receive transactions
+	SenderRateLimit uint64                                   `json:"senderRateLimit,omitempty"` // Maximum transactions accepted per sender per minute (0 = unlimited)
+	MinGasPrices    map[common.Address]*math.HexOrDecimal256 `json:"minGasPrices,omitempty"`    // Minimum gas prices required by destination
+}
+
+// LoadTxAdmissionRules reads a set of admission rules from a JSON file.
+func LoadTxAdmissionRules(file string) (*TxAdmissionRules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules := new(TxAdmissionRules)
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(rules); err != nil {
+		return nil, fmt.Errorf("invalid admission rules %s: %v", file, err)
+	}
+	return rules, nil
+}
+
+// Filters creates the admission filters enforcing the rule set.
+func (rules *TxAdmissionRules) Filters() []TxFilter {
+	var filters []TxFilter
+	if len(rules.Blacklist) > 0 {
+		filters = append(filters, NewBlacklistFilter(rules.Blacklist))
+	}
+	if len(rules.MinGasPrices) > 0 {
+		prices := make(map[common.Address]*big.Int, len(rules.MinGasPrices))
+		for addr, price := range rules.MinGasPrices {
+			if price != nil {
+				prices[addr] = (*big.Int)(price)
+			}
+		}
+		filters = append(filters, NewDestinationPriceFilter(prices))
+	}
+	if rules.SenderRateLimit > 0 {
+		filters = append(filters, NewSenderRateFilter(rules.SenderRateLimit, senderRateWindow))
+	}
+	return filters
+}
+
+// blacklistFilter rejects transactions from or to a set of addresses.
+type blacklistFilter struct {
+	addrs map[common.Address]struct{}
+}
+
+// NewBlacklistFilter creates an admission filter rejecting all the transactions
+// sent from or to any of the given addresses.
+func NewBlacklistFilter(addrs []common.Address) TxFilter {
+	filter := &blacklistFilter{addrs: make(map[common.Address]struct{}, len(addrs))}
+	for _, addr := range addrs {
+		filter.addrs[addr] = struct{}{}
+	}
+	return filter
+}
+
+// Name implements TxFilter.
+func (f *blacklistFilter) Name() string { return "blacklist" }
+
+// Admit implements TxFilter.
+func (f *blacklistFilter) Admit(tx *types.Transaction, from common.Address, local bool) error {
+	if _, ok := f.addrs[from]; ok {
+		return ErrBlacklisted
+	}
+	if to := tx.To(); to != nil {
+		if _, ok := f.addrs[*to]; ok {
+			return ErrBlacklisted
+		}
+	}
+	return nil
+}
+
+// senderRateFilter caps the number of remote transactions accepted from a single
+// sender within a sliding time window.
+type senderRateFilter struct {
+	limit  uint64
+	window time.Duration
+	seen   map[common.Address][]time.Time // Admission times within the window, oldest first
+	clean  time.Time                      // Last time quiet senders were forgotten
+	lock   sync.Mutex
+}
+
+// NewSenderRateFilter creates an admission filter accepting at most limit remote
+// transactions from each sender within any window of the given length.
+func NewSenderRateFilter(limit uint64, window time.Duration) AccountingTxFilter {
+	return &senderRateFilter{
+		limit:  limit,
+		window: window,
+		seen:   make(map[common.Address][]time.Time),
+	}
+}
+
+// Name implements TxFilter.
+func (f *senderRateFilter) Name() string { return "ratelimit" }
+
+// Admit implements TxFilter.
+func (f *senderRateFilter) Admit(tx *types.Transaction, from common.Address, local bool) error {
+	if local {
+		return nil
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if uint64(len(f.recent(from, time.Now()))) >= f.limit {
+		return ErrSenderRateLimited
+	}
+	return nil
+}
+
+// Added implements AccountingTxFilter.
+func (f *senderRateFilter) Added(tx *types.Transaction, from common.Address) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	now := time.Now()
+	f.seen[from] = append(f.recent(from, now), now)
+
+	// Once every window, forget the senders that went quiet
+	if now.Sub(f.clean) >= f.window {
+		for addr, times := range f.seen {
+			if now.Sub(times[len(times)-1]) >= f.window {
+				delete(f.seen, addr)
+			}
+		}
+		f.clean = now
+	}
+}
+
+// recent drops the admissions of a sender that left the window, returning the
+// remaining ones.
+//
+// Note, this method assumes the filter lock is held.
+func (f *senderRateFilter) recent(from common.Address, now time.Time) []time.Time {
+	seen := f.seen[from]
+	for len(seen) > 0 && now.Sub(seen[0]) >= f.window {
+		seen = seen[1:]
+	}
+	if len(seen) == 0 {
+		delete(f.seen, from)
+		return nil
+	}
+	f.seen[from] = seen
+	return seen
+}
+
+// destinationPriceFilter enforces minimum gas prices on transactions sent to a
+// set of destinations.
+type destinationPriceFilter struct {
+	prices map[common.Address]*big.Int
+}
+
+// NewDestinationPriceFilter creates an admission filter rejecting transactions
+// sent to any of the given destinations below their minimum gas price.
+func NewDestinationPriceFilter(prices map[common.Address]*big.Int) TxFilter {
+	return &destinationPriceFilter{prices: prices}
+}
+
+// Name implements TxFilter.
+func (f *destinationPriceFilter) Name() string { return "destprice" }
+
+// Admit implements TxFilter.
+func (f *destinationPriceFilter) Admit(tx *types.Transaction, from common.Address, local bool) error {
+	if to := tx.To(); to != nil {
+		if price, ok := f.prices[*to]; ok && tx.GasPrice().Cmp(price) < 0 {
+			return ErrDestinationUnderpriced
+		}
+	}
+	return nil
+}
+
+// admit runs a transaction through the admission filters of the pool, except
+// for the accounting ones, metering the rejections by filter.
+//
+// Note, this method assumes the pool lock is held.
+func (pool *TxPool) admit(tx *types.Transaction, from common.Address, local bool) error {
+	for _, filters := range [][]TxFilter{pool.config.Filters, pool.ruleFilters} {
+		for _, filter := range filters {
+			if _, ok := filter.(AccountingTxFilter); ok {
+				continue
+			}
+			if err := filter.Admit(tx, from, local); err != nil {
+				metrics.GetOrRegisterCounter("txpool/rejected/"+filter.Name(), nil).Inc(1)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// limit runs a newly submitted remote transaction through the accounting
+// admission filters of the pool, metering the rejections by filter.
+//
+// Note, this method assumes the pool lock is held.
+func (pool *TxPool) limit(tx *types.Transaction) error {
+	from, err := types.Sender(pool.signer, tx)
+	if err != nil {
+		return ErrInvalidSender
+	}
+	local := pool.locals.contains(from)
+	for _, filters := range [][]TxFilter{pool.config.Filters, pool.ruleFilters} {
+		for _, filter := range filters {
+			if _, ok := filter.(AccountingTxFilter); !ok {
+				continue
+			}
+			if err := filter.Admit(tx, from, local); err != nil {
+				metrics.GetOrRegisterCounter("txpool/rejected/"+filter.Name(), nil).Inc(1)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// account records a newly submitted remote transaction added to the pool with
+// its accounting admission filters.
+//
+// Note, this method assumes the pool lock is held.
+func (pool *TxPool) account(tx *types.Transaction) {
+	from, _ := types.Sender(pool.signer, tx) // already validated
+	if pool.locals.contains(from) {
+		return
+	}
+	for _, filters := range [][]TxFilter{pool.config.Filters, pool.ruleFilters} {
+		for _, filter := range filters {
+			if filter, ok := filter.(AccountingTxFilter); ok {
+				filter.Added(tx, from)
+			}
+		}
+	}
+}
+
+// AdmissionRules returns the currently enforced declarative admission rules.
+func (pool *TxPool) AdmissionRules() *TxAdmissionRules {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	return pool.rules
+}
+
+// SetAdmissionRules replaces the declarative admission rules of the pool. The
+// state of the previous rule filters (e.g. rate limits) is discarded and any
+// pooled transaction rejected by the new rules is dropped.
+func (pool *TxPool) SetAdmissionRules(rules *TxAdmissionRules) {
+	pool.mu.Lock()
+	pool.rules, pool.ruleFilters = rules, nil
+	if rules != nil {
+		pool.ruleFilters = rules.Filters()
+	}
+	pool.readmit()
+	changes := pool.takeChanges()
+	pool.mu.Unlock()
+
+	pool.announceChanges(changes)
+}
+
+// readmit runs all the pending and queued transactions through the declarative
+// admission filters of the pool, except for the accounting ones, dropping the
+// rejected transactions.
+//
+// Note, this method assumes the pool lock is held.
+func (pool *TxPool) readmit() {
+	var rejected []*types.Transaction
+	for _, lists := range []map[common.Address]*txList{pool.pending, pool.queue} {
+		for addr, list := range lists {
+			local := pool.locals.contains(addr)
+			for _, tx := range list.Flatten() {
+				for _, filter := range pool.ruleFilters {
+					if _, ok := filter.(AccountingTxFilter); ok {
+						continue
+					}
+					if err := filter.Admit(tx, addr, local); err != nil {
+						log.Trace("Dropping inadmissible transaction", "hash", tx.Hash(), "filter", filter.Name(), "err", err)
+						rejected = append(rejected, tx)
+						break
+					}
+				}
+			}
+		}
+	}
+	for _, tx := range rejected {
+		pool.dropped(tx, TxDropRejected, nil)
+		pool.removeTx(tx.Hash(), true)
+	}
+	if len(rejected) > 0 {
+		log.Debug("Dropped inadmissible transactions", "count", len(rejected))
+	}
+}
+
+// ReloadAdmissionRules reloads the declarative admission rules from the rule
+// file configured on startup.
+func (pool *TxPool) ReloadAdmissionRules() error {
+	file := pool.config.Rules
+	if file == "" {
+		return errors.New("no admission rule file configured")
+	}
+	rules, err := LoadTxAdmissionRules(file)
+	if err != nil {
+		return err
+	}
+	pool.SetAdmissionRules(rules)
+	log.Info("Loaded transaction admission rules", "file", file, "blacklist", len(rules.Blacklist), "ratelimit", rules.SenderRateLimit, "prices", len(rules.MinGasPrices))
+	return nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// maxTxBundles is the maximum number of transaction bundles tracked by the pool.
+	maxTxBundles = 256
+
+	// maxSenderBundles is the maximum number of transaction bundles tracked by the
+	// pool containing transactions of any single sender.
+	maxSenderBundles = 4
+
+	// maxBundleBlocks is the number of blocks a bundle without a target block is
+	// kept for, and how far ahead of the chain head targets may be.
+	maxBundleBlocks = 25
+)
+
+var (
+	// ErrBundleEmpty is returned if a transaction bundle contains no transactions.
+	ErrBundleEmpty = errors.New("empty bundle")
+
+	// ErrBundleKnown is returned if a transaction bundle is already in the pool.
+	ErrBundleKnown = errors.New("known bundle")
+
+	// ErrBundleExpired is returned if a transaction bundle targets a block that is
+	// already part of the chain.
+	ErrBundleExpired = errors.New("bundle expired")
+
+	// ErrBundleTimestamps is returned if a transaction bundle has an empty
+	// timestamp window.
+	ErrBundleTimestamps = errors.New("bundle max timestamp below min timestamp")
+
+	// ErrBundleNonceGap is returned if the transactions of a sender within a bundle
+	// do not have consecutive nonces.
+	ErrBundleNonceGap = errors.New("bundle nonces not consecutive")
+
+	// ErrBundleFuture is returned if a transaction bundle targets a block too far
+	// ahead of the chain head.
+	ErrBundleFuture = errors.New("bundle target block too far ahead")
+
+	// ErrBundlePoolFull is returned if the pool cannot accept more bundles.
+	ErrBundlePoolFull = errors.New("bundle pool full")
+
+	// ErrBundleSenderLimit is returned if a sender of a transaction bundle already
+	// has the maximum number of bundles in the pool.
+	ErrBundleSenderLimit = errors.New("bundle limit per sender reached")
+)
+
+// TxBundle is a sequence of transactions that must be included together, in
+// order, at the top of a block or not at all.
+type TxBundle struct {
+	Txs          types.Transactions // Transactions to include, in order
+	BlockNumber  uint64             // Block number the bundle is valid for (0 = any)
+	MinTimestamp uint64             // Minimum block timestamp the bundle is valid for (0 = any)
+	MaxTimestamp uint64             // Maximum block timestamp the bundle is valid for (0 = any)
+
+	expiry uint64 // Last block number the bundle is kept for by the pool
+}
+
+// Hash returns the identifier of the bundle, the hash of its transaction hashes.
+func (b *TxBundle) Hash() common.Hash {
+	hashes := make([]byte, 0, len(b.Txs)*common.HashLength)
+	for _, tx := range b.Txs {
+		hashes = append(hashes, tx.Hash().Bytes()...)
+	}
+	return crypto.Keccak256Hash(hashes)
+}
+
+// Gas returns the total gas allowance of the bundle's transactions.
+func (b *TxBundle) Gas() uint64 {
+	var gas uint64
+	for _, tx := range b.Txs {
+		gas += tx.Gas()
+	}
+	return gas
+}
+
+// includable returns whether the bundle may be included in a block with the
+// given number and timestamp.
+func (b *TxBundle) includable(number uint64, time uint64) bool {
+	if b.BlockNumber != 0 && b.BlockNumber != number {
+		return false
+	}
+	if b.expiry != 0 && number > b.expiry {
+		return false
+	}
+	if b.MinTimestamp != 0 && time < b.MinTimestamp {
+		return false
+	}
+	if b.MaxTimestamp != 0 && time > b.MaxTimestamp {
+		return false
+	}
+	return true
+}
+
+// AddBundle validates a transaction bundle against the current pool state and
+// stores it for the miner to include at the top of an upcoming block.
+//
+// Every transaction needs to be individually valid on top of the pool's head
+// state, priced like any remote transaction, and the transactions of each sender
+// need to have consecutive nonces. As every tracked bundle is simulated for every
+// block being mined, their number is capped in total and per sender, and they
+// are dropped once their target block, or maxBundleBlocks blocks if untargeted,
+// is past.
+func (pool *TxPool) AddBundle(bundle *TxBundle) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if len(bundle.Txs) == 0 {
+		return ErrBundleEmpty
+	}
+	if bundle.MaxTimestamp != 0 && bundle.MaxTimestamp < bundle.MinTimestamp {
+		return ErrBundleTimestamps
+	}
+	head := pool.chain.CurrentBlock().NumberU64()
+	if bundle.BlockNumber != 0 && bundle.BlockNumber <= head {
+		return ErrBundleExpired
+	}
+	if bundle.BlockNumber > head+maxBundleBlocks {
+		return ErrBundleFuture
+	}
+	if bundle.Gas() > pool.currentMaxGas {
+		return ErrGasLimit
+	}
+	// Validate the individual transactions, their pricing and nonce ordering
+	var (
+		full   = uint64(pool.all.Count()) >= pool.config.GlobalSlots+pool.config.GlobalQueue
+		nonces = make(map[common.Address]uint64)
+	)
+	for _, tx := range bundle.Txs {
+		if err := pool.validateTx(tx, false); err != nil {
+			return err
+		}
+		from, _ := types.Sender(pool.signer, tx) // already validated
+		if full && !pool.locals.contains(from) && pool.priced.Underpriced(tx, pool.locals) {
+			return ErrUnderpriced
+		}
+		if next, ok := nonces[from]; ok && tx.Nonce() != next {
+			return ErrBundleNonceGap
+		}
+		nonces[from] = tx.Nonce() + 1
+	}
+	hash := bundle.Hash()
+	for _, known := range pool.bundles {
+		if known.Hash() == hash {
+			return ErrBundleKnown
+		}
+	}
+	if len(pool.bundles) >= maxTxBundles {
+		return ErrBundlePoolFull
+	}
+	counts := make(map[common.Address]int)
+	for _, known := range pool.bundles {
+		for from := range pool.bundleSenders(known) {
+			counts[from]++
+		}
+	}
+	for from := range nonces {
+		if counts[from] >= maxSenderBundles {
+			return ErrBundleSenderLimit
+		}
+	}
+	bundle.expiry = bundle.BlockNumber
+	if bundle.expiry == 0 {
+		bundle.expiry = head + maxBundleBlocks
+	}
+	pool.bundles = append(pool.bundles, bundle)
+	log.Trace("Added transaction bundle", "hash", hash, "txs", len(bundle.Txs), "block", bundle.BlockNumber)
+	return nil
+}
+
+// Bundles retrieves the transaction bundles that may be included in a block with
+// the given number and timestamp, in arrival order.
+func (pool *TxPool) Bundles(number *big.Int, time uint64) []*TxBundle {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	var bundles []*TxBundle
+	for _, bundle := range pool.bundles {
+		if bundle.includable(number.Uint64(), time) {
+			bundles = append(bundles, bundle)
+		}
+	}
+	return bundles
+}
+
+// bundleSenders returns the senders of the transactions of a bundle.
+func (pool *TxPool) bundleSenders(bundle *TxBundle) map[common.Address]struct{} {
+	senders := make(map[common.Address]struct{})
+	for _, tx := range bundle.Txs {
+		from, _ := types.Sender(pool.signer, tx) // already validated
+		senders[from] = struct{}{}
+	}
+	return senders
+}
+
+// pruneBundles removes all the bundles that cannot be included any more on top
+// of the new head: the expired ones, the ones targeting past blocks or timestamps,
+// and the ones containing transactions with already used nonces.
+//
+// Note, this method assumes the pool lock is held and the state was reset.
+func (pool *TxPool) pruneBundles(head *types.Header) {
+	bundles := pool.bundles[:0]
+	for _, bundle := range pool.bundles {
+		if bundle.expiry <= head.Number.Uint64() {
+			continue
+		}
+		if bundle.MaxTimestamp != 0 && bundle.MaxTimestamp <= head.Time {
+			continue
+		}
+		stale := false
+		for _, tx := range bundle.Txs {
+			from, _ := types.Sender(pool.signer, tx)
+			if tx.Nonce() < pool.currentState.GetNonce(from) {
+				stale = true
+				break
+			}
+		}
+		if stale {
+			continue
+		}
+		bundles = append(bundles, bundle)
+	}
+	for i := len(bundles); i < len(pool.bundles); i++ {
+		pool.bundles[i] = nil
+	}
+	pool.bundles = bundles
+}
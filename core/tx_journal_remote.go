@@ -0,0 +1,244 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// remoteJournalEntry is the disk representation of a journaled remote transaction.
+type remoteJournalEntry struct {
+	Tx     *types.Transaction
+	Time   uint64 // Local arrival time of the transaction in unix nanoseconds
+	Origin string // Identifier of the peer the transaction was received from
+}
+
+// txRemoteJournal is a size bounded snapshot of the remote transactions of the
+// pool, with the aim of refilling the pool quickly after a node restart.
+//
+// Contrary to the local journal, it is not appended to on every insertion, only
+// regenerated periodically and on shutdown.
+type txRemoteJournal struct {
+	path  string // Filesystem path to store the transactions at
+	limit uint64 // Maximum size of the journal in bytes
+}
+
+// newTxRemoteJournal creates a new remote transaction journal.
+func newTxRemoteJournal(path string, limit uint64) *txRemoteJournal {
+	return &txRemoteJournal{
+		path:  path,
+		limit: limit,
+	}
+}
+
+// load parses a remote transaction journal dump from disk, loading its contents
+// into the specified pool. The transactions are revalidated by the pool against
+// its current head.
+func (journal *txRemoteJournal) load(add func([]*remoteJournalEntry) []error) error {
+	// Skip the parsing if the journal file doesn't exist at all
+	if _, err := os.Stat(journal.path); os.IsNotExist(err) {
+		return nil
+	}
+	input, err := os.Open(journal.path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	var (
+		stream         = rlp.NewStream(bufio.NewReader(input), 0)
+		total, dropped = 0, 0
+		failure        error
+		batch          []*remoteJournalEntry
+	)
+	loadBatch := func(entries []*remoteJournalEntry) {
+		for _, err := range add(entries) {
+			if err != nil {
+				log.Trace("Failed to add journaled remote transaction", "err", err)
+				dropped++
+			}
+		}
+	}
+	for {
+		entry := new(remoteJournalEntry)
+		if err = stream.Decode(entry); err != nil {
+			if err != io.EOF {
+				failure = err
+			}
+			if len(batch) > 0 {
+				loadBatch(batch)
+			}
+			break
+		}
+		total++
+
+		if batch = append(batch, entry); len(batch) > 1024 {
+			loadBatch(batch)
+			batch = batch[:0]
+		}
+	}
+	log.Info("Loaded remote transaction journal", "transactions", total, "dropped", dropped)
+
+	return failure
+}
+
+// save regenerates the remote transaction journal from the given entries, in
+// order, until the size limit is reached.
+func (journal *txRemoteJournal) save(entries []*remoteJournalEntry) error {
+	replacement, err := os.OpenFile(journal.path+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var (
+		output    = bufio.NewWriter(replacement)
+		size      uint64
+		journaled int
+	)
+	for _, entry := range entries {
+		blob, err := rlp.EncodeToBytes(entry)
+		if err != nil {
+			replacement.Close()
+			return err
+		}
+		if size+uint64(len(blob)) > journal.limit {
+			break
+		}
+		if _, err := output.Write(blob); err != nil {
+			replacement.Close()
+			return err
+		}
+		size += uint64(len(blob))
+		journaled++
+	}
+	if err := output.Flush(); err != nil {
+		replacement.Close()
+		return err
+	}
+	replacement.Close()
+
+	if err = os.Rename(journal.path+".new", journal.path); err != nil {
+		return err
+	}
+	log.Info("Regenerated remote transaction journal", "transactions", journaled, "dropped", len(entries)-journaled, "size", common.StorageSize(size))
+	return nil
+}
+
+// AddRemotesFrom enqueues a batch of transactions received from the given peer
+// into the pool if they are valid, tracking their origin for the journal.
+func (pool *TxPool) AddRemotesFrom(origin string, txs []*types.Transaction) []error {
+	errs := pool.AddRemotes(txs)
+	if pool.remoteJournal != nil {
+		pool.mu.Lock()
+		for i, tx := range txs {
+			if errs[i] == nil {
+				pool.origins[tx.Hash()] = origin
+			}
+		}
+		pool.mu.Unlock()
+	}
+	return errs
+}
+
+// addJournaled injects a batch of journaled remote transactions into the pool,
+// restoring their arrival times and origins. The transactions were already
+// admitted before the restart, so they bypass the accounting filters (e.g. rate
+// limits), but are still accounted with them.
+func (pool *TxPool) addJournaled(entries []*remoteJournalEntry) []error {
+	txs := make([]*types.Transaction, len(entries))
+	for i, entry := range entries {
+		if entry.Time != 0 {
+			entry.Tx.SetTime(time.Unix(0, int64(entry.Time)))
+		}
+		txs[i] = entry.Tx
+	}
+	errs := pool.addTxsLimited(txs, false, false, true)
+
+	pool.mu.Lock()
+	for i, entry := range entries {
+		if errs[i] == nil && entry.Origin != "" {
+			pool.origins[entry.Tx.Hash()] = entry.Origin
+		}
+	}
+	pool.mu.Unlock()
+
+	return errs
+}
+
+// remote retrieves the journal entries of all the remote transactions currently
+// in the pool: executable ones first, then the queued ones. Within both groups
+// the accounts are ordered by the gas price of their first transaction, so the
+// most valuable ones survive the journal's size limit.
+//
+// Note, this method assumes the pool lock is held.
+func (pool *TxPool) remote() []*remoteJournalEntry {
+	var entries []*remoteJournalEntry
+	for _, lists := range []map[common.Address]*txList{pool.pending, pool.queue} {
+		accounts := make([]types.Transactions, 0, len(lists))
+		for addr, list := range lists {
+			if pool.locals.contains(addr) || list.Empty() {
+				continue
+			}
+			accounts = append(accounts, list.Flatten())
+		}
+		sort.SliceStable(accounts, func(i, j int) bool {
+			return accounts[i][0].GasPrice().Cmp(accounts[j][0].GasPrice()) > 0
+		})
+		for _, txs := range accounts {
+			for _, tx := range txs {
+				entries = append(entries, &remoteJournalEntry{
+					Tx:     tx,
+					Time:   uint64(tx.Time().UnixNano()),
+					Origin: pool.origins[tx.Hash()],
+				})
+			}
+		}
+	}
+	return entries
+}
+
+// saveRemotes regenerates the remote transaction journal from the current pool
+// contents.
+func (pool *TxPool) saveRemotes() {
+	pool.mu.RLock()
+	entries := pool.remote()
+	pool.mu.RUnlock()
+
+	if err := pool.remoteJournal.save(entries); err != nil {
+		log.Warn("Failed to save remote transaction journal", "err", err)
+	}
+}
+
+// pruneOrigins drops the tracked origins of all the transactions not in the pool
+// any more.
+//
+// Note, this method assumes the pool lock is held.
+func (pool *TxPool) pruneOrigins() {
+	for hash := range pool.origins {
+		if pool.all.Get(hash) == nil {
+			delete(pool.origins, hash)
+		}
+	}
+}
@@ -143,6 +143,12 @@ type TxPoolConfig struct {
 	GlobalQueue  uint64 // Maximum number of non-executable transaction slots for all accounts
 
 	Lifetime time.Duration // Maximum amount of time non-executable transaction are queued
+
+	RemoteJournal      string // Journal of remote transactions to survive node restarts (disabled if empty)
+	RemoteJournalLimit uint64 // Maximum size of the remote transaction journal in bytes
+
+	Rules   string     // JSON file of declarative transaction admission rules
+	Filters []TxFilter `toml:"-"` // Custom admission filters run before the declarative rules
 }
 
 // DefaultTxPoolConfig contains the default configurations for the transaction
@@ -160,6 +166,8 @@ var DefaultTxPoolConfig = TxPoolConfig{
 	GlobalQueue:  1024,
 
 	Lifetime: 3 * time.Hour,
+
+	RemoteJournalLimit: 16 * 1024 * 1024,
 }
 
 // sanitize checks the provided user configurations and changes anything that's
@@ -198,6 +206,10 @@ func (config *TxPoolConfig) sanitize() TxPoolConfig {
 		log.Warn("Sanitizing invalid txpool lifetime", "provided", conf.Lifetime, "updated", DefaultTxPoolConfig.Lifetime)
 		conf.Lifetime = DefaultTxPoolConfig.Lifetime
 	}
+	if conf.RemoteJournal != "" && conf.RemoteJournalLimit < 1 {
+		log.Warn("Sanitizing invalid txpool remote journal limit", "provided", conf.RemoteJournalLimit, "updated", DefaultTxPoolConfig.RemoteJournalLimit)
+		conf.RemoteJournalLimit = DefaultTxPoolConfig.RemoteJournalLimit
+	}
 	return conf
 }
 
@@ -215,9 +227,14 @@ type TxPool struct {
 	gasPrice    *big.Int
 	txFeed      event.Feed
 	localTxFeed event.Feed
-	scope       event.SubscriptionScope
-	signer      types.Signer
-	mu          sync.RWMutex
+
+	lifecycleFeed event.Feed    // Feed of transaction state changes
+	changes       []TxLifecycle // State changes to announce once the lock is released
+	drops         *txDropLog    // Recently dropped transactions with their drop reasons
+
+	scope  event.SubscriptionScope
+	signer types.Signer
+	mu     sync.RWMutex
 
 	istanbul bool // Fork indicator whether we are in the istanbul stage.
 
@@ -228,11 +245,18 @@ type TxPool struct {
 	locals  *accountSet // Set of local transaction to exempt from eviction rules
 	journal *txJournal  // Journal of local transaction to back up to disk
 
+	remoteJournal *txRemoteJournal       // Journal of remote transactions to back up to disk
+	origins       map[common.Hash]string // Peers the journaled remote transactions arrived from
+
 	pending map[common.Address]*txList   // All currently processable transactions
 	queue   map[common.Address]*txList   // Queued but non-processable transactions
 	beats   map[common.Address]time.Time // Last heartbeat from each known account
 	all     *txLookup                    // All transactions to allow lookups
 	priced  *txPricedList                // All transactions sorted by price
+	bundles []*TxBundle                  // Transaction bundles to include atomically at the top of blocks
+
+	rules       *TxAdmissionRules // Declarative admission rules currently enforced
+	ruleFilters []TxFilter        // Admission filters created from the declarative rules
 
 	chainHeadCh     chan ChainHeadEvent
 	chainHeadSub    event.Subscription
@@ -271,6 +295,7 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 		reorgDoneCh:     make(chan chan struct{}),
 		reorgShutdownCh: make(chan struct{}),
 		gasPrice:        new(big.Int).SetUint64(config.PriceLimit),
+		drops:           newTxDropLog(txDropHistory),
 	}
 	pool.locals = newAccountSet(pool.signer)
 	for _, addr := range config.Locals {
@@ -280,6 +305,13 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 	pool.priced = newTxPricedList(pool.all)
 	pool.reset(nil, chain.CurrentBlock().Header())
 
+	// Load the declarative admission rules before accepting any transactions
+	if config.Rules != "" {
+		if err := pool.ReloadAdmissionRules(); err != nil {
+			log.Warn("Failed to load transaction admission rules", "err", err)
+		}
+	}
+
 	// Start the reorg loop early so it can handle requests generated during journal loading.
 	pool.wg.Add(1)
 	go pool.scheduleReorgLoop()
@@ -295,6 +327,15 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 			log.Warn("Failed to rotate transaction journal", "err", err)
 		}
 	}
+	// If remote journaling is enabled, refill the pool from disk
+	if config.RemoteJournal != "" {
+		pool.remoteJournal = newTxRemoteJournal(config.RemoteJournal, config.RemoteJournalLimit)
+		pool.origins = make(map[common.Hash]string)
+
+		if err := pool.remoteJournal.load(pool.addJournaled); err != nil {
+			log.Warn("Failed to load remote transaction journal", "err", err)
+		}
+	}
 
 	// Subscribe events from blockchain and start the main event loop.
 	pool.chainHeadSub = pool.chain.SubscribeChainHeadEvent(pool.chainHeadCh)
@@ -360,12 +401,19 @@ func (pool *TxPool) loop() {
 				// Any non-locals old enough should be removed
 				if time.Since(pool.beats[addr]) > pool.config.Lifetime {
 					for _, tx := range pool.queue[addr].Flatten() {
+						pool.dropped(tx, TxDropExpired, nil)
 						pool.removeTx(tx.Hash(), true)
 					}
 				}
 			}
+			if pool.remoteJournal != nil {
+				pool.pruneOrigins()
+			}
+			changes := pool.takeChanges()
 			pool.mu.Unlock()
 
+			pool.announceChanges(changes)
+
 		// Handle local transaction journal rotation
 		case <-journal.C:
 			if pool.journal != nil {
@@ -375,6 +423,9 @@ func (pool *TxPool) loop() {
 				}
 				pool.mu.Unlock()
 			}
+			if pool.remoteJournal != nil {
+				pool.saveRemotes()
+			}
 		}
 	}
 }
@@ -391,6 +442,9 @@ func (pool *TxPool) Stop() {
 	if pool.journal != nil {
 		pool.journal.close()
 	}
+	if pool.remoteJournal != nil {
+		pool.saveRemotes()
+	}
 	log.Info("Transaction pool stopped")
 }
 
@@ -563,7 +617,8 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	if tx.Gas() < intrGas {
 		return ErrIntrinsicGas
 	}
-	return nil
+	// Run the transaction through the custom admission filters
+	return pool.admit(tx, from, local)
 }
 
 // add validates a transaction and inserts it into the non-executable queue for later
@@ -600,6 +655,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (replaced bool, err e
 		for _, tx := range drop {
 			log.Trace("Discarding freshly underpriced transaction", "hash", tx.Hash(), "price", tx.GasPrice())
 			underpricedTxMeter.Mark(1)
+			pool.dropped(tx, TxDropUnderpriced, nil)
 			pool.removeTx(tx.Hash(), false)
 		}
 	}
@@ -617,11 +673,13 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (replaced bool, err e
 			pool.all.Remove(old.Hash())
 			pool.priced.Removed(1)
 			pendingReplaceMeter.Mark(1)
+			pool.dropped(old, TxDropReplaced, tx)
 		}
 		pool.all.Add(tx)
 		pool.priced.Put(tx)
 		pool.journalTx(from, tx)
 		pool.queueTxEvent(tx)
+		pool.lifecycle(tx, TxLifecycleAdded, "")
 		log.Trace("Pooled new executable transaction", "hash", hash, "from", from, "to", tx.To())
 		return old != nil, nil
 	}
@@ -641,6 +699,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (replaced bool, err e
 		localGauge.Inc(1)
 	}
 	pool.journalTx(from, tx)
+	pool.lifecycle(tx, TxLifecycleAdded, "")
 
 	log.Trace("Pooled new future transaction", "hash", hash, "from", from, "to", tx.To())
 	return replaced, nil
@@ -666,6 +725,7 @@ func (pool *TxPool) enqueueTx(hash common.Hash, tx *types.Transaction) (bool, er
 		pool.all.Remove(old.Hash())
 		pool.priced.Removed(1)
 		queuedReplaceMeter.Mark(1)
+		pool.dropped(old, TxDropReplaced, tx)
 	} else {
 		// Nothing was replaced, bump the queued counter
 		queuedGauge.Inc(1)
@@ -707,6 +767,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 		pool.priced.Removed(1)
 
 		pendingDiscardMeter.Mark(1)
+		pool.dropped(tx, TxDropReplaced, list.txs.Get(tx.Nonce()))
 		return false
 	}
 	// Otherwise discard any previous transaction and mark this
@@ -715,6 +776,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 		pool.priced.Removed(1)
 
 		pendingReplaceMeter.Mark(1)
+		pool.dropped(old, TxDropReplaced, tx)
 	} else {
 		// Nothing was replaced, bump the pending counter
 		pendingGauge.Inc(1)
@@ -727,6 +789,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 	// Set the potentially new pending nonce and notify any subsystems of the new tx
 	pool.beats[addr] = time.Now()
 	pool.pendingNonces.set(addr, tx.Nonce()+1)
+	pool.lifecycle(tx, TxLifecyclePromoted, "")
 
 	return true
 }
@@ -779,6 +842,12 @@ func (pool *TxPool) AddRemote(tx *types.Transaction) error {
 
 // addTxs attempts to queue a batch of transactions if they are valid.
 func (pool *TxPool) addTxs(txs []*types.Transaction, local, sync bool) []error {
+	return pool.addTxsLimited(txs, local, !local, sync)
+}
+
+// addTxsLimited attempts to queue a batch of transactions if they are valid,
+// optionally running them through the accounting admission filters too.
+func (pool *TxPool) addTxsLimited(txs []*types.Transaction, local, limit, sync bool) []error {
 	fmt.Printf("\n\n addTxs id:%x \n\n", txs[0].Hash())
 	// Filter out known ones without obtaining the pool lock or recovering signatures
 	var (
@@ -806,9 +875,12 @@ func (pool *TxPool) addTxs(txs []*types.Transaction, local, sync bool) []error {
 	}
 	// Process all the new transaction and merge any errors into the original slice
 	pool.mu.Lock()
-	newErrs, dirtyAddrs := pool.addTxsLocked(news, local)
+	newErrs, dirtyAddrs := pool.addTxsLocked(news, local, limit)
+	changes := pool.takeChanges()
 	pool.mu.Unlock()
 
+	pool.announceChanges(changes)
+
 	var nilSlot = 0
 	for _, err := range newErrs {
 		for errs[nilSlot] != nil {
@@ -824,14 +896,24 @@ func (pool *TxPool) addTxs(txs []*types.Transaction, local, sync bool) []error {
 	return errs
 }
 
-// addTxsLocked attempts to queue a batch of transactions if they are valid.
+// addTxsLocked attempts to queue a batch of newly submitted transactions if they
+// are valid, accounting the remote ones added with the admission filters. If
+// limit is set, the accounting filters are also consulted before adding them.
 // The transaction pool lock must be held.
-func (pool *TxPool) addTxsLocked(txs []*types.Transaction, local bool) ([]error, *accountSet) {
+func (pool *TxPool) addTxsLocked(txs []*types.Transaction, local, limit bool) ([]error, *accountSet) {
 	dirty := newAccountSet(pool.signer)
 	errs := make([]error, len(txs))
 	for i, tx := range txs {
+		if limit {
+			if errs[i] = pool.limit(tx); errs[i] != nil {
+				continue
+			}
+		}
 		replaced, err := pool.add(tx, local)
 		errs[i] = err
+		if err == nil && !local {
+			pool.account(tx)
+		}
 		if err == nil && !replaced {
 			dirty.addTx(tx)
 		}
@@ -1069,8 +1151,11 @@ func (pool *TxPool) runReorg(done chan struct{}, reset *txpoolResetRequest, dirt
 		txs := list.Flatten() // Heavy but will be cached and is needed by the miner anyway
 		pool.pendingNonces.set(addr, txs[len(txs)-1].Nonce()+1)
 	}
+	changes := pool.takeChanges()
 	pool.mu.Unlock()
 
+	pool.announceChanges(changes)
+
 	// Notify subsystems for newly added transactions
 	if len(events) > 0 {
 		var txs []*types.Transaction
@@ -1159,10 +1244,15 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	pool.pendingNonces = newTxNoncer(statedb)
 	pool.currentMaxGas = newHead.GasLimit
 
+	// Drop any bundles that can no longer be included
+	pool.pruneBundles(newHead)
+
 	// Inject any transactions discarded due to reorgs
 	log.Debug("Reinjecting stale transactions", "count", len(reinject))
 	senderCacher.recover(pool.signer, reinject)
-	pool.addTxsLocked(reinject, false)
+	for _, tx := range reinject {
+		pool.add(tx, false)
+	}
 
 	// Update all fork indicator by next pending block number.
 	next := new(big.Int).Add(newHead.Number, big.NewInt(1))
@@ -1187,6 +1277,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) []*types.Trans
 		for _, tx := range forwards {
 			hash := tx.Hash()
 			pool.all.Remove(hash)
+			pool.dropped(tx, TxDropMined, nil)
 			log.Trace("Removed old queued transaction", "hash", hash)
 		}
 		// Drop all transactions that are too costly (low balance or out of gas)
@@ -1194,6 +1285,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) []*types.Trans
 		for _, tx := range drops {
 			hash := tx.Hash()
 			pool.all.Remove(hash)
+			pool.dropped(tx, TxDropUnpayable, nil)
 			log.Trace("Removed unpayable queued transaction", "hash", hash)
 		}
 		queuedNofundsMeter.Mark(int64(len(drops)))
@@ -1216,6 +1308,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) []*types.Trans
 			for _, tx := range caps {
 				hash := tx.Hash()
 				pool.all.Remove(hash)
+				pool.dropped(tx, TxDropCapped, nil)
 				log.Trace("Removed cap-exceeding queued transaction", "hash", hash)
 			}
 			queuedRateLimitMeter.Mark(int64(len(caps)))
@@ -1277,6 +1370,7 @@ func (pool *TxPool) truncatePending() {
 						// Drop the transaction from the global pools too
 						hash := tx.Hash()
 						pool.all.Remove(hash)
+						pool.dropped(tx, TxDropCapped, nil)
 
 						// Update the account nonce to the dropped transaction
 						pool.pendingNonces.setIfLower(offenders[i], tx.Nonce())
@@ -1304,6 +1398,7 @@ func (pool *TxPool) truncatePending() {
 					// Drop the transaction from the global pools too
 					hash := tx.Hash()
 					pool.all.Remove(hash)
+					pool.dropped(tx, TxDropCapped, nil)
 
 					// Update the account nonce to the dropped transaction
 					pool.pendingNonces.setIfLower(addr, tx.Nonce())
@@ -1350,6 +1445,7 @@ func (pool *TxPool) truncateQueue() {
 		// Drop all transactions if they are less than the overflow
 		if size := uint64(list.Len()); size <= drop {
 			for _, tx := range list.Flatten() {
+				pool.dropped(tx, TxDropCapped, nil)
 				pool.removeTx(tx.Hash(), true)
 			}
 			drop -= size
@@ -1359,6 +1455,7 @@ func (pool *TxPool) truncateQueue() {
 		// Otherwise drop only last few transactions
 		txs := list.Flatten()
 		for i := len(txs) - 1; i >= 0 && drop > 0; i-- {
+			pool.dropped(txs[i], TxDropCapped, nil)
 			pool.removeTx(txs[i].Hash(), true)
 			drop--
 			queuedRateLimitMeter.Mark(1)
@@ -1379,6 +1476,7 @@ func (pool *TxPool) demoteUnexecutables() {
 		for _, tx := range olds {
 			hash := tx.Hash()
 			pool.all.Remove(hash)
+			pool.dropped(tx, TxDropMined, nil)
 			log.Trace("Removed old pending transaction", "hash", hash)
 		}
 		// Drop all transactions that are too costly (low balance or out of gas), and queue any invalids back for later
@@ -1387,6 +1485,7 @@ func (pool *TxPool) demoteUnexecutables() {
 			hash := tx.Hash()
 			log.Trace("Removed unpayable pending transaction", "hash", hash)
 			pool.all.Remove(hash)
+			pool.dropped(tx, TxDropUnpayable, nil)
 		}
 		pool.priced.Removed(len(olds) + len(drops))
 		pendingNofundsMeter.Mark(int64(len(drops)))
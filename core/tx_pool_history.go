@@ -0,0 +1,191 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// txDropHistory is the number of dropped transactions the pool remembers the
+// drop reason of.
+const txDropHistory = 4096
+
+// TxLifecycleStage is a state change of a transaction inside the pool.
+type TxLifecycleStage string
+
+const (
+	TxLifecycleAdded    TxLifecycleStage = "added"    // Accepted into the pool
+	TxLifecyclePromoted TxLifecycleStage = "promoted" // Moved from the queue to the executable set
+	TxLifecycleReplaced TxLifecycleStage = "replaced" // Superseded by another transaction with the same nonce
+	TxLifecycleDropped  TxLifecycleStage = "dropped"  // Evicted from the pool
+	TxLifecycleMined    TxLifecycleStage = "mined"    // Removed because its nonce was used on chain
+)
+
+// Reasons of transactions being dropped from the pool.
+const (
+	TxDropReplaced    = "replaced"    // Superseded by a better priced transaction with the same nonce
+	TxDropUnderpriced = "underpriced" // Evicted by better priced transactions on a full pool
+	TxDropUnpayable   = "unpayable"   // Sender balance too low or gas above the block limit
+	TxDropCapped      = "capped"      // Exceeded the account or global slot limits
+	TxDropExpired     = "expired"     // Queued for longer than the configured lifetime
+	TxDropMined       = "mined"       // Nonce used on chain
+	TxDropRejected    = "rejected"    // Refused by reloaded admission rules
+)
+
+// TxLifecycle is a single transaction state change inside the pool.
+type TxLifecycle struct {
+	Hash   common.Hash      `json:"hash"`
+	From   common.Address   `json:"from"`
+	Nonce  uint64           `json:"nonce"`
+	Stage  TxLifecycleStage `json:"stage"`
+	Reason string           `json:"reason,omitempty"` // Drop reason of replaced, dropped and mined transactions
+}
+
+// TxDrop is the record of a transaction removed from the pool.
+type TxDrop struct {
+	Hash       common.Hash    `json:"hash"`
+	From       common.Address `json:"from"`
+	Nonce      uint64         `json:"nonce"`
+	Reason     string         `json:"reason"`
+	ReplacedBy *common.Hash   `json:"replacedBy,omitempty"` // Transaction superseding a replaced one
+	Time       time.Time      `json:"time"`
+}
+
+// txDropLog is a fixed size ring buffer of the most recently dropped transactions,
+// indexed by hash.
+type txDropLog struct {
+	drops []*TxDrop
+	next  int
+	index map[common.Hash]*TxDrop
+}
+
+func newTxDropLog(size int) *txDropLog {
+	return &txDropLog{
+		drops: make([]*TxDrop, size),
+		index: make(map[common.Hash]*TxDrop),
+	}
+}
+
+// add inserts a new drop record, overwriting the oldest one if the log is full.
+func (l *txDropLog) add(drop *TxDrop) {
+	if old := l.drops[l.next]; old != nil && l.index[old.Hash] == old {
+		delete(l.index, old.Hash)
+	}
+	l.drops[l.next] = drop
+	l.index[drop.Hash] = drop
+	l.next = (l.next + 1) % len(l.drops)
+}
+
+// get retrieves the drop record of a transaction, if still remembered.
+func (l *txDropLog) get(hash common.Hash) *TxDrop {
+	return l.index[hash]
+}
+
+// lifecycle records a state change of a transaction to be announced once the
+// pool lock is released.
+//
+// Note, this method assumes the pool lock is held.
+func (pool *TxPool) lifecycle(tx *types.Transaction, stage TxLifecycleStage, reason string) {
+	from, _ := types.Sender(pool.signer, tx) // already validated
+	pool.changes = append(pool.changes, TxLifecycle{
+		Hash:   tx.Hash(),
+		From:   from,
+		Nonce:  tx.Nonce(),
+		Stage:  stage,
+		Reason: reason,
+	})
+}
+
+// dropped records the removal of a transaction from the pool, remembering the
+// reason for later queries. Mined transactions are only announced, not logged.
+//
+// Note, this method assumes the pool lock is held.
+func (pool *TxPool) dropped(tx *types.Transaction, reason string, replacement *types.Transaction) {
+	switch {
+	case reason == TxDropMined:
+		pool.lifecycle(tx, TxLifecycleMined, reason)
+		return
+	case replacement != nil:
+		pool.lifecycle(tx, TxLifecycleReplaced, reason)
+	default:
+		pool.lifecycle(tx, TxLifecycleDropped, reason)
+	}
+	from, _ := types.Sender(pool.signer, tx) // already validated
+	drop := &TxDrop{
+		Hash:   tx.Hash(),
+		From:   from,
+		Nonce:  tx.Nonce(),
+		Reason: reason,
+		Time:   time.Now(),
+	}
+	if replacement != nil {
+		hash := replacement.Hash()
+		drop.ReplacedBy = &hash
+	}
+	pool.drops.add(drop)
+}
+
+// takeChanges retrieves and clears the pending lifecycle changes.
+//
+// Note, this method assumes the pool lock is held.
+func (pool *TxPool) takeChanges() []TxLifecycle {
+	changes := pool.changes
+	pool.changes = nil
+	return changes
+}
+
+// announceChanges sends out a batch of lifecycle changes to the subscribers.
+func (pool *TxPool) announceChanges(changes []TxLifecycle) {
+	if len(changes) > 0 {
+		pool.lifecycleFeed.Send(TxLifecycleEvent{Changes: changes})
+	}
+}
+
+// DropReason retrieves the record of a recently dropped transaction, or nil if
+// the transaction is unknown or was dropped too long ago.
+func (pool *TxPool) DropReason(hash common.Hash) *TxDrop {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	return pool.drops.get(hash)
+}
+
+// ContentFrom retrieves the pending and queued transactions of a single account,
+// sorted by nonce.
+func (pool *TxPool) ContentFrom(addr common.Address) (types.Transactions, types.Transactions) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	var pending, queued types.Transactions
+	if list := pool.pending[addr]; list != nil {
+		pending = list.Flatten()
+	}
+	if list := pool.queue[addr]; list != nil {
+		queued = list.Flatten()
+	}
+	return pending, queued
+}
+
+// SubscribeTxLifecycleEvent registers a subscription of TxLifecycleEvent and
+// starts sending event to the given channel.
+func (pool *TxPool) SubscribeTxLifecycleEvent(ch chan<- TxLifecycleEvent) event.Subscription {
+	return pool.scope.Track(pool.lifecycleFeed.Subscribe(ch))
+}
@@ -18,6 +18,7 @@ package core
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/big"
@@ -33,6 +34,7 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // testTxPoolConfig is a transaction pool configuration without stateful disk
@@ -1828,3 +1830,423 @@ func benchmarkPoolBatchInsert(b *testing.B, size int) {
 		pool.AddRemotes(batch)
 	}
 }
+
+// Tests that transaction bundles are validated on admission and pruned once they
+// cannot be included any more.
+func TestTransactionBundles(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	pool.currentState.AddBalance(from, big.NewInt(1000000000))
+
+	if err := pool.AddBundle(&TxBundle{}); err != ErrBundleEmpty {
+		t.Fatalf("empty bundle error mismatch: have %v, want %v", err, ErrBundleEmpty)
+	}
+	if err := pool.AddBundle(&TxBundle{Txs: types.Transactions{transaction(0, 100000, key)}, MinTimestamp: 2, MaxTimestamp: 1}); err != ErrBundleTimestamps {
+		t.Fatalf("timestamp error mismatch: have %v, want %v", err, ErrBundleTimestamps)
+	}
+	if err := pool.AddBundle(&TxBundle{Txs: types.Transactions{transaction(0, 100000, key), transaction(2, 100000, key)}}); err != ErrBundleNonceGap {
+		t.Fatalf("nonce gap error mismatch: have %v, want %v", err, ErrBundleNonceGap)
+	}
+	if err := pool.AddBundle(&TxBundle{Txs: types.Transactions{transaction(0, 100, key)}}); err != ErrIntrinsicGas {
+		t.Fatalf("invalid transaction error mismatch: have %v, want %v", err, ErrIntrinsicGas)
+	}
+	if err := pool.AddBundle(&TxBundle{Txs: types.Transactions{pricedTransaction(0, 100000, big.NewInt(0), key)}}); err != ErrUnderpriced {
+		t.Fatalf("underpriced bundle error mismatch: have %v, want %v", err, ErrUnderpriced)
+	}
+	if err := pool.AddBundle(&TxBundle{Txs: types.Transactions{transaction(0, 100000, key)}, BlockNumber: maxBundleBlocks + 1}); err != ErrBundleFuture {
+		t.Fatalf("future bundle error mismatch: have %v, want %v", err, ErrBundleFuture)
+	}
+	// Add a few valid bundles and ensure duplicates are rejected
+	first := &TxBundle{Txs: types.Transactions{transaction(0, 100000, key), transaction(1, 100000, key)}, BlockNumber: 1}
+	if err := pool.AddBundle(first); err != nil {
+		t.Fatalf("failed to add bundle: %v", err)
+	}
+	if err := pool.AddBundle(first); err != ErrBundleKnown {
+		t.Fatalf("duplicate bundle error mismatch: have %v, want %v", err, ErrBundleKnown)
+	}
+	second := &TxBundle{Txs: types.Transactions{transaction(1, 100000, key)}, MinTimestamp: 10, MaxTimestamp: 20}
+	if err := pool.AddBundle(second); err != nil {
+		t.Fatalf("failed to add bundle: %v", err)
+	}
+	// Check that bundles are only returned for their block numbers and timestamps
+	if bundles := pool.Bundles(big.NewInt(1), 15); len(bundles) != 2 {
+		t.Fatalf("includable bundle count mismatch: have %d, want %d", len(bundles), 2)
+	}
+	if bundles := pool.Bundles(big.NewInt(2), 15); len(bundles) != 1 || bundles[0] != second {
+		t.Fatalf("includable bundles mismatch: have %v, want [%v]", bundles, second)
+	}
+	if bundles := pool.Bundles(big.NewInt(1), 25); len(bundles) != 1 || bundles[0] != first {
+		t.Fatalf("includable bundles mismatch: have %v, want [%v]", bundles, first)
+	}
+	if bundles := pool.Bundles(big.NewInt(maxBundleBlocks+1), 15); len(bundles) != 0 {
+		t.Fatalf("expired bundles returned: %v", bundles)
+	}
+	// Bump the account nonce and ensure bundles with stale nonces are dropped
+	pool.currentState.SetNonce(from, 1)
+	<-pool.requestReset(nil, nil)
+
+	if bundles := pool.Bundles(big.NewInt(1), 15); len(bundles) != 1 || bundles[0] != second {
+		t.Fatalf("pruned bundles mismatch: have %v, want [%v]", bundles, second)
+	}
+	// Fill up the bundles of the sender and ensure no more are accepted
+	for i := 1; i < maxSenderBundles; i++ {
+		if err := pool.AddBundle(&TxBundle{Txs: types.Transactions{transaction(1, uint64(100000+i), key)}}); err != nil {
+			t.Fatalf("failed to add bundle %d: %v", i, err)
+		}
+	}
+	if err := pool.AddBundle(&TxBundle{Txs: types.Transactions{transaction(1, 200000, key)}}); err != ErrBundleSenderLimit {
+		t.Fatalf("sender limit error mismatch: have %v, want %v", err, ErrBundleSenderLimit)
+	}
+}
+
+// testTxFilter is an admission filter rejecting transactions with a given nonce.
+type testTxFilter uint64
+
+func (f testTxFilter) Name() string { return "test" }
+
+func (f testTxFilter) Admit(tx *types.Transaction, from common.Address, local bool) error {
+	if tx.Nonce() == uint64(f) {
+		return errors.New("test filter")
+	}
+	return nil
+}
+
+// Tests that custom and declarative admission filters are enforced by the pool
+// and that the declarative rules can be reloaded at runtime.
+func TestTransactionAdmissionFilters(t *testing.T) {
+	t.Parallel()
+
+	// Create a few funded accounts, one of them blacklisted
+	key, _ := crypto.GenerateKey()
+	black, _ := crypto.GenerateKey()
+
+	// Create the rule file and the pool enforcing it
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary rule file: %v", err)
+	}
+	rules := file.Name()
+	defer os.Remove(rules)
+
+	fmt.Fprintf(file, `{"blacklist": ["%s"], "senderRateLimit": 2, "minGasPrices": {"%s": "5"}}`, crypto.PubkeyToAddress(black.PublicKey).Hex(), common.Address{}.Hex())
+	file.Close()
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	blockchain := &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	config := testTxPoolConfig
+	config.Rules = rules
+	config.Filters = []TxFilter{testTxFilter(3)}
+
+	pool := NewTxPool(config, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+
+	for _, k := range []*ecdsa.PrivateKey{key, black} {
+		pool.currentState.AddBalance(crypto.PubkeyToAddress(k.PublicKey), big.NewInt(1000000000))
+	}
+	if rules := pool.AdmissionRules(); rules == nil || rules.SenderRateLimit != 2 {
+		t.Fatalf("admission rules mismatch: have %v", rules)
+	}
+	// Ensure all the declarative and custom filters are enforced
+	if err := pool.AddRemote(pricedTransaction(0, 100000, big.NewInt(4), key)); err != ErrDestinationUnderpriced {
+		t.Fatalf("destination price error mismatch: have %v, want %v", err, ErrDestinationUnderpriced)
+	}
+	if err := pool.AddRemote(pricedTransaction(0, 100000, big.NewInt(5), black)); err != ErrBlacklisted {
+		t.Fatalf("blacklist error mismatch: have %v, want %v", err, ErrBlacklisted)
+	}
+	if err := pool.AddRemote(pricedTransaction(3, 100000, big.NewInt(5), key)); err == nil || err.Error() != "test filter" {
+		t.Fatalf("custom filter error mismatch: have %v, want %v", err, "test filter")
+	}
+	// Ensure only the remote transactions actually added count towards the rate limit
+	if err := pool.AddRemote(pricedTransaction(0, 1, big.NewInt(5), key)); err != ErrIntrinsicGas {
+		t.Fatalf("intrinsic gas error mismatch: have %v, want %v", err, ErrIntrinsicGas)
+	}
+	for i := uint64(0); i < 2; i++ {
+		if err := pool.AddRemote(pricedTransaction(i, 100000, big.NewInt(5), key)); err != nil {
+			t.Fatalf("failed to add transaction %d: %v", i, err)
+		}
+	}
+	if err := pool.AddRemote(pricedTransaction(2, 100000, big.NewInt(5), key)); err != ErrSenderRateLimited {
+		t.Fatalf("rate limit error mismatch: have %v, want %v", err, ErrSenderRateLimited)
+	}
+	if err := pool.AddLocal(pricedTransaction(0, 100000, big.NewInt(5), black)); err != ErrBlacklisted {
+		t.Fatalf("local blacklist error mismatch: have %v, want %v", err, ErrBlacklisted)
+	}
+	// Relax the rules on disk, reload them and ensure they are not enforced any more
+	if err := ioutil.WriteFile(rules, []byte(`{"senderRateLimit": 10}`), 0600); err != nil {
+		t.Fatalf("failed to update rule file: %v", err)
+	}
+	if err := pool.ReloadAdmissionRules(); err != nil {
+		t.Fatalf("failed to reload admission rules: %v", err)
+	}
+	if err := pool.AddRemote(pricedTransaction(2, 100000, big.NewInt(1), key)); err != nil {
+		t.Fatalf("failed to add transaction after reload: %v", err)
+	}
+	if err := pool.AddRemote(pricedTransaction(0, 100000, big.NewInt(1), black)); err != nil {
+		t.Fatalf("failed to add previously blacklisted transaction: %v", err)
+	}
+	// Ensure invalid rule files are rejected without dropping the current rules
+	if err := ioutil.WriteFile(rules, []byte(`{"unknown": true}`), 0600); err != nil {
+		t.Fatalf("failed to update rule file: %v", err)
+	}
+	if err := pool.ReloadAdmissionRules(); err == nil {
+		t.Fatalf("invalid rule file accepted")
+	}
+	if rules := pool.AdmissionRules(); rules == nil || rules.SenderRateLimit != 10 {
+		t.Fatalf("admission rules mismatch: have %v", rules)
+	}
+	// Blacklist a sender with pooled transactions and ensure they are dropped
+	if err := ioutil.WriteFile(rules, []byte(fmt.Sprintf(`{"blacklist": ["%s"]}`, crypto.PubkeyToAddress(black.PublicKey).Hex())), 0600); err != nil {
+		t.Fatalf("failed to update rule file: %v", err)
+	}
+	dropped := pool.Get(pricedTransaction(0, 100000, big.NewInt(1), black).Hash())
+	if dropped == nil {
+		t.Fatalf("blacklisted transaction missing before reload")
+	}
+	if err := pool.ReloadAdmissionRules(); err != nil {
+		t.Fatalf("failed to reload admission rules: %v", err)
+	}
+	if pool.Get(dropped.Hash()) != nil {
+		t.Fatalf("blacklisted transaction retained after reload")
+	}
+	if drop := pool.DropReason(dropped.Hash()); drop == nil || drop.Reason != TxDropRejected {
+		t.Fatalf("drop reason mismatch: have %v, want %s", drop, TxDropRejected)
+	}
+	if pending, queued := pool.Stats(); pending+queued != 3 {
+		t.Fatalf("pooled transaction count mismatch: have %d, want %d", pending+queued, 3)
+	}
+}
+
+// Tests that remote transactions are journaled across restarts together with
+// their arrival times and origins, revalidated on load and bounded in size.
+func TestTransactionRemoteJournaling(t *testing.T) {
+	t.Parallel()
+
+	// Create a temporary file for the journal
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary journal: %v", err)
+	}
+	journal := file.Name()
+	defer os.Remove(journal)
+
+	file.Close()
+	os.Remove(journal)
+
+	// Create the original pool with a few remote and local transactions
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	blockchain := &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	config := testTxPoolConfig
+	config.RemoteJournal = journal
+
+	local, _ := crypto.GenerateKey()
+	first, _ := crypto.GenerateKey()
+	second, _ := crypto.GenerateKey()
+	for _, key := range []*ecdsa.PrivateKey{local, first, second} {
+		statedb.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+	}
+	pool := NewTxPool(config, params.TestChainConfig, blockchain)
+
+	if err := pool.AddLocal(pricedTransaction(0, 100000, big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	txs := []*types.Transaction{
+		pricedTransaction(0, 100000, big.NewInt(1), first),
+		pricedTransaction(1, 100000, big.NewInt(1), first),
+		pricedTransaction(3, 100000, big.NewInt(1), first),
+	}
+	for i, err := range pool.AddRemotesFrom("peer1", txs) {
+		if err != nil {
+			t.Fatalf("failed to add remote transaction %d: %v", i, err)
+		}
+	}
+	best := pricedTransaction(0, 100000, big.NewInt(2), second)
+	if err := pool.AddRemotesFrom("peer2", []*types.Transaction{best})[0]; err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	// Terminate the old pool, bump a remote nonce, create a new pool and ensure
+	// the still valid remote transactions survive
+	pool.Stop()
+	statedb.SetNonce(crypto.PubkeyToAddress(first.PublicKey), 1)
+	blockchain = &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	pool = NewTxPool(config, params.TestChainConfig, blockchain)
+
+	pending, queued := pool.Stats()
+	if pending != 2 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	}
+	if queued != 1 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	restored := pool.Get(txs[1].Hash())
+	if restored == nil {
+		t.Fatalf("journaled transaction missing")
+	}
+	if !restored.Time().Equal(txs[1].Time()) {
+		t.Errorf("arrival time mismatch: have %v, want %v", restored.Time(), txs[1].Time())
+	}
+	pool.mu.RLock()
+	origin := pool.origins[txs[1].Hash()]
+	pool.mu.RUnlock()
+	if origin != "peer1" {
+		t.Errorf("origin mismatch: have %q, want %q", origin, "peer1")
+	}
+	pool.Stop()
+
+	// Shrink the journal to a single entry and ensure the most valuable one is kept
+	blob, _ := rlp.EncodeToBytes(&remoteJournalEntry{Tx: best, Time: uint64(best.Time().UnixNano()), Origin: "peer2"})
+	config.RemoteJournalLimit = uint64(len(blob))
+
+	pool = NewTxPool(config, params.TestChainConfig, blockchain)
+	pool.Stop()
+
+	pool = NewTxPool(config, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+
+	if pending, queued = pool.Stats(); pending != 1 || queued != 0 {
+		t.Fatalf("transactions mismatched: have %d/%d, want %d/%d", pending, queued, 1, 0)
+	}
+	if pool.Get(best.Hash()) == nil {
+		t.Fatalf("most valuable transaction not journaled")
+	}
+}
+
+// Tests that journaled remote transactions are restored regardless of the rate
+// limits of the pool, but are still accounted against them.
+func TestTransactionRemoteJournalingRateLimit(t *testing.T) {
+	t.Parallel()
+
+	// Create a temporary file for the journal
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary journal: %v", err)
+	}
+	journal := file.Name()
+	defer os.Remove(journal)
+
+	file.Close()
+	os.Remove(journal)
+
+	// Create the original pool without limits and fill it with remote transactions
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	blockchain := &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	config := testTxPoolConfig
+	config.RemoteJournal = journal
+
+	key, _ := crypto.GenerateKey()
+	statedb.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1000000000))
+
+	pool := NewTxPool(config, params.TestChainConfig, blockchain)
+
+	txs := make([]*types.Transaction, 5)
+	for i := range txs {
+		txs[i] = pricedTransaction(uint64(i), 100000, big.NewInt(1), key)
+	}
+	for i, err := range pool.AddRemotesFrom("peer", txs) {
+		if err != nil {
+			t.Fatalf("failed to add remote transaction %d: %v", i, err)
+		}
+	}
+	pool.Stop()
+
+	// Restart the pool with a rate limit below the journaled transaction count
+	config.Filters = []TxFilter{NewSenderRateFilter(2, time.Minute)}
+	pool = NewTxPool(config, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+
+	for i, tx := range txs {
+		if pool.Get(tx.Hash()) == nil {
+			t.Errorf("journaled transaction %d missing", i)
+		}
+	}
+	if err := pool.AddRemotesFrom("peer", []*types.Transaction{pricedTransaction(5, 100000, big.NewInt(1), key)})[0]; err != ErrSenderRateLimited {
+		t.Fatalf("rate limit error mismatch: have %v, want %v", err, ErrSenderRateLimited)
+	}
+}
+
+// Tests that the pool remembers why transactions were dropped and announces the
+// lifecycle of its transactions.
+func TestTransactionDropReasons(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	pool.currentState.AddBalance(from, big.NewInt(1000000000))
+
+	events := make(chan TxLifecycleEvent, 16)
+	sub := pool.SubscribeTxLifecycleEvent(events)
+	defer sub.Unsubscribe()
+
+	// Add a transaction, replace it and ensure the drop is remembered
+	tx := pricedTransaction(0, 100000, big.NewInt(1), key)
+	if err := pool.addRemoteSync(tx); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	replacement := pricedTransaction(0, 100000, big.NewInt(2), key)
+	if err := pool.addRemoteSync(replacement); err != nil {
+		t.Fatalf("failed to replace transaction: %v", err)
+	}
+	drop := pool.DropReason(tx.Hash())
+	if drop == nil || drop.Reason != TxDropReplaced || drop.ReplacedBy == nil || *drop.ReplacedBy != replacement.Hash() {
+		t.Fatalf("drop record mismatch: have %+v", drop)
+	}
+	if pending, queued := pool.ContentFrom(from); len(pending) != 1 || len(queued) != 0 || pending[0].Hash() != replacement.Hash() {
+		t.Fatalf("account content mismatch: have %v/%v", pending, queued)
+	}
+	// Include the replacement on chain and ensure it's announced, but not logged
+	pool.currentState.SetNonce(from, 1)
+	<-pool.requestReset(nil, nil)
+
+	if drop := pool.DropReason(replacement.Hash()); drop != nil {
+		t.Fatalf("mined transaction logged as dropped: %+v", drop)
+	}
+	want := []TxLifecycle{
+		{Hash: tx.Hash(), Stage: TxLifecycleAdded},
+		{Hash: tx.Hash(), Stage: TxLifecyclePromoted},
+		{Hash: tx.Hash(), Stage: TxLifecycleReplaced, Reason: TxDropReplaced},
+		{Hash: replacement.Hash(), Stage: TxLifecycleAdded},
+		{Hash: replacement.Hash(), Stage: TxLifecycleMined, Reason: TxDropMined},
+	}
+	var have []TxLifecycle
+	for len(have) < len(want) {
+		select {
+		case ev := <-events:
+			have = append(have, ev.Changes...)
+		case <-time.After(time.Second):
+			t.Fatalf("lifecycle event timeout: have %d, want %d", len(have), len(want))
+		}
+	}
+	for i, change := range have {
+		if change.Hash != want[i].Hash || change.Stage != want[i].Stage || change.Reason != want[i].Reason || change.From != from {
+			t.Errorf("change %d mismatch: have %+v, want %+v", i, change, want[i])
+		}
+	}
+}
+
+// Tests that the drop log only remembers the most recent drops.
+func TestTransactionDropLog(t *testing.T) {
+	drops := newTxDropLog(2)
+	for i := byte(1); i <= 3; i++ {
+		drops.add(&TxDrop{Hash: common.Hash{i}, Reason: TxDropExpired})
+	}
+	if drops.get(common.Hash{1}) != nil {
+		t.Errorf("overwritten drop still remembered")
+	}
+	for i := byte(2); i <= 3; i++ {
+		if drops.get(common.Hash{i}) == nil {
+			t.Errorf("drop %d forgotten", i)
+		}
+	}
+}
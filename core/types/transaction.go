@@ -22,6 +22,7 @@ import (
 	"io"
 	"math/big"
 	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
@@ -37,6 +38,8 @@ var (
 
 type Transaction struct {
 	data txdata
+	time time.Time // Time first seen locally
+
 	// caches
 	hash atomic.Value
 	size atomic.Value
@@ -101,7 +104,7 @@ func newTransaction(nonce uint64, to *common.Address, amount *big.Int, gasLimit
 		d.Price.Set(gasPrice)
 	}
 
-	return &Transaction{data: d}
+	return &Transaction{data: d, time: time.Now()}
 }
 
 // ChainId returns which chain id this transaction was signed for (if at all)
@@ -134,6 +137,7 @@ func (tx *Transaction) DecodeRLP(s *rlp.Stream) error {
 	err := s.Decode(&tx.data)
 	if err == nil {
 		tx.size.Store(common.StorageSize(rlp.ListSize(size)))
+		tx.time = time.Now()
 	}
 
 	return err
@@ -168,7 +172,7 @@ func (tx *Transaction) UnmarshalJSON(input []byte) error {
 		}
 	}
 
-	*tx = Transaction{data: dec}
+	*tx = Transaction{data: dec, time: time.Now()}
 	return nil
 }
 
@@ -179,6 +183,14 @@ func (tx *Transaction) Value() *big.Int    { return new(big.Int).Set(tx.data.Amo
 func (tx *Transaction) Nonce() uint64      { return tx.data.AccountNonce }
 func (tx *Transaction) CheckNonce() bool   { return true }
 
+// Time returns the time when the transaction was first seen locally, either by
+// creating or by decoding it.
+func (tx *Transaction) Time() time.Time { return tx.time }
+
+// SetTime overrides the time when the transaction was first seen locally, e.g.
+// when restoring it from a journal.
+func (tx *Transaction) SetTime(t time.Time) { tx.time = t }
+
 // To returns the recipient address of the transaction.
 // It returns nil if the transaction is a contract creation.
 func (tx *Transaction) To() *common.Address {
@@ -240,7 +252,7 @@ func (tx *Transaction) WithSignature(signer Signer, sig []byte) (*Transaction, e
 	if err != nil {
 		return nil, err
 	}
-	cpy := &Transaction{data: tx.data}
+	cpy := &Transaction{data: tx.data, time: tx.time}
 	cpy.data.R, cpy.data.S, cpy.data.V = r, s, v
 	return cpy, nil
 }
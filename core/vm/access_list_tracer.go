@@ -0,0 +1,139 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// AccessTuple is an account along with the storage slots of it accessed by a
+// transaction.
+type AccessTuple struct {
+	Address     common.Address `json:"address"`
+	StorageKeys []common.Hash  `json:"storageKeys"`
+}
+
+// AccessList is the list of accounts and storage slots accessed by a transaction.
+type AccessList []AccessTuple
+
+// accessSet is the set of accessed accounts, each with its set of accessed slots.
+type accessSet map[common.Address]map[common.Hash]struct{}
+
+// addAddress adds an account to the set, unless it's already present.
+func (s accessSet) addAddress(addr common.Address) {
+	if _, ok := s[addr]; !ok {
+		s[addr] = make(map[common.Hash]struct{})
+	}
+}
+
+// addSlot adds a storage slot of an account to the set.
+func (s accessSet) addSlot(addr common.Address, slot common.Hash) {
+	s.addAddress(addr)
+	s[addr][slot] = struct{}{}
+}
+
+// AccessListTracer is a Tracer collecting every account and storage slot read or
+// written during execution: the sender and recipient, the targets of all the
+// calls, contract creations, balance and code queries and self destructs, and
+// all the slots loaded or stored.
+type AccessListTracer struct {
+	set accessSet
+}
+
+// NewAccessListTracer creates a new access list tracer.
+func NewAccessListTracer() *AccessListTracer {
+	return &AccessListTracer{set: make(accessSet)}
+}
+
+// CaptureStart implements the Tracer interface, adding the sender and recipient
+// of the transaction.
+func (t *AccessListTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.set.addAddress(from)
+	t.set.addAddress(to)
+	return nil
+}
+
+// CaptureState implements the Tracer interface, adding the accounts and slots
+// accessed by the opcode.
+func (t *AccessListTracer) CaptureState(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	if err != nil {
+		return nil
+	}
+	switch op {
+	case SLOAD, SSTORE:
+		if stack.len() >= 1 {
+			t.set.addSlot(contract.Address(), common.BigToHash(stack.Back(0)))
+		}
+	case BALANCE, EXTCODESIZE, EXTCODECOPY, EXTCODEHASH, SELFDESTRUCT:
+		if stack.len() >= 1 {
+			t.set.addAddress(common.BigToAddress(stack.Back(0)))
+		}
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		if stack.len() >= 2 {
+			t.set.addAddress(common.BigToAddress(stack.Back(1)))
+		}
+	case CREATE:
+		t.set.addAddress(crypto.CreateAddress(contract.Address(), env.StateDB.GetNonce(contract.Address())))
+
+	case CREATE2:
+		if stack.len() >= 4 {
+			offset, size := stack.Back(1), stack.Back(2)
+			if offset.IsUint64() && size.IsUint64() && offset.Uint64()+size.Uint64() <= uint64(memory.Len()) {
+				code := memory.GetPtr(offset.Int64(), size.Int64())
+				t.set.addAddress(crypto.CreateAddress2(contract.Address(), common.BigToHash(stack.Back(3)), crypto.Keccak256(code)))
+			}
+		}
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface, nothing is accessed by a failing
+// opcode.
+func (t *AccessListTracer) CaptureFault(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface.
+func (t *AccessListTracer) CaptureEnd(output []byte, gasUsed uint64, elapsed time.Duration, err error) error {
+	return nil
+}
+
+// AccessList returns the accessed accounts and slots, sorted for a deterministic
+// output.
+func (t *AccessListTracer) AccessList() AccessList {
+	list := make(AccessList, 0, len(t.set))
+	for addr, slots := range t.set {
+		tuple := AccessTuple{Address: addr, StorageKeys: make([]common.Hash, 0, len(slots))}
+		for slot := range slots {
+			tuple.StorageKeys = append(tuple.StorageKeys, slot)
+		}
+		sort.Slice(tuple.StorageKeys, func(i, j int) bool {
+			return bytes.Compare(tuple.StorageKeys[i][:], tuple.StorageKeys[j][:]) < 0
+		})
+		list = append(list, tuple)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return bytes.Compare(list[i].Address[:], list[j].Address[:]) < 0
+	})
+	return list
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestAccessListTracer(t *testing.T) {
+	var (
+		from     = common.HexToAddress("0xaa")
+		to       = common.HexToAddress("0xcc")
+		callee   = common.HexToAddress("0xdd")
+		queried  = common.HexToAddress("0xee")
+		canTrans = func(StateDB, common.Address, *big.Int) bool { return true }
+		transfer = func(StateDB, common.Address, common.Address, *big.Int) {}
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+
+	// SLOAD(1), SSTORE(2, 1), BALANCE(0xee), STATICCALL(gas, 0xdd, 0, 0, 0, 0)
+	statedb.SetCode(to, []byte{
+		byte(PUSH1), 0x01, byte(SLOAD), byte(POP),
+		byte(PUSH1), 0x01, byte(PUSH1), 0x02, byte(SSTORE),
+		byte(PUSH1), 0xee, byte(BALANCE), byte(POP),
+		byte(PUSH1), 0x00, byte(DUP1), byte(DUP1), byte(DUP1), byte(PUSH1), 0xdd, byte(GAS), byte(STATICCALL),
+		byte(STOP),
+	})
+	tracer := NewAccessListTracer()
+	env := NewEVM(Context{CanTransfer: canTrans, Transfer: transfer, BlockNumber: big.NewInt(0)}, statedb, params.TestChainConfig, Config{Debug: true, Tracer: tracer})
+	if _, _, err := env.Call(AccountRef(from), to, nil, 100000, new(big.Int)); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+
+	want := AccessList{
+		{Address: from, StorageKeys: []common.Hash{}},
+		{Address: to, StorageKeys: []common.Hash{common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(2))}},
+		{Address: callee, StorageKeys: []common.Hash{}},
+		{Address: queried, StorageKeys: []common.Hash{}},
+	}
+	if have := tracer.AccessList(); !reflect.DeepEqual(have, want) {
+		t.Fatalf("access list mismatch: have %v, want %v", have, want)
+	}
+}
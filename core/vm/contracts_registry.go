@@ -0,0 +1,114 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	registryLock sync.RWMutex
+	registry     = make(map[string]PrecompiledContract) // Additional precompiled contracts by name
+)
+
+// RegisterPrecompile makes a precompiled contract implementation available under
+// a name, for chain configs to activate at an address of their choice. It is
+// meant to be called from init functions, and panics if the name is taken.
+func RegisterPrecompile(name string, p PrecompiledContract) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if p == nil {
+		panic("vm: nil precompile registered as " + name)
+	}
+	if _, ok := registry[name]; ok {
+		panic("vm: precompile registered twice as " + name)
+	}
+	registry[name] = p
+}
+
+// RegisteredPrecompile returns the precompiled contract implementation registered
+// under a name, nil if none is.
+func RegisteredPrecompile(name string) PrecompiledContract {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	return registry[name]
+}
+
+// CheckPrecompiles checks that the additional precompiled contracts of a chain
+// config are well formed and their implementations registered.
+func CheckPrecompiles(config *params.ChainConfig) error {
+	if err := config.CheckPrecompiles(); err != nil {
+		return err
+	}
+	for _, precompile := range config.Precompiles {
+		if RegisteredPrecompile(precompile.Name) == nil {
+			return fmt.Errorf("precompile %q at address %x not registered", precompile.Name, precompile.Address)
+		}
+	}
+	return nil
+}
+
+// activePrecompiles returns the precompiled contracts of the fork the rules are
+// of, along with the additional ones of the chain active at the block. Chains
+// without additional ones share the default sets.
+func activePrecompiles(config *params.ChainConfig, rules params.Rules, num *big.Int) map[common.Address]PrecompiledContract {
+	precompiles := PrecompiledContractsHomestead
+	if rules.IsByzantium {
+		precompiles = PrecompiledContractsByzantium
+	}
+	if rules.IsIstanbul {
+		precompiles = PrecompiledContractsIstanbul
+	}
+	additional := config.ActivePrecompiles(num)
+	if len(additional) == 0 {
+		return precompiles
+	}
+	active := make(map[common.Address]PrecompiledContract, len(precompiles)+len(additional))
+	for address, p := range precompiles {
+		active[address] = p
+	}
+	for _, precompile := range additional {
+		p := RegisteredPrecompile(precompile.Name)
+		if p == nil {
+			p = &unregisteredPrecompile{name: precompile.Name}
+		}
+		active[precompile.Address] = p
+	}
+	return active
+}
+
+// unregisteredPrecompile stands in for an additional precompiled contract whose
+// implementation is not registered, failing every call to it. Chain configs are
+// checked on startup, so it is only ever hit by tools running arbitrary configs.
+type unregisteredPrecompile struct {
+	name string
+}
+
+func (c *unregisteredPrecompile) RequiredGas(input []byte) uint64 {
+	return 0
+}
+
+func (c *unregisteredPrecompile) Run(input []byte) ([]byte, error) {
+	return nil, fmt.Errorf("precompile %q not registered", c.name)
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ed25519Verify is a sample additional precompiled contract, verifying an
+// ed25519 signature given as (public key, signature, message).
+type ed25519Verify struct{}
+
+func (c *ed25519Verify) RequiredGas(input []byte) uint64 {
+	return 2000
+}
+
+func (c *ed25519Verify) Run(input []byte) ([]byte, error) {
+	if len(input) < ed25519.PublicKeySize+ed25519.SignatureSize {
+		return common.LeftPadBytes(nil, 32), nil
+	}
+	var (
+		key = input[:ed25519.PublicKeySize]
+		sig = input[ed25519.PublicKeySize : ed25519.PublicKeySize+ed25519.SignatureSize]
+		msg = input[ed25519.PublicKeySize+ed25519.SignatureSize:]
+	)
+	if !ed25519.Verify(key, msg, sig) {
+		return common.LeftPadBytes(nil, 32), nil
+	}
+	return common.LeftPadBytes([]byte{1}, 32), nil
+}
+
+func init() {
+	RegisterPrecompile("ed25519Verify", &ed25519Verify{})
+}
+
+// Tests that chains without additional precompiled contracts, mainnet among
+// them, keep using the default set of their fork at every block.
+func TestActivePrecompilesDefault(t *testing.T) {
+	tests := []struct {
+		config *params.ChainConfig
+		block  int64
+		want   map[common.Address]PrecompiledContract
+	}{
+		{params.MainnetChainConfig, 0, PrecompiledContractsHomestead},
+		{params.MainnetChainConfig, 4369999, PrecompiledContractsHomestead},
+		{params.MainnetChainConfig, 4370000, PrecompiledContractsByzantium},
+		{params.MainnetChainConfig, 9068999, PrecompiledContractsByzantium},
+		{params.MainnetChainConfig, 9069000, PrecompiledContractsIstanbul},
+		{params.MainnetChainConfig, 20000000, PrecompiledContractsIstanbul},
+		{params.RinkebyChainConfig, 5435345, PrecompiledContractsIstanbul},
+		{params.AllEthashProtocolChanges, 0, PrecompiledContractsIstanbul},
+	}
+	for i, tt := range tests {
+		num := big.NewInt(tt.block)
+		have := activePrecompiles(tt.config, tt.config.Rules(num), num)
+		if reflect.ValueOf(have).Pointer() != reflect.ValueOf(tt.want).Pointer() {
+			t.Errorf("test %d: block %d not using the default set", i, tt.block)
+		}
+		evm := NewEVM(Context{BlockNumber: num}, nil, tt.config, Config{})
+		for b := 0; b <= 0xff; b++ {
+			addr := common.BytesToAddress([]byte{byte(b)})
+			if have, want := evm.IsPrecompile(addr), tt.want[addr] != nil; have != want {
+				t.Errorf("test %d: precompile at %x mismatch: have %v, want %v", i, addr, have, want)
+			}
+		}
+	}
+}
+
+func TestAdditionalPrecompiles(t *testing.T) {
+	var (
+		ed25519Addr = common.HexToAddress("0x0100")
+		missingAddr = common.HexToAddress("0x0101")
+	)
+	config := *params.AllEthashProtocolChanges
+	config.Precompiles = []params.PrecompileConfig{
+		{Address: ed25519Addr, Name: "ed25519Verify", Block: big.NewInt(10)},
+		{Address: missingAddr, Name: "missing", Block: big.NewInt(10)},
+	}
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	msg := []byte("consortium")
+	input := append(append(common.CopyBytes(pub), ed25519.Sign(priv, msg)...), msg...)
+
+	call := func(block int64, addr common.Address, input []byte) ([]byte, uint64, error) {
+		statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+		vmctx := Context{
+			CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+			Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+			BlockNumber: big.NewInt(block),
+		}
+		evm := NewEVM(vmctx, statedb, &config, Config{})
+		ret, gas, err := evm.Call(AccountRef(common.Address{}), addr, input, 100000, new(big.Int))
+		return ret, 100000 - gas, err
+	}
+	// Before activation, the addresses are plain empty accounts
+	if ret, used, err := call(9, ed25519Addr, input); err != nil || len(ret) != 0 || used != 0 {
+		t.Errorf("inactive precompile called: ret %x, used %d, err %v", ret, used, err)
+	}
+	// Once active, calls run the registered implementation
+	ret, used, err := call(10, ed25519Addr, input)
+	if err != nil || !bytes.Equal(ret, common.LeftPadBytes([]byte{1}, 32)) || used != 2000 {
+		t.Errorf("valid signature mismatch: ret %x, used %d, err %v", ret, used, err)
+	}
+	input[len(input)-1] ^= 0xff
+	if ret, _, err := call(11, ed25519Addr, input); err != nil || !bytes.Equal(ret, common.LeftPadBytes(nil, 32)) {
+		t.Errorf("invalid signature mismatch: ret %x, err %v", ret, err)
+	}
+	// Unregistered implementations fail every call, the default set is kept
+	if _, used, err := call(10, missingAddr, input); err == nil || used != 100000 {
+		t.Errorf("unregistered precompile call mismatch: used %d, err %v", used, err)
+	}
+	if ret, _, err := call(10, common.BytesToAddress([]byte{4}), input); err != nil || !bytes.Equal(ret, input) {
+		t.Errorf("identity precompile mismatch: ret %x, err %v", ret, err)
+	}
+}
+
+func TestCheckPrecompiles(t *testing.T) {
+	tests := []struct {
+		precompiles []params.PrecompileConfig
+		ok          bool
+	}{
+		{nil, true},
+		{[]params.PrecompileConfig{{Address: common.HexToAddress("0x0100"), Name: "ed25519Verify", Block: common.Big0}}, true},
+		{[]params.PrecompileConfig{{Address: common.HexToAddress("0x0100"), Name: "missing", Block: common.Big0}}, false},
+		{[]params.PrecompileConfig{{Address: common.HexToAddress("0x0100"), Name: "ed25519Verify"}}, false},
+		{[]params.PrecompileConfig{{Address: common.HexToAddress("0x0100"), Block: common.Big0}}, false},
+		{[]params.PrecompileConfig{{Address: common.HexToAddress("0x09"), Name: "ed25519Verify", Block: common.Big0}}, false},
+		{[]params.PrecompileConfig{{Address: common.HexToAddress("0xff"), Name: "ed25519Verify", Block: common.Big0}}, false},
+		{[]params.PrecompileConfig{
+			{Address: common.HexToAddress("0x0100"), Name: "ed25519Verify", Block: common.Big0},
+			{Address: common.HexToAddress("0x0100"), Name: "ed25519Verify", Block: common.Big1},
+		}, false},
+	}
+	for i, tt := range tests {
+		config := *params.AllEthashProtocolChanges
+		config.Precompiles = tt.precompiles
+		if err := CheckPrecompiles(&config); (err == nil) != tt.ok {
+			t.Errorf("test %d: error mismatch: have %v, want ok %v", i, err, tt.ok)
+		}
+	}
+}
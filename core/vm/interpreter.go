@@ -32,6 +32,7 @@ type Config struct {
 	Tracer                  Tracer // Opcode logger
 	NoRecursion             bool   // Disables call, callcode, delegate call and create
 	EnablePreimageRecording bool   // Enables recording of SHA3/keccak preimages
+	NoInstructionStream     bool   // Disables the cached pre-decoded instruction streams
 
 	JumpTable [256]operation // EVM instruction table, automatically populated if unset
 
@@ -83,6 +84,8 @@ type EVMInterpreter struct {
 
 	readOnly   bool   // Whether to throw on stateful modifications
 	returnData []byte // Last CALL's return data for subsequent reuse
+
+	table uint8 // Identifier of the default instruction set in use, 0 if customised
 }
 
 // NewEVMInterpreter returns a new instance of the Interpreter.
@@ -90,23 +93,28 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 	// We use the STOP instruction whether to see
 	// the jump table was initialised. If it was not
 	// we'll set the default jump table.
+	var table uint8
 	if !cfg.JumpTable[STOP].valid {
 		var jt JumpTable
 		switch {
 		case evm.chainRules.IsIstanbul:
-			jt = istanbulInstructionSet
+			jt, table = istanbulInstructionSet, 7
 		case evm.chainRules.IsConstantinople:
-			jt = constantinopleInstructionSet
+			jt, table = constantinopleInstructionSet, 6
 		case evm.chainRules.IsByzantium:
-			jt = byzantiumInstructionSet
+			jt, table = byzantiumInstructionSet, 5
 		case evm.chainRules.IsEIP158:
-			jt = spuriousDragonInstructionSet
+			jt, table = spuriousDragonInstructionSet, 4
 		case evm.chainRules.IsEIP150:
-			jt = tangerineWhistleInstructionSet
+			jt, table = tangerineWhistleInstructionSet, 3
 		case evm.chainRules.IsHomestead:
-			jt = homesteadInstructionSet
+			jt, table = homesteadInstructionSet, 2
 		default:
-			jt = frontierInstructionSet
+			jt, table = frontierInstructionSet, 1
+		}
+		// Instruction streams are only cached for the unmodified instruction sets
+		if len(cfg.ExtraEips) > 0 {
+			table = 0
 		}
 		for i, eip := range cfg.ExtraEips {
 			if err := EnableEIP(eip, &jt); err != nil {
@@ -119,8 +127,9 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 	}
 
 	return &EVMInterpreter{
-		evm: evm,
-		cfg: cfg,
+		evm:   evm,
+		cfg:   cfg,
+		table: table,
 	}
 }
 
@@ -190,6 +199,14 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			}
 		}()
 	}
+	// Execute the pre-decoded instruction stream of the code if available,
+	// continuing opcode by opcode from wherever it bails out
+	if stream := in.instructionStream(contract); stream != nil {
+		var finished bool
+		if ret, pc, finished, err = in.runStream(stream, contract, mem, stack); finished {
+			return ret, err
+		}
+	}
 	// The Interpreter main run loop (contextual). This loop runs until either an
 	// explicit STOP, RETURN or SELFDESTRUCT is executed, an error occurred during
 	// the execution of one of the operations or until the done flag is set by the
@@ -0,0 +1,183 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// CodeProfile is the execution profile of a contract code, aggregated over all
+// the executions traced.
+type CodeProfile struct {
+	Code []byte            `json:"-"`
+	Hits map[uint64]uint64 `json:"hits"` // Number of executions of each program counter
+	Gas  map[uint64]uint64 `json:"gas"`  // Gas spent by each program counter, excluding calls
+}
+
+// profileLocation is an executed program counter of a contract code.
+type profileLocation struct {
+	hash common.Hash
+	pc   uint64
+}
+
+// profileNode is a location executed through a given call stack, with children
+// for the locations executed by the frames it called into.
+type profileNode struct {
+	loc      profileLocation
+	parent   *profileNode
+	children map[profileLocation]*profileNode
+
+	hits uint64
+	gas  uint64
+}
+
+// child returns the node of the location executed from this call stack.
+func (n *profileNode) child(loc profileLocation) *profileNode {
+	if child, ok := n.children[loc]; ok {
+		return child
+	}
+	if n.children == nil {
+		n.children = make(map[profileLocation]*profileNode)
+	}
+	child := &profileNode{loc: loc, parent: n}
+	n.children[loc] = child
+	return child
+}
+
+// profileFrame is a call frame being profiled.
+type profileFrame struct {
+	contract *Contract
+	hash     common.Hash
+	caller   *profileNode // Node of the call site in the parent frame
+	last     *profileNode // Node of the last location executed in the frame
+}
+
+// Profiler is a Tracer aggregating, over any number of transactions, how many
+// times each program counter of each contract code was executed and how much gas
+// it spent. Gas forwarded to calls is accounted to the callee, and the call stacks
+// are kept to produce profiles with inclusive costs.
+//
+// Contract codes are identified by their hash, including the init codes of
+// contract creations. The profiler is not safe for concurrent use.
+type Profiler struct {
+	codes  map[common.Hash]*CodeProfile
+	root   *profileNode
+	frames []*profileFrame
+}
+
+// NewProfiler creates a new, empty profiler.
+func NewProfiler() *Profiler {
+	return &Profiler{
+		codes: make(map[common.Hash]*CodeProfile),
+		root:  new(profileNode),
+	}
+}
+
+// CaptureStart implements the Tracer interface, starting the profiling of a new
+// transaction.
+func (p *Profiler) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	p.frames = p.frames[:0]
+	return nil
+}
+
+// CaptureState implements the Tracer interface, accounting the execution of an
+// opcode to its location.
+func (p *Profiler) CaptureState(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	if err != nil {
+		return nil
+	}
+	// Track the frame the opcode is executed in
+	if depth < len(p.frames) {
+		p.frames = p.frames[:depth]
+	}
+	for depth > len(p.frames) {
+		caller := p.root
+		if len(p.frames) > 0 {
+			caller = p.frames[len(p.frames)-1].last
+		}
+		p.frames = append(p.frames, &profileFrame{caller: caller})
+	}
+	frame := p.frames[depth-1]
+	if frame.contract != contract {
+		frame.contract, frame.hash = contract, contract.CodeHash
+		if frame.hash == (common.Hash{}) {
+			frame.hash = crypto.Keccak256Hash(contract.Code)
+		}
+	}
+	code, ok := p.codes[frame.hash]
+	if !ok {
+		code = &CodeProfile{
+			Code: common.CopyBytes(contract.Code),
+			Hits: make(map[uint64]uint64),
+			Gas:  make(map[uint64]uint64),
+		}
+		p.codes[frame.hash] = code
+	}
+	// Calls are charged the gas forwarded to the callee, which it accounts itself
+	switch op {
+	case CALL, CALLCODE, DELEGATECALL, STATICCALL:
+		if cost >= env.callGasTemp {
+			cost -= env.callGasTemp
+		}
+	}
+	code.Hits[pc]++
+	code.Gas[pc] += cost
+
+	frame.last = frame.caller.child(profileLocation{hash: frame.hash, pc: pc})
+	frame.last.hits++
+	frame.last.gas += cost
+	return nil
+}
+
+// CaptureFault implements the Tracer interface, failing opcodes are not accounted.
+func (p *Profiler) CaptureFault(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd implements the Tracer interface.
+func (p *Profiler) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	return nil
+}
+
+// Codes returns the profiles of all the contract codes executed, keyed by code
+// hash.
+func (p *Profiler) Codes() map[common.Hash]*CodeProfile {
+	return p.codes
+}
+
+// OpcodeGas returns the gas spent by each opcode over all the contract codes.
+func (p *Profiler) OpcodeGas() map[OpCode]uint64 {
+	gas := make(map[OpCode]uint64)
+	for _, code := range p.codes {
+		for pc, spent := range code.Gas {
+			gas[code.opcode(pc)] += spent
+		}
+	}
+	return gas
+}
+
+// opcode returns the opcode at a program counter, STOP if past the end of the code.
+func (c *CodeProfile) opcode(pc uint64) OpCode {
+	if pc < uint64(len(c.Code)) {
+		return OpCode(c.Code[pc])
+	}
+	return STOP
+}
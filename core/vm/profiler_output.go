@@ -0,0 +1,284 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// protobuf is a minimal protocol buffer encoder, enough to produce pprof profiles.
+type protobuf struct {
+	bytes.Buffer
+}
+
+// varint appends a base 128 varint.
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	b.WriteByte(byte(x))
+}
+
+// uint64 appends an integer field, omitted if zero.
+func (b *protobuf) uint64(tag int, x uint64) {
+	if x != 0 {
+		b.varint(uint64(tag) << 3)
+		b.varint(x)
+	}
+}
+
+// uint64s appends a packed repeated integer field.
+func (b *protobuf) uint64s(tag int, xs []uint64) {
+	var inner protobuf
+	for _, x := range xs {
+		inner.varint(x)
+	}
+	b.bytes(tag, inner.Bytes())
+}
+
+// bytes appends a length delimited field, used for strings and messages.
+func (b *protobuf) bytes(tag int, data []byte) {
+	b.varint(uint64(tag)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+// WriteProfile writes the aggregated call stacks in the gzipped protocol buffer
+// format of pprof, with the execution counts and gas spent as sample values. Each
+// contract code is a function, and its program counters are the line numbers.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	var (
+		profile protobuf
+		strs    = map[string]uint64{"": 0}
+		strList = []string{""}
+	)
+	str := func(s string) uint64 {
+		if id, ok := strs[s]; ok {
+			return id
+		}
+		strs[s] = uint64(len(strList))
+		strList = append(strList, s)
+		return strs[s]
+	}
+	valueType := func(typ, unit string) []byte {
+		var msg protobuf
+		msg.uint64(1, str(typ))
+		msg.uint64(2, str(unit))
+		return msg.Bytes()
+	}
+	profile.bytes(1, valueType("samples", "count"))
+	profile.bytes(1, valueType("gas", "gas"))
+
+	// Collect the nodes of all the call stacks in a deterministic order
+	var (
+		nodes []*profileNode
+		queue = []*profileNode{p.root}
+	)
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		children := make([]*profileNode, 0, len(node.children))
+		for _, child := range node.children {
+			children = append(children, child)
+		}
+		sort.Slice(children, func(i, j int) bool {
+			if cmp := bytes.Compare(children[i].loc.hash[:], children[j].loc.hash[:]); cmp != 0 {
+				return cmp < 0
+			}
+			return children[i].loc.pc < children[j].loc.pc
+		})
+		nodes = append(nodes, children...)
+		queue = append(queue, children...)
+	}
+	// Emit a sample per call stack, along with its locations and functions
+	var (
+		locations = make(map[profileLocation]uint64)
+		functions = make(map[common.Hash]uint64)
+	)
+	for _, node := range nodes {
+		var stack []uint64
+		for n := node; n != p.root; n = n.parent {
+			id, ok := locations[n.loc]
+			if !ok {
+				fn, ok := functions[n.loc.hash]
+				if !ok {
+					fn = uint64(len(functions) + 1)
+					functions[n.loc.hash] = fn
+
+					var function protobuf
+					function.uint64(1, fn)
+					function.uint64(2, str(n.loc.hash.Hex()))
+					function.uint64(4, str(n.loc.hash.Hex()))
+					profile.bytes(5, function.Bytes())
+				}
+				id = uint64(len(locations) + 1)
+				locations[n.loc] = id
+
+				var line, location protobuf
+				line.uint64(1, fn)
+				line.uint64(2, n.loc.pc)
+				location.uint64(1, id)
+				location.uint64(3, n.loc.pc)
+				location.bytes(4, line.Bytes())
+				profile.bytes(4, location.Bytes())
+			}
+			stack = append(stack, id)
+		}
+		var label, sample protobuf
+		label.uint64(1, str("opcode"))
+		label.uint64(2, str(p.codes[node.loc.hash].opcode(node.loc.pc).String()))
+
+		sample.uint64s(1, stack)
+		sample.uint64s(2, []uint64{node.hits, node.gas})
+		sample.bytes(3, label.Bytes())
+		profile.bytes(2, sample.Bytes())
+	}
+	for _, s := range strList {
+		profile.bytes(6, []byte(s))
+	}
+	profile.bytes(11, valueType("gas", "gas"))
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile.Bytes()); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// SourceMapEntry is the source range an instruction was compiled from.
+type SourceMapEntry struct {
+	Start  int  // Byte offset of the range in the source file
+	Length int  // Length of the range in bytes
+	File   int  // Index of the source file, -1 if none
+	Jump   byte // Whether the instruction jumps into ('i') or out of ('o') a function
+}
+
+// ParseSourceMap parses a compressed source map in the format emitted by the
+// Solidity compiler, with one entry per instruction, PUSH data excluded.
+func ParseSourceMap(srcmap string) ([]SourceMapEntry, error) {
+	var (
+		entries []SourceMapEntry
+		entry   = SourceMapEntry{File: -1, Jump: '-'}
+	)
+	if srcmap == "" {
+		return nil, nil
+	}
+	for i, item := range strings.Split(srcmap, ";") {
+		for j, field := range strings.Split(item, ":") {
+			if field == "" {
+				continue // Inherited from the previous entry
+			}
+			if j == 3 {
+				if len(field) != 1 {
+					return nil, fmt.Errorf("entry %d: invalid jump type %q", i, field)
+				}
+				entry.Jump = field[0]
+				continue
+			}
+			if j > 3 {
+				continue // Modifier depth, unused
+			}
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: %v", i, err)
+			}
+			switch j {
+			case 0:
+				entry.Start = n
+			case 1:
+				entry.Length = n
+			case 2:
+				entry.File = n
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// SourceFile is a source file referenced by a source map.
+type SourceFile struct {
+	Name    string
+	Content []byte
+}
+
+// WriteCoverage writes the line coverage of the sources a contract code was
+// compiled from in the lcov tracefile format. The source map maps instructions
+// to ranges in the sources, which are indexed by their position in the list.
+// The hit count of a line is the highest of the instructions starting on it.
+func (p *Profiler) WriteCoverage(w io.Writer, code []byte, srcmap string, sources []SourceFile) error {
+	entries, err := ParseSourceMap(srcmap)
+	if err != nil {
+		return err
+	}
+	profile := p.codes[crypto.Keccak256Hash(code)]
+	if profile == nil {
+		return errors.New("code not executed")
+	}
+	// Map the instructions to the lines they start on
+	lines := make([]map[int]uint64, len(sources))
+	for i := range lines {
+		lines[i] = make(map[int]uint64)
+	}
+	for pc, i := uint64(0), 0; pc < uint64(len(code)) && i < len(entries); pc, i = pc+1, i+1 {
+		entry := entries[i]
+		if entry.File >= 0 && entry.File < len(sources) && entry.Start <= len(sources[entry.File].Content) {
+			line := bytes.Count(sources[entry.File].Content[:entry.Start], []byte{'\n'}) + 1
+			if hits := profile.Hits[pc]; hits >= lines[entry.File][line] {
+				lines[entry.File][line] = hits
+			}
+		}
+		if op := OpCode(code[pc]); op >= PUSH1 && op <= PUSH32 {
+			pc += uint64(op - PUSH1 + 1)
+		}
+	}
+	// Write a record per source file
+	for i, source := range sources {
+		numbers := make([]int, 0, len(lines[i]))
+		for line := range lines[i] {
+			numbers = append(numbers, line)
+		}
+		sort.Ints(numbers)
+
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "TN:\nSF:%s\n", source.Name)
+		hit := 0
+		for _, line := range numbers {
+			fmt.Fprintf(&buf, "DA:%d,%d\n", line, lines[i][line])
+			if lines[i][line] > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(&buf, "LF:%d\nLH:%d\nend_of_record\n", len(numbers), hit)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
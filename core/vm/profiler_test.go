@@ -0,0 +1,153 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// runProfileTest executes the code with the profiler, along with a contract it
+// may call storing 1 in slot 0: PUSH1 0x01, PUSH1 0x00, SSTORE, STOP, PUSH1 0x02,
+// STOP. The gas used is returned.
+func runProfileTest(t *testing.T, profiler *Profiler, code []byte) uint64 {
+	var (
+		caller = common.BytesToAddress([]byte("caller"))
+		callee = common.BytesToAddress([]byte("callee"))
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	statedb.SetCode(caller, code)
+	statedb.SetCode(callee, hexutil.MustDecode("0x600160005500600200"))
+
+	vmctx := Context{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: big.NewInt(1),
+	}
+	vmenv := NewEVM(vmctx, statedb, params.AllEthashProtocolChanges, Config{Debug: true, Tracer: profiler})
+	_, left, err := vmenv.Call(AccountRef(common.Address{}), caller, nil, 1000000, new(big.Int))
+	if err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	return 1000000 - left
+}
+
+// Tests that the profiler accounts the hits and gas of each program counter,
+// charging the gas forwarded to calls to the callees.
+func TestProfiler(t *testing.T) {
+	// PUSH1 0x00 (x4), PUSH1 0x00, PUSH6 "callee", PUSH2 0xc350, CALL, POP, STOP
+	code := hexutil.MustDecode("0x60006000600060006000656361" + "6c6c6565" + "61c350f15000")
+
+	profiler := NewProfiler()
+	used := runProfileTest(t, profiler, code)
+	used += runProfileTest(t, profiler, code)
+
+	caller := profiler.Codes()[crypto.Keccak256Hash(code)]
+	if caller == nil {
+		t.Fatalf("caller code not profiled")
+	}
+	if want := map[uint64]uint64{0: 2, 2: 2, 4: 2, 6: 2, 8: 2, 10: 2, 17: 2, 20: 2, 21: 2, 22: 2}; !reflect.DeepEqual(caller.Hits, want) {
+		t.Errorf("caller hits mismatch: have %v, want %v", caller.Hits, want)
+	}
+	if gas := caller.Gas[20]; gas != 2*700 {
+		t.Errorf("call gas mismatch: have %d, want %d", gas, 2*700)
+	}
+	callee := profiler.Codes()[crypto.Keccak256Hash(hexutil.MustDecode("0x600160005500600200"))]
+	if callee == nil {
+		t.Fatalf("callee code not profiled")
+	}
+	if want := map[uint64]uint64{0: 2, 2: 2, 4: 2, 5: 2}; !reflect.DeepEqual(callee.Hits, want) {
+		t.Errorf("callee hits mismatch: have %v, want %v", callee.Hits, want)
+	}
+	var total uint64
+	for _, gas := range profiler.OpcodeGas() {
+		total += gas
+	}
+	if total != used {
+		t.Errorf("total gas mismatch: have %d, want %d", total, used)
+	}
+	if gas := profiler.OpcodeGas()[SSTORE]; gas != 2*20000 {
+		t.Errorf("SSTORE gas mismatch: have %d, want %d", gas, 2*20000)
+	}
+	// Ensure the pprof profile is well formed enough to decompress
+	var buf bytes.Buffer
+	if err := profiler.WriteProfile(&buf); err != nil {
+		t.Fatalf("failed to write profile: %v", err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("invalid profile: %v", err)
+	}
+	if blob, err := ioutil.ReadAll(gz); err != nil || len(blob) == 0 {
+		t.Fatalf("invalid profile: %v", err)
+	}
+}
+
+func TestParseSourceMap(t *testing.T) {
+	entries, err := ParseSourceMap("0:6:0:-;:;7:9;17:5:1:i;;-1:0:-1:o:1")
+	if err != nil {
+		t.Fatalf("failed to parse source map: %v", err)
+	}
+	want := []SourceMapEntry{
+		{Start: 0, Length: 6, File: 0, Jump: '-'},
+		{Start: 0, Length: 6, File: 0, Jump: '-'},
+		{Start: 7, Length: 9, File: 0, Jump: '-'},
+		{Start: 17, Length: 5, File: 1, Jump: 'i'},
+		{Start: 17, Length: 5, File: 1, Jump: 'i'},
+		{Start: -1, Length: 0, File: -1, Jump: 'o'},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("source map mismatch: have %v, want %v", entries, want)
+	}
+	if _, err := ParseSourceMap("0:1:x"); err == nil {
+		t.Errorf("invalid source map accepted")
+	}
+}
+
+// Tests that line coverage is derived from the source map of an executed code.
+func TestProfilerCoverage(t *testing.T) {
+	code := hexutil.MustDecode("0x600160005500600200")
+
+	profiler := NewProfiler()
+	runProfileTest(t, profiler, code)
+
+	var (
+		source = SourceFile{Name: "test.sol", Content: []byte("a = 1;\nstore(a);\nstop;\ndead;\n")}
+		buf    bytes.Buffer
+	)
+	if err := profiler.WriteCoverage(&buf, code, "0:6:0:-;:;7:9;17:5;23:5", []SourceFile{source}); err != nil {
+		t.Fatalf("failed to write coverage: %v", err)
+	}
+	want := "TN:\nSF:test.sol\nDA:1,1\nDA:2,1\nDA:3,1\nDA:4,0\nLF:4\nLH:3\nend_of_record\n"
+	if buf.String() != want {
+		t.Errorf("coverage mismatch:\nhave %q\nwant %q", buf.String(), want)
+	}
+	if err := profiler.WriteCoverage(&buf, []byte{0x00}, "", nil); err == nil {
+		t.Errorf("coverage of unexecuted code written")
+	}
+}
@@ -0,0 +1,454 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+// streamCacheLimit is the approximate memory allowance in bytes of the cached
+// instruction streams.
+const streamCacheLimit = 64 * 1024 * 1024
+
+// streamCache contains the instruction streams of recently executed contracts,
+// keyed by code hash and instruction set.
+var streamCache = newStreamLRU(streamCacheLimit)
+
+// streamLRU is a least recently used cache of instruction streams, bounded by
+// their summed size instead of their count, since a stream of a large contract
+// takes orders of magnitude more memory than one of a small contract.
+type streamLRU struct {
+	streams *simplelru.LRU
+	size    uint64 // Summed size of the cached streams
+	limit   uint64 // Maximum summed size of the cached streams
+	lock    sync.Mutex
+}
+
+// newStreamLRU creates an instruction stream cache holding at most limit bytes.
+func newStreamLRU(limit uint64) *streamLRU {
+	cache := &streamLRU{limit: limit}
+	cache.streams, _ = simplelru.NewLRU(math.MaxInt32, func(key, value interface{}) {
+		cache.size -= value.(*instructionStream).size
+	})
+	return cache
+}
+
+// Get retrieves a cached instruction stream, marking it as recently used.
+func (c *streamLRU) Get(key streamKey) (*instructionStream, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stream, ok := c.streams.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return stream.(*instructionStream), true
+}
+
+// Add inserts an instruction stream into the cache, evicting the least recently
+// used ones until the cache fits into its limit. Streams larger than the whole
+// cache are not cached at all.
+func (c *streamLRU) Add(key streamKey, stream *instructionStream) {
+	if stream.size > c.limit {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.streams.Contains(key) {
+		return
+	}
+	c.streams.Add(key, stream)
+	c.size += stream.size
+	for c.size > c.limit {
+		c.streams.RemoveOldest()
+	}
+}
+
+// streamKey identifies a contract code translated with a given instruction set.
+type streamKey struct {
+	hash  common.Hash
+	table uint8
+}
+
+// instructionKind is the way an instruction of a stream is executed.
+type instructionKind uint8
+
+const (
+	kindOp        instructionKind = iota // Dispatched through the jump table
+	kindInvalid                          // Undefined opcode, aborting execution
+	kindJumpdest                         // Jump destination, a no-op
+	kindPush                             // PUSH with a pre-decoded immediate value
+	kindPushJump                         // PUSH followed by JUMP to a static destination
+	kindPushJumpi                        // PUSH followed by JUMPI to a static destination
+	kindDupSwap                          // DUPn followed by SWAPm
+	kindSwapPop                          // SWAPn followed by POP
+)
+
+// instruction is a single, possibly fused, operation of an instruction stream.
+type instruction struct {
+	kind   instructionKind
+	op     OpCode   // Opcode of the instruction, the first one if fused
+	n, m   uint8    // Operands of fused DUP and SWAP instructions
+	gasOp  bool     // Whether the operation depends on or charges gas dynamically
+	pc     uint64   // Position of the instruction in the code
+	imm    *big.Int // Immediate value of PUSH instructions, never modified
+	target int      // Index of the static jump destination, -1 if invalid
+	rest   uint64   // Static gas of the instructions following in the block
+	block  *streamBlock
+}
+
+// streamBlock contains the requirements of a basic block, checked once when
+// entering the block instead of before every instruction.
+type streamBlock struct {
+	gas      uint64 // Static gas of all the instructions in the block
+	minStack int    // Minimum stack size when entering the block
+	maxStack int    // Maximum stack size when entering the block
+}
+
+// instructionStream is contract code translated into instructions, with push
+// data decoded, static gas charged per basic block and common opcode sequences
+// fused together.
+type instructionStream struct {
+	code      []instruction
+	jumpdests map[uint64]int // Instruction index of each valid jump destination
+	size      uint64         // Approximate memory footprint of the stream in bytes
+}
+
+// Approximate memory footprints of the parts of an instruction stream that are
+// not accounted for by the size of the instruction slice.
+const (
+	streamImmSize      = 64 // Big integer holding the immediate value of a push
+	streamJumpdestSize = 48 // Jump destination map entry including overhead
+)
+
+// newInstructionStream translates the code into an instruction stream for the
+// given instruction set.
+func newInstructionStream(code []byte, jt *JumpTable) *instructionStream {
+	stream := &instructionStream{jumpdests: make(map[uint64]int)}
+
+	// Decode the opcodes, marking the instructions starting basic blocks. Blocks
+	// are started by jump destinations and after any control flow change.
+	starts := []bool{}
+	newBlock := true
+	for pc := uint64(0); ; pc++ {
+		op, end := STOP, pc >= uint64(len(code)) // Execution stops past the end of the code
+		if !end {
+			op = OpCode(code[pc])
+		}
+		ins := instruction{kind: kindOp, op: op, pc: pc, target: -1}
+		operation := &jt[op]
+
+		switch {
+		case !operation.valid:
+			ins.kind = kindInvalid
+		case op == JUMPDEST:
+			ins.kind = kindJumpdest
+			newBlock = true
+		case op >= PUSH1 && op <= PUSH32:
+			size := uint64(op - PUSH1 + 1)
+			start, end := pc+1, pc+1+size
+			if start > uint64(len(code)) {
+				start = uint64(len(code))
+			}
+			if end > uint64(len(code)) {
+				end = uint64(len(code))
+			}
+			ins.kind = kindPush
+			ins.imm = new(big.Int).SetBytes(common.RightPadBytes(code[start:end], int(size)))
+			pc += size
+		default:
+			ins.gasOp = operation.dynamicGas != nil || op == GAS
+		}
+		stream.code = append(stream.code, ins)
+		starts = append(starts, newBlock)
+
+		if end {
+			break
+		}
+		newBlock = !operation.valid || operation.jumps || operation.halts || operation.reverts
+	}
+	// Fuse common sequences within blocks
+	fused := make([]instruction, 0, len(stream.code))
+	fusedStarts := make([]bool, 0, len(stream.code))
+	for i := 0; i < len(stream.code); i++ {
+		ins := stream.code[i]
+		if i+1 < len(stream.code) && !starts[i+1] {
+			next := stream.code[i+1]
+			switch {
+			case ins.kind == kindPush && next.op == JUMP:
+				ins.kind = kindPushJump
+				i++
+			case ins.kind == kindPush && next.op == JUMPI:
+				ins.kind = kindPushJumpi
+				i++
+			case ins.op >= DUP1 && ins.op <= DUP16 && next.op >= SWAP1 && next.op <= SWAP16:
+				ins.kind, ins.n, ins.m = kindDupSwap, uint8(ins.op-DUP1+1), uint8(next.op-SWAP1+1)
+				i++
+			case ins.op >= SWAP1 && ins.op <= SWAP16 && next.op == POP:
+				ins.kind, ins.n = kindSwapPop, uint8(ins.op-SWAP1+1)
+				i++
+			}
+		}
+		fused = append(fused, ins)
+		fusedStarts = append(fusedStarts, starts[i-int(countFused(ins.kind))])
+	}
+	stream.code, starts = fused, fusedStarts
+
+	// Index the jump destinations and resolve the static jumps. Only opcodes are
+	// decoded as instructions, so push data can't be mistaken for destinations.
+	for i := range stream.code {
+		if stream.code[i].kind == kindJumpdest {
+			stream.jumpdests[stream.code[i].pc] = i
+		}
+	}
+	for i := range stream.code {
+		ins := &stream.code[i]
+		if ins.kind != kindPushJump && ins.kind != kindPushJumpi {
+			continue
+		}
+		if ins.imm.BitLen() < 63 {
+			if target, ok := stream.jumpdests[ins.imm.Uint64()]; ok {
+				ins.target = target
+			}
+		}
+	}
+	// Compute the gas and stack requirements of the blocks
+	for i := 0; i < len(stream.code); {
+		var (
+			block  = &streamBlock{maxStack: int(params.StackLimit)}
+			height int
+			end    = i + 1
+		)
+		for end < len(stream.code) && !starts[end] {
+			end++
+		}
+		for j := i; j < end; j++ {
+			ins := &stream.code[j]
+			if ins.kind == kindInvalid {
+				break
+			}
+			for _, op := range ins.opcodes() {
+				operation := &jt[op]
+				block.gas += operation.constantGas
+				if min := operation.minStack - height; min > block.minStack {
+					block.minStack = min
+				}
+				if max := operation.maxStack - height; max < block.maxStack {
+					block.maxStack = max
+				}
+				height += int(params.StackLimit) - operation.maxStack
+			}
+		}
+		var rest uint64
+		for j := end - 1; j >= i; j-- {
+			stream.code[j].rest = rest
+			if stream.code[j].kind != kindInvalid {
+				for _, op := range stream.code[j].opcodes() {
+					rest += jt[op].constantGas
+				}
+			}
+		}
+		stream.code[i].block = block
+		i = end
+	}
+	// Estimate the memory used by the stream for the cache accounting
+	stream.size = uint64(len(stream.code))*uint64(unsafe.Sizeof(instruction{})) + uint64(len(stream.jumpdests))*streamJumpdestSize
+	for i := range stream.code {
+		if stream.code[i].imm != nil {
+			stream.size += streamImmSize
+		}
+		if stream.code[i].block != nil {
+			stream.size += uint64(unsafe.Sizeof(streamBlock{}))
+		}
+	}
+	return stream
+}
+
+// countFused returns the number of additional opcodes merged into an instruction
+// of the given kind.
+func countFused(kind instructionKind) int {
+	switch kind {
+	case kindPushJump, kindPushJumpi, kindDupSwap, kindSwapPop:
+		return 1
+	}
+	return 0
+}
+
+// opcodes returns the opcodes executed by the instruction.
+func (ins *instruction) opcodes() []OpCode {
+	switch ins.kind {
+	case kindPushJump:
+		return []OpCode{ins.op, JUMP}
+	case kindPushJumpi:
+		return []OpCode{ins.op, JUMPI}
+	case kindDupSwap:
+		return []OpCode{ins.op, SWAP1 + OpCode(ins.m-1)}
+	case kindSwapPop:
+		return []OpCode{ins.op, POP}
+	}
+	return []OpCode{ins.op}
+}
+
+// instructionStream returns the instruction stream of the contract code, or nil
+// if the code should be interpreted opcode by opcode. Init code of contract
+// creations is run once, so it isn't worth decoding nor polluting the cache.
+func (in *EVMInterpreter) instructionStream(contract *Contract) *instructionStream {
+	if in.cfg.Debug || in.cfg.NoInstructionStream || in.table == 0 || contract.CodeHash == (common.Hash{}) || contract.deployment {
+		return nil
+	}
+	key := streamKey{hash: contract.CodeHash, table: in.table}
+	if stream, ok := streamCache.Get(key); ok {
+		return stream
+	}
+	stream := newInstructionStream(contract.Code, (*JumpTable)(&in.cfg.JumpTable))
+	streamCache.Add(key, stream)
+	return stream
+}
+
+// runStream executes an instruction stream. The static gas and the stack bounds
+// of each block are checked when entering it, falling back to the opcode by
+// opcode execution if they don't hold, to fail at the exact same operation.
+// Gas is refunded around operations depending on it, so that they observe the
+// same amount as when charged per opcode.
+//
+// If the execution didn't finish, the program counter to continue executing the
+// code from is returned.
+func (in *EVMInterpreter) runStream(stream *instructionStream, contract *Contract, mem *Memory, stack *Stack) (ret []byte, pc uint64, finished bool, err error) {
+	code := stream.code
+	for i := 0; ; {
+		ins := &code[i]
+		if block := ins.block; block != nil {
+			if atomic.LoadInt32(&in.evm.abort) != 0 {
+				return nil, 0, true, nil
+			}
+			if sLen := stack.len(); contract.Gas < block.gas || sLen < block.minStack || sLen > block.maxStack {
+				return nil, ins.pc, false, nil
+			}
+			contract.Gas -= block.gas
+		}
+		switch ins.kind {
+		case kindPush:
+			stack.push(in.intPool.get().Set(ins.imm))
+			i++
+
+		case kindJumpdest:
+			i++
+
+		case kindPushJump:
+			if ins.target < 0 {
+				return nil, 0, true, errInvalidJump
+			}
+			i = ins.target
+
+		case kindPushJumpi:
+			cond := stack.pop()
+			if cond.Sign() != 0 {
+				if ins.target < 0 {
+					return nil, 0, true, errInvalidJump
+				}
+				i = ins.target
+			} else {
+				i++
+			}
+			in.intPool.put(cond)
+
+		case kindDupSwap:
+			stack.dup(in.intPool, int(ins.n))
+			stack.swap(int(ins.m) + 1)
+			i++
+
+		case kindSwapPop:
+			stack.swap(int(ins.n) + 1)
+			in.intPool.put(stack.pop())
+			i++
+
+		case kindInvalid:
+			return nil, 0, true, fmt.Errorf("invalid opcode 0x%x", int(ins.op))
+
+		default:
+			operation := &in.cfg.JumpTable[ins.op]
+			if in.readOnly && in.evm.chainRules.IsByzantium {
+				if operation.writes || (ins.op == CALL && stack.Back(2).Sign() != 0) {
+					return nil, 0, true, errWriteProtection
+				}
+			}
+			// Give back the gas charged in advance for the rest of the block
+			if ins.gasOp {
+				contract.Gas += ins.rest
+			}
+			var memorySize uint64
+			if operation.memorySize != nil {
+				memSize, overflow := operation.memorySize(stack)
+				if overflow {
+					return nil, 0, true, errGasUintOverflow
+				}
+				if memorySize, overflow = math.SafeMul(toWordSize(memSize), 32); overflow {
+					return nil, 0, true, errGasUintOverflow
+				}
+			}
+			if operation.dynamicGas != nil {
+				dynamicCost, err := operation.dynamicGas(in.evm, contract, stack, mem, memorySize)
+				if err != nil || !contract.UseGas(dynamicCost) {
+					return nil, 0, true, ErrOutOfGas
+				}
+			}
+			if memorySize > 0 {
+				mem.Resize(memorySize)
+			}
+			pc = ins.pc
+			res, err := operation.execute(&pc, in, contract, mem, stack)
+			if verifyPool {
+				verifyIntegerPool(in.intPool)
+			}
+			if operation.returns {
+				in.returnData = res
+			}
+			switch {
+			case err != nil:
+				return nil, 0, true, err
+			case operation.reverts:
+				return res, 0, true, errExecutionReverted
+			case operation.halts:
+				return res, 0, true, nil
+			}
+			// Charge the rest of the block again, or continue opcode by opcode
+			// if there's not enough gas left for it
+			if ins.gasOp {
+				if contract.Gas < ins.rest {
+					return nil, code[i+1].pc, false, nil
+				}
+				contract.Gas -= ins.rest
+			}
+			switch {
+			case !operation.jumps || pc == ins.pc+1:
+				i++
+			default:
+				i = stream.jumpdests[pc]
+			}
+		}
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that code is split into the correct blocks, with opcodes fused and the
+// static gas and stack requirements computed per block.
+func TestInstructionStreamAnalysis(t *testing.T) {
+	// PUSH1 0x06, JUMP, INVALID, INVALID, INVALID, JUMPDEST, DUP1, SWAP1, PUSH1 0x01,
+	// PUSH1 0x05, JUMPI, SWAP1, POP, STOP
+	code := hexutil.MustDecode("0x600656fefefe5b8090600160055790505000")
+	stream := newInstructionStream(code, &istanbulInstructionSet)
+
+	kinds := []instructionKind{kindPushJump, kindInvalid, kindInvalid, kindInvalid, kindJumpdest, kindDupSwap, kindPush, kindPushJumpi, kindSwapPop, kindOp, kindOp, kindOp}
+	if len(stream.code) != len(kinds) {
+		t.Fatalf("instruction count mismatch: have %d, want %d", len(stream.code), len(kinds))
+	}
+	for i, kind := range kinds {
+		if stream.code[i].kind != kind {
+			t.Errorf("instruction %d: kind mismatch: have %d, want %d", i, stream.code[i].kind, kind)
+		}
+	}
+	if target := stream.code[0].target; target != 4 {
+		t.Errorf("static jump target mismatch: have %d, want %d", target, 4)
+	}
+	if target := stream.code[7].target; target != -1 {
+		t.Errorf("invalid static jump target mismatch: have %d, want %d", target, -1)
+	}
+	blocks := map[int]streamBlock{
+		0:  {gas: 3 + 8, minStack: 0, maxStack: 1023},
+		1:  {gas: 0, minStack: 0, maxStack: 1024},
+		2:  {gas: 0, minStack: 0, maxStack: 1024},
+		3:  {gas: 0, minStack: 0, maxStack: 1024},
+		4:  {gas: 1 + 3 + 3 + 3 + 3 + 10, minStack: 1, maxStack: 1021},
+		8:  {gas: 3 + 2 + 2, minStack: 2, maxStack: 1024},
+		11: {gas: 0, minStack: 0, maxStack: 1024},
+	}
+	for i, ins := range stream.code {
+		want, ok := blocks[i]
+		switch {
+		case !ok && ins.block != nil:
+			t.Errorf("instruction %d: unexpected block start", i)
+		case ok && ins.block == nil:
+			t.Errorf("instruction %d: missing block start", i)
+		case ok && *ins.block != want:
+			t.Errorf("instruction %d: block mismatch: have %+v, want %+v", i, *ins.block, want)
+		}
+	}
+	if rest := stream.code[6].rest; rest != 3+10 {
+		t.Errorf("remaining block gas mismatch: have %d, want %d", rest, 3+10)
+	}
+	if pc := stream.code[len(stream.code)-1].pc; pc != uint64(len(code)) {
+		t.Errorf("terminating instruction position mismatch: have %d, want %d", pc, len(code))
+	}
+}
+
+// streamTestConfigs are the chain configurations to compare executions with.
+var streamTestConfigs = map[string]*params.ChainConfig{
+	"Frontier":  {ChainID: big.NewInt(1)},
+	"Byzantium": {ChainID: big.NewInt(1), HomesteadBlock: new(big.Int), EIP150Block: new(big.Int), EIP155Block: new(big.Int), EIP158Block: new(big.Int), ByzantiumBlock: new(big.Int)},
+	"Istanbul":  params.AllEthashProtocolChanges,
+}
+
+// streamTestPrograms are handwritten programs exercising the fast path.
+var streamTestPrograms = []string{
+	// Countdown loop storing the counter: PUSH1 0x0a, JUMPDEST, DUP1, DUP1, SSTORE,
+	// PUSH1 0x01, SWAP1, SUB, DUP1, PUSH1 0x02, JUMPI, STOP
+	"0x600a5b8080556001900380600257",
+	// Loop burning gas until the GAS opcode reports less than 0x100
+	"0x5b6101005a1160005700",
+	// Recursive call to itself with all the gas, returning the call result
+	"0x60006000600060006000305af160005260206000f3",
+	// Memory expansion, hashing and logging
+	"0x60ff6101005260206101002060005260206000a160206000f3",
+	// Reverting with data after a write
+	"0x6001600055600160005260206000fd",
+	// Jump into push data
+	"0x600456600b5b00",
+	// Stack underflow within a block
+	"0x600101",
+	// Self destruct to the caller
+	"0x33ff",
+	// Truncated push at the end of the code
+	"0x6001617f",
+}
+
+// runStreamTest executes the code with the given gas, returning a summary of the
+// outcome to compare.
+func runStreamTest(code []byte, config *params.ChainConfig, gas uint64, noStream bool) string {
+	var (
+		address = common.BytesToAddress([]byte("contract"))
+		caller  = common.BytesToAddress([]byte("caller"))
+	)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	statedb.CreateAccount(address)
+	statedb.SetCode(address, code)
+	statedb.SetState(address, common.Hash{}, common.BytesToHash([]byte{1}))
+	statedb.SetBalance(address, big.NewInt(1000))
+	statedb.Finalise(true)
+
+	vmctx := Context{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		GetHash:     func(uint64) common.Hash { return common.Hash{} },
+		BlockNumber: big.NewInt(1),
+		Time:        big.NewInt(1),
+		Difficulty:  big.NewInt(1),
+		GasLimit:    10000000,
+		GasPrice:    big.NewInt(1),
+	}
+	vmenv := NewEVM(vmctx, statedb, config, Config{NoInstructionStream: noStream})
+	ret, left, err := vmenv.Call(AccountRef(caller), address, []byte{0x01, 0x02}, gas, new(big.Int))
+
+	return fmt.Sprintf("ret=%x left=%d err=%v refund=%d logs=%d root=%x",
+		ret, left, err, statedb.GetRefund(), len(statedb.Logs()), statedb.IntermediateRoot(true))
+}
+
+// Tests that the handwritten programs behave the same whether executed opcode by
+// opcode or from instruction streams, with every gas amount up to completion.
+func TestInstructionStreamEquivalence(t *testing.T) {
+	for name, config := range streamTestConfigs {
+		for i, program := range streamTestPrograms {
+			code := hexutil.MustDecode(program)
+			for gas := uint64(0); gas < 2000; gas++ {
+				want := runStreamTest(code, config, gas, true)
+				if have := runStreamTest(code, config, gas, false); have != want {
+					t.Fatalf("%s program %d, gas %d: outcome mismatch:\nhave %s\nwant %s", name, i, gas, have, want)
+				}
+			}
+			for _, gas := range []uint64{10000, 100000, 1000000} {
+				want := runStreamTest(code, config, gas, true)
+				if have := runStreamTest(code, config, gas, false); have != want {
+					t.Fatalf("%s program %d, gas %d: outcome mismatch:\nhave %s\nwant %s", name, i, gas, have, want)
+				}
+			}
+		}
+	}
+}
+
+// randomStreamProgram generates a program made mostly of valid opcodes, jumping
+// around between its jump destinations.
+func randomStreamProgram(rnd *rand.Rand) []byte {
+	var (
+		code      []byte
+		jumpdests []int
+		jumps     []int // Positions of the push data of static jumps
+	)
+	for n := 4 + rnd.Intn(60); n > 0; n-- {
+		switch r := rnd.Intn(100); {
+		case r < 10:
+			jumpdests = append(jumpdests, len(code))
+			code = append(code, byte(JUMPDEST))
+		case r < 20:
+			jumps = append(jumps, len(code)+1)
+			code = append(code, byte(PUSH1), 0, []byte{byte(JUMP), byte(JUMPI)}[rnd.Intn(2)])
+		case r < 35:
+			code = append(code, byte(PUSH1), byte(rnd.Intn(4)))
+		case r < 45:
+			code = append(code, byte(DUP1)+byte(rnd.Intn(4)))
+		case r < 55:
+			code = append(code, byte(SWAP1)+byte(rnd.Intn(4)))
+		case r < 60:
+			code = append(code, byte(POP))
+		case r < 65:
+			code = append(code, byte(GAS))
+		case r < 68:
+			// CALL to itself forwarding some gas
+			code = append(code, byte(PUSH1), 0, byte(DUP1), byte(DUP1), byte(DUP1), byte(DUP1), byte(ADDRESS), byte(PUSH2), 0x10, 0x00, byte(CALL))
+		case r < 70:
+			code = append(code, byte(RETURN))
+		case r < 72:
+			code = append(code, byte(REVERT))
+		case r < 75:
+			code = append(code, byte(PUSH2), byte(rnd.Intn(256)), byte(rnd.Intn(256)))
+		default:
+			ops := []OpCode{ADD, SUB, MUL, LT, ISZERO, MSTORE, MLOAD, SSTORE, SLOAD, SHA3, PC, MSIZE, CALLDATALOAD, LOG0, BALANCE, EXTCODEHASH, SHL, RETURNDATASIZE, RETURNDATACOPY, STOP}
+			code = append(code, byte(ops[rnd.Intn(len(ops))]))
+		}
+	}
+	for _, pos := range jumps {
+		if len(jumpdests) > 0 && rnd.Intn(10) > 0 {
+			code[pos] = byte(jumpdests[rnd.Intn(len(jumpdests))])
+		} else {
+			code[pos] = byte(rnd.Intn(len(code)))
+		}
+	}
+	return code
+}
+
+// Tests that random programs behave the same whether executed opcode by opcode
+// or from instruction streams.
+func TestInstructionStreamEquivalenceRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		code := randomStreamProgram(rnd)
+		for name, config := range streamTestConfigs {
+			for _, gas := range []uint64{uint64(rnd.Intn(100)), uint64(rnd.Intn(1000)), uint64(rnd.Intn(10000)), 50000} {
+				want := runStreamTest(code, config, gas, true)
+				if have := runStreamTest(code, config, gas, false); have != want {
+					t.Fatalf("%s program %x, gas %d: outcome mismatch:\nhave %s\nwant %s", name, code, gas, have, want)
+				}
+			}
+		}
+	}
+}
+
+// Tests that the instruction stream cache is bounded by the summed size of the
+// streams, evicting the least recently used ones.
+func TestInstructionStreamCacheLimit(t *testing.T) {
+	small := newInstructionStream(hexutil.MustDecode("0x6001600201"), &istanbulInstructionSet)
+	large := newInstructionStream(make([]byte, 1024), &istanbulInstructionSet)
+	if small.size == 0 || large.size <= small.size {
+		t.Fatalf("stream sizes mismatch: small %d, large %d", small.size, large.size)
+	}
+	cache := newStreamLRU(large.size + small.size)
+
+	cache.Add(streamKey{hash: common.Hash{1}}, small)
+	cache.Add(streamKey{hash: common.Hash{2}}, small)
+	cache.Get(streamKey{hash: common.Hash{1}})
+	cache.Add(streamKey{hash: common.Hash{3}}, large)
+
+	if _, ok := cache.Get(streamKey{hash: common.Hash{2}}); ok {
+		t.Errorf("least recently used stream not evicted")
+	}
+	for _, hash := range []common.Hash{{1}, {3}} {
+		if _, ok := cache.Get(streamKey{hash: hash}); !ok {
+			t.Errorf("stream %x evicted", hash[:1])
+		}
+	}
+	if cache.size != large.size+small.size {
+		t.Errorf("cache size mismatch: have %d, want %d", cache.size, large.size+small.size)
+	}
+	// Streams larger than the whole cache must be rejected
+	cache = newStreamLRU(large.size - 1)
+	cache.Add(streamKey{hash: common.Hash{1}}, large)
+	if cache.size != 0 || cache.streams.Len() != 0 {
+		t.Errorf("oversized stream cached")
+	}
+}
+
+// Tests that the init code of contract creations is not cached as a stream.
+func TestInstructionStreamSkipsInitCode(t *testing.T) {
+	// Init code returning an empty contract: PUSH1 0x00, DUP1, RETURN
+	code := hexutil.MustDecode("0x600080f3")
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	vmctx := Context{
+		CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+		Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+		BlockNumber: big.NewInt(1),
+	}
+	vmenv := NewEVM(vmctx, statedb, params.AllEthashProtocolChanges, Config{})
+	if _, _, _, err := vmenv.Create(AccountRef(common.Address{}), code, 100000, new(big.Int)); err != nil {
+		t.Fatalf("failed to create contract: %v", err)
+	}
+	key := streamKey{hash: crypto.Keccak256Hash(code), table: vmenv.interpreter.(*EVMInterpreter).table}
+	if _, ok := streamCache.Get(key); ok {
+		t.Fatalf("init code cached as instruction stream")
+	}
+}
+
+func BenchmarkInstructionStream(b *testing.B) {
+	// Countdown loop from 0xffff: PUSH2 0xffff, JUMPDEST, PUSH1 0x01, SWAP1, SUB,
+	// DUP1, PUSH1 0x03, JUMPI, STOP
+	code := hexutil.MustDecode("0x61ffff5b60019003806003570000")
+	for _, noStream := range []bool{true, false} {
+		name := "stream"
+		if noStream {
+			name = "classic"
+		}
+		b.Run(name, func(b *testing.B) {
+			address := common.BytesToAddress([]byte("contract"))
+			statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+			statedb.CreateAccount(address)
+			statedb.SetCode(address, code)
+
+			vmctx := Context{
+				CanTransfer: func(StateDB, common.Address, *big.Int) bool { return true },
+				Transfer:    func(StateDB, common.Address, common.Address, *big.Int) {},
+				BlockNumber: big.NewInt(1),
+			}
+			vmenv := NewEVM(vmctx, statedb, params.AllEthashProtocolChanges, Config{NoInstructionStream: noStream})
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := vmenv.Call(AccountRef(common.Address{}), address, nil, 10000000, new(big.Int)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
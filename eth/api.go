@@ -40,6 +40,10 @@ import (
 	"github.com/ethereum/go-ethereum/trie"
 )
 
+// errReadOnly is returned if a modification is requested from a node running on
+// top of a read-only database.
+var errReadOnly = errors.New("node is running on a read-only database")
+
 // PublicEthereumAPI provides an API to access Ethereum full node-related
 // information.
 type PublicEthereumAPI struct {
@@ -154,6 +158,106 @@ func (api *PrivateMinerAPI) GetHashrate() uint64 {
 	return api.e.miner.HashRate()
 }
 
+// BlockTemplate is the starting point of an externally built block.
+type BlockTemplate struct {
+	ParentHash common.Hash    `json:"parentHash"`
+	Number     *hexutil.Big   `json:"number"`
+	Timestamp  hexutil.Uint64 `json:"timestamp"`
+	GasLimit   hexutil.Uint64 `json:"gasLimit"`
+	Coinbase   common.Address `json:"miner"`
+	Difficulty *hexutil.Big   `json:"difficulty"`
+	Extra      hexutil.Bytes  `json:"extraData"`
+	StateRoot  common.Hash    `json:"stateRoot"` // Pending state the transactions execute on
+}
+
+// GetBlockTemplate returns the header fields of a new block on top of the current
+// chain head, for an external builder to fill with transactions.
+func (api *PrivateMinerAPI) GetBlockTemplate() (*BlockTemplate, error) {
+	header, parent, err := api.e.Miner().BlockTemplate()
+	if err != nil {
+		return nil, err
+	}
+	return &BlockTemplate{
+		ParentHash: header.ParentHash,
+		Number:     (*hexutil.Big)(header.Number),
+		Timestamp:  hexutil.Uint64(header.Time),
+		GasLimit:   hexutil.Uint64(header.GasLimit),
+		Coinbase:   header.Coinbase,
+		Difficulty: (*hexutil.Big)(header.Difficulty),
+		Extra:      header.Extra,
+		StateRoot:  parent.Root(),
+	}, nil
+}
+
+// SubmitBlockArgs is an externally built block to seal.
+type SubmitBlockArgs struct {
+	ParentHash   common.Hash     `json:"parentHash"`
+	Timestamp    hexutil.Uint64  `json:"timestamp"`
+	Transactions []hexutil.Bytes `json:"transactions"` // RLP encoded transactions in execution order
+}
+
+// SubmitBlockResult is the outcome of executing an externally built block.
+type SubmitBlockResult struct {
+	Header   *types.Header    `json:"header"`
+	SealHash common.Hash      `json:"sealHash"`
+	Receipts []*types.Receipt `json:"receipts"`
+}
+
+// SubmitBlock executes the given transactions in order on top of the requested
+// parent and hands the resulting block to the consensus engine for sealing. Once
+// sealed, the block is imported and broadcast like any locally mined one. If any
+// of the transactions fails, the block is rejected.
+func (api *PrivateMinerAPI) SubmitBlock(args SubmitBlockArgs) (*SubmitBlockResult, error) {
+	if api.e.config.ReadOnly {
+		return nil, errReadOnly
+	}
+	txs := make(types.Transactions, len(args.Transactions))
+	for i, blob := range args.Transactions {
+		tx := new(types.Transaction)
+		if err := rlp.DecodeBytes(blob, tx); err != nil {
+			return nil, fmt.Errorf("invalid transaction %d: %v", i, err)
+		}
+		txs[i] = tx
+	}
+	block, receipts, err := api.e.Miner().SubmitBlock(args.ParentHash, uint64(args.Timestamp), txs)
+	if err != nil {
+		return nil, err
+	}
+	return &SubmitBlockResult{
+		Header:   block.Header(),
+		SealHash: api.e.engine.SealHash(block.Header()),
+		Receipts: receipts,
+	}, nil
+}
+
+// PrivateTxPoolAPI is the collection of transaction pool related APIs exposed
+// over the private txpool endpoint.
+type PrivateTxPoolAPI struct {
+	eth *Ethereum
+}
+
+// NewPrivateTxPoolAPI creates a new API definition for the private transaction
+// pool methods of the Ethereum service.
+func NewPrivateTxPoolAPI(eth *Ethereum) *PrivateTxPoolAPI {
+	return &PrivateTxPoolAPI{eth: eth}
+}
+
+// AdmissionRules returns the declarative transaction admission rules currently
+// enforced by the pool.
+func (api *PrivateTxPoolAPI) AdmissionRules() *core.TxAdmissionRules {
+	return api.eth.TxPool().AdmissionRules()
+}
+
+// ReloadAdmissionRules reloads the declarative transaction admission rules from
+// the rule file configured on startup. Pooled transactions rejected by the new
+// rules are dropped.
+func (api *PrivateTxPoolAPI) ReloadAdmissionRules() (bool, error) {
+	if err := api.eth.TxPool().ReloadAdmissionRules(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // PrivateAdminAPI is the collection of Ethereum full node-related APIs
 // exposed over the private admin endpoint.
 type PrivateAdminAPI struct {
@@ -215,6 +319,15 @@ func hasAllBlocks(chain *core.BlockChain, bs []*types.Block) bool {
 	return true
 }
 
+// RefreshDatabase updates the view of a read-only database to the data persisted
+// by its owner node since the last refresh, moving the chain head along.
+func (api *PrivateAdminAPI) RefreshDatabase() (bool, error) {
+	if err := api.eth.RefreshDatabase(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // ImportChain imports a blockchain from a local file.
 func (api *PrivateAdminAPI) ImportChain(file string) (bool, error) {
 	// Make sure the can access the file to import
@@ -0,0 +1,153 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package eth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ProfileConfig holds extra parameters to profiling functions.
+type ProfileConfig struct {
+	Reexec *uint64
+	Pprof  bool // Whether to return a pprof profile of the gas spent per call stack
+}
+
+// profileResult is the execution profile of a range of blocks.
+type profileResult struct {
+	Codes   map[common.Hash]*vm.CodeProfile `json:"codes"`           // Hits and gas per program counter of each code
+	Opcodes map[string]uint64               `json:"opcodes"`         // Gas spent by each opcode
+	Pprof   hexutil.Bytes                   `json:"pprof,omitempty"` // Gzipped pprof profile of the gas per call stack
+}
+
+// ProfileChain executes all the transactions of the blocks between start and
+// end (both included), and returns how many times each program counter of each
+// contract code was executed and how much gas it spent. Optionally, a pprof
+// profile with the gas spent per call stack is returned too.
+//
+// The state of the parent of the first block is computed once (re-executing up
+// to reexec blocks if needed), and the range is then executed on top of it.
+func (api *PrivateDebugAPI) ProfileChain(ctx context.Context, start, end rpc.BlockNumber, config *ProfileConfig) (*profileResult, error) {
+	from, to := api.blockByNumber(start), api.blockByNumber(end)
+	if from == nil {
+		return nil, fmt.Errorf("starting block #%d not found", start)
+	}
+	if to == nil {
+		return nil, fmt.Errorf("end block #%d not found", end)
+	}
+	if from.NumberU64() > to.NumberU64() {
+		return nil, fmt.Errorf("end block (#%d) needs to come after start block (#%d)", end, start)
+	}
+	reexec := defaultTraceReexec
+	if config != nil && config.Reexec != nil {
+		reexec = *config.Reexec
+	}
+	var (
+		profiler = vm.NewProfiler()
+		statedb  *state.StateDB
+	)
+	for number := from.NumberU64(); number <= to.NumberU64(); number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if number == 0 {
+			continue // The genesis block has no transactions
+		}
+		block := to // May be the pending block, not retrievable by number
+		if number != to.NumberU64() {
+			block = api.eth.blockchain.GetBlockByNumber(number)
+		}
+		if block == nil {
+			return nil, fmt.Errorf("block #%d not found", number)
+		}
+		// Retrieve the state of the first block's parent, later ones build on it
+		if statedb == nil {
+			parent := api.eth.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+			if parent == nil {
+				return nil, fmt.Errorf("parent %#x not found", block.ParentHash())
+			}
+			var err error
+			if statedb, err = api.computeStateDB(parent, reexec); err != nil {
+				return nil, err
+			}
+		}
+		if err := api.profileBlock(block, statedb, profiler); err != nil {
+			return nil, err
+		}
+	}
+	result := &profileResult{
+		Codes:   profiler.Codes(),
+		Opcodes: make(map[string]uint64),
+	}
+	for op, gas := range profiler.OpcodeGas() {
+		result.Opcodes[op.String()] = gas
+	}
+	if config != nil && config.Pprof {
+		var dump bytes.Buffer
+		if err := profiler.WriteProfile(&dump); err != nil {
+			return nil, err
+		}
+		result.Pprof = dump.Bytes()
+	}
+	return result, nil
+}
+
+// profileBlock executes all the transactions of a block on top of its parent
+// state, accounting them into the profiler. The block rewards are applied too,
+// leaving the state ready for profiling the next block.
+func (api *PrivateDebugAPI) profileBlock(block *types.Block, statedb *state.StateDB, profiler *vm.Profiler) error {
+	signer := types.MakeSigner(api.eth.blockchain.Config(), block.Number())
+	for _, tx := range block.Transactions() {
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			return fmt.Errorf("transaction %#x invalid: %v", tx.Hash(), err)
+		}
+		vmctx := core.NewEVMContext(msg, block.Header(), api.eth.blockchain, nil)
+
+		vmenv := vm.NewEVM(vmctx, statedb, api.eth.blockchain.Config(), vm.Config{Debug: true, Tracer: profiler})
+		if _, _, _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.Gas())); err != nil {
+			return fmt.Errorf("transaction %#x failed: %v", tx.Hash(), err)
+		}
+		// Finalize the state so any modifications are written to the trie
+		// Only delete empty objects if EIP158/161 (a.k.a Spurious Dragon) is in effect
+		statedb.Finalise(vmenv.ChainConfig().IsEIP158(block.Number()))
+	}
+	api.eth.engine.Finalize(api.eth.blockchain, block.Header(), statedb, block.Transactions(), block.Uncles())
+	return nil
+}
+
+// blockByNumber retrieves a block by number, resolving the pending and latest
+// placeholders.
+func (api *PrivateDebugAPI) blockByNumber(number rpc.BlockNumber) *types.Block {
+	switch number {
+	case rpc.PendingBlockNumber:
+		return api.eth.miner.PendingBlock()
+	case rpc.LatestBlockNumber:
+		return api.eth.blockchain.CurrentBlock()
+	default:
+		return api.eth.blockchain.GetBlockByNumber(uint64(number))
+	}
+}
@@ -25,12 +25,14 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/bft"
 	"github.com/ethereum/go-ethereum/consensus/clique"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
@@ -70,6 +72,14 @@ type Ethereum struct {
 	// Channel for shutting down the service
 	shutdownChan chan bool
 
+	// Read-only database refresh loop
+	refreshQuit chan struct{}  // Channel for terminating the refresh loop
+	refreshWg   sync.WaitGroup // Wait group tracking the refresh loop
+
+	// Clique signer monitoring loop
+	monitorQuit chan struct{}  // Channel for terminating the monitor loop
+	monitorWg   sync.WaitGroup // Wait group tracking the monitor loop
+
 	// Handlers
 	txPool          *core.TxPool
 	blockchain      *core.BlockChain
@@ -140,9 +150,23 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
-	chainConfig, genesisHash, genesisErr := core.SetupGenesisBlockWithOverride(chainDb, config.Genesis, config.OverrideIstanbul, config.OverrideMuirGlacier)
-	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
-		return nil, genesisErr
+	var (
+		chainConfig *params.ChainConfig
+		genesisHash common.Hash
+		genesisErr  error
+	)
+	if config.ReadOnly {
+		// The database is owned by another node, use its chain configuration as is
+		genesisHash = rawdb.ReadCanonicalHash(chainDb, 0)
+		if chainConfig = rawdb.ReadChainConfig(chainDb, genesisHash); chainConfig == nil {
+			chainDb.Close()
+			return nil, errors.New("missing chain configuration in read-only database")
+		}
+	} else {
+		chainConfig, genesisHash, genesisErr = core.SetupGenesisBlockWithOverride(chainDb, config.Genesis, config.OverrideIstanbul, config.OverrideMuirGlacier)
+		if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
+			return nil, genesisErr
+		}
 	}
 	log.Info("Initialised chain configuration", "config", chainConfig)
 
@@ -153,6 +177,8 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 		accountManager: ctx.AccountManager,
 		engine:         CreateConsensusEngine(ctx, chainConfig, &config.Ethash, config.Miner.Notify, config.Miner.Noverify, chainDb),
 		shutdownChan:   make(chan bool),
+		refreshQuit:    make(chan struct{}),
+		monitorQuit:    make(chan struct{}),
 		networkID:      config.NetworkId,
 		gasPrice:       config.Miner.GasPrice,
 		etherbase:      config.Miner.Etherbase,
@@ -170,6 +196,8 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	if !config.SkipBcVersionCheck {
 		if bcVersion != nil && *bcVersion > core.BlockChainVersion {
 			return nil, fmt.Errorf("database version is v%d, Geth %s only supports v%d", *bcVersion, params.VersionWithMeta, core.BlockChainVersion)
+		} else if (bcVersion == nil || *bcVersion < core.BlockChainVersion) && config.ReadOnly {
+			return nil, fmt.Errorf("database version is v%s, read-only access requires v%d", dbVer, core.BlockChainVersion)
 		} else if bcVersion == nil || *bcVersion < core.BlockChainVersion {
 			log.Warn("Upgrade blockchain database version", "from", dbVer, "to", core.BlockChainVersion)
 			rawdb.WriteDatabaseVersion(chainDb, core.BlockChainVersion)
@@ -187,6 +215,11 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 			TrieDirtyLimit:      config.TrieDirtyCache,
 			TrieDirtyDisabled:   config.NoPruning,
 			TrieTimeLimit:       config.TrieTimeout,
+			ReverseDiffs:        config.StateDiffs,
+			ReverseDiffLimit:    config.StateDiffLimit,
+			TxLookupLimit:       config.TxLookupLimit,
+			ReadOnly:            config.ReadOnly,
+			ParallelExec:        config.ParallelExec,
 		}
 	)
 	eth.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, chainConfig, eth.engine, vmConfig, eth.shouldPreserve)
@@ -199,11 +232,20 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 		eth.blockchain.SetHead(compat.RewindTo)
 		rawdb.WriteChainConfig(chainDb, genesisHash, chainConfig)
 	}
-	eth.bloomIndexer.Start(eth.blockchain)
+	// Bloom bits are maintained by the database owner in read-only mode
+	if !config.ReadOnly {
+		eth.bloomIndexer.Start(eth.blockchain)
+	}
 
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
 	}
+	if config.TxPool.RemoteJournal != "" {
+		config.TxPool.RemoteJournal = ctx.ResolvePath(config.TxPool.RemoteJournal)
+	}
+	if config.TxPool.Rules != "" {
+		config.TxPool.Rules = ctx.ResolvePath(config.TxPool.Rules)
+	}
 	eth.txPool = core.NewTxPool(config.TxPool, chainConfig, eth.blockchain)
 
 	eth.fragpool = reedsolomon.NewFragPool()
@@ -260,6 +302,9 @@ func CreateConsensusEngine(ctx *node.ServiceContext, chainConfig *params.ChainCo
 	if chainConfig.Clique != nil {
 		return clique.New(chainConfig.Clique, db)
 	}
+	if chainConfig.BFT != nil {
+		return bft.New(chainConfig.BFT, db)
+	}
 	// Otherwise assume proof-of-work
 	switch config.PowMode {
 	case ethash.ModeFake:
@@ -279,6 +324,9 @@ func CreateConsensusEngine(ctx *node.ServiceContext, chainConfig *params.ChainCo
 			DatasetDir:     config.DatasetDir,
 			DatasetsInMem:  config.DatasetsInMem,
 			DatasetsOnDisk: config.DatasetsOnDisk,
+
+			StratumAddr:       config.StratumAddr,
+			StratumDifficulty: config.StratumDifficulty,
 		}, notify, noverify)
 		engine.SetThreads(-1) // Disable CPU mining
 		return engine
@@ -329,6 +377,10 @@ func (s *Ethereum) APIs() []rpc.API {
 			Version:   "1.0",
 			Service:   filters.NewPublicFilterAPI(s.APIBackend, false),
 			Public:    true,
+		}, {
+			Namespace: "txpool",
+			Version:   "1.0",
+			Service:   NewPrivateTxPoolAPI(s),
 		}, {
 			Namespace: "admin",
 			Version:   "1.0",
@@ -478,6 +530,14 @@ func (s *Ethereum) StartMining(threads int) error {
 			}
 			clique.Authorize(eb, wallet.SignData)
 		}
+		if bft, ok := s.engine.(*bft.BFT); ok {
+			wallet, err := s.accountManager.Find(accounts.Account{Address: eb})
+			if wallet == nil || err != nil {
+				log.Error("Etherbase account unavailable locally", "err", err)
+				return fmt.Errorf("validator missing: %v", err)
+			}
+			bft.Authorize(eb, wallet.SignData)
+		}
 		// If mining is started, we can disable the transaction rejection mechanism
 		// introduced to speed sync times.
 		atomic.StoreUint32(&s.protocolManager.acceptTxs, 1)
@@ -528,6 +588,9 @@ func (s *Ethereum) Protocols() []p2p.Protocol {
 	if s.lesServer != nil {
 		protos = append(protos, s.lesServer.Protocols()...)
 	}
+	if handler, ok := s.engine.(consensus.Handler); ok {
+		protos = append(protos, handler.Protocols()...)
+	}
 	return protos
 }
 
@@ -555,12 +618,92 @@ func (s *Ethereum) Start(srvr *p2p.Server) error {
 	if s.lesServer != nil {
 		s.lesServer.Start(srvr)
 	}
+	// Follow the database owner if running read-only
+	if s.config.ReadOnly && s.config.ReadOnlyRefresh > 0 {
+		s.refreshWg.Add(1)
+		go s.refreshLoop(s.config.ReadOnlyRefresh)
+	}
+	// Track the sealing performance of the signers if running proof-of-authority
+	if engine, ok := s.engine.(*clique.Clique); ok {
+		engine.SetMissedSlotWarnings(s.config.Miner.WarnMissed)
+
+		s.monitorWg.Add(1)
+		go s.monitorLoop(engine)
+	}
+	// Run the consensus protocol if finalizing blocks via byzantine agreement
+	if engine, ok := s.engine.(*bft.BFT); ok {
+		if err := engine.Start(s.blockchain); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// RefreshDatabase updates the view of a read-only database to the data persisted
+// by its owner node since the last refresh, moving the chain head along.
+func (s *Ethereum) RefreshDatabase() error {
+	if !s.config.ReadOnly {
+		return errors.New("database is not read-only")
+	}
+	refresher, ok := s.chainDb.(ethdb.Refresher)
+	if !ok {
+		return errors.New("database does not support refreshing")
+	}
+	if err := refresher.Refresh(); err != nil {
+		return err
+	}
+	return s.blockchain.Reload()
+}
+
+// refreshLoop periodically refreshes the view of a read-only database until the
+// service is stopped.
+func (s *Ethereum) refreshLoop(interval time.Duration) {
+	defer s.refreshWg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.RefreshDatabase(); err != nil {
+				log.Warn("Failed to refresh read-only database", "err", err)
+			}
+		case <-s.refreshQuit:
+			return
+		}
+	}
+}
+
+// monitorLoop feeds the new chain heads into the clique signer monitor until the
+// service is stopped.
+func (s *Ethereum) monitorLoop(engine *clique.Clique) {
+	defer s.monitorWg.Done()
+
+	heads := make(chan core.ChainHeadEvent, 16)
+	sub := s.blockchain.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case head := <-heads:
+			engine.TrackHead(s.blockchain, head.Block.Header())
+		case <-sub.Err():
+			return
+		case <-s.monitorQuit:
+			return
+		}
+	}
+}
+
 // Stop implements node.Service, terminating all internal goroutines used by the
 // Ethereum protocol.
 func (s *Ethereum) Stop() error {
+	close(s.refreshQuit)
+	s.refreshWg.Wait()
+	close(s.monitorQuit)
+	s.monitorWg.Wait()
+
 	s.bloomIndexer.Close()
 	s.blockchain.Stop()
 	s.engine.Close()
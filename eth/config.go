@@ -55,6 +55,7 @@ var DefaultConfig = Config{
 		GasCeil:  8000000,
 		GasPrice: big.NewInt(params.GWei),
 		Recommit: 3 * time.Second,
+		Ordering: miner.OrderingPrice,
 	},
 	TxPool: core.DefaultTxPoolConfig,
 	GPO: gasprice.Config{
@@ -97,6 +98,18 @@ type Config struct {
 
 	NoPruning  bool // Whether to disable pruning and flush everything to disk
 	NoPrefetch bool // Whether to disable prefetching and only load state on demand
+	StateDiffs bool // Whether to record reverse state diffs for historical state access
+
+	StateDiffLimit uint64 `toml:",omitempty"` // The maximum number of blocks from head whose reverse state diffs are retained.
+
+	ParallelExec bool // Whether to execute block transactions speculatively in parallel
+
+	TxLookupLimit uint64 `toml:",omitempty"` // The maximum number of blocks from head whose tx indices are reserved.
+
+	// ReadOnly runs the node on top of the database of another live node, serving
+	// only the data persisted by that node without syncing or accepting transactions.
+	ReadOnly        bool          `toml:"-"`
+	ReadOnlyRefresh time.Duration `toml:"-"` // Interval of following the database owner (0 = only on demand)
 
 	// Whitelist of required block number -> hash values to accept
 	Whitelist map[uint64]common.Hash `toml:"-"`
@@ -23,6 +23,12 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		SyncMode                downloader.SyncMode
 		NoPruning               bool
 		NoPrefetch              bool
+		StateDiffs              bool
+		StateDiffLimit          uint64 `toml:",omitempty"`
+		ParallelExec            bool
+		TxLookupLimit           uint64                 `toml:",omitempty"`
+		ReadOnly                bool                   `toml:"-"`
+		ReadOnlyRefresh         time.Duration          `toml:"-"`
 		Whitelist               map[uint64]common.Hash `toml:"-"`
 		LightServ               int                    `toml:",omitempty"`
 		LightIngress            int                    `toml:",omitempty"`
@@ -56,6 +62,12 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.SyncMode = c.SyncMode
 	enc.NoPruning = c.NoPruning
 	enc.NoPrefetch = c.NoPrefetch
+	enc.StateDiffs = c.StateDiffs
+	enc.StateDiffLimit = c.StateDiffLimit
+	enc.ParallelExec = c.ParallelExec
+	enc.TxLookupLimit = c.TxLookupLimit
+	enc.ReadOnly = c.ReadOnly
+	enc.ReadOnlyRefresh = c.ReadOnlyRefresh
 	enc.Whitelist = c.Whitelist
 	enc.LightServ = c.LightServ
 	enc.LightIngress = c.LightIngress
@@ -93,6 +105,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		SyncMode                *downloader.SyncMode
 		NoPruning               *bool
 		NoPrefetch              *bool
+		StateDiffs              *bool
+		StateDiffLimit          *uint64 `toml:",omitempty"`
+		ParallelExec            *bool
+		TxLookupLimit           *uint64                `toml:",omitempty"`
+		ReadOnly                *bool                  `toml:"-"`
+		ReadOnlyRefresh         *time.Duration         `toml:"-"`
 		Whitelist               map[uint64]common.Hash `toml:"-"`
 		LightServ               *int                   `toml:",omitempty"`
 		LightIngress            *int                   `toml:",omitempty"`
@@ -139,6 +157,24 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.NoPrefetch != nil {
 		c.NoPrefetch = *dec.NoPrefetch
 	}
+	if dec.StateDiffs != nil {
+		c.StateDiffs = *dec.StateDiffs
+	}
+	if dec.StateDiffLimit != nil {
+		c.StateDiffLimit = *dec.StateDiffLimit
+	}
+	if dec.ParallelExec != nil {
+		c.ParallelExec = *dec.ParallelExec
+	}
+	if dec.TxLookupLimit != nil {
+		c.TxLookupLimit = *dec.TxLookupLimit
+	}
+	if dec.ReadOnly != nil {
+		c.ReadOnly = *dec.ReadOnly
+	}
+	if dec.ReadOnlyRefresh != nil {
+		c.ReadOnlyRefresh = *dec.ReadOnlyRefresh
+	}
 	if dec.Whitelist != nil {
 		c.Whitelist = dec.Whitelist
 	}
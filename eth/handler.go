@@ -577,7 +577,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 
 				txs := make([]*types.Transaction, 0)
 				txs = append(txs, &tx)
-				errs := pm.txpool.AddRemotes(txs) // do not need
+				errs := pm.txpool.AddRemotesFrom(p.id, txs) // do not need
 				for _, err = range errs {
 					if err != nil {
 						log.Error("Error in TxFragMsg", "error:", err)
@@ -1202,7 +1202,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 			p.MarkTransaction(tx.Hash())
 		}
-		pm.txpool.AddRemotes(txs)
+		pm.txpool.AddRemotesFrom(p.id, txs)
 
 	default:
 		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
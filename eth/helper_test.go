@@ -110,6 +110,12 @@ func (p *testTxPool) AddRemotes(txs []*types.Transaction) []error {
 	return make([]error, len(txs))
 }
 
+// AddRemotesFrom appends a batch of transactions to the pool, ignoring their
+// origin peer.
+func (p *testTxPool) AddRemotesFrom(origin string, txs []*types.Transaction) []error {
+	return p.AddRemotes(txs)
+}
+
 // Pending returns all the transactions known to the pool
 func (p *testTxPool) Pending() (map[common.Address]types.Transactions, error) {
 	p.lock.RLock()
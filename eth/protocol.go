@@ -99,8 +99,9 @@ var errorToString = map[int]string{
 }
 
 type txPool interface {
-	// AddRemotes should add the given transactions to the pool.
-	AddRemotes([]*types.Transaction) []error
+	// AddRemotesFrom should add the given transactions received from the given
+	// peer to the pool.
+	AddRemotesFrom(string, []*types.Transaction) []error
 
 	// Pending should return pending transactions.
 	// The slice should be modifiable by the caller.
@@ -0,0 +1,108 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// ResultTracer is a transaction tracer which can be interrupted and which
+// aggregates its trace into a JSON result. Both the JavaScript and the native
+// tracers implement it.
+type ResultTracer interface {
+	vm.Tracer
+
+	// GetResult returns the JSON encoded result of the trace, or any error that
+	// occurred during tracing.
+	GetResult() (json.RawMessage, error)
+
+	// Stop terminates execution of the tracer at the first opportune moment.
+	Stop(err error)
+}
+
+// TxTracer is implemented by tracers which need to access the state before the
+// transaction executes, instead of gathering it lazily from the opcodes.
+type TxTracer interface {
+	// CaptureTxStart is called with the state and the execution context of the
+	// transaction before it is applied.
+	CaptureTxStart(statedb vm.StateDB, vmctx vm.Context, msg core.Message)
+}
+
+// natives contains all the built in Go tracers by name. Those mirroring one of
+// the JavaScript tracers (callTracer, prestateTracer and 4byteTracer) are
+// registered under the same name and replace it: requesting one of those names
+// runs the native version, producing the same output. The JavaScript versions
+// remain available by passing their source code as the tracer.
+var natives = map[string]func() ResultTracer{
+	"callTracer":         newCallTracer,
+	"prestateTracer":     newPrestateTracer,
+	"prestateDiffTracer": newPrestateDiffTracer,
+	"4byteTracer":        newFourByteTracer,
+	"opcountTracer":      newOpcountTracer,
+}
+
+// NewNative instantiates a new native tracer by name, returning false if no
+// Go implementation exists with the requested name.
+func NewNative(name string) (ResultTracer, bool) {
+	if ctor, ok := natives[name]; ok {
+		return ctor(), true
+	}
+	return nil, false
+}
+
+// interrupter implements the interruption mechanism shared by the native tracers.
+type interrupter struct {
+	interrupt uint32       // Atomic flag to signal execution interruption
+	reason    atomic.Value // Textual reason for the interruption
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (i *interrupter) Stop(err error) {
+	i.reason.Store(&err)
+	atomic.StoreUint32(&i.interrupt, 1)
+}
+
+// stopReason returns the error the tracer was stopped with, if any.
+func (i *interrupter) stopReason() error {
+	if reason, ok := i.reason.Load().(*error); ok {
+		return *reason
+	}
+	return nil
+}
+
+// interrupted returns whether the tracer was stopped.
+func (i *interrupter) interrupted() bool {
+	return atomic.LoadUint32(&i.interrupt) > 0
+}
+
+// memorySlice returns a copy of the requested range of memory, or nil if it's
+// out of bounds, matching the memory accessor of the JavaScript tracers.
+func memorySlice(memory *vm.Memory, offset, size *big.Int) []byte {
+	if !offset.IsUint64() || !size.IsUint64() {
+		return nil
+	}
+	off, n := offset.Uint64(), size.Uint64()
+	if off+n < off || uint64(memory.Len()) < off+n {
+		return nil
+	}
+	return memory.GetCopy(int64(off), int64(n))
+}
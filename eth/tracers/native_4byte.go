@@ -0,0 +1,105 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// fourByteTracer is a native Go implementation of 4byte_tracer.js, counting the
+// 4 byte method identifiers and call data sizes of all the calls made by a
+// transaction.
+type fourByteTracer struct {
+	interrupter
+
+	ids   map[string]int // Number of calls per method id and call data size
+	fault error
+}
+
+// newFourByteTracer creates a native 4byte tracer.
+func newFourByteTracer() ResultTracer {
+	return &fourByteTracer{ids: make(map[string]int)}
+}
+
+// store counts a call with the given method id and call data size.
+func (t *fourByteTracer) store(id []byte, size string) {
+	t.ids[hexutil.Encode(id)+"-"+size]++
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *fourByteTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	if len(input) >= 4 {
+		t.store(input[:4], strconv.Itoa(len(input)-4))
+	}
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *fourByteTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.fault != nil {
+		return nil
+	}
+	if t.interrupted() {
+		t.fault = t.stopReason()
+		return nil
+	}
+	// Skip anything but calls to non-precompiled contracts
+	var ptr int
+	switch op {
+	case vm.CALL, vm.CALLCODE:
+		ptr = 3
+	case vm.DELEGATECALL, vm.STATICCALL:
+		ptr = 2
+	default:
+		return nil
+	}
+	if env.IsPrecompile(common.BigToAddress(stack.Back(1))) {
+		return nil
+	}
+	offset, size := stack.Back(ptr), stack.Back(ptr+1)
+	if size.Cmp(big.NewInt(4)) >= 0 {
+		t.store(memorySlice(memory, offset, big.NewInt(4)), new(big.Int).Sub(size, big.NewInt(4)).String())
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *fourByteTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *fourByteTracer) CaptureEnd(output []byte, gasUsed uint64, elapsed time.Duration, err error) error {
+	return nil
+}
+
+// GetResult returns the method identifier counts, or any error that was
+// encountered during tracing.
+func (t *fourByteTracer) GetResult() (json.RawMessage, error) {
+	if t.fault != nil {
+		return nil, t.fault
+	}
+	return json.Marshal(t.ids)
+}
@@ -0,0 +1,261 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// callFrame is a single call in the call tree assembled by the call tracer. The
+// exported fields are the ones surfaced in the result, the rest are internal
+// bookkeeping while the call is running.
+type callFrame struct {
+	Type    string       `json:"type"`
+	From    string       `json:"from,omitempty"`
+	To      string       `json:"to,omitempty"`
+	Value   string       `json:"value,omitempty"`
+	Gas     string       `json:"gas,omitempty"`
+	GasUsed string       `json:"gasUsed,omitempty"`
+	Input   string       `json:"input,omitempty"`
+	Output  string       `json:"output,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Time    string       `json:"time,omitempty"`
+	Calls   []*callFrame `json:"calls,omitempty"`
+
+	gasIn     uint64   // Gas available before the call opcode
+	gasCost   uint64   // Gas cost of the call opcode
+	allowance *big.Int // Gas available inside the call, nil if never entered
+	outOff    *big.Int // Memory offset of the call output
+	outLen    *big.Int // Memory size of the call output
+}
+
+// callTracer is a native Go implementation of call_tracer.js, assembling the
+// tree of internal calls made by a transaction.
+type callTracer struct {
+	interrupter
+
+	callstack  []*callFrame // Calls currently being executed, the root first
+	descended  bool         // Whether the last opcode entered a new call
+	typ        string       // Type of the top level call (CALL or CREATE)
+	from, to   common.Address
+	input      []byte
+	gas, used  uint64
+	value      *big.Int
+	output     []byte
+	elapsed    time.Duration
+	err, fault error
+}
+
+// newCallTracer creates a native call tracer.
+func newCallTracer() ResultTracer {
+	return &callTracer{callstack: []*callFrame{{}}}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *callTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.typ = "CALL"
+	if create {
+		t.typ = "CREATE"
+	}
+	t.from, t.to, t.input, t.gas, t.value = from, to, input, gas, value
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *callTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.fault != nil {
+		return nil
+	}
+	if t.interrupted() {
+		t.fault = t.stopReason()
+		return nil
+	}
+	if err != nil {
+		t.captureFault(err)
+		return nil
+	}
+	// Open a new frame on any call or contract creation
+	switch op {
+	case vm.CREATE, vm.CREATE2:
+		t.callstack = append(t.callstack, &callFrame{
+			Type:    op.String(),
+			From:    hexutil.Encode(contract.Address().Bytes()),
+			Input:   hexutil.Encode(memorySlice(memory, stack.Back(1), stack.Back(2))),
+			Value:   hexutil.EncodeBig(stack.Back(0)),
+			gasIn:   gas,
+			gasCost: cost,
+		})
+		t.descended = true
+		return nil
+
+	case vm.SELFDESTRUCT:
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, &callFrame{Type: op.String()})
+		return nil
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		to := common.BigToAddress(stack.Back(1))
+		if env.IsPrecompile(to) {
+			return nil
+		}
+		off := 1
+		if op == vm.DELEGATECALL || op == vm.STATICCALL {
+			off = 0
+		}
+		call := &callFrame{
+			Type:    op.String(),
+			From:    hexutil.Encode(contract.Address().Bytes()),
+			To:      hexutil.Encode(to.Bytes()),
+			Input:   hexutil.Encode(memorySlice(memory, stack.Back(2+off), stack.Back(3+off))),
+			gasIn:   gas,
+			gasCost: cost,
+			outOff:  new(big.Int).Set(stack.Back(4 + off)),
+			outLen:  new(big.Int).Set(stack.Back(5 + off)),
+		}
+		if off == 1 {
+			call.Value = hexutil.EncodeBig(stack.Back(2))
+		}
+		t.callstack = append(t.callstack, call)
+		t.descended = true
+		return nil
+	}
+	// If we've just descended into an inner call, retrieve its true allowance
+	if t.descended {
+		if depth >= len(t.callstack) {
+			t.callstack[len(t.callstack)-1].allowance = new(big.Int).SetUint64(gas)
+		}
+		t.descended = false
+	}
+	if op == vm.REVERT {
+		t.callstack[len(t.callstack)-1].Error = "execution reverted"
+		return nil
+	}
+	// If we've just returned from an inner call, pop and finalize its frame
+	if depth == len(t.callstack)-1 {
+		call := t.callstack[len(t.callstack)-1]
+		t.callstack = t.callstack[:len(t.callstack)-1]
+
+		ret := stack.Back(0)
+		if call.Type == vm.CREATE.String() || call.Type == vm.CREATE2.String() {
+			used := new(big.Int).SetUint64(call.gasIn)
+			used.Sub(used, new(big.Int).SetUint64(call.gasCost))
+			used.Sub(used, new(big.Int).SetUint64(gas))
+			call.GasUsed = "0x" + used.Text(16)
+
+			if ret.Sign() != 0 {
+				addr := common.BigToAddress(ret)
+				call.To = hexutil.Encode(addr.Bytes())
+				call.Output = hexutil.Encode(env.StateDB.GetCode(addr))
+			} else if call.Error == "" {
+				call.Error = "internal failure"
+			}
+		} else if call.allowance != nil {
+			used := new(big.Int).SetUint64(call.gasIn)
+			used.Sub(used, new(big.Int).SetUint64(call.gasCost))
+			used.Add(used, call.allowance)
+			used.Sub(used, new(big.Int).SetUint64(gas))
+			call.GasUsed = "0x" + used.Text(16)
+
+			if ret.Sign() != 0 {
+				call.Output = hexutil.Encode(memorySlice(memory, call.outOff, call.outLen))
+			} else if call.Error == "" {
+				call.Error = "internal failure"
+			}
+		}
+		if call.allowance != nil {
+			call.Gas = "0x" + call.allowance.Text(16)
+		}
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, call)
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *callTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.fault == nil {
+		t.captureFault(err)
+	}
+	return nil
+}
+
+// captureFault marks the currently executing call as failed, unless it's already
+// marked so (e.g. reverted).
+func (t *callTracer) captureFault(err error) {
+	if t.callstack[len(t.callstack)-1].Error != "" {
+		return
+	}
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	call.Error = err.Error()
+	if call.allowance != nil {
+		call.Gas = "0x" + call.allowance.Text(16)
+		call.GasUsed = call.Gas
+	}
+	if len(t.callstack) > 0 {
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, call)
+		return
+	}
+	t.callstack = append(t.callstack, call)
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *callTracer) CaptureEnd(output []byte, gasUsed uint64, elapsed time.Duration, err error) error {
+	t.output, t.used, t.elapsed, t.err = output, gasUsed, elapsed, err
+	return nil
+}
+
+// GetResult returns the call tree of the transaction, or any error that was
+// encountered during tracing.
+func (t *callTracer) GetResult() (json.RawMessage, error) {
+	if t.fault != nil {
+		return nil, t.fault
+	}
+	value := "0x0"
+	if t.value != nil {
+		value = hexutil.EncodeBig(t.value)
+	}
+	result := &callFrame{
+		Type:    t.typ,
+		From:    hexutil.Encode(t.from.Bytes()),
+		To:      hexutil.Encode(t.to.Bytes()),
+		Value:   value,
+		Gas:     hexutil.EncodeUint64(t.gas),
+		GasUsed: hexutil.EncodeUint64(t.used),
+		Input:   hexutil.Encode(t.input),
+		Output:  hexutil.Encode(t.output),
+		Time:    t.elapsed.String(),
+		Calls:   t.callstack[0].Calls,
+		Error:   t.callstack[0].Error,
+	}
+	if result.Error == "" && t.err != nil {
+		result.Error = t.err.Error()
+	}
+	if result.Error != "" {
+		result.Output = ""
+	}
+	return json.Marshal(result)
+}
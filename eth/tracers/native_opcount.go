@@ -0,0 +1,78 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// opcountTracer is a native Go implementation of opcount_tracer.js, counting
+// the number of opcodes executed by a transaction.
+type opcountTracer struct {
+	interrupter
+
+	count int
+	fault error
+}
+
+// newOpcountTracer creates a native opcount tracer.
+func newOpcountTracer() ResultTracer {
+	return new(opcountTracer)
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *opcountTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *opcountTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.fault != nil {
+		return nil
+	}
+	if t.interrupted() {
+		t.fault = t.stopReason()
+		return nil
+	}
+	t.count++
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *opcountTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *opcountTracer) CaptureEnd(output []byte, gasUsed uint64, elapsed time.Duration, err error) error {
+	return nil
+}
+
+// GetResult returns the number of executed opcodes, or any error that was
+// encountered during tracing.
+func (t *opcountTracer) GetResult() (json.RawMessage, error) {
+	if t.fault != nil {
+		return nil, t.fault
+	}
+	return json.Marshal(t.count)
+}
@@ -0,0 +1,274 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// prestateAccount is the state of an account before the traced transaction.
+type prestateAccount struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   uint64                      `json:"nonce"`
+	Code    hexutil.Bytes               `json:"code"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// diffAccount is the part of an account's state modified by a transaction, all
+// fields being omitted if unchanged.
+type diffAccount struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Nonce   *uint64                     `json:"nonce,omitempty"`
+	Code    *hexutil.Bytes              `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// stateDiff is the result of the prestate tracer in diff mode, containing the
+// modified accounts before and after the transaction.
+type stateDiff struct {
+	Pre  map[common.Address]*diffAccount `json:"pre"`
+	Post map[common.Address]*diffAccount `json:"post"`
+}
+
+// prestateTracer is a native Go implementation of prestate_tracer.js, gathering
+// the state of all the accounts and storage slots touched by a transaction, as
+// it was before the transaction executed.
+//
+// In diff mode, the tracer instead returns the state of the modified accounts
+// and storage slots both before and after the transaction.
+type prestateTracer struct {
+	interrupter
+
+	prestate map[common.Address]*prestateAccount // Touched accounts, nil until the first opcode
+	db       vm.StateDB                          // State database to look up the accounts in
+	diff     bool                                // Whether to return the state diff instead
+	create   bool                                // Whether the transaction creates a contract
+	from, to common.Address
+	value    *big.Int
+	fault    error
+}
+
+// newPrestateTracer creates a native prestate tracer.
+func newPrestateTracer() ResultTracer {
+	return new(prestateTracer)
+}
+
+// newPrestateDiffTracer creates a native prestate tracer in diff mode.
+func newPrestateDiffTracer() ResultTracer {
+	return &prestateTracer{diff: true}
+}
+
+// lookupAccount retrieves the state of an account, unless already gathered.
+func (t *prestateTracer) lookupAccount(addr common.Address) {
+	if _, ok := t.prestate[addr]; ok {
+		return
+	}
+	t.prestate[addr] = &prestateAccount{
+		Balance: (*hexutil.Big)(new(big.Int).Set(t.db.GetBalance(addr))),
+		Nonce:   t.db.GetNonce(addr),
+		Code:    common.CopyBytes(t.db.GetCode(addr)),
+		Storage: make(map[common.Hash]common.Hash),
+	}
+}
+
+// lookupStorage retrieves a storage slot of an account, unless already gathered.
+func (t *prestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	t.lookupAccount(addr)
+	if _, ok := t.prestate[addr].Storage[key]; ok {
+		return
+	}
+	t.prestate[addr].Storage[key] = t.db.GetState(addr, key)
+}
+
+// CaptureTxStart implements the TxTracer interface to gather the accounts of the
+// sender, recipient and coinbase before the transaction modifies them. In plain
+// mode the tracer sticks to the lazy lookups of its JavaScript counterpart.
+func (t *prestateTracer) CaptureTxStart(statedb vm.StateDB, vmctx vm.Context, msg core.Message) {
+	if !t.diff {
+		return
+	}
+	t.db = statedb
+	t.prestate = make(map[common.Address]*prestateAccount)
+
+	t.lookupAccount(msg.From())
+	if to := msg.To(); to != nil {
+		t.lookupAccount(*to)
+	} else {
+		t.lookupAccount(crypto.CreateAddress(msg.From(), msg.Nonce()))
+	}
+	t.lookupAccount(vmctx.Coinbase)
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *prestateTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.create, t.from, t.to, t.value = create, from, to, value
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *prestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if t.fault != nil {
+		return nil
+	}
+	if t.interrupted() {
+		t.fault = t.stopReason()
+		return nil
+	}
+	t.db = env.StateDB
+	if t.prestate == nil {
+		t.prestate = make(map[common.Address]*prestateAccount)
+		t.lookupAccount(contract.Address())
+	}
+	switch op {
+	case vm.EXTCODECOPY, vm.EXTCODESIZE, vm.BALANCE:
+		t.lookupAccount(common.BigToAddress(stack.Back(0)))
+
+	case vm.CREATE:
+		from := contract.Address()
+		t.lookupAccount(crypto.CreateAddress(from, env.StateDB.GetNonce(from)))
+
+	case vm.CREATE2:
+		from := contract.Address()
+		code := memorySlice(memory, stack.Back(1), stack.Back(2))
+		t.lookupAccount(crypto.CreateAddress2(from, common.BigToHash(stack.Back(3)), crypto.Keccak256(code)))
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.lookupAccount(common.BigToAddress(stack.Back(1)))
+
+	case vm.SSTORE, vm.SLOAD:
+		t.lookupStorage(contract.Address(), common.BigToHash(stack.Back(0)))
+
+	case vm.SELFDESTRUCT:
+		if t.diff {
+			t.lookupAccount(common.BigToAddress(stack.Back(0)))
+		}
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *prestateTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *prestateTracer) CaptureEnd(output []byte, gasUsed uint64, elapsed time.Duration, err error) error {
+	return nil
+}
+
+// GetResult returns the pre-transaction state of the touched accounts, or any
+// error that was encountered during tracing.
+func (t *prestateTracer) GetResult() (json.RawMessage, error) {
+	if t.fault != nil {
+		return nil, t.fault
+	}
+	if t.diff {
+		return json.Marshal(t.stateDiff())
+	}
+	// If no code was executed there's no state to look the accounts up in
+	if t.prestate == nil {
+		return json.RawMessage("{}"), nil
+	}
+	// Rewind the value transfer and the nonce bump of the transaction itself
+	t.lookupAccount(t.from)
+
+	value := t.value
+	if value == nil {
+		value = new(big.Int)
+	}
+	from := t.prestate[t.from]
+	fromBal := new(big.Int).Add(from.Balance.ToInt(), value)
+
+	if to, ok := t.prestate[t.to]; ok {
+		to.Balance = (*hexutil.Big)(new(big.Int).Sub(to.Balance.ToInt(), value))
+	}
+	from.Balance = (*hexutil.Big)(fromBal)
+	from.Nonce--
+
+	if t.create {
+		delete(t.prestate, t.to)
+	}
+	return json.Marshal(t.prestate)
+}
+
+// stateDiff compares the gathered accounts against their current state, which
+// is the state after the transaction, collecting the modified fields.
+func (t *prestateTracer) stateDiff() *stateDiff {
+	diff := &stateDiff{
+		Pre:  make(map[common.Address]*diffAccount),
+		Post: make(map[common.Address]*diffAccount),
+	}
+	for addr, prev := range t.prestate {
+		// Retrieve the post state of the account, destructed ones being empty
+		var (
+			suicided = t.db.HasSuicided(addr)
+			balance  = new(big.Int)
+			nonce    uint64
+			code     []byte
+		)
+		if !suicided {
+			balance = t.db.GetBalance(addr)
+			nonce = t.db.GetNonce(addr)
+			code = t.db.GetCode(addr)
+		}
+		pre, post := new(diffAccount), new(diffAccount)
+		modified := false
+
+		if prev.Balance.ToInt().Cmp(balance) != 0 {
+			pre.Balance, post.Balance = prev.Balance, (*hexutil.Big)(new(big.Int).Set(balance))
+			modified = true
+		}
+		if prev.Nonce != nonce {
+			preNonce, postNonce := prev.Nonce, nonce
+			pre.Nonce, post.Nonce = &preNonce, &postNonce
+			modified = true
+		}
+		if !bytes.Equal(prev.Code, code) {
+			preCode, postCode := prev.Code, hexutil.Bytes(common.CopyBytes(code))
+			pre.Code, post.Code = &preCode, &postCode
+			modified = true
+		}
+		for key, val := range prev.Storage {
+			var current common.Hash
+			if !suicided {
+				current = t.db.GetState(addr, key)
+			}
+			if current == val {
+				continue
+			}
+			if pre.Storage == nil {
+				pre.Storage, post.Storage = make(map[common.Hash]common.Hash), make(map[common.Hash]common.Hash)
+			}
+			pre.Storage[key], post.Storage[key] = val, current
+			modified = true
+		}
+		if modified {
+			diff.Pre[addr], diff.Post[addr] = pre, post
+		}
+	}
+	return diff
+}
@@ -277,7 +277,8 @@ func (cw *contractWrapper) pushObject(vm *duktape.Context) {
 // Tracer provides an implementation of Tracer that evaluates a Javascript
 // function for each VM execution step.
 type Tracer struct {
-	inited bool // Flag whether the context was already inited from the EVM
+	inited bool    // Flag whether the context was already inited from the EVM
+	env    *vm.EVM // EVM traced, resolving the precompiled contracts once inited
 
 	vm *duktape.Context // Javascript VM instance
 
@@ -390,8 +391,13 @@ func New(code string) (*Tracer, error) {
 		return 1
 	})
 	tracer.vm.PushGlobalGoFunction("isPrecompiled", func(ctx *duktape.Context) int {
-		_, ok := vm.PrecompiledContractsIstanbul[common.BytesToAddress(popSlice(ctx))]
-		ctx.PushBoolean(ok)
+		addr := common.BytesToAddress(popSlice(ctx))
+		if tracer.env != nil {
+			ctx.PushBoolean(tracer.env.IsPrecompile(addr))
+		} else {
+			_, ok := vm.PrecompiledContractsIstanbul[addr]
+			ctx.PushBoolean(ok)
+		}
 		return 1
 	})
 	tracer.vm.PushGlobalGoFunction("slice", func(ctx *duktape.Context) int {
@@ -537,6 +543,7 @@ func (jst *Tracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost
 		// Initialize the context if it wasn't done yet
 		if !jst.inited {
 			jst.ctx["block"] = env.BlockNumber.Uint64()
+			jst.env = env
 			jst.inited = true
 		}
 		// If tracing was interrupted, set the error and stop
@@ -20,6 +20,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/rand"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"math/big"
 	"path/filepath"
@@ -199,6 +200,142 @@ func TestPrestateTracerCreate2(t *testing.T) {
 	}
 }
 
+// Tests that the native prestate tracer picks up the accounts created by CREATE2.
+func TestPrestateTracerCreate2Native(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	var (
+		origin   = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+		signer   = types.NewEIP155Signer(big.NewInt(1))
+	)
+	tx, err := types.SignTx(types.NewTransaction(1, contract, new(big.Int), 5000000, big.NewInt(1), []byte{}), signer, key)
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	// The code deploys 'deadbeef' with CREATE2 and salt 'cafebabe', the same as
+	// in the JavaScript tracer test above
+	statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), core.GenesisAlloc{
+		contract: {
+			Nonce:   1,
+			Code:    hexutil.MustDecode("0x63deadbeef60005263cafebabe6004601c6000F560005260206000F3"),
+			Balance: big.NewInt(1),
+		},
+		origin: {Nonce: 1, Balance: big.NewInt(500000000000000)},
+	})
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		BlockNumber: new(big.Int).SetUint64(8000000),
+		Time:        new(big.Int).SetUint64(5),
+		Difficulty:  big.NewInt(0x30000),
+		GasLimit:    uint64(6000000),
+		GasPrice:    big.NewInt(1),
+	}
+	tracer, _ := NewNative("prestateTracer")
+	evm := vm.NewEVM(context, statedb, params.MainnetChainConfig, vm.Config{Debug: true, Tracer: tracer})
+
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, _, _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	ret := make(map[string]interface{})
+	if err := json.Unmarshal(res, &ret); err != nil {
+		t.Fatalf("failed to unmarshal trace result: %v", err)
+	}
+	if _, has := ret["0x60f3f640a8508fc6a86d45df051962668e1e8ac7"]; !has {
+		t.Fatalf("Expected 0x60f3f640a8508fc6a86d45df051962668e1e8ac7 in result")
+	}
+}
+
+// Tests that the prestate tracer in diff mode reports only the modified fields
+// and storage slots of the touched accounts, before and after the transaction.
+func TestPrestateDiffTracer(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	var (
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		contract = common.HexToAddress("0x00000000000000000000000000000000deadbeef")
+		coinbase = common.HexToAddress("0x00000000000000000000000000000000c0ffee00")
+		signer   = types.NewEIP155Signer(big.NewInt(1))
+	)
+	tx, err := types.SignTx(types.NewTransaction(1, contract, big.NewInt(10), 100000, big.NewInt(1), nil), signer, key)
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	// The contract overwrites slot 0 and reads slot 1 without modifying it
+	alloc := core.GenesisAlloc{
+		contract: {
+			Code: hexutil.MustDecode("0x60026000556001545000"),
+			Storage: map[common.Hash]common.Hash{
+				common.HexToHash("0x00"): common.HexToHash("0x01"),
+				common.HexToHash("0x01"): common.HexToHash("0x01"),
+			},
+			Balance: new(big.Int),
+		},
+		sender: {Nonce: 1, Balance: big.NewInt(500000000000000)},
+	}
+	statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), alloc)
+
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      sender,
+		Coinbase:    coinbase,
+		BlockNumber: new(big.Int).SetUint64(8000000),
+		Time:        new(big.Int).SetUint64(5),
+		Difficulty:  big.NewInt(0x30000),
+		GasLimit:    uint64(6000000),
+		GasPrice:    big.NewInt(1),
+	}
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	tracer, _ := NewNative("prestateDiffTracer")
+	tracer.(TxTracer).CaptureTxStart(statedb, context, msg)
+
+	evm := vm.NewEVM(context, statedb, params.MainnetChainConfig, vm.Config{Debug: true, Tracer: tracer})
+	_, gas, _, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	diff := new(stateDiff)
+	if err := json.Unmarshal(res, diff); err != nil {
+		t.Fatalf("failed to unmarshal trace result: %v", err)
+	}
+	// Only the three modified accounts should be reported
+	if len(diff.Pre) != 3 || len(diff.Post) != 3 {
+		t.Fatalf("modified account count mismatch: have %d/%d, want 3/3: %s", len(diff.Pre), len(diff.Post), res)
+	}
+	fee := new(big.Int).SetUint64(gas)
+	if pre, post := diff.Pre[sender], diff.Post[sender]; pre.Balance.ToInt().Cmp(alloc[sender].Balance) != 0 ||
+		new(big.Int).Sub(pre.Balance.ToInt(), post.Balance.ToInt()).Cmp(new(big.Int).Add(fee, big.NewInt(10))) != 0 ||
+		*pre.Nonce != 1 || *post.Nonce != 2 || pre.Code != nil || pre.Storage != nil {
+		t.Errorf("sender diff mismatch: %s", res)
+	}
+	slot := common.HexToHash("0x00")
+	if pre, post := diff.Pre[contract], diff.Post[contract]; pre.Balance.ToInt().Sign() != 0 || post.Balance.ToInt().Cmp(big.NewInt(10)) != 0 ||
+		pre.Nonce != nil || pre.Code != nil || len(pre.Storage) != 1 || len(post.Storage) != 1 ||
+		pre.Storage[slot] != common.HexToHash("0x01") || post.Storage[slot] != common.HexToHash("0x02") {
+		t.Errorf("contract diff mismatch: %s", res)
+	}
+	if pre, post := diff.Pre[coinbase], diff.Post[coinbase]; pre.Balance.ToInt().Sign() != 0 || post.Balance.ToInt().Cmp(fee) != 0 {
+		t.Errorf("coinbase diff mismatch: %s", res)
+	}
+}
+
 // Iterates over all the input-output datasets in the tracer test harness and
 // runs the JavaScript tracers against them.
 func TestCallTracer(t *testing.T) {
@@ -275,3 +412,136 @@ func TestCallTracer(t *testing.T) {
 		})
 	}
 }
+
+// Iterates over all the input-output datasets in the tracer test harness and
+// runs the native call tracer against them.
+func TestCallTracerNative(t *testing.T) {
+	forEachCallTracerTest(t, func(t *testing.T, test *callTracerTest) {
+		res, err := runCallTracerTest(test, func() (ResultTracer, error) { return newCallTracer(), nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret := new(callTrace)
+		if err := json.Unmarshal(res, ret); err != nil {
+			t.Fatalf("failed to unmarshal trace result: %v", err)
+		}
+		if !reflect.DeepEqual(ret, test.Result) {
+			t.Fatalf("trace mismatch: \nhave %+v\nwant %+v", ret, test.Result)
+		}
+	})
+}
+
+// Tests that the native tracers produce the same output as their JavaScript
+// counterparts for all the datasets in the tracer test harness.
+func TestNativeTracersMatchJavaScript(t *testing.T) {
+	for name := range natives {
+		if _, ok := all[name]; !ok {
+			continue
+		}
+		name := name // capture range variable
+		t.Run(name, func(t *testing.T) {
+			forEachCallTracerTest(t, func(t *testing.T, test *callTracerTest) {
+				want, err := runCallTracerTest(test, func() (ResultTracer, error) { return New(name) })
+				if err != nil {
+					t.Fatal(err)
+				}
+				have, err := runCallTracerTest(test, func() (ResultTracer, error) { return natives[name](), nil })
+				if err != nil {
+					t.Fatal(err)
+				}
+				var haveObj, wantObj interface{}
+				if err := json.Unmarshal(have, &haveObj); err != nil {
+					t.Fatalf("failed to unmarshal native trace result: %v", err)
+				}
+				if err := json.Unmarshal(want, &wantObj); err != nil {
+					t.Fatalf("failed to unmarshal javascript trace result: %v", err)
+				}
+				// Execution times naturally differ between the runs
+				for _, obj := range []interface{}{haveObj, wantObj} {
+					if obj, ok := obj.(map[string]interface{}); ok {
+						delete(obj, "time")
+					}
+				}
+				if !reflect.DeepEqual(haveObj, wantObj) {
+					t.Fatalf("trace mismatch: \nhave %s\nwant %s", have, want)
+				}
+			})
+		})
+	}
+}
+
+// forEachCallTracerTest loads all the call tracer datasets from the tracer test
+// harness, running the given check on each of them.
+func forEachCallTracerTest(t *testing.T, check func(t *testing.T, test *callTracerTest)) {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "call_tracer_") {
+			continue
+		}
+		file := file // capture range variable
+		t.Run(camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json")), func(t *testing.T) {
+			t.Parallel()
+
+			// Call tracer test found, read if from disk
+			blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+			if err != nil {
+				t.Fatalf("failed to read testcase: %v", err)
+			}
+			test := new(callTracerTest)
+			if err := json.Unmarshal(blob, test); err != nil {
+				t.Fatalf("failed to parse testcase: %v", err)
+			}
+			check(t, test)
+		})
+	}
+}
+
+// runCallTracerTest executes the transaction of a tracer test dataset on top of
+// its prestate, returning the result of the tracer.
+func runCallTracerTest(test *callTracerTest, newTracer func() (ResultTracer, error)) (json.RawMessage, error) {
+	// Configure a blockchain with the given prestate
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		return nil, fmt.Errorf("failed to parse testcase input: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	origin, _ := signer.Sender(tx)
+
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+		GasPrice:    tx.GasPrice(),
+	}
+	statedb := tests.MakePreState(rawdb.NewMemoryDatabase(), test.Genesis.Alloc)
+
+	// Create the tracer, the EVM environment and run it
+	tracer, err := newTracer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tracer: %v", err)
+	}
+	evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, _, _, err = st.TransitionDb(); err != nil {
+		return nil, fmt.Errorf("failed to execute transaction: %v", err)
+	}
+	// Retrieve the trace result
+	res, err := tracer.GetResult()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve trace result: %v", err)
+	}
+	return res, nil
+}
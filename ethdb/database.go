@@ -67,6 +67,14 @@ type KeyValueStore interface {
 	io.Closer
 }
 
+// Refresher wraps the Refresh method of a read-only database view opened on top
+// of data owned by another process.
+type Refresher interface {
+	// Refresh updates the view of the database with the changes made by its owner
+	// since opening or the last refresh.
+	Refresh() error
+}
+
 // AncientReader contains the methods required to read from immutable ancient data.
 type AncientReader interface {
 	// HasAncient returns an indicator whether the specified data exists in the
@@ -17,6 +17,11 @@
 package leveldb
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/ethdb"
@@ -38,3 +43,126 @@ func TestLevelDB(t *testing.T) {
 		})
 	})
 }
+
+// Tests that a secondary database serves a consistent view of a primary that is
+// being written concurrently, exposing the new writes only after a refresh.
+func TestSecondary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb-secondary-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	primary, err := New(filepath.Join(dir, "primary"), 16, 16, "")
+	if err != nil {
+		t.Fatalf("failed to open primary: %v", err)
+	}
+	defer primary.Close()
+
+	for i := 0; i < 1000; i++ {
+		primary.Put([]byte(fmt.Sprintf("key-%04d", i)), bytes.Repeat([]byte{byte(i)}, 100))
+	}
+	primary.Compact(nil, nil) // Make sure both tables and logs are present
+	for i := 1000; i < 1100; i++ {
+		primary.Put([]byte(fmt.Sprintf("key-%04d", i)), bytes.Repeat([]byte{byte(i)}, 100))
+	}
+	secondary, err := NewSecondary(filepath.Join(dir, "primary"), 16, 16)
+	if err != nil {
+		t.Fatalf("failed to open secondary: %v", err)
+	}
+	defer secondary.Close()
+
+	if blob, err := secondary.Get([]byte("key-0000")); err != nil || !bytes.Equal(blob, bytes.Repeat([]byte{0}, 100)) {
+		t.Fatalf("compacted entry mismatch: have %x, %v", blob, err)
+	}
+	if has, _ := secondary.Has([]byte("key-1099")); !has {
+		t.Fatalf("journalled entry missing")
+	}
+	// Write more data into the primary and ensure it's not visible yet
+	it := secondary.NewIterator()
+	for i := 1100; i < 1200; i++ {
+		primary.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte{0x01})
+	}
+	if has, _ := secondary.Has([]byte("key-1100")); has {
+		t.Fatalf("entry visible before refresh")
+	}
+	if err := secondary.Refresh(); err != nil {
+		t.Fatalf("failed to refresh secondary: %v", err)
+	}
+	if has, _ := secondary.Has([]byte("key-1199")); !has {
+		t.Fatalf("entry missing after refresh")
+	}
+	// Iterators created before the refresh must keep working on the old view
+	var count int
+	for it.Next() {
+		count++
+	}
+	it.Release()
+	if count != 1100 {
+		t.Fatalf("stale iterator entry count mismatch: have %d, want %d", count, 1100)
+	}
+	// Writes must be rejected
+	if err := secondary.Put([]byte("key"), []byte("value")); err != errReadOnly {
+		t.Fatalf("put error mismatch: have %v, want %v", err, errReadOnly)
+	}
+	batch := secondary.NewBatch()
+	if err := batch.Write(); err != errReadOnly {
+		t.Fatalf("batch error mismatch: have %v, want %v", err, errReadOnly)
+	}
+	// Closing the secondary must clean up all the checkpoints
+	secondary.Close()
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("leftover checkpoints: have %d entries, want 1", len(files))
+	}
+}
+
+// Tests that opening a secondary database deletes the checkpoints left behind by
+// crashed instances, but keeps the ones still in use.
+func TestSecondaryStaleCheckpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leveldb-secondary-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	primary, err := New(filepath.Join(dir, "primary"), 16, 16, "")
+	if err != nil {
+		t.Fatalf("failed to open primary: %v", err)
+	}
+	defer primary.Close()
+	primary.Put([]byte("key"), []byte("value"))
+
+	// Simulate a crashed instance leaving its unlocked checkpoint behind
+	stale := filepath.Join(dir, "primary-secondary-stale")
+	if err := os.MkdirAll(filepath.Join(stale, "checkpoint-0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(stale, "LOCK"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	live, err := NewSecondary(filepath.Join(dir, "primary"), 16, 16)
+	if err != nil {
+		t.Fatalf("failed to open secondary: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("stale checkpoint not removed: %v", err)
+	}
+	// Opening another instance must not touch the live one's checkpoint
+	other, err := NewSecondary(filepath.Join(dir, "primary"), 16, 16)
+	if err != nil {
+		t.Fatalf("failed to open secondary: %v", err)
+	}
+	other.Close()
+
+	if blob, err := live.Get([]byte("key")); err != nil || !bytes.Equal(blob, []byte("value")) {
+		t.Fatalf("live secondary broken: have %x, err %v", blob, err)
+	}
+	if err := live.Refresh(); err != nil {
+		t.Fatalf("failed to refresh secondary: %v", err)
+	}
+	live.Close()
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("leftover checkpoints: have %d entries, want 1", len(files))
+	}
+}
@@ -0,0 +1,463 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build !js
+
+package leveldb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/prometheus/tsdb/fileutil"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// checkpointRetries is the number of times to retry creating a checkpoint if the
+// primary database keeps changing its file set during the process.
+const checkpointRetries = 16
+
+var (
+	// errReadOnly is returned if a write is attempted on a secondary database.
+	errReadOnly = errors.New("read-only secondary database")
+
+	// errCheckpointRace is returned if the primary database changed its version
+	// while a checkpoint was being created.
+	errCheckpointRace = errors.New("primary database changed during checkpoint")
+)
+
+// Secondary is a read-only view of a LevelDB database owned and concurrently
+// written by another process.
+//
+// LevelDB only permits a single process to open a database, so the view is backed
+// by a private checkpoint instead: the immutable table files are hard linked (or
+// copied if linking is not possible), the manifest and the write ahead logs are
+// copied and the result is opened in read-only mode. The checkpoints are created
+// in a locked working directory next to the primary database to allow hard
+// linking, and are deleted when no longer in use. Working directories left behind
+// by crashed processes are deleted when the next secondary is opened.
+//
+// The view is consistent, it does not see writes made by the primary after its
+// creation until Refresh is called.
+type Secondary struct {
+	fn      string // Path of the primary database
+	cache   int    // Memory allowance for the block cache
+	handles int    // File handle allowance for the table cache
+
+	root  string            // Working directory holding the checkpoints
+	flock fileutil.Releaser // File-system lock marking the working directory live
+	refs  int32             // Number of live views (plus one for the owner)
+
+	view *secondaryView // Currently active checkpoint of the primary database
+	lock sync.RWMutex   // Mutex protecting the view swaps
+
+	log log.Logger // Contextual logger tracking the database path
+}
+
+// secondaryView is a reference counted checkpoint of the primary database, kept
+// alive until the last read or iterator using it finishes.
+type secondaryView struct {
+	owner *Secondary  // Secondary database the view belongs to
+	db    *leveldb.DB // LevelDB instance opened on the checkpoint
+	dir   string      // Directory of the checkpoint
+	refs  int32       // Number of users of the view (including the owner)
+}
+
+// release drops a reference to the view, closing and deleting the checkpoint
+// once the last user is done with it.
+func (v *secondaryView) release() {
+	if atomic.AddInt32(&v.refs, -1) == 0 {
+		v.db.Close()
+		os.RemoveAll(v.dir)
+		v.owner.release()
+	}
+}
+
+// NewSecondary opens a read-only view of a LevelDB database that may be in use
+// by another process.
+func NewSecondary(file string, cache int, handles int) (*Secondary, error) {
+	// Ensure we have some minimal caching and file guarantees
+	if cache < minCache {
+		cache = minCache
+	}
+	if handles < minHandles {
+		handles = minHandles
+	}
+	// Clean up any checkpoints left behind by crashed processes and create our
+	// own working directory, locked for as long as it's in use
+	removeStaleCheckpoints(file)
+
+	root, err := ioutil.TempDir(filepath.Dir(file), filepath.Base(file)+"-secondary-")
+	if err != nil {
+		return nil, err
+	}
+	flock, _, err := fileutil.Flock(filepath.Join(root, "LOCK"))
+	if err != nil {
+		os.RemoveAll(root)
+		return nil, err
+	}
+	db := &Secondary{
+		fn:      file,
+		cache:   cache,
+		handles: handles,
+		root:    root,
+		flock:   flock,
+		refs:    1,
+		log:     log.New("database", file, "mode", "secondary"),
+	}
+	view, err := db.open()
+	if err != nil {
+		db.release()
+		return nil, err
+	}
+	db.view = view
+	db.log.Info("Opened secondary database", "cache", common.StorageSize(cache*1024*1024), "handles", handles)
+	return db, nil
+}
+
+// open creates a new checkpoint of the primary database and opens it.
+func (db *Secondary) open() (*secondaryView, error) {
+	dir, err := checkpoint(db.fn, db.root)
+	if err != nil {
+		return nil, err
+	}
+	ldb, err := leveldb.OpenFile(dir, &opt.Options{
+		OpenFilesCacheCapacity: db.handles,
+		BlockCacheCapacity:     db.cache * opt.MiB,
+		Filter:                 filter.NewBloomFilter(10),
+		ReadOnly:               true,
+		Strict:                 opt.NoStrict, // The copied logs might end in a partial record
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	atomic.AddInt32(&db.refs, 1)
+	return &secondaryView{owner: db, db: ldb, dir: dir, refs: 1}, nil
+}
+
+// release drops a reference to the working directory, unlocking and deleting it
+// once the database is closed and all its views are released.
+func (db *Secondary) release() {
+	if atomic.AddInt32(&db.refs, -1) == 0 {
+		db.flock.Release()
+		os.RemoveAll(db.root)
+	}
+}
+
+// acquire retrieves the current view of the primary database, increasing its
+// reference count. The caller must release the view when done.
+func (db *Secondary) acquire() (*secondaryView, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if db.view == nil {
+		return nil, leveldb.ErrClosed
+	}
+	atomic.AddInt32(&db.view.refs, 1)
+	return db.view, nil
+}
+
+// Refresh replaces the view of the database with a new checkpoint, making all
+// the writes of the primary up to this point visible. Reads and iterators that
+// are in progress finish on the previous view.
+func (db *Secondary) Refresh() error {
+	view, err := db.open()
+	if err != nil {
+		return err
+	}
+	db.lock.Lock()
+	old := db.view
+	db.view = view
+	db.lock.Unlock()
+
+	if old == nil {
+		// Database was closed meanwhile, discard the new view too
+		db.lock.Lock()
+		db.view = nil
+		db.lock.Unlock()
+		view.release()
+		return leveldb.ErrClosed
+	}
+	old.release()
+	db.log.Debug("Refreshed secondary database")
+	return nil
+}
+
+// Close releases the current view of the database. The checkpoint is deleted
+// after all pending reads finish.
+func (db *Secondary) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.view != nil {
+		db.view.release()
+		db.view = nil
+		db.release()
+	}
+	return nil
+}
+
+// Has retrieves if a key is present in the key-value store.
+func (db *Secondary) Has(key []byte) (bool, error) {
+	view, err := db.acquire()
+	if err != nil {
+		return false, err
+	}
+	defer view.release()
+
+	return view.db.Has(key, nil)
+}
+
+// Get retrieves the given key if it's present in the key-value store.
+func (db *Secondary) Get(key []byte) ([]byte, error) {
+	view, err := db.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer view.release()
+
+	dat, err := view.db.Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return dat, nil
+}
+
+// Put rejects the insertion, secondary databases are read-only.
+func (db *Secondary) Put(key []byte, value []byte) error {
+	return errReadOnly
+}
+
+// Delete rejects the deletion, secondary databases are read-only.
+func (db *Secondary) Delete(key []byte) error {
+	return errReadOnly
+}
+
+// NewBatch creates a batch that rejects all writes, secondary databases are
+// read-only.
+func (db *Secondary) NewBatch() ethdb.Batch {
+	return readonlyBatch{}
+}
+
+// NewIterator creates a binary-alphabetical iterator over the entire keyspace
+// contained within the current view of the database.
+func (db *Secondary) NewIterator() ethdb.Iterator {
+	return db.iterator(nil)
+}
+
+// NewIteratorWithStart creates a binary-alphabetical iterator over a subset of
+// database content starting at a particular initial key (or after, if it does
+// not exist).
+func (db *Secondary) NewIteratorWithStart(start []byte) ethdb.Iterator {
+	return db.iterator(&util.Range{Start: start})
+}
+
+// NewIteratorWithPrefix creates a binary-alphabetical iterator over a subset
+// of database content with a particular key prefix.
+func (db *Secondary) NewIteratorWithPrefix(prefix []byte) ethdb.Iterator {
+	return db.iterator(util.BytesPrefix(prefix))
+}
+
+// iterator creates an iterator over the current view, keeping the view alive
+// until the iterator is released.
+func (db *Secondary) iterator(slice *util.Range) ethdb.Iterator {
+	view, err := db.acquire()
+	if err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	return &secondaryIterator{Iterator: view.db.NewIterator(slice, nil), view: view}
+}
+
+// Stat returns a particular internal stat of the current view of the database.
+func (db *Secondary) Stat(property string) (string, error) {
+	view, err := db.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer view.release()
+
+	if !strings.HasPrefix(property, "leveldb.") {
+		property = "leveldb." + property
+	}
+	return view.db.GetProperty(property)
+}
+
+// Compact rejects the compaction, secondary databases are read-only.
+func (db *Secondary) Compact(start []byte, limit []byte) error {
+	return errReadOnly
+}
+
+// Path returns the path to the primary database directory.
+func (db *Secondary) Path() string {
+	return db.fn
+}
+
+// secondaryIterator is an iterator over a view of a secondary database, which
+// releases the view along with itself.
+type secondaryIterator struct {
+	iterator.Iterator
+	view *secondaryView
+	once sync.Once
+}
+
+// Release releases the iterator and its reference to the database view.
+func (it *secondaryIterator) Release() {
+	it.Iterator.Release()
+	it.once.Do(it.view.release)
+}
+
+// readonlyBatch is a batch of a secondary database, rejecting all writes.
+type readonlyBatch struct{}
+
+func (readonlyBatch) Put(key, value []byte) error         { return errReadOnly }
+func (readonlyBatch) Delete(key []byte) error             { return errReadOnly }
+func (readonlyBatch) ValueSize() int                      { return 0 }
+func (readonlyBatch) Write() error                        { return errReadOnly }
+func (readonlyBatch) Reset()                              {}
+func (readonlyBatch) Replay(w ethdb.KeyValueWriter) error { return nil }
+
+// removeStaleCheckpoints deletes the working directories of secondary databases
+// opened on the given primary which are not locked by any live instance.
+func removeStaleCheckpoints(file string) {
+	roots, _ := filepath.Glob(filepath.Join(filepath.Dir(file), filepath.Base(file)+"-secondary-*"))
+	for _, root := range roots {
+		flock, _, err := fileutil.Flock(filepath.Join(root, "LOCK"))
+		if err != nil {
+			continue // In use by another instance
+		}
+		flock.Release()
+		if err := os.RemoveAll(root); err != nil {
+			log.Warn("Failed to remove stale database checkpoint", "path", root, "err", err)
+			continue
+		}
+		log.Info("Removed stale database checkpoint", "path", root)
+	}
+}
+
+// checkpoint creates a private copy of a live LevelDB database in the given
+// working directory, retrying if the primary changes its file set during the
+// process.
+func checkpoint(file string, root string) (string, error) {
+	var err error
+	for i := 0; i < checkpointRetries; i++ {
+		var dir string
+		if dir, err = ioutil.TempDir(root, "checkpoint-"); err != nil {
+			return "", err
+		}
+		if err = tryCheckpoint(file, dir); err == nil {
+			return dir, nil
+		}
+		os.RemoveAll(dir)
+		log.Debug("Failed to create database checkpoint", "database", file, "attempt", i, "err", err)
+	}
+	return "", err
+}
+
+// tryCheckpoint attempts to create a consistent copy of a live LevelDB database.
+//
+// Every version change of the primary (table compaction or log rotation) is
+// recorded in the manifest before any file is deleted, so if neither the active
+// manifest, nor its size changed while the files were being linked and copied,
+// all the files referenced by the copied manifest are present in the checkpoint.
+func tryCheckpoint(src string, dst string) error {
+	current, err := ioutil.ReadFile(filepath.Join(src, "CURRENT"))
+	if err != nil {
+		return err
+	}
+	manifest := strings.TrimSpace(string(current))
+	if !strings.HasPrefix(manifest, "MANIFEST-") {
+		return errors.New("invalid database manifest reference")
+	}
+	stat, err := os.Stat(filepath.Join(src, manifest))
+	if err != nil {
+		return err
+	}
+	if err := copyFile(filepath.Join(src, manifest), filepath.Join(dst, manifest), stat.Size()); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		var (
+			name = file.Name()
+			from = filepath.Join(src, name)
+			to   = filepath.Join(dst, name)
+		)
+		switch filepath.Ext(name) {
+		case ".ldb", ".sst":
+			// Table files are immutable, link them if possible
+			if err := os.Link(from, to); err != nil {
+				if err := copyFile(from, to, -1); err != nil {
+					return err
+				}
+			}
+		case ".log":
+			// Journals are appended to live, copy whatever's there
+			if err := copyFile(from, to, -1); err != nil {
+				return err
+			}
+		}
+	}
+	// Ensure the primary did not move to a different version meanwhile
+	if recheck, err := ioutil.ReadFile(filepath.Join(src, "CURRENT")); err != nil || !bytes.Equal(recheck, current) {
+		return errCheckpointRace
+	}
+	if restat, err := os.Stat(filepath.Join(src, manifest)); err != nil || restat.Size() != stat.Size() {
+		return errCheckpointRace
+	}
+	return ioutil.WriteFile(filepath.Join(dst, "CURRENT"), current, 0644)
+}
+
+// copyFile copies the first size bytes of a file, or all of it if size is
+// negative.
+func copyFile(src string, dst string, size int64) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader = in
+	if size >= 0 {
+		reader = io.LimitReader(in, size)
+	}
+	if _, err := io.Copy(out, reader); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
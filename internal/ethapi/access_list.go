@@ -0,0 +1,118 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// accessListResult is the result of an access list discovery.
+type accessListResult struct {
+	AccessList vm.AccessList  `json:"accessList"`
+	GasUsed    hexutil.Uint64 `json:"gasUsed"`
+	Failed     bool           `json:"failed"`
+}
+
+// AccessList executes the call on the requested state, collecting the accounts
+// and storage slots it accesses. Along with the access list, the gas used and the
+// failure status of the call are returned.
+//
+// Note, access lists don't affect execution before Berlin, so a single run is
+// enough to discover all the entries.
+func AccessList(ctx context.Context, b Backend, args CallArgs, blockNrOrHash rpc.BlockNumberOrHash, timeout time.Duration, globalGasCap *big.Int) (vm.AccessList, uint64, bool, error) {
+	defer func(start time.Time) { log.Debug("Access list discovery finished", "runtime", time.Since(start)) }(time.Now())
+
+	tracer := vm.NewAccessListTracer()
+	gas, failed, err := traceAccessList(ctx, b, args, blockNrOrHash, tracer, timeout, globalGasCap)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	return tracer.AccessList(), gas, failed, nil
+}
+
+// traceAccessList executes a call on the requested state with the given access
+// list tracer.
+func traceAccessList(ctx context.Context, b Backend, args CallArgs, blockNrOrHash rpc.BlockNumberOrHash, tracer *vm.AccessListTracer, timeout time.Duration, globalGasCap *big.Int) (uint64, bool, error) {
+	state, header, err := b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if state == nil || err != nil {
+		return 0, false, err
+	}
+	msg := args.toMessage(b, globalGasCap, new(big.Int).SetUint64(defaultGasPrice))
+
+	// Setup context so it may be cancelled the call has completed
+	// or, in case of unmetered gas, setup a context with a timeout.
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	// Get a new instance of the EVM, running the tracer on it
+	evm, vmError, err := b.GetEVM(ctx, msg, state, header)
+	if err != nil {
+		return 0, false, err
+	}
+	evm = vm.NewEVM(evm.Context, state, b.ChainConfig(), vm.Config{Debug: true, Tracer: tracer})
+
+	// Wait for the context to be done and cancel the evm. Even if the
+	// EVM has finished, cancelling may be done (repeatedly)
+	go func() {
+		<-ctx.Done()
+		evm.Cancel()
+	}()
+
+	gp := new(core.GasPool).AddGas(math.MaxUint64)
+	_, gas, failed, err := core.ApplyMessage(evm, msg, gp)
+	if err := vmError(); err != nil {
+		return 0, false, err
+	}
+	// If the timer caused an abort, return an appropriate error message
+	if evm.Cancelled() {
+		return 0, false, fmt.Errorf("execution aborted (timeout = %v)", timeout)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return gas, failed, nil
+}
+
+// CreateAccessList returns every account and storage slot the given call reads
+// or writes when executed on the requested state (pending by default), along
+// with the gas it uses.
+func (s *PublicBlockChainAPI) CreateAccessList(ctx context.Context, args CallArgs, blockNrOrHash *rpc.BlockNumberOrHash) (*accessListResult, error) {
+	bNrOrHash := rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber)
+	if blockNrOrHash != nil {
+		bNrOrHash = *blockNrOrHash
+	}
+	list, gas, failed, err := AccessList(ctx, s.b, args, bNrOrHash, 5*time.Second, s.b.RPCGasCap())
+	if err != nil {
+		return nil, err
+	}
+	return &accessListResult{AccessList: list, GasUsed: hexutil.Uint64(gas), Failed: failed}, nil
+}
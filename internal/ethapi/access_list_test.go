@@ -0,0 +1,53 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Tests that the access list of a call contains the accounts it touched along
+// with the storage slots accessed.
+func TestAccessList(t *testing.T) {
+	contract := common.Address{0x01}
+	b := newSimulateBackend(t, core.GenesisAlloc{contract: {Code: storageCode, Balance: common.Big0}})
+	defer b.chain.Stop()
+
+	call := newSimulateCall(contract, common.Hash{0x2a}.Bytes())
+	list, gas, failed, err := AccessList(context.Background(), b, call.CallArgs, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to create access list: %v", err)
+	}
+	if failed || gas == 0 {
+		t.Fatalf("call result mismatch: failed %v, gas %d", failed, gas)
+	}
+	if len(list) != 2 || list[0].Address != contract || list[1].Address != *call.From {
+		t.Fatalf("access list accounts mismatch: have %+v", list)
+	}
+	if len(list[0].StorageKeys) != 1 || list[0].StorageKeys[0] != (common.Hash{}) {
+		t.Fatalf("access list slots mismatch: have %x", list[0].StorageKeys)
+	}
+	if len(list[1].StorageKeys) != 0 {
+		t.Fatalf("sender slots mismatch: have %x", list[1].StorageKeys)
+	}
+}
@@ -36,6 +36,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -51,6 +52,10 @@ const (
 	defaultGasPrice = params.GWei
 )
 
+// errTxIndexingInProgress is returned if a transaction can't be found while the
+// transaction indexer is still catching up with the chain.
+var errTxIndexingInProgress = errors.New("transaction indexing is in progress")
+
 // PublicEthereumAPI provides an API to access Ethereum related information.
 // It offers only methods that operate on public data that is freely available to anyone.
 type PublicEthereumAPI struct {
@@ -97,6 +102,17 @@ func (s *PublicEthereumAPI) Syncing() (interface{}, error) {
 	}, nil
 }
 
+// TxIndexStatus returns the progress of the background transaction indexer:
+// - indexedBlocks:   number of recent blocks whose transactions are indexed
+// - remainingBlocks: number of blocks still waiting to be indexed
+func (s *PublicEthereumAPI) TxIndexStatus() map[string]interface{} {
+	progress := s.b.TxIndexProgress()
+	return map[string]interface{}{
+		"indexedBlocks":   hexutil.Uint64(progress.Indexed),
+		"remainingBlocks": hexutil.Uint64(progress.Remaining),
+	}
+}
+
 // PublicTxPoolAPI offers and API for the transaction pool. It only operates on data that is non confidential.
 type PublicTxPoolAPI struct {
 	b Backend
@@ -134,6 +150,60 @@ func (s *PublicTxPoolAPI) Content() map[string]map[string]map[string]*RPCTransac
 	return content
 }
 
+// ContentFrom returns the transactions of a single account contained within the
+// transaction pool.
+func (s *PublicTxPoolAPI) ContentFrom(addr common.Address) map[string]map[string]*RPCTransaction {
+	content := map[string]map[string]*RPCTransaction{
+		"pending": make(map[string]*RPCTransaction),
+		"queued":  make(map[string]*RPCTransaction),
+	}
+	pending, queue := s.b.TxPoolContentFrom(addr)
+
+	for _, tx := range pending {
+		content["pending"][fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx)
+	}
+	for _, tx := range queue {
+		content["queued"][fmt.Sprintf("%d", tx.Nonce())] = newRPCPendingTransaction(tx)
+	}
+	return content
+}
+
+// DropReason returns why a transaction was recently removed from the pool, or
+// null if the pool does not remember dropping it.
+func (s *PublicTxPoolAPI) DropReason(hash common.Hash) *core.TxDrop {
+	return s.b.TxPoolDropReason(hash)
+}
+
+// Lifecycle creates a subscription that fires for every state change of the
+// transactions in the pool: added, promoted, replaced, dropped and mined.
+func (s *PublicTxPoolAPI) Lifecycle(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		events := make(chan core.TxLifecycleEvent, 128)
+		sub := s.b.SubscribeTxLifecycleEvent(events)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-events:
+				for _, change := range ev.Changes {
+					notifier.Notify(rpcSub.ID, change)
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
 // Status returns the number of pending and queued transaction in the pool.
 func (s *PublicTxPoolAPI) Status() map[string]hexutil.Uint {
 	pending, queue := s.b.Stats()
@@ -757,25 +827,8 @@ type account struct {
 	StateDiff *map[common.Hash]common.Hash `json:"stateDiff"`
 }
 
-func DoCall(ctx context.Context, b Backend, args CallArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides map[common.Address]account, vmCfg vm.Config, timeout time.Duration, globalGasCap *big.Int) ([]byte, uint64, bool, error) {
-	defer func(start time.Time) { log.Debug("Executing EVM call finished", "runtime", time.Since(start)) }(time.Now())
-
-	state, header, err := b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
-	if state == nil || err != nil {
-		return nil, 0, false, err
-	}
-	// Set sender address or use a default if none specified
-	var addr common.Address
-	if args.From == nil {
-		if wallets := b.AccountManager().Wallets(); len(wallets) > 0 {
-			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
-				addr = accounts[0].Address
-			}
-		}
-	} else {
-		addr = *args.From
-	}
-	// Override the fields of specified contracts before execution.
+// applyOverrides overrides the fields of the specified accounts in the state.
+func applyOverrides(state *state.StateDB, overrides map[common.Address]account) error {
 	for addr, account := range overrides {
 		// Override account nonce.
 		if account.Nonce != nil {
@@ -790,7 +843,7 @@ func DoCall(ctx context.Context, b Backend, args CallArgs, blockNrOrHash rpc.Blo
 			state.SetBalance(addr, (*big.Int)(*account.Balance))
 		}
 		if account.State != nil && account.StateDiff != nil {
-			return nil, 0, false, fmt.Errorf("account %s has both 'state' and 'stateDiff'", addr.Hex())
+			return fmt.Errorf("account %s has both 'state' and 'stateDiff'", addr.Hex())
 		}
 		// Replace entire state if caller requires.
 		if account.State != nil {
@@ -803,6 +856,24 @@ func DoCall(ctx context.Context, b Backend, args CallArgs, blockNrOrHash rpc.Blo
 			}
 		}
 	}
+	return nil
+}
+
+// toMessage converts the call arguments into a message, defaulting the sender
+// to the first local account, the gas price to the given one and capping the
+// gas at the global allowance.
+func (args *CallArgs) toMessage(b Backend, globalGasCap *big.Int, gasPrice *big.Int) types.Message {
+	// Set sender address or use a default if none specified
+	var addr common.Address
+	if args.From == nil {
+		if wallets := b.AccountManager().Wallets(); len(wallets) > 0 {
+			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
+				addr = accounts[0].Address
+			}
+		}
+	} else {
+		addr = *args.From
+	}
 	// Set default gas & gas price if none were set
 	gas := uint64(math.MaxUint64 / 2)
 	if args.Gas != nil {
@@ -812,7 +883,6 @@ func DoCall(ctx context.Context, b Backend, args CallArgs, blockNrOrHash rpc.Blo
 		log.Warn("Caller gas above allowance, capping", "requested", gas, "cap", globalGasCap)
 		gas = globalGasCap.Uint64()
 	}
-	gasPrice := new(big.Int).SetUint64(defaultGasPrice)
 	if args.GasPrice != nil {
 		gasPrice = args.GasPrice.ToInt()
 	}
@@ -828,7 +898,21 @@ func DoCall(ctx context.Context, b Backend, args CallArgs, blockNrOrHash rpc.Blo
 	}
 
 	// Create new call message
-	msg := types.NewMessage(addr, args.To, 0, value, gas, gasPrice, data, false)
+	return types.NewMessage(addr, args.To, 0, value, gas, gasPrice, data, false)
+}
+
+func DoCall(ctx context.Context, b Backend, args CallArgs, blockNrOrHash rpc.BlockNumberOrHash, overrides map[common.Address]account, vmCfg vm.Config, timeout time.Duration, globalGasCap *big.Int) ([]byte, uint64, bool, error) {
+	defer func(start time.Time) { log.Debug("Executing EVM call finished", "runtime", time.Since(start)) }(time.Now())
+
+	state, header, err := b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if state == nil || err != nil {
+		return nil, 0, false, err
+	}
+	// Override the fields of specified contracts before execution.
+	if err := applyOverrides(state, overrides); err != nil {
+		return nil, 0, false, err
+	}
+	msg := args.toMessage(b, globalGasCap, new(big.Int).SetUint64(defaultGasPrice))
 
 	// Setup context so it may be cancelled the call has completed
 	// or, in case of unmetered gas, setup a context with a timeout.
@@ -1269,8 +1353,10 @@ func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, has
 	if tx := s.b.GetPoolTransaction(hash); tx != nil {
 		return newRPCPendingTransaction(tx), nil
 	}
-
-	// Transaction unknown, return as such
+	// Transaction unknown, it might still be waiting for indexing
+	if !s.b.TxIndexProgress().Done() {
+		return nil, errTxIndexingInProgress
+	}
 	return nil, nil
 }
 
@@ -1284,6 +1370,9 @@ func (s *PublicTransactionPoolAPI) GetRawTransactionByHash(ctx context.Context,
 	if tx == nil {
 		if tx = s.b.GetPoolTransaction(hash); tx == nil {
 			// Transaction not found anywhere, abort
+			if !s.b.TxIndexProgress().Done() {
+				return nil, errTxIndexingInProgress
+			}
 			return nil, nil
 		}
 	}
@@ -1295,6 +1384,9 @@ func (s *PublicTransactionPoolAPI) GetRawTransactionByHash(ctx context.Context,
 func (s *PublicTransactionPoolAPI) GetTransactionReceipt(ctx context.Context, hash common.Hash) (map[string]interface{}, error) {
 	tx, blockHash, blockNumber, index := rawdb.ReadTransaction(s.b.ChainDb(), hash)
 	if tx == nil {
+		if !s.b.TxIndexProgress().Done() {
+			return nil, errTxIndexingInProgress
+		}
 		return nil, nil
 	}
 	receipts, err := s.b.GetReceipts(ctx, blockHash)
@@ -1519,6 +1611,43 @@ func (s *PublicTransactionPoolAPI) SendRawTransaction(ctx context.Context, encod
 	return SubmitTransaction(ctx, s.b, tx)
 }
 
+// SendBundleArgs represents the arguments for submitting a transaction bundle.
+type SendBundleArgs struct {
+	Txs          []hexutil.Bytes `json:"txs"`
+	BlockNumber  hexutil.Uint64  `json:"blockNumber"`
+	MinTimestamp *hexutil.Uint64 `json:"minTimestamp"`
+	MaxTimestamp *hexutil.Uint64 `json:"maxTimestamp"`
+}
+
+// SendBundle submits a sequence of signed transactions that must be included
+// together, in order, at the top of a block or not at all. The bundle is only
+// valid for the given block number (or the next few blocks if zero) and timestamp
+// window, and its transactions are priced like any other remote transaction.
+func (s *PublicTransactionPoolAPI) SendBundle(ctx context.Context, args SendBundleArgs) (common.Hash, error) {
+	bundle := &core.TxBundle{
+		Txs:         make(types.Transactions, len(args.Txs)),
+		BlockNumber: uint64(args.BlockNumber),
+	}
+	for i, encodedTx := range args.Txs {
+		tx := new(types.Transaction)
+		if err := rlp.DecodeBytes(encodedTx, tx); err != nil {
+			return common.Hash{}, fmt.Errorf("transaction %d: %v", i, err)
+		}
+		bundle.Txs[i] = tx
+	}
+	if args.MinTimestamp != nil {
+		bundle.MinTimestamp = uint64(*args.MinTimestamp)
+	}
+	if args.MaxTimestamp != nil {
+		bundle.MaxTimestamp = uint64(*args.MaxTimestamp)
+	}
+	if err := s.b.SendBundle(ctx, bundle); err != nil {
+		return common.Hash{}, err
+	}
+	log.Info("Submitted transaction bundle", "hash", bundle.Hash(), "txs", len(bundle.Txs), "block", bundle.BlockNumber)
+	return bundle.Hash(), nil
+}
+
 // Sign calculates an ECDSA signature for:
 // keccack256("\x19Ethereum Signed Message:\n" + len(message) + message).
 //
@@ -0,0 +1,246 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/eth/tracers"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// maxSimulateCalls is the maximum number of calls accepted in a single simulated
+// sequence.
+const maxSimulateCalls = 256
+
+// BlockOverrides is a set of header fields to override when simulating calls
+// on top of a block.
+type BlockOverrides struct {
+	Number     *hexutil.Big    `json:"number"`
+	Time       *hexutil.Uint64 `json:"timestamp"`
+	GasLimit   *hexutil.Uint64 `json:"gasLimit"`
+	Coinbase   *common.Address `json:"coinbase"`
+	Difficulty *hexutil.Big    `json:"difficulty"`
+}
+
+// apply returns a copy of the header with the requested fields overridden.
+func (o *BlockOverrides) apply(header *types.Header) *types.Header {
+	header = types.CopyHeader(header)
+	if o == nil {
+		return header
+	}
+	if o.Number != nil {
+		header.Number = new(big.Int).Set(o.Number.ToInt())
+	}
+	if o.Time != nil {
+		header.Time = uint64(*o.Time)
+	}
+	if o.GasLimit != nil {
+		header.GasLimit = uint64(*o.GasLimit)
+	}
+	if o.Coinbase != nil {
+		header.Coinbase = *o.Coinbase
+	}
+	if o.Difficulty != nil {
+		header.Difficulty = new(big.Int).Set(o.Difficulty.ToInt())
+	}
+	return header
+}
+
+// SimulateCall is a single call in a simulated sequence, along with the state
+// overrides to apply before executing it.
+type SimulateCall struct {
+	CallArgs
+	StateOverrides map[common.Address]account `json:"stateOverrides"`
+}
+
+// SimulateConfig is the optional configuration of a call simulation.
+type SimulateConfig struct {
+	Tracer *string `json:"tracer"` // Name or JavaScript code of the tracer to run on each call
+}
+
+// SimulateResult is the outcome of a single call in a simulated sequence.
+type SimulateResult struct {
+	ReturnValue hexutil.Bytes   `json:"returnValue"`
+	Logs        []*types.Log    `json:"logs"`
+	GasUsed     hexutil.Uint64  `json:"gasUsed"`
+	Failed      bool            `json:"failed"`
+	Error       string          `json:"error,omitempty"`
+	Trace       json.RawMessage `json:"trace,omitempty"`
+}
+
+// DoCallMany executes a sequence of calls on top of the requested block, each
+// call seeing the state modifications of the previous ones. Calls which can't
+// be applied (e.g. insufficient balance) are reported and have no effect on
+// the state, while reverted ones are reported as failed.
+//
+// The timeout and the global gas cap apply to the sequence as a whole, not to
+// the individual calls.
+func DoCallMany(ctx context.Context, b Backend, calls []SimulateCall, blockNrOrHash rpc.BlockNumberOrHash, blockOverrides *BlockOverrides, config *SimulateConfig, timeout time.Duration, globalGasCap *big.Int) ([]*SimulateResult, error) {
+	defer func(start time.Time) { log.Debug("Executing EVM call sequence finished", "runtime", time.Since(start)) }(time.Now())
+
+	if len(calls) > maxSimulateCalls {
+		return nil, fmt.Errorf("too many calls: have %d, max %d", len(calls), maxSimulateCalls)
+	}
+	state, header, err := b.StateAndHeaderByNumberOrHash(ctx, blockNrOrHash)
+	if state == nil || err != nil {
+		return nil, err
+	}
+	header = blockOverrides.apply(header)
+
+	// Setup context so it may be cancelled the sequence has completed
+	// or, in case of unmetered gas, setup a context with a timeout.
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	var budget *big.Int // Gas left from the global cap, nil if unlimited
+	if globalGasCap != nil {
+		budget = new(big.Int).Set(globalGasCap)
+	}
+	results := make([]*SimulateResult, 0, len(calls))
+	for i, call := range calls {
+		if budget != nil && budget.Sign() <= 0 {
+			return nil, fmt.Errorf("call %d: gas cap of %v exhausted", i, globalGasCap)
+		}
+		if err := applyOverrides(state, call.StateOverrides); err != nil {
+			return nil, fmt.Errorf("call %d: %v", i, err)
+		}
+		// Senders aren't funded by the simulation, so only charge for gas if a
+		// price is explicitly requested.
+		msg := call.toMessage(b, budget, new(big.Int))
+
+		result, err := simulateCall(ctx, b, state, header, blockOverrides, msg, i, config, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("call %d: %v", i, err)
+		}
+		if budget != nil {
+			budget.Sub(budget, new(big.Int).SetUint64(uint64(result.GasUsed)))
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// simulateCall applies a single message of a call sequence on the state, the
+// context carrying the deadline of the whole sequence.
+func simulateCall(ctx context.Context, b Backend, state *state.StateDB, header *types.Header, blockOverrides *BlockOverrides, msg types.Message, index int, config *SimulateConfig, timeout time.Duration) (*SimulateResult, error) {
+	// Setup context so it may be cancelled the call has completed
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The backend funds the sender to let eth_call run regardless of the balance,
+	// which would leak into the subsequent calls, so restore it afterwards.
+	var (
+		snapshot = state.Snapshot()
+		balance  = new(big.Int).Set(state.GetBalance(msg.From()))
+	)
+	evm, vmError, err := b.GetEVM(ctx, msg, state, header)
+	if err != nil {
+		return nil, err
+	}
+	state.SetBalance(msg.From(), balance)
+
+	// The consensus engine may derive the coinbase from the header seal, so
+	// force the overridden one into the EVM context.
+	if blockOverrides != nil && blockOverrides.Coinbase != nil {
+		evm.Context.Coinbase = *blockOverrides.Coinbase
+	}
+	var tracer tracers.ResultTracer
+	if config != nil && config.Tracer != nil {
+		var ok bool
+		if tracer, ok = tracers.NewNative(*config.Tracer); !ok {
+			if tracer, err = tracers.New(*config.Tracer); err != nil {
+				return nil, err
+			}
+		}
+		if txTracer, ok := tracer.(tracers.TxTracer); ok {
+			txTracer.CaptureTxStart(state, evm.Context, msg)
+		}
+		evm = vm.NewEVM(evm.Context, state, b.ChainConfig(), vm.Config{Debug: true, Tracer: tracer})
+	}
+	// Wait for the context to be done and cancel the evm. Even if the
+	// EVM has finished, cancelling may be done (repeatedly)
+	go func() {
+		<-ctx.Done()
+		evm.Cancel()
+		if tracer != nil {
+			tracer.Stop(ctx.Err())
+		}
+	}()
+
+	// Apply the message, collecting the logs emitted by this call only
+	state.Prepare(common.Hash{}, common.Hash{}, index)
+	logs := len(state.GetLogs(common.Hash{}))
+
+	gp := new(core.GasPool).AddGas(math.MaxUint64)
+	res, gas, failed, err := core.ApplyMessage(evm, msg, gp)
+	if err := vmError(); err != nil {
+		return nil, err
+	}
+	// If the timer caused an abort, return an appropriate error message
+	if evm.Cancelled() {
+		return nil, fmt.Errorf("execution aborted (timeout = %v)", timeout)
+	}
+	result := &SimulateResult{Logs: []*types.Log{}}
+	if err != nil {
+		// The message couldn't be applied, drop any changes it made
+		state.RevertToSnapshot(snapshot)
+		result.Failed, result.Error = true, err.Error()
+		return result, nil
+	}
+	result.ReturnValue, result.GasUsed, result.Failed = res, hexutil.Uint64(gas), failed
+	if emitted := state.GetLogs(common.Hash{}); len(emitted) > logs {
+		result.Logs = emitted[logs:]
+	}
+	if tracer != nil {
+		if result.Trace, err = tracer.GetResult(); err != nil {
+			return nil, err
+		}
+	}
+	state.Finalise(b.ChainConfig().IsEIP158(header.Number))
+	return result, nil
+}
+
+// CallMany executes a sequence of calls on top of the given block, each one
+// seeing the effects of the previous ones. Per call state overrides and block
+// header overrides can be specified, and each call can optionally be traced.
+//
+// The whole sequence is subject to a single timeout and the RPC gas cap, and is
+// limited to a maximum number of calls.
+//
+// Note, this function doesn't make any changes in the state/blockchain and is
+// useful to simulate dependent transactions.
+func (s *PublicBlockChainAPI) CallMany(ctx context.Context, calls []SimulateCall, blockNrOrHash rpc.BlockNumberOrHash, blockOverrides *BlockOverrides, config *SimulateConfig) ([]*SimulateResult, error) {
+	return DoCallMany(ctx, s.b, calls, blockNrOrHash, blockOverrides, config, 5*time.Second, s.b.RPCGasCap())
+}
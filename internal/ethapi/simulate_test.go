@@ -0,0 +1,281 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	// storageCode stores the first calldata word in slot 0 if called with
+	// data, otherwise returns the current content of slot 0.
+	storageCode = common.Hex2Bytes("3615600c57600035600055005b60005460005260206000f3")
+
+	// headerCode returns the block number, timestamp and coinbase.
+	headerCode = common.Hex2Bytes("43600052426020524160405260606000f3")
+
+	// revertCode unconditionally reverts.
+	revertCode = common.Hex2Bytes("60006000fd")
+)
+
+// simulateBackend is a minimal API backend serving the head state of a local
+// chain, enough to execute call sequences on.
+type simulateBackend struct {
+	Backend
+	chain *core.BlockChain
+}
+
+func newSimulateBackend(t *testing.T, alloc core.GenesisAlloc) *simulateBackend {
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		gspec = &core.Genesis{Config: params.TestChainConfig, Alloc: alloc}
+	)
+	gspec.MustCommit(db)
+
+	chain, err := core.NewBlockChain(db, nil, params.TestChainConfig, ethash.NewFaker(), vm.Config{}, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	return &simulateBackend{chain: chain}
+}
+
+func (b *simulateBackend) ChainConfig() *params.ChainConfig {
+	return b.chain.Config()
+}
+
+func (b *simulateBackend) StateAndHeaderByNumberOrHash(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (*state.StateDB, *types.Header, error) {
+	header := b.chain.CurrentHeader()
+	statedb, err := b.chain.StateAt(header.Root)
+	return statedb, header, err
+}
+
+func (b *simulateBackend) GetEVM(ctx context.Context, msg core.Message, state *state.StateDB, header *types.Header) (*vm.EVM, func() error, error) {
+	state.SetBalance(msg.From(), math.MaxBig256)
+	vmError := func() error { return nil }
+
+	context := core.NewEVMContext(msg, header, b.chain, nil)
+	return vm.NewEVM(context, state, b.chain.Config(), *b.chain.GetVMConfig()), vmError, nil
+}
+
+// simulate runs a call sequence against the head of the backend's chain.
+func (b *simulateBackend) simulate(t *testing.T, calls []SimulateCall, overrides *BlockOverrides, config *SimulateConfig) []*SimulateResult {
+	results, err := DoCallMany(context.Background(), b, calls, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber), overrides, config, time.Second, nil)
+	if err != nil {
+		t.Fatalf("failed to simulate calls: %v", err)
+	}
+	if len(results) != len(calls) {
+		t.Fatalf("result count mismatch: have %d, want %d", len(results), len(calls))
+	}
+	return results
+}
+
+// newSimulateCall creates a call from a fixed sender to the given address.
+func newSimulateCall(to common.Address, data []byte) SimulateCall {
+	from := common.Address{0xff}
+	input := hexutil.Bytes(data)
+	return SimulateCall{CallArgs: CallArgs{From: &from, To: &to, Data: &input}}
+}
+
+// withCode installs the given code at the call target before executing it.
+func withCode(call SimulateCall, code []byte) SimulateCall {
+	input := hexutil.Bytes(code)
+	call.StateOverrides = map[common.Address]account{*call.To: {Code: &input}}
+	return call
+}
+
+// Tests that calls in a sequence see the state modifications of the previous
+// ones, and that per call state overrides are applied before each call.
+func TestCallManyStateOverrides(t *testing.T) {
+	b := newSimulateBackend(t, nil)
+	defer b.chain.Stop()
+
+	var (
+		contract = common.Address{0x01}
+		first    = common.BigToHash(big.NewInt(42))
+		second   = common.BigToHash(big.NewInt(1337))
+	)
+	overridden := newSimulateCall(contract, nil)
+	overridden.StateOverrides = map[common.Address]account{
+		contract: {StateDiff: &map[common.Hash]common.Hash{{}: second}},
+	}
+	results := b.simulate(t, []SimulateCall{
+		withCode(newSimulateCall(contract, first.Bytes()), storageCode), // Deploy and store the first value
+		newSimulateCall(contract, nil),                                  // Read the value back
+		overridden,                                                      // Override the slot and read it back
+		newSimulateCall(contract, nil),                                  // Read the override back
+	}, nil, nil)
+
+	for i, result := range results {
+		if result.Failed {
+			t.Fatalf("call %d: failed: %s", i, result.Error)
+		}
+	}
+	if len(results[0].ReturnValue) != 0 {
+		t.Errorf("store returned data: %x", results[0].ReturnValue)
+	}
+	if !bytes.Equal(results[1].ReturnValue, first.Bytes()) {
+		t.Errorf("stored value not visible to later call: have %x, want %x", results[1].ReturnValue, first)
+	}
+	if !bytes.Equal(results[2].ReturnValue, second.Bytes()) {
+		t.Errorf("state override not applied: have %x, want %x", results[2].ReturnValue, second)
+	}
+	if !bytes.Equal(results[3].ReturnValue, second.Bytes()) {
+		t.Errorf("state override not visible to later call: have %x, want %x", results[3].ReturnValue, second)
+	}
+}
+
+// Tests that block overrides are visible to the simulated calls.
+func TestCallManyBlockOverrides(t *testing.T) {
+	b := newSimulateBackend(t, nil)
+	defer b.chain.Stop()
+
+	var (
+		number    = hexutil.Big(*big.NewInt(1000))
+		timestamp = hexutil.Uint64(123456789)
+		coinbase  = common.Address{0xc0, 0xff, 0xee}
+	)
+	results := b.simulate(t, []SimulateCall{
+		withCode(newSimulateCall(common.Address{0x02}, nil), headerCode),
+	}, &BlockOverrides{Number: &number, Time: &timestamp, Coinbase: &coinbase}, nil)
+
+	if results[0].Failed {
+		t.Fatalf("call failed: %s", results[0].Error)
+	}
+	want := append(append(common.BigToHash(number.ToInt()).Bytes(), common.BigToHash(new(big.Int).SetUint64(uint64(timestamp))).Bytes()...), coinbase.Hash().Bytes()...)
+	if !bytes.Equal(results[0].ReturnValue, want) {
+		t.Errorf("block overrides mismatch:\nhave %x\nwant %x", results[0].ReturnValue, want)
+	}
+}
+
+// Tests that reverted calls are reported as failed without aborting the rest
+// of the sequence or affecting the state seen by it.
+func TestCallManyRevert(t *testing.T) {
+	b := newSimulateBackend(t, nil)
+	defer b.chain.Stop()
+
+	var (
+		contract = common.Address{0x03}
+		value    = common.BigToHash(big.NewInt(42))
+	)
+	results := b.simulate(t, []SimulateCall{
+		withCode(newSimulateCall(contract, value.Bytes()), storageCode),
+		withCode(newSimulateCall(common.Address{0x04}, nil), revertCode),
+		newSimulateCall(contract, nil),
+	}, nil, nil)
+
+	if results[0].Failed {
+		t.Fatalf("store failed: %s", results[0].Error)
+	}
+	if !results[1].Failed {
+		t.Errorf("reverted call not reported as failed")
+	}
+	if results[1].GasUsed == 0 {
+		t.Errorf("reverted call reported no gas usage")
+	}
+	if len(results[1].ReturnValue) != 0 {
+		t.Errorf("reverted call returned data: %x", results[1].ReturnValue)
+	}
+	if results[2].Failed {
+		t.Fatalf("call after revert failed: %s", results[2].Error)
+	}
+	if !bytes.Equal(results[2].ReturnValue, value.Bytes()) {
+		t.Errorf("state lost after reverted call: have %x, want %x", results[2].ReturnValue, value)
+	}
+}
+
+// Tests that a trace is produced for every call in the sequence if a tracer
+// is requested.
+func TestCallManyTrace(t *testing.T) {
+	b := newSimulateBackend(t, nil)
+	defer b.chain.Stop()
+
+	var (
+		contract = common.Address{0x05}
+		tracer   = "callTracer"
+	)
+	calls := []SimulateCall{
+		withCode(newSimulateCall(contract, common.BigToHash(big.NewInt(42)).Bytes()), storageCode),
+		withCode(newSimulateCall(common.Address{0x06}, nil), revertCode),
+		newSimulateCall(contract, nil),
+	}
+	results := b.simulate(t, calls, nil, &SimulateConfig{Tracer: &tracer})
+
+	for i, result := range results {
+		if len(result.Trace) == 0 {
+			t.Fatalf("call %d: missing trace", i)
+		}
+		var trace struct {
+			Type  string         `json:"type"`
+			To    common.Address `json:"to"`
+			Error string         `json:"error"`
+		}
+		if err := json.Unmarshal(result.Trace, &trace); err != nil {
+			t.Fatalf("call %d: failed to unmarshal trace: %v", i, err)
+		}
+		if trace.Type != "CALL" {
+			t.Errorf("call %d: trace type mismatch: have %s, want CALL", i, trace.Type)
+		}
+		if trace.To != *calls[i].To {
+			t.Errorf("call %d: trace target mismatch: have %x, want %x", i, trace.To, *calls[i].To)
+		}
+		if failed := trace.Error != ""; failed != result.Failed {
+			t.Errorf("call %d: trace failure mismatch: have %v, want %v", i, failed, result.Failed)
+		}
+	}
+}
+
+// Tests that the number of calls in a sequence is limited, and that the global
+// gas cap is shared by all the calls of the sequence.
+func TestCallManyLimits(t *testing.T) {
+	b := newSimulateBackend(t, nil)
+	defer b.chain.Stop()
+
+	latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+
+	calls := make([]SimulateCall, maxSimulateCalls+1)
+	for i := range calls {
+		calls[i] = newSimulateCall(common.Address{0x07}, nil)
+	}
+	if _, err := DoCallMany(context.Background(), b, calls, latest, nil, nil, time.Second, nil); err == nil {
+		t.Fatalf("oversized call sequence accepted")
+	}
+	// Plain transfers use exactly the intrinsic gas, so the cap allows two of them
+	gasCap := new(big.Int).SetUint64(2 * params.TxGas)
+	if _, err := DoCallMany(context.Background(), b, calls[:2], latest, nil, nil, time.Second, gasCap); err != nil {
+		t.Fatalf("failed to simulate calls within the gas cap: %v", err)
+	}
+	if _, err := DoCallMany(context.Background(), b, calls[:3], latest, nil, nil, time.Second, gasCap); err == nil {
+		t.Fatalf("call sequence exceeding the gas cap accepted")
+	}
+}
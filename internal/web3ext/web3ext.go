@@ -20,6 +20,7 @@ package web3ext
 var Modules = map[string]string{
 	"accounting": AccountingJs,
 	"admin":      AdminJs,
+	"bft":        BFTJs,
 	"chequebook": ChequebookJs,
 	"clique":     CliqueJs,
 	"ethash":     EthashJs,
@@ -107,6 +108,23 @@ web3._extend({
 			call: 'clique_status',
 			params: 0
 		}),
+		new web3._extend.Method({
+			name: 'getVotes',
+			call: 'clique_getVotes',
+			params: 2,
+			inputFormatter: [web3._extend.utils.fromDecimal, web3._extend.utils.fromDecimal]
+		}),
+		new web3._extend.Method({
+			name: 'getSignerHistory',
+			call: 'clique_getSignerHistory',
+			params: 0
+		}),
+		new web3._extend.Method({
+			name: 'getSignerStats',
+			call: 'clique_getSignerStats',
+			params: 1,
+			inputFormatter: [null]
+		}),
 	],
 	properties: [
 		new web3._extend.Property({
@@ -117,6 +135,62 @@ web3._extend({
 });
 `
 
+const BFTJs = `
+web3._extend({
+	property: 'bft',
+	methods: [
+		new web3._extend.Method({
+			name: 'getSnapshot',
+			call: 'bft_getSnapshot',
+			params: 1,
+			inputFormatter: [web3._extend.utils.fromDecimal]
+		}),
+		new web3._extend.Method({
+			name: 'getSnapshotAtHash',
+			call: 'bft_getSnapshotAtHash',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'getValidators',
+			call: 'bft_getValidators',
+			params: 1,
+			inputFormatter: [web3._extend.utils.fromDecimal]
+		}),
+		new web3._extend.Method({
+			name: 'getValidatorsAtHash',
+			call: 'bft_getValidatorsAtHash',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'propose',
+			call: 'bft_propose',
+			params: 2
+		}),
+		new web3._extend.Method({
+			name: 'discard',
+			call: 'bft_discard',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'getCommit',
+			call: 'bft_getCommit',
+			params: 1,
+			inputFormatter: [web3._extend.utils.fromDecimal]
+		}),
+	],
+	properties: [
+		new web3._extend.Property({
+			name: 'proposals',
+			getter: 'bft_proposals'
+		}),
+		new web3._extend.Property({
+			name: 'status',
+			getter: 'bft_status'
+		}),
+	]
+});
+`
+
 const EthashJs = `
 web3._extend({
 	property: 'ethash',
@@ -180,6 +254,10 @@ web3._extend({
 			call: 'admin_importChain',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'refreshDatabase',
+			call: 'admin_refreshDatabase'
+		}),
 		new web3._extend.Method({
 			name: 'sleepBlocks',
 			call: 'admin_sleepBlocks',
@@ -409,6 +487,12 @@ web3._extend({
 			params: 2,
 			inputFormatter: [null, null]
 		}),
+		new web3._extend.Method({
+			name: 'profileChain',
+			call: 'debug_profileChain',
+			params: 3,
+			inputFormatter: [null, null, null]
+		}),
 		new web3._extend.Method({
 			name: 'traceBlockByNumber',
 			call: 'debug_traceBlockByNumber',
@@ -492,6 +576,23 @@ web3._extend({
 			params: 1,
 			inputFormatter: [web3._extend.formatters.inputTransactionFormatter]
 		}),
+		new web3._extend.Method({
+			name: 'sendBundle',
+			call: 'eth_sendBundle',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'callMany',
+			call: 'eth_callMany',
+			params: 4,
+			inputFormatter: [null, web3._extend.formatters.inputBlockNumberFormatter, null, null]
+		}),
+		new web3._extend.Method({
+			name: 'createAccessList',
+			call: 'eth_createAccessList',
+			params: 2,
+			inputFormatter: [web3._extend.formatters.inputCallFormatter, web3._extend.formatters.inputBlockNumberFormatter]
+		}),
 		new web3._extend.Method({
 			name: 'submitTransaction',
 			call: 'eth_submitTransaction',
@@ -557,6 +658,10 @@ web3._extend({
 				return formatted;
 			}
 		}),
+		new web3._extend.Property({
+			name: 'txIndexStatus',
+			getter: 'eth_txIndexStatus'
+		}),
 	]
 });
 `
@@ -601,6 +706,15 @@ web3._extend({
 			name: 'getHashrate',
 			call: 'miner_getHashrate'
 		}),
+		new web3._extend.Method({
+			name: 'getBlockTemplate',
+			call: 'miner_getBlockTemplate'
+		}),
+		new web3._extend.Method({
+			name: 'submitBlock',
+			call: 'miner_submitBlock',
+			params: 1
+		}),
 	],
 	properties: []
 });
@@ -735,7 +849,22 @@ web3._extend({
 const TxpoolJs = `
 web3._extend({
 	property: 'txpool',
-	methods: [],
+	methods: [
+		new web3._extend.Method({
+			name: 'contentFrom',
+			call: 'txpool_contentFrom',
+			params: 1,
+		}),
+		new web3._extend.Method({
+			name: 'dropReason',
+			call: 'txpool_dropReason',
+			params: 1,
+		}),
+		new web3._extend.Method({
+			name: 'reloadAdmissionRules',
+			call: 'txpool_reloadAdmissionRules',
+		}),
+	],
 	properties:
 	[
 		new web3._extend.Property({
@@ -746,6 +875,10 @@ web3._extend({
 			name: 'inspect',
 			getter: 'txpool_inspect'
 		}),
+		new web3._extend.Property({
+			name: 'admissionRules',
+			getter: 'txpool_admissionRules'
+		}),
 		new web3._extend.Property({
 			name: 'status',
 			getter: 'txpool_status',
@@ -0,0 +1,151 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var (
+	// errNoEtherbase is returned if an external block is requested without an
+	// etherbase to credit the block rewards and fees to.
+	errNoEtherbase = errors.New("etherbase missing")
+
+	// errUnknownParent is returned if an external block is built on top of a
+	// block not in the local chain.
+	errUnknownParent = errors.New("unknown parent block")
+
+	// errWorkerClosed is returned if an external block is submitted after the
+	// worker was shut down.
+	errWorkerClosed = errors.New("worker closed")
+)
+
+// builderHeader creates the header of a new block on top of the given parent,
+// filling it the same way the worker does for its own blocks. The consensus
+// engine may move the timestamp forward (e.g. clique enforcing its period).
+func (w *worker) builderHeader(parent *types.Block, timestamp uint64) (*types.Header, error) {
+	w.mu.RLock()
+	coinbase, extra := w.coinbase, w.extra
+	w.mu.RUnlock()
+
+	if coinbase == (common.Address{}) {
+		return nil, errNoEtherbase
+	}
+	if timestamp <= parent.Time() {
+		timestamp = parent.Time() + 1
+	}
+	num := parent.Number()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     num.Add(num, common.Big1),
+		GasLimit:   core.CalcGasLimit(parent, w.config.GasFloor, w.config.GasCeil),
+		Extra:      extra,
+		Time:       timestamp,
+		Coinbase:   coinbase,
+	}
+	if err := w.prepareHeader(header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// blockTemplate returns the header of a new block on top of the current chain
+// head, along with the parent block whose state the transactions execute on.
+func (w *worker) blockTemplate() (*types.Header, *types.Block, error) {
+	parent := w.chain.CurrentBlock()
+
+	header, err := w.builderHeader(parent, uint64(time.Now().Unix()))
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, parent, nil
+}
+
+// buildBlock executes an ordered list of transactions on top of the given parent
+// and assembles the resulting block. Contrary to the worker's own blocks, no
+// transaction is skipped: the first failing one invalidates the entire block.
+func (w *worker) buildBlock(parentHash common.Hash, timestamp uint64, txs types.Transactions) (*types.Block, []*types.Receipt, *state.StateDB, error) {
+	parent := w.chain.GetBlockByHash(parentHash)
+	if parent == nil {
+		return nil, nil, nil, errUnknownParent
+	}
+	header, err := w.builderHeader(parent, timestamp)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	env, err := w.makeEnv(parent, header)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if w.chainConfig.DAOForkSupport && w.chainConfig.DAOForkBlock != nil && w.chainConfig.DAOForkBlock.Cmp(header.Number) == 0 {
+		misc.ApplyDAOHardFork(env.state)
+	}
+	env.gasPool = new(core.GasPool).AddGas(header.GasLimit)
+
+	for i, tx := range txs {
+		env.state.Prepare(tx.Hash(), common.Hash{}, i)
+
+		receipt, err := core.ApplyTransaction(w.chainConfig, w.chain, &header.Coinbase, env.gasPool, env.state, header, tx, &header.GasUsed, *w.chain.GetVMConfig())
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("transaction %d (%x) failed: %v", i, tx.Hash(), err)
+		}
+		env.txs = append(env.txs, tx)
+		env.receipts = append(env.receipts, receipt)
+	}
+	block, err := w.engine.FinalizeAndAssemble(w.chain, header, env.state, env.txs, nil, env.receipts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return block, env.receipts, env.state, nil
+}
+
+// submitBlock builds a block from an externally ordered transaction list and
+// hands it to the sealer. Once sealed, the block is written to the chain and
+// broadcast like any locally mined one.
+//
+// Note, the worker's own sealing tasks interrupt the external one, so external
+// building should be used with local mining stopped. Engines sealing with a key
+// (e.g. clique) also need the etherbase to be an authorized signer.
+func (w *worker) submitBlock(parentHash common.Hash, timestamp uint64, txs types.Transactions) (*types.Block, []*types.Receipt, error) {
+	block, receipts, state, err := w.buildBlock(parentHash, timestamp, txs)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The result loop fills in the block fields of the task receipts, keep ours pristine
+	taskReceipts := make([]*types.Receipt, len(receipts))
+	for i, receipt := range receipts {
+		taskReceipts[i] = new(types.Receipt)
+		*taskReceipts[i] = *receipt
+	}
+	select {
+	case w.taskCh <- &task{receipts: taskReceipts, state: state, block: block, createdAt: time.Now()}:
+		log.Info("Submitted external block for sealing", "number", block.Number(), "sealhash", w.engine.SealHash(block.Header()),
+			"txs", len(txs), "gas", block.GasUsed())
+	case <-w.exitCh:
+		return nil, nil, errWorkerClosed
+	}
+	return block, receipts, nil
+}
@@ -51,6 +51,11 @@ type Config struct {
 	GasPrice  *big.Int       // Minimum gas price for mining a transaction
 	Recommit  time.Duration  // The time interval for miner to re-create mining work.
 	Noverify  bool           // Disable remote mining solution verification(only useful in ethash).
+
+	Ordering          string           // Transaction ordering policy (price, fifo, fairshare or priority)
+	PriorityAddresses []common.Address `toml:",omitempty"` // Senders whose transactions are included first by the priority ordering
+
+	WarnMissed bool // Warn when the local signer misses its in-turn slot (only useful in clique).
 }
 
 // Miner creates blocks and searches for proof-of-work values.
@@ -188,3 +193,17 @@ func (miner *Miner) SetEtherbase(addr common.Address) {
 func (self *Miner) SubscribePendingLogs(ch chan<- []*types.Log) event.Subscription {
 	return self.worker.pendingLogsFeed.Subscribe(ch)
 }
+
+// BlockTemplate returns the header of a new block on top of the current chain
+// head for an external builder to fill, along with the parent block whose state
+// the transactions will execute on.
+func (miner *Miner) BlockTemplate() (*types.Header, *types.Block, error) {
+	return miner.worker.blockTemplate()
+}
+
+// SubmitBlock executes an externally ordered list of transactions on top of the
+// given parent and hands the resulting block to the consensus engine for sealing.
+// The returned block is not sealed yet.
+func (miner *Miner) SubmitBlock(parent common.Hash, timestamp uint64, txs types.Transactions) (*types.Block, []*types.Receipt, error) {
+	return miner.worker.submitBlock(parent, timestamp, txs)
+}
@@ -0,0 +1,293 @@
+// Copyright 2020 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Names of the built-in transaction ordering policies.
+const (
+	OrderingPrice     = "price"     // Locals first, then by gas price (default)
+	OrderingFIFO      = "fifo"      // By local arrival time
+	OrderingFairShare = "fairshare" // Round robin between senders
+	OrderingPriority  = "priority"  // Priority senders first, then by gas price
+)
+
+// TransactionSet is a sorted set of pending transactions the worker fills the
+// blocks from. It is implemented by types.TransactionsByPriceAndNonce.
+type TransactionSet interface {
+	// Peek returns the next transaction to include, nil if the set is exhausted.
+	Peek() *types.Transaction
+
+	// Shift replaces the current transaction with the next one from the same
+	// account.
+	Shift()
+
+	// Pop removes the current transaction and all the subsequent ones from the
+	// same account.
+	Pop()
+}
+
+// TransactionOrdering is a policy deciding the order in which the pending
+// transactions are included into the mined blocks.
+type TransactionOrdering interface {
+	// Order groups and sorts the pending transactions into sets, which the worker
+	// consumes one after the other. The transactions of each account are sorted
+	// by nonce and the input map may be reowned by the ordering.
+	Order(signer types.Signer, pending map[common.Address]types.Transactions, locals []common.Address) []TransactionSet
+}
+
+// NewTransactionOrdering creates the transaction ordering policy selected by the
+// mining configuration.
+func NewTransactionOrdering(config *Config) (TransactionOrdering, error) {
+	switch config.Ordering {
+	case "", OrderingPrice:
+		return new(priceOrdering), nil
+	case OrderingFIFO:
+		return new(fifoOrdering), nil
+	case OrderingFairShare:
+		return new(fairShareOrdering), nil
+	case OrderingPriority:
+		if len(config.PriorityAddresses) == 0 {
+			return nil, fmt.Errorf("%s transaction ordering requires priority addresses", OrderingPriority)
+		}
+		priority := make(map[common.Address]struct{}, len(config.PriorityAddresses))
+		for _, addr := range config.PriorityAddresses {
+			priority[addr] = struct{}{}
+		}
+		return &priorityOrdering{priority: priority}, nil
+	default:
+		return nil, fmt.Errorf("unknown transaction ordering %q", config.Ordering)
+	}
+}
+
+// splitAccounts moves the transactions of the given accounts out of the pending
+// set into a new one.
+func splitAccounts(pending map[common.Address]types.Transactions, accounts []common.Address) map[common.Address]types.Transactions {
+	split := make(map[common.Address]types.Transactions)
+	for _, account := range accounts {
+		if txs := pending[account]; len(txs) > 0 {
+			delete(pending, account)
+			split[account] = txs
+		}
+	}
+	return split
+}
+
+// priceOrdering includes the transactions of local accounts first, followed by
+// the remote ones, both sorted by gas price in a nonce-honouring way.
+type priceOrdering struct{}
+
+// Order implements TransactionOrdering.
+func (o *priceOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions, locals []common.Address) []TransactionSet {
+	var (
+		sets      []TransactionSet
+		localTxs  = splitAccounts(pending, locals)
+		remoteTxs = pending
+	)
+	if len(localTxs) > 0 {
+		sets = append(sets, types.NewTransactionsByPriceAndNonce(signer, localTxs))
+	}
+	if len(remoteTxs) > 0 {
+		sets = append(sets, types.NewTransactionsByPriceAndNonce(signer, remoteTxs))
+	}
+	return sets
+}
+
+// priorityOrdering includes the transactions of a set of priority accounts first,
+// followed by the rest in price order.
+type priorityOrdering struct {
+	priority map[common.Address]struct{}
+}
+
+// Order implements TransactionOrdering.
+func (o *priorityOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions, locals []common.Address) []TransactionSet {
+	accounts := make([]common.Address, 0, len(o.priority))
+	for account := range o.priority {
+		accounts = append(accounts, account)
+	}
+	var sets []TransactionSet
+	if priorityTxs := splitAccounts(pending, accounts); len(priorityTxs) > 0 {
+		sets = append(sets, types.NewTransactionsByPriceAndNonce(signer, priorityTxs))
+	}
+	return append(sets, new(priceOrdering).Order(signer, pending, locals)...)
+}
+
+// fifoOrdering includes the transactions in the order they were first seen by
+// the local node, irrespective of their gas price or origin.
+type fifoOrdering struct{}
+
+// Order implements TransactionOrdering.
+func (o *fifoOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions, locals []common.Address) []TransactionSet {
+	if len(pending) == 0 {
+		return nil
+	}
+	return []TransactionSet{newTxsByArrival(pending)}
+}
+
+// txsByArrival is a transaction set returning the transactions in arrival order,
+// while honouring the nonce ordering of each account.
+type txsByArrival struct {
+	txs   []types.Transactions // Per account nonce-sorted list of transactions
+	heads txsHeadHeap          // Index of the accounts in arrival order of their heads
+}
+
+func newTxsByArrival(pending map[common.Address]types.Transactions) *txsByArrival {
+	set := &txsByArrival{txs: make([]types.Transactions, 0, len(pending))}
+	for _, txs := range pending {
+		set.txs = append(set.txs, txs)
+	}
+	set.heads = txsHeadHeap{txs: set.txs, index: make([]int, len(set.txs))}
+	for i := range set.txs {
+		set.heads.index[i] = i
+	}
+	heap.Init(&set.heads)
+	return set
+}
+
+// Peek implements TransactionSet, returning the earliest seen transaction.
+func (s *txsByArrival) Peek() *types.Transaction {
+	if len(s.heads.index) == 0 {
+		return nil
+	}
+	return s.txs[s.heads.index[0]][0]
+}
+
+// Shift implements TransactionSet.
+func (s *txsByArrival) Shift() {
+	account := s.heads.index[0]
+	if s.txs[account] = s.txs[account][1:]; len(s.txs[account]) > 0 {
+		heap.Fix(&s.heads, 0)
+	} else {
+		heap.Pop(&s.heads)
+	}
+}
+
+// Pop implements TransactionSet.
+func (s *txsByArrival) Pop() {
+	heap.Pop(&s.heads)
+}
+
+// txsHeadHeap is a heap of account indices, ordered by the arrival time of the
+// account's head transaction, breaking ties by gas price and hash.
+type txsHeadHeap struct {
+	txs   []types.Transactions
+	index []int
+}
+
+func (h txsHeadHeap) Len() int      { return len(h.index) }
+func (h txsHeadHeap) Swap(i, j int) { h.index[i], h.index[j] = h.index[j], h.index[i] }
+
+func (h txsHeadHeap) Less(i, j int) bool {
+	a, b := h.txs[h.index[i]][0], h.txs[h.index[j]][0]
+	if !a.Time().Equal(b.Time()) {
+		return a.Time().Before(b.Time())
+	}
+	if cmp := a.GasPrice().Cmp(b.GasPrice()); cmp != 0 {
+		return cmp > 0
+	}
+	ha, hb := a.Hash(), b.Hash()
+	return bytes.Compare(ha[:], hb[:]) < 0
+}
+
+func (h *txsHeadHeap) Push(x interface{}) {
+	h.index = append(h.index, x.(int))
+}
+
+func (h *txsHeadHeap) Pop() interface{} {
+	old := h.index
+	n := len(old)
+	x := old[n-1]
+	h.index = old[0 : n-1]
+	return x
+}
+
+// fairShareOrdering includes the transactions of the pending accounts in a round
+// robin fashion, one transaction per account per round, so that no sender can
+// crowd out the others. Within a round, accounts are sorted by the gas price of
+// their next transaction.
+type fairShareOrdering struct{}
+
+// Order implements TransactionOrdering.
+func (o *fairShareOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions, locals []common.Address) []TransactionSet {
+	if len(pending) == 0 {
+		return nil
+	}
+	return []TransactionSet{newTxsByRoundRobin(pending)}
+}
+
+// txsByRoundRobin is a transaction set returning the next transaction of each
+// account in turn.
+type txsByRoundRobin struct {
+	round []types.Transactions // Accounts with a transaction left in the current round
+	next  []types.Transactions // Accounts already served in the current round
+}
+
+func newTxsByRoundRobin(pending map[common.Address]types.Transactions) *txsByRoundRobin {
+	set := &txsByRoundRobin{round: make([]types.Transactions, 0, len(pending))}
+	for _, txs := range pending {
+		set.round = append(set.round, txs)
+	}
+	set.sortRound()
+	return set
+}
+
+// sortRound orders the accounts of the current round by the gas price of their
+// head transaction, breaking ties by hash.
+func (s *txsByRoundRobin) sortRound() {
+	sort.Slice(s.round, func(i, j int) bool {
+		a, b := s.round[i][0], s.round[j][0]
+		if cmp := a.GasPrice().Cmp(b.GasPrice()); cmp != 0 {
+			return cmp > 0
+		}
+		ha, hb := a.Hash(), b.Hash()
+		return bytes.Compare(ha[:], hb[:]) < 0
+	})
+}
+
+// Peek implements TransactionSet.
+func (s *txsByRoundRobin) Peek() *types.Transaction {
+	if len(s.round) == 0 {
+		return nil
+	}
+	return s.round[0][0]
+}
+
+// Shift implements TransactionSet, deferring the account's next transaction to
+// the following round.
+func (s *txsByRoundRobin) Shift() {
+	if txs := s.round[0][1:]; len(txs) > 0 {
+		s.next = append(s.next, txs)
+	}
+	s.Pop()
+}
+
+// Pop implements TransactionSet.
+func (s *txsByRoundRobin) Pop() {
+	s.round = s.round[1:]
+	if len(s.round) == 0 && len(s.next) > 0 {
+		s.round, s.next = s.next, nil
+		s.sortRound()
+	}
+}
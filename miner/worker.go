@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"errors"
 	"math/big"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -76,6 +77,10 @@ const (
 	staleThreshold = 7
 )
 
+// errBundleReverted is returned if a transaction of a bundle reverts during its
+// execution, invalidating the entire bundle.
+var errBundleReverted = errors.New("bundle transaction reverted")
+
 // environment is the worker's current environment and holds all of the current state information.
 type environment struct {
 	signer types.Signer
@@ -127,6 +132,7 @@ type worker struct {
 	engine      consensus.Engine
 	eth         Backend
 	chain       *core.BlockChain
+	ordering    TransactionOrdering
 
 	// Feeds
 	pendingLogsFeed event.Feed
@@ -203,6 +209,14 @@ func newWorker(config *Config, chainConfig *params.ChainConfig, engine consensus
 		resubmitIntervalCh: make(chan time.Duration),
 		resubmitAdjustCh:   make(chan *intervalAdjust, resubmitAdjustChanSize),
 	}
+	// Create the transaction ordering policy, falling back to the default one
+	ordering, err := NewTransactionOrdering(config)
+	if err != nil {
+		log.Warn("Invalid transaction ordering, using default", "err", err)
+		ordering = new(priceOrdering)
+	}
+	worker.ordering = ordering
+
 	// Subscribe NewTxsEvent for tx pool
 	worker.txsSub = eth.TxPool().SubscribeNewTxsEvent(worker.txsCh)
 	// Subscribe events for blockchain
@@ -470,9 +484,12 @@ func (w *worker) mainLoop() {
 					acc, _ := types.Sender(w.current.signer, tx)
 					txs[acc] = append(txs[acc], tx)
 				}
-				txset := types.NewTransactionsByPriceAndNonce(w.current.signer, txs)
 				tcount := w.current.tcount
-				w.commitTransactions(txset, coinbase, nil)
+				for _, txset := range w.ordering.Order(w.current.signer, txs, nil) {
+					if w.commitTransactions(txset, coinbase, nil) {
+						break
+					}
+				}
 				// Only update the snapshot if any new transactons were added
 				// to the pending block
 				if tcount != w.current.tcount {
@@ -613,12 +630,44 @@ func (w *worker) resultLoop() {
 	}
 }
 
+// prepareHeader initializes the consensus fields of a new block header and
+// applies the DAO hard-fork extra-data override if needed.
+func (w *worker) prepareHeader(header *types.Header) error {
+	if err := w.engine.Prepare(w.chain, header); err != nil {
+		return err
+	}
+	// If we are care about TheDAO hard-fork check whether to override the extra-data or not
+	if daoBlock := w.chainConfig.DAOForkBlock; daoBlock != nil {
+		// Check whether the block is among the fork extra-override range
+		limit := new(big.Int).Add(daoBlock, params.DAOForkExtraRange)
+		if header.Number.Cmp(daoBlock) >= 0 && header.Number.Cmp(limit) < 0 {
+			// Depending whether we support or oppose the fork, override differently
+			if w.chainConfig.DAOForkSupport {
+				header.Extra = common.CopyBytes(params.DAOForkBlockExtra)
+			} else if bytes.Equal(header.Extra, params.DAOForkBlockExtra) {
+				header.Extra = []byte{} // If miner opposes, don't let it use the reserved extra-data
+			}
+		}
+	}
+	return nil
+}
+
 // makeCurrent creates a new environment for the current cycle.
 func (w *worker) makeCurrent(parent *types.Block, header *types.Header) error {
-	state, err := w.chain.StateAt(parent.Root())
+	env, err := w.makeEnv(parent, header)
 	if err != nil {
 		return err
 	}
+	w.current = env
+	return nil
+}
+
+// makeEnv creates a new block building environment on top of the given parent.
+func (w *worker) makeEnv(parent *types.Block, header *types.Header) (*environment, error) {
+	state, err := w.chain.StateAt(parent.Root())
+	if err != nil {
+		return nil, err
+	}
 	env := &environment{
 		signer:    types.NewEIP155Signer(w.chainConfig.ChainID),
 		state:     state,
@@ -639,8 +688,7 @@ func (w *worker) makeCurrent(parent *types.Block, header *types.Header) error {
 
 	// Keep track of transactions which return errors so they can be removed
 	env.tcount = 0
-	w.current = env
-	return nil
+	return env, nil
 }
 
 // commitUncle adds the given block to uncle block set, returns error if failed to add.
@@ -709,7 +757,7 @@ func (w *worker) commitTransaction(tx *types.Transaction, coinbase common.Addres
 	return receipt.Logs, nil
 }
 
-func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coinbase common.Address, interrupt *int32) bool {
+func (w *worker) commitTransactions(txs TransactionSet, coinbase common.Address, interrupt *int32) bool {
 	// Short circuit if current is nil
 	if w.current == nil {
 		return true
@@ -799,7 +847,20 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		}
 	}
 
-	if !w.isRunning() && len(coalescedLogs) > 0 {
+	w.postPendingLogs(coalescedLogs)
+
+	// Notify resubmit loop to decrease resubmitting interval if current interval is larger
+	// than the user-specified one.
+	if interrupt != nil {
+		w.resubmitAdjustCh <- &intervalAdjust{inc: false}
+	}
+	return false
+}
+
+// postPendingLogs sends the logs of the transactions committed to the pending
+// block to the pending log subscribers.
+func (w *worker) postPendingLogs(logs []*types.Log) {
+	if !w.isRunning() && len(logs) > 0 {
 		// We don't push the pendingLogsEvent while we are mining. The reason is that
 		// when we are mining, the worker will regenerate a mining block every 3 seconds.
 		// In order to avoid pushing the repeated pendingLog, we disable the pending log pushing.
@@ -807,19 +868,141 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		// make a copy, the state caches the logs and these logs get "upgraded" from pending to mined
 		// logs by filling in the block hash when the block was mined by the local miner. This can
 		// cause a race condition if a log was "upgraded" before the PendingLogsEvent is processed.
-		cpy := make([]*types.Log, len(coalescedLogs))
-		for i, l := range coalescedLogs {
+		cpy := make([]*types.Log, len(logs))
+		for i, l := range logs {
 			cpy[i] = new(types.Log)
 			*cpy[i] = *l
 		}
 		w.pendingLogsFeed.Send(cpy)
 	}
-	// Notify resubmit loop to decrease resubmitting interval if current interval is larger
-	// than the user-specified one.
-	if interrupt != nil {
-		w.resubmitAdjustCh <- &intervalAdjust{inc: false}
+}
+
+// simulateBundle executes a transaction bundle on top of a copy of the current
+// mining state, returning the amount paid to the coinbase by the bundle (gas
+// fees and direct transfers) and the gas it used. Bundles with any failing or
+// reverted transaction are rejected.
+func (w *worker) simulateBundle(bundle *core.TxBundle, coinbase common.Address) (*big.Int, uint64, error) {
+	var (
+		statedb = w.current.state.Copy()
+		gasPool = new(core.GasPool).AddGas(w.current.gasPool.Gas())
+		gasUsed uint64
+		before  = statedb.GetBalance(coinbase)
+	)
+	for i, tx := range bundle.Txs {
+		statedb.Prepare(tx.Hash(), common.Hash{}, w.current.tcount+i)
+
+		receipt, err := core.ApplyTransaction(w.chainConfig, w.chain, &coinbase, gasPool, statedb, w.current.header, tx, &gasUsed, *w.chain.GetVMConfig())
+		if err != nil {
+			return nil, 0, err
+		}
+		if receipt.Status == types.ReceiptStatusFailed {
+			return nil, 0, errBundleReverted
+		}
 	}
-	return false
+	return new(big.Int).Sub(statedb.GetBalance(coinbase), before), gasUsed, nil
+}
+
+// commitBundle applies all the transactions of a bundle onto the current mining
+// state, returning their logs. If any of them fails or reverts, the whole bundle
+// is rolled back.
+//
+// Note, the state is backed up as a full copy instead of a snapshot, since the
+// revisions are discarded whenever a transaction is finalised.
+func (w *worker) commitBundle(bundle *core.TxBundle, coinbase common.Address) ([]*types.Log, error) {
+	var (
+		state    = w.current.state.Copy()
+		gas      = w.current.gasPool.Gas()
+		gasUsed  = w.current.header.GasUsed
+		tcount   = w.current.tcount
+		txs      = len(w.current.txs)
+		receipts = len(w.current.receipts)
+		logs     []*types.Log
+	)
+	rollback := func() {
+		w.current.state = state
+		*w.current.gasPool = core.GasPool(gas)
+		w.current.header.GasUsed = gasUsed
+		w.current.tcount = tcount
+		w.current.txs = w.current.txs[:txs]
+		w.current.receipts = w.current.receipts[:receipts]
+	}
+	for _, tx := range bundle.Txs {
+		w.current.state.Prepare(tx.Hash(), common.Hash{}, w.current.tcount)
+
+		receipt, err := core.ApplyTransaction(w.chainConfig, w.chain, &coinbase, w.current.gasPool, w.current.state, w.current.header, tx, &w.current.header.GasUsed, *w.chain.GetVMConfig())
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		if receipt.Status == types.ReceiptStatusFailed {
+			rollback()
+			return nil, errBundleReverted
+		}
+		w.current.txs = append(w.current.txs, tx)
+		w.current.receipts = append(w.current.receipts, receipt)
+		w.current.tcount++
+
+		logs = append(logs, receipt.Logs...)
+	}
+	return logs, nil
+}
+
+// commitBundles simulates the given transaction bundles on top of the current
+// mining state and includes the valid ones, most profitable first. Bundles that
+// become invalid due to a previously included one are discarded atomically.
+func (w *worker) commitBundles(bundles []*core.TxBundle, coinbase common.Address) {
+	if w.current.gasPool == nil {
+		w.current.gasPool = new(core.GasPool).AddGas(w.current.header.GasLimit)
+	}
+	type simulatedBundle struct {
+		bundle *core.TxBundle
+		profit *big.Int
+		gas    uint64
+	}
+	var simulated []simulatedBundle
+	for _, bundle := range bundles {
+		profit, gas, err := w.simulateBundle(bundle, coinbase)
+		if err != nil {
+			log.Debug("Discarding invalid transaction bundle", "hash", bundle.Hash(), "err", err)
+			continue
+		}
+		simulated = append(simulated, simulatedBundle{bundle: bundle, profit: profit, gas: gas})
+	}
+	sort.SliceStable(simulated, func(i, j int) bool {
+		return simulated[i].profit.Cmp(simulated[j].profit) > 0
+	})
+	var (
+		included      = make(map[common.Hash]struct{})
+		coalescedLogs []*types.Log
+	)
+	for _, sim := range simulated {
+		if w.current.gasPool.Gas() < sim.gas {
+			log.Trace("Not enough gas for transaction bundle", "hash", sim.bundle.Hash(), "have", w.current.gasPool, "want", sim.gas)
+			continue
+		}
+		// Skip bundles sharing transactions with an already included one
+		overlap := false
+		for _, tx := range sim.bundle.Txs {
+			if _, ok := included[tx.Hash()]; ok {
+				overlap = true
+				break
+			}
+		}
+		if overlap {
+			continue
+		}
+		logs, err := w.commitBundle(sim.bundle, coinbase)
+		if err != nil {
+			log.Debug("Discarding conflicting transaction bundle", "hash", sim.bundle.Hash(), "err", err)
+			continue
+		}
+		coalescedLogs = append(coalescedLogs, logs...)
+		for _, tx := range sim.bundle.Txs {
+			included[tx.Hash()] = struct{}{}
+		}
+		log.Debug("Committed transaction bundle", "hash", sim.bundle.Hash(), "txs", len(sim.bundle.Txs), "profit", sim.profit)
+	}
+	w.postPendingLogs(coalescedLogs)
 }
 
 // commitNewWork generates several new sealing tasks based on the parent block.
@@ -856,23 +1039,10 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 		}
 		header.Coinbase = w.coinbase
 	}
-	if err := w.engine.Prepare(w.chain, header); err != nil {
+	if err := w.prepareHeader(header); err != nil {
 		log.Error("Failed to prepare header for mining", "err", err)
 		return
 	}
-	// If we are care about TheDAO hard-fork check whether to override the extra-data or not
-	if daoBlock := w.chainConfig.DAOForkBlock; daoBlock != nil {
-		// Check whether the block is among the fork extra-override range
-		limit := new(big.Int).Add(daoBlock, params.DAOForkExtraRange)
-		if header.Number.Cmp(daoBlock) >= 0 && header.Number.Cmp(limit) < 0 {
-			// Depending whether we support or oppose the fork, override differently
-			if w.chainConfig.DAOForkSupport {
-				header.Extra = common.CopyBytes(params.DAOForkBlockExtra)
-			} else if bytes.Equal(header.Extra, params.DAOForkBlockExtra) {
-				header.Extra = []byte{} // If miner opposes, don't let it use the reserved extra-data
-			}
-		}
-	}
 	// Could potentially happen if starting to mine in an odd state.
 	err := w.makeCurrent(parent, header)
 	if err != nil {
@@ -915,33 +1085,23 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 		w.commit(uncles, nil, false, tstart)
 	}
 
+	// Place the most profitable transaction bundles at the top of the block
+	if bundles := w.eth.TxPool().Bundles(header.Number, header.Time); len(bundles) > 0 {
+		w.commitBundles(bundles, w.coinbase)
+	}
 	// Fill the block with all available pending transactions.
 	pending, err := w.eth.TxPool().Pending()
 	if err != nil {
 		log.Error("Failed to fetch pending transactions", "err", err)
 		return
 	}
-	// Short circuit if there is no available pending transactions
-	if len(pending) == 0 {
+	// Short circuit if there is no available pending transactions or bundles
+	if len(pending) == 0 && w.current.tcount == 0 {
 		w.updateSnapshot()
 		return
 	}
-	// Split the pending transactions into locals and remotes
-	localTxs, remoteTxs := make(map[common.Address]types.Transactions), pending
-	for _, account := range w.eth.TxPool().Locals() {
-		if txs := remoteTxs[account]; len(txs) > 0 {
-			delete(remoteTxs, account)
-			localTxs[account] = txs
-		}
-	}
-	if len(localTxs) > 0 {
-		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, localTxs)
-		if w.commitTransactions(txs, w.coinbase, interrupt) {
-			return
-		}
-	}
-	if len(remoteTxs) > 0 {
-		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, remoteTxs)
+	// Order the pending transactions according to the configured policy
+	for _, txs := range w.ordering.Order(w.current.signer, pending, w.eth.TxPool().Locals()) {
 		if w.commitTransactions(txs, w.coinbase, interrupt) {
 			return
 		}
@@ -17,9 +17,11 @@
 package miner
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"math/big"
 	"math/rand"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -62,6 +64,8 @@ var (
 	testUserKey, _  = crypto.GenerateKey()
 	testUserAddress = crypto.PubkeyToAddress(testUserKey.PublicKey)
 
+	testSenderKeys = make([]*ecdsa.PrivateKey, 3) // Funded accounts for multi-sender tests
+
 	// Test transactions
 	pendingTxs []*types.Transaction
 	newTxs     []*types.Transaction
@@ -76,6 +80,9 @@ var (
 func init() {
 	testTxPoolConfig = core.DefaultTxPoolConfig
 	testTxPoolConfig.Journal = ""
+	for i := range testSenderKeys {
+		testSenderKeys[i], _ = crypto.GenerateKey()
+	}
 	ethashChainConfig = params.TestChainConfig
 	cliqueChainConfig = params.TestChainConfig
 	cliqueChainConfig.Clique = &params.CliqueConfig{
@@ -104,6 +111,9 @@ func newTestWorkerBackend(t *testing.T, chainConfig *params.ChainConfig, engine
 		Config: chainConfig,
 		Alloc:  core.GenesisAlloc{testBankAddress: {Balance: testBankFunds}},
 	}
+	for _, key := range testSenderKeys {
+		gspec.Alloc[crypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{Balance: testBankFunds}
+	}
 
 	switch e := engine.(type) {
 	case *clique.Clique:
@@ -306,6 +316,284 @@ func testEmptyWork(t *testing.T, chainConfig *params.ChainConfig, engine consens
 	}
 }
 
+func TestBundleInclusion(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	w, b := newTestWorker(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+	defer w.close()
+
+	// Create a bundle paying out to a fresh account and one deploying a reverting contract
+	var (
+		recipient = common.Address{0x01}
+		signer    = types.HomesteadSigner{}
+	)
+	tx0, _ := types.SignTx(types.NewTransaction(0, recipient, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, testBankKey)
+	tx1, _ := types.SignTx(types.NewTransaction(1, recipient, big.NewInt(2), params.TxGas, big.NewInt(1), nil), signer, testBankKey)
+	good := &core.TxBundle{Txs: types.Transactions{tx0, tx1}}
+
+	revert, _ := types.SignTx(types.NewContractCreation(0, big.NewInt(0), 100000, big.NewInt(10), common.FromHex("0x60006000fd")), signer, testBankKey)
+	bad := &core.TxBundle{Txs: types.Transactions{revert}}
+
+	for _, bundle := range []*core.TxBundle{good, bad} {
+		if err := b.txPool.AddBundle(bundle); err != nil {
+			t.Fatalf("failed to add bundle: %v", err)
+		}
+	}
+	taskCh := make(chan struct{}, 2)
+	w.newTaskHook = func(task *task) {
+		if task.block.NumberU64() != 1 || len(task.receipts) == 0 {
+			return
+		}
+		// The full work must start with the bundle and drop the pending transaction
+		txs := task.block.Transactions()
+		if len(txs) != 2 {
+			t.Errorf("transaction count mismatch: have %d, want %d", len(txs), 2)
+		} else if txs[0].Hash() != tx0.Hash() || txs[1].Hash() != tx1.Hash() {
+			t.Errorf("bundle not included at the top of the block")
+		}
+		if balance := task.state.GetBalance(recipient); balance.Cmp(big.NewInt(3)) != 0 {
+			t.Errorf("recipient balance mismatch: have %v, want %v", balance, 3)
+		}
+		taskCh <- struct{}{}
+	}
+	w.skipSealHook = func(task *task) bool { return true }
+	w.fullTaskHook = func() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	w.start()
+
+	select {
+	case <-taskCh:
+	case <-time.NewTimer(3 * time.Second).C:
+		t.Error("new task timeout")
+	}
+}
+
+func TestBundlePendingLogs(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	w, b := newTestWorker(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+	defer w.close()
+
+	logCh := make(chan []*types.Log, 1)
+	sub := w.pendingLogsFeed.Subscribe(logCh)
+	defer sub.Unsubscribe()
+
+	// Bundle the deployment of a contract logging from its constructor
+	tx, _ := types.SignTx(types.NewContractCreation(0, big.NewInt(0), 100000, big.NewInt(1), common.FromHex("0x60006000a0")), types.HomesteadSigner{}, testBankKey)
+	if err := b.txPool.AddBundle(&core.TxBundle{Txs: types.Transactions{tx}}); err != nil {
+		t.Fatalf("failed to add bundle: %v", err)
+	}
+	w.startCh <- struct{}{}
+
+	select {
+	case logs := <-logCh:
+		if len(logs) != 1 || logs[0].TxHash != tx.Hash() {
+			t.Errorf("pending logs mismatch: have %v, want 1 log of %x", logs, tx.Hash())
+		}
+	case <-time.NewTimer(3 * time.Second).C:
+		t.Error("pending logs timeout")
+	}
+}
+
+func TestBundlePartialFailure(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	w, b := newTestWorker(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+	defer w.close()
+
+	// Create a profitable bundle and a cheaper one whose second transaction
+	// conflicts with it, so it only fails once the first one is included
+	var (
+		recipient = common.Address{0x01}
+		victim    = common.Address{0x02}
+		signer    = types.HomesteadSigner{}
+	)
+	tx0, _ := types.SignTx(types.NewTransaction(0, recipient, big.NewInt(1), params.TxGas, big.NewInt(10), nil), signer, testSenderKeys[0])
+	good := &core.TxBundle{Txs: types.Transactions{tx0}}
+
+	tx1, _ := types.SignTx(types.NewTransaction(0, victim, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, testSenderKeys[1])
+	tx2, _ := types.SignTx(types.NewTransaction(0, victim, big.NewInt(2), params.TxGas, big.NewInt(1), nil), signer, testSenderKeys[0])
+	bad := &core.TxBundle{Txs: types.Transactions{tx1, tx2}}
+
+	for _, bundle := range []*core.TxBundle{good, bad} {
+		if err := b.txPool.AddBundle(bundle); err != nil {
+			t.Fatalf("failed to add bundle: %v", err)
+		}
+	}
+	taskCh := make(chan struct{}, 2)
+	w.newTaskHook = func(task *task) {
+		if task.block.NumberU64() != 1 || len(task.receipts) == 0 {
+			return
+		}
+		// The full work must contain the good bundle and the pending transaction only
+		txs := task.block.Transactions()
+		if len(txs) != 2 {
+			t.Errorf("transaction count mismatch: have %d, want %d", len(txs), 2)
+		} else if txs[0].Hash() != tx0.Hash() || txs[1].Hash() != pendingTxs[0].Hash() {
+			t.Errorf("transaction order mismatch: have [%x %x], want [%x %x]", txs[0].Hash(), txs[1].Hash(), tx0.Hash(), pendingTxs[0].Hash())
+		}
+		// None of the failed bundle's effects may leak into the state
+		if balance := task.state.GetBalance(victim); balance.Sign() != 0 {
+			t.Errorf("victim balance mismatch: have %v, want 0", balance)
+		}
+		if nonce := task.state.GetNonce(crypto.PubkeyToAddress(testSenderKeys[1].PublicKey)); nonce != 0 {
+			t.Errorf("partial bundle sender nonce mismatch: have %d, want 0", nonce)
+		}
+		if gasUsed := task.block.GasUsed(); gasUsed != 2*params.TxGas {
+			t.Errorf("gas used mismatch: have %d, want %d", gasUsed, 2*params.TxGas)
+		}
+		taskCh <- struct{}{}
+	}
+	w.skipSealHook = func(task *task) bool { return true }
+	w.fullTaskHook = func() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	w.start()
+
+	select {
+	case <-taskCh:
+	case <-time.NewTimer(3 * time.Second).C:
+		t.Error("new task timeout")
+	}
+}
+
+func TestExternalBlockBuilding(t *testing.T) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	w, b := newTestWorker(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+	defer w.close()
+
+	header, parent, err := w.blockTemplate()
+	if err != nil {
+		t.Fatalf("failed to create block template: %v", err)
+	}
+	if header.ParentHash != parent.Hash() || header.Number.Uint64() != 1 || header.Coinbase != testBankAddress {
+		t.Fatalf("block template mismatch: parent %x, number %v, coinbase %x", header.ParentHash, header.Number, header.Coinbase)
+	}
+	// Transactions must be executed strictly in the requested order
+	var (
+		recipient = common.Address{0x01}
+		signer    = types.HomesteadSigner{}
+	)
+	tx0, _ := types.SignTx(types.NewTransaction(0, recipient, big.NewInt(1), params.TxGas, big.NewInt(1), nil), signer, testBankKey)
+	tx1, _ := types.SignTx(types.NewTransaction(1, recipient, big.NewInt(2), params.TxGas, big.NewInt(1), nil), signer, testBankKey)
+
+	if _, _, err := w.submitBlock(parent.Hash(), header.Time, types.Transactions{tx1, tx0}); err == nil {
+		t.Fatalf("nonce gapped block accepted")
+	}
+	if _, _, err := w.submitBlock(common.Hash{0xff}, header.Time, types.Transactions{tx0, tx1}); err != errUnknownParent {
+		t.Fatalf("unknown parent error mismatch: have %v, want %v", err, errUnknownParent)
+	}
+	// Submit a valid block and wait for it to be sealed and imported
+	heads := make(chan core.ChainHeadEvent, 1)
+	sub := b.chain.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	block, receipts, err := w.submitBlock(parent.Hash(), header.Time, types.Transactions{tx0, tx1})
+	if err != nil {
+		t.Fatalf("failed to submit block: %v", err)
+	}
+	if len(receipts) != 2 || receipts[1].CumulativeGasUsed != 2*params.TxGas {
+		t.Fatalf("receipts mismatch: have %d, want %d", len(receipts), 2)
+	}
+	select {
+	case head := <-heads:
+		if head.Block.NumberU64() != 1 || head.Block.TxHash() != block.TxHash() {
+			t.Fatalf("imported block mismatch: number %d, txs %d", head.Block.NumberU64(), len(head.Block.Transactions()))
+		}
+	case <-time.NewTimer(3 * time.Second).C:
+		t.Fatalf("external block not imported")
+	}
+	statedb, _ := b.chain.State()
+	if balance := statedb.GetBalance(recipient); balance.Cmp(big.NewInt(3)) != 0 {
+		t.Errorf("recipient balance mismatch: have %v, want %v", balance, 3)
+	}
+}
+
+func TestTransactionOrderingPrice(t *testing.T) {
+	testTransactionOrdering(t, OrderingPrice, "b0 b1 c0 a0 a1")
+}
+func TestTransactionOrderingFIFO(t *testing.T) {
+	testTransactionOrdering(t, OrderingFIFO, "a0 b0 a1 c0 b1")
+}
+func TestTransactionOrderingFairShare(t *testing.T) {
+	testTransactionOrdering(t, OrderingFairShare, "b0 c0 a0 b1 a1")
+}
+func TestTransactionOrderingPriority(t *testing.T) {
+	testTransactionOrdering(t, OrderingPriority, "a0 a1 b0 b1 c0")
+}
+
+func testTransactionOrdering(t *testing.T, ordering string, want string) {
+	engine := ethash.NewFaker()
+	defer engine.Close()
+
+	b := newTestWorkerBackend(t, ethashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+
+	config := *testConfig
+	config.Ordering = ordering
+	config.PriorityAddresses = []common.Address{crypto.PubkeyToAddress(testSenderKeys[0].PublicKey)}
+
+	w := newWorker(&config, ethashChainConfig, engine, b, new(event.TypeMux), nil, false)
+	w.setEtherbase(testBankAddress)
+	defer w.close()
+
+	// Create the remote transactions of three senders in a fixed arrival order
+	var (
+		names = make(map[common.Hash]string)
+		txs   []*types.Transaction
+	)
+	for _, spec := range []struct {
+		name   string
+		sender int
+		nonce  uint64
+		price  int64
+	}{
+		{"a0", 0, 0, 1}, {"b0", 1, 0, 3}, {"a1", 0, 1, 1}, {"c0", 2, 0, 2}, {"b1", 1, 1, 3},
+	} {
+		tx, _ := types.SignTx(types.NewTransaction(spec.nonce, testUserAddress, big.NewInt(1), params.TxGas, big.NewInt(spec.price), nil), types.HomesteadSigner{}, testSenderKeys[spec.sender])
+		names[tx.Hash()] = spec.name
+		txs = append(txs, tx)
+	}
+	for _, err := range b.txPool.AddRemotesSync(txs) {
+		if err != nil {
+			t.Fatalf("failed to add transaction: %v", err)
+		}
+	}
+	taskCh := make(chan string, 1)
+	w.newTaskHook = func(task *task) {
+		if task.block.NumberU64() != 1 || len(task.receipts) == 0 {
+			return
+		}
+		var order []string
+		for _, tx := range task.block.Transactions() {
+			order = append(order, names[tx.Hash()])
+		}
+		select {
+		case taskCh <- strings.Join(order, " "):
+		default:
+		}
+	}
+	w.skipSealHook = func(task *task) bool { return true }
+	w.fullTaskHook = func() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	w.start()
+
+	select {
+	case have := <-taskCh:
+		if have != want {
+			t.Errorf("block composition mismatch: have %q, want %q", have, want)
+		}
+	case <-time.NewTimer(3 * time.Second).C:
+		t.Error("new task timeout")
+	}
+}
+
 func TestStreamUncleBlock(t *testing.T) {
 	ethash := ethash.NewFaker()
 	defer ethash.Close()
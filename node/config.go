@@ -70,6 +70,11 @@ type Config struct {
 	// in memory.
 	DataDir string
 
+	// SecondaryDataDir, if set, is the data directory of another live node whose
+	// chain database should be opened read-only instead of the one in DataDir.
+	// The node's own keys, IPC endpoint and other files still reside in DataDir.
+	SecondaryDataDir string `toml:",omitempty"`
+
 	// Configuration of peer-to-peer networking.
 	P2P p2p.Config
 
@@ -346,6 +351,24 @@ func (c *Config) ResolvePath(path string) string {
 	return filepath.Join(c.instanceDir(), path)
 }
 
+// resolveDatabase resolves the path of a chain database with a freezer, returning
+// whether it is owned by another node and needs to be opened read-only.
+func (c *Config) resolveDatabase(path string) (string, bool) {
+	if c.SecondaryDataDir == "" {
+		return c.ResolvePath(path), false
+	}
+	if filepath.IsAbs(path) {
+		return path, true
+	}
+	// Backwards-compatibility: use the geth 1.4 layout if the primary has it
+	if _, isOld := isOldGethResource[path]; isOld && c.name() == "geth" {
+		if oldpath := filepath.Join(c.SecondaryDataDir, path); common.FileExist(oldpath) {
+			return oldpath, true
+		}
+	}
+	return filepath.Join(c.SecondaryDataDir, c.name(), path), true
+}
+
 func (c *Config) instanceDir() string {
 	if c.DataDir == "" {
 		return ""
@@ -17,6 +17,7 @@
 package params
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"math/big"
@@ -215,16 +216,16 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil}
+	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(EthashConfig), nil, nil}
 
 	// AllCliqueProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the Ethereum core developers into the Clique consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}, nil}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, new(EthashConfig), nil}
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(EthashConfig), nil, nil}
 	TestRules       = TestChainConfig.Rules(new(big.Int))
 )
 
@@ -297,11 +298,27 @@ type ChainConfig struct {
 	MuirGlacierBlock    *big.Int `json:"muirGlacierBlock,omitempty"`    // Eip-2384 (bomb delay) switch block (nil = no fork, 0 = already activated)
 	EWASMBlock          *big.Int `json:"ewasmBlock,omitempty"`          // EWASM switch block (nil = no fork, 0 = already activated)
 
+	// Additional precompiled contracts of private chains
+	Precompiles []PrecompileConfig `json:"precompiles,omitempty"`
+
 	// Various consensus engines
 	Ethash *EthashConfig `json:"ethash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
+	BFT    *BFTConfig    `json:"bft,omitempty"`
+}
+
+// PrecompileConfig is an additional precompiled contract of a private chain,
+// whose implementation is registered with the EVM under a name.
+type PrecompileConfig struct {
+	Address common.Address `json:"address"` // Address the contract is called at, above the reserved range
+	Name    string         `json:"name"`    // Name the implementation is registered under
+	Block   *big.Int       `json:"block"`   // Activation block (0 = active from genesis)
 }
 
+// MaxReservedPrecompile is the highest address reserved for the precompiled
+// contracts of the Ethereum protocol, which additional ones may not take.
+var MaxReservedPrecompile = common.BytesToAddress([]byte{0xff})
+
 // EthashConfig is the consensus engine configs for proof-of-work based sealing.
 type EthashConfig struct{}
 
@@ -321,6 +338,19 @@ func (c *CliqueConfig) String() string {
 	return "clique"
 }
 
+// BFTConfig is the consensus engine configs for proof-of-authority based sealing
+// with byzantine fault tolerant finality.
+type BFTConfig struct {
+	Period         uint64 `json:"period"`                   // Minimum number of seconds between blocks
+	Epoch          uint64 `json:"epoch"`                    // Epoch length to reset votes and checkpoint
+	RequestTimeout uint64 `json:"requestTimeout,omitempty"` // Milliseconds to wait for a round to commit before changing it
+}
+
+// String implements the stringer interface, returning the consensus engine details.
+func (c *BFTConfig) String() string {
+	return "bft"
+}
+
 // String implements the fmt.Stringer interface.
 func (c *ChainConfig) String() string {
 	var engine interface{}
@@ -329,6 +359,8 @@ func (c *ChainConfig) String() string {
 		engine = c.Ethash
 	case c.Clique != nil:
 		engine = c.Clique
+	case c.BFT != nil:
+		engine = c.BFT
 	default:
 		engine = "unknown"
 	}
@@ -406,6 +438,17 @@ func (c *ChainConfig) IsEWASM(num *big.Int) bool {
 	return isForked(c.EWASMBlock, num)
 }
 
+// ActivePrecompiles returns the additional precompiled contracts active at num.
+func (c *ChainConfig) ActivePrecompiles(num *big.Int) []PrecompileConfig {
+	var active []PrecompileConfig
+	for _, precompile := range c.Precompiles {
+		if isForked(precompile.Block, num) {
+			active = append(active, precompile)
+		}
+	}
+	return active
+}
+
 // CheckCompatible checks whether scheduled fork transitions have been imported
 // with a mismatching chain configuration.
 func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
@@ -461,6 +504,29 @@ func (c *ChainConfig) CheckConfigForkOrder() error {
 	return nil
 }
 
+// CheckPrecompiles checks that the additional precompiled contracts are well
+// formed, each at its own address outside of the reserved range.
+func (c *ChainConfig) CheckPrecompiles() error {
+	seen := make(map[common.Address]bool)
+	for _, precompile := range c.Precompiles {
+		if bytes.Compare(precompile.Address[:], MaxReservedPrecompile[:]) <= 0 {
+			return fmt.Errorf("precompile %q at reserved address %x", precompile.Name, precompile.Address)
+		}
+		if seen[precompile.Address] {
+			return fmt.Errorf("duplicate precompile at address %x", precompile.Address)
+		}
+		seen[precompile.Address] = true
+
+		if precompile.Name == "" {
+			return fmt.Errorf("precompile at address %x has no name", precompile.Address)
+		}
+		if precompile.Block == nil {
+			return fmt.Errorf("precompile %q at address %x has no activation block", precompile.Name, precompile.Address)
+		}
+	}
+	return nil
+}
+
 func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *ConfigCompatError {
 	if isForkIncompatible(c.HomesteadBlock, newcfg.HomesteadBlock, head) {
 		return newCompatError("Homestead fork block", c.HomesteadBlock, newcfg.HomesteadBlock)
@@ -501,6 +567,40 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.EWASMBlock, newcfg.EWASMBlock, head) {
 		return newCompatError("ewasm fork block", c.EWASMBlock, newcfg.EWASMBlock)
 	}
+	return checkPrecompilesCompatible(c.Precompiles, newcfg.Precompiles, head)
+}
+
+// checkPrecompilesCompatible checks that no additional precompiled contract was
+// added, removed, rescheduled or swapped for another implementation past head.
+func checkPrecompilesCompatible(stored, new []PrecompileConfig, head *big.Int) *ConfigCompatError {
+	find := func(list []PrecompileConfig, address common.Address) *PrecompileConfig {
+		for i := range list {
+			if list[i].Address == address {
+				return &list[i]
+			}
+		}
+		return nil
+	}
+	for _, list := range [][]PrecompileConfig{stored, new} {
+		for _, precompile := range list {
+			var (
+				s, n   = find(stored, precompile.Address), find(new, precompile.Address)
+				sb, nb *big.Int
+			)
+			if s != nil {
+				sb = s.Block
+			}
+			if n != nil {
+				nb = n.Block
+			}
+			if isForkIncompatible(sb, nb, head) {
+				return newCompatError(fmt.Sprintf("precompile %x activation block", precompile.Address), sb, nb)
+			}
+			if s != nil && n != nil && s.Name != n.Name && isForked(sb, head) {
+				return newCompatError(fmt.Sprintf("precompile %x implementation", precompile.Address), sb, nb)
+			}
+		}
+	}
 	return nil
 }
 
@@ -20,9 +20,13 @@ import (
 	"math/big"
 	"reflect"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
 func TestCheckCompatible(t *testing.T) {
+	precompileAddr := common.HexToAddress("0x0100")
+
 	type test struct {
 		stored, new *ChainConfig
 		head        uint64
@@ -70,6 +74,40 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     9,
 			},
 		},
+		{
+			stored:  &ChainConfig{Precompiles: []PrecompileConfig{{Address: precompileAddr, Name: "a", Block: big.NewInt(10)}}},
+			new:     &ChainConfig{Precompiles: []PrecompileConfig{{Address: precompileAddr, Name: "a", Block: big.NewInt(10)}}},
+			head:    20,
+			wantErr: nil,
+		},
+		{
+			stored:  &ChainConfig{Precompiles: []PrecompileConfig{{Address: precompileAddr, Name: "a", Block: big.NewInt(10)}}},
+			new:     &ChainConfig{Precompiles: []PrecompileConfig{{Address: precompileAddr, Name: "b", Block: big.NewInt(30)}}},
+			head:    5,
+			wantErr: nil,
+		},
+		{
+			stored: &ChainConfig{},
+			new:    &ChainConfig{Precompiles: []PrecompileConfig{{Address: precompileAddr, Name: "a", Block: big.NewInt(10)}}},
+			head:   20,
+			wantErr: &ConfigCompatError{
+				What:         "precompile 0000000000000000000000000000000000000100 activation block",
+				StoredConfig: nil,
+				NewConfig:    big.NewInt(10),
+				RewindTo:     9,
+			},
+		},
+		{
+			stored: &ChainConfig{Precompiles: []PrecompileConfig{{Address: precompileAddr, Name: "a", Block: big.NewInt(10)}}},
+			new:    &ChainConfig{Precompiles: []PrecompileConfig{{Address: precompileAddr, Name: "b", Block: big.NewInt(10)}}},
+			head:   15,
+			wantErr: &ConfigCompatError{
+				What:         "precompile 0000000000000000000000000000000000000100 implementation",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    big.NewInt(10),
+				RewindTo:     9,
+			},
+		},
 	}
 
 	for _, test := range tests {